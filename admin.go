@@ -0,0 +1,386 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/common/log"
+
+	"github.com/cubrid/cubrid-exporter/collector"
+)
+
+// adminAPI serves the administrative endpoints under /-/. Read endpoints are
+// open once enabled; write endpoints additionally require a bearer token
+// from the admin token file.
+type adminAPI struct {
+	read, write bool
+	// tokens maps bearer tokens to the identity recorded in the audit log.
+	tokens map[string]string
+	// lockedDown is set to 1 once the write endpoints are locked down.
+	lockedDown int32
+}
+
+// lockDown rejects every further request to the write endpoints, after a
+// security self-audit failed for reason.
+func (a *adminAPI) lockDown(reason string) {
+	if atomic.SwapInt32(&a.lockedDown, 1) == 0 {
+		log.Errorf("Locking down the admin write endpoints after a failed security self-audit (%s)", reason)
+	}
+}
+
+// loadAdminTokens reads the admin token file. Each line holds a token,
+// optionally prefixed with "identity:". Empty lines and lines starting with #
+// are ignored.
+func loadAdminTokens(path string) (map[string]string, error) {
+	tokens := map[string]string{}
+	if path == "" {
+		return tokens, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		identity, token := fmt.Sprintf("token-%d", n), line
+		if i := strings.Index(line, ":"); i >= 0 {
+			identity, token = line[:i], line[i+1:]
+		}
+		if token == "" {
+			return nil, fmt.Errorf("line %d: empty token", n)
+		}
+		tokens[token] = identity
+	}
+	return tokens, scanner.Err()
+}
+
+// identity returns the identity authenticated by the request's bearer token.
+func (a *adminAPI) identity(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return "", false
+	}
+	presented := []byte(strings.TrimPrefix(auth, "Bearer "))
+	for token, identity := range a.tokens {
+		if subtle.ConstantTimeCompare(presented, []byte(token)) == 1 {
+			return identity, true
+		}
+	}
+	return "", false
+}
+
+// handleRead registers a read-only endpoint if the read group is enabled.
+func (a *adminAPI) handleRead(path string, h http.HandlerFunc) {
+	if !a.read {
+		return
+	}
+	http.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "Method not allowed.", http.StatusMethodNotAllowed)
+			return
+		}
+		h(w, r)
+	})
+}
+
+// handleWrite registers a mutating endpoint if the write group is enabled.
+// Requests without a valid admin token are rejected; accepted ones are audited.
+func (a *adminAPI) handleWrite(path string, h http.HandlerFunc) {
+	if !a.write {
+		return
+	}
+	http.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Method not allowed.", http.StatusMethodNotAllowed)
+			return
+		}
+		if a.authorize(w, r) {
+			h(w, r)
+		}
+	})
+}
+
+// handleReadWrite registers an endpoint serving GET through read if the read
+// group is enabled and writeMethod through write if the write group is.
+func (a *adminAPI) handleReadWrite(path string, read http.HandlerFunc, writeMethod string, write http.HandlerFunc) {
+	if !a.read && !a.write {
+		return
+	}
+	var allowed []string
+	if a.read {
+		allowed = append(allowed, http.MethodGet)
+	}
+	if a.write {
+		allowed = append(allowed, writeMethod)
+	}
+	http.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case a.read && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+			read(w, r)
+		case a.write && r.Method == writeMethod:
+			if a.authorize(w, r) {
+				write(w, r)
+			}
+		default:
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			http.Error(w, "Method not allowed.", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// authorize rejects requests without a valid admin token, or any while locked
+// down, and audits the others.
+func (a *adminAPI) authorize(w http.ResponseWriter, r *http.Request) bool {
+	if atomic.LoadInt32(&a.lockedDown) == 1 {
+		log.Warnf("audit: rejected %s %s from %s while locked down", r.Method, r.URL.Path, r.RemoteAddr)
+		http.Error(w, "Service unavailable: write endpoints are locked down after a failed security self-audit.", http.StatusServiceUnavailable)
+		return false
+	}
+	identity, ok := a.identity(r)
+	if !ok {
+		log.Warnf("audit: rejected unauthenticated %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+		http.Error(w, "Forbidden: write endpoints require a valid token from --web.admin-token-file.", http.StatusForbidden)
+		return false
+	}
+	log.Infof("audit: %s %s by %q from %s", r.Method, r.URL.RequestURI(), identity, r.RemoteAddr)
+	return true
+}
+
+func hwmHandler(hwm *collector.HighWaterMarks) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		if _, err := hwm.WriteTo(w); err != nil {
+			log.Errorln("Error writing high-water marks:", err)
+		}
+	}
+}
+
+func hwmResetHandler(hwm *collector.HighWaterMarks) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		hwm.Reset()
+		w.Write([]byte("High-water marks reset.\n"))
+	}
+}
+
+func collectorReenableHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("collector")
+	if name == "" {
+		http.Error(w, "Missing collector parameter.", http.StatusBadRequest)
+		return
+	}
+	if collector.ReenableCollector(name) {
+		fmt.Fprintf(w, "Collector %s re-enabled.\n", name)
+		return
+	}
+	fmt.Fprintf(w, "Collector %s was not disabled.\n", name)
+}
+
+// logLevelPath is the prefix of the per-collector log level endpoint,
+// followed by the collector name.
+const logLevelPath = "/-/loglevel/"
+
+func logLevelReadHandler(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, logLevelPath)
+	found := false
+	for _, override := range collector.CollectorLogLevels() {
+		if name != "" && override.Collector != name {
+			continue
+		}
+		found = true
+		expires := "never"
+		if !override.Expires.IsZero() {
+			expires = override.Expires.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s %s expires=%s\n", override.Collector, override.Level, expires)
+	}
+	if !found && name != "" {
+		fmt.Fprintf(w, "%s uses --log.level.\n", name)
+	}
+}
+
+// logLevelWriteHandler overrides the log level of a collector with the level
+// parameter, reverting after the optional ttl parameter. The level "default"
+// removes the override.
+func logLevelWriteHandler(scrapers map[collector.Scraper]bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, logLevelPath)
+		known := false
+		for scraper := range scrapers {
+			known = known || scraper.Name() == name
+		}
+		if !known {
+			http.Error(w, fmt.Sprintf("Unknown collector %q.", name), http.StatusNotFound)
+			return
+		}
+
+		level := r.URL.Query().Get("level")
+		if level == "default" {
+			collector.ClearCollectorLogLevel(name)
+			log.Infof("audit: log level override of collector %s removed", name)
+			fmt.Fprintf(w, "Collector %s uses --log.level.\n", name)
+			return
+		}
+		var ttl time.Duration
+		if v := r.URL.Query().Get("ttl"); v != "" {
+			var err error
+			if ttl, err = time.ParseDuration(v); err != nil {
+				http.Error(w, fmt.Sprintf("Invalid ttl: %s.", err), http.StatusBadRequest)
+				return
+			}
+		}
+		if err := collector.SetCollectorLogLevel(name, level, ttl); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid log level: %s.", err), http.StatusBadRequest)
+			return
+		}
+		log.Infof("audit: log level of collector %s set to %s (ttl %s)", name, level, ttl)
+		fmt.Fprintf(w, "Collector %s logs at %s.\n", name, level)
+	}
+}
+
+// errorsHandler serves the journal of recent collector errors as JSON,
+// filtered by the optional since (RFC 3339) and collector parameters.
+func errorsHandler(w http.ResponseWriter, r *http.Request) {
+	var since time.Time
+	if v := r.URL.Query().Get("since"); v != "" {
+		var err error
+		if since, err = time.Parse(time.RFC3339, v); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid since: %s.", err), http.StatusBadRequest)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(collector.ErrorJournal(since, r.URL.Query().Get("collector"))); err != nil {
+		log.Errorln("Error writing error journal:", err)
+	}
+}
+
+func errorsClearHandler(w http.ResponseWriter, r *http.Request) {
+	collector.ClearErrorJournal()
+	w.Write([]byte("Error journal cleared.\n"))
+}
+
+// coverageHandler serves the statdump keys and columns observed from the
+// server and whether a collector consumes them as JSON.
+func coverageHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(collector.Coverage.Sources()); err != nil {
+		log.Errorln("Error writing coverage report:", err)
+	}
+}
+
+// recommendationsHandler serves the configuration changes suggested by the
+// recent scrapes as JSON.
+func recommendationsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(collector.Recommendations()); err != nil {
+		log.Errorln("Error writing recommendations:", err)
+	}
+}
+
+// warmStartHandler serves the latest collected metrics to starting replicas
+// as JSON.
+func warmStartHandler(w http.ResponseWriter, r *http.Request) {
+	snapshot, err := collector.WarmStart.Snapshot()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error creating snapshot: %s.", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(snapshot); err != nil {
+		log.Errorln("Error writing warm-start snapshot:", err)
+	}
+}
+
+// queriesHandler serves the query audit as JSON.
+func queriesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(collector.Audit.Entries()); err != nil {
+		log.Errorln("Error writing query audit:", err)
+	}
+}
+
+// chaosHandler serves the active injected faults as JSON.
+func chaosHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(collector.Chaos.Faults()); err != nil {
+		log.Errorln("Error writing injected faults:", err)
+	}
+}
+
+// chaosInjectHandler injects the fault given by the type, collector, family,
+// factor and latency parameters for the mandatory ttl.
+func chaosInjectHandler(w http.ResponseWriter, r *http.Request) {
+	if !collector.ChaosEnabled() {
+		http.Error(w, "Fault injection requires --chaos.enable.", http.StatusForbidden)
+		return
+	}
+	params := r.URL.Query()
+	ttl, err := time.ParseDuration(params.Get("ttl"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid ttl: %s.", err), http.StatusBadRequest)
+		return
+	}
+	fault := collector.Fault{
+		Type:      params.Get("type"),
+		Collector: params.Get("collector"),
+		Family:    params.Get("family"),
+	}
+	if v := params.Get("factor"); v != "" {
+		if fault.Factor, err = strconv.ParseFloat(v, 64); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid factor: %s.", err), http.StatusBadRequest)
+			return
+		}
+	}
+	if v := params.Get("latency"); v != "" {
+		if fault.Latency, err = time.ParseDuration(v); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid latency: %s.", err), http.StatusBadRequest)
+			return
+		}
+	}
+	if fault, err = collector.Chaos.Inject(fault, ttl); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid fault: %s.", err), http.StatusBadRequest)
+		return
+	}
+	log.Warnf("audit: injected %s fault until %s", fault.Type, fault.Expires.Format(time.RFC3339))
+	fmt.Fprintf(w, "Injected %s fault until %s.\n", fault.Type, fault.Expires.Format(time.RFC3339))
+}
+
+// chaosClearHandler removes all injected faults.
+func chaosClearHandler(w http.ResponseWriter, r *http.Request) {
+	if !collector.ChaosEnabled() {
+		http.Error(w, "Fault injection requires --chaos.enable.", http.StatusForbidden)
+		return
+	}
+	n := collector.Chaos.Clear()
+	log.Warnf("audit: cleared %d injected faults", n)
+	fmt.Fprintf(w, "Cleared %d injected faults.\n", n)
+}
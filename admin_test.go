@@ -0,0 +1,125 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/cubrid/cubrid-exporter/collector"
+)
+
+// adminRequest runs a request with the bearer token through authorize and
+// returns the status of the response.
+func adminRequest(a *adminAPI, token string) int {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/-/hwm/reset", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if a.authorize(rec, req) {
+		return http.StatusOK
+	}
+	return rec.Code
+}
+
+func TestAdminLockDown(t *testing.T) {
+	a := &adminAPI{write: true, tokens: map[string]string{"s3cret": "ops"}}
+	if code := adminRequest(a, "s3cret"); code != http.StatusOK {
+		t.Fatalf("status before the lockdown = %d, want %d", code, http.StatusOK)
+	}
+	a.lockDown("reached_driver")
+	if code := adminRequest(a, "s3cret"); code != http.StatusServiceUnavailable {
+		t.Errorf("status while locked down = %d, want %d", code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestLoadAdminTokens(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tokens")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "tokens")
+	content := "# operators\n\nops:s3cret\n  plain-token  \n"
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := loadAdminTokens(path)
+	if err != nil {
+		t.Fatalf("error loading tokens: %s", err)
+	}
+	want := map[string]string{"s3cret": "ops", "plain-token": "token-4"}
+	if !reflect.DeepEqual(tokens, want) {
+		t.Errorf("tokens = %v, want %v", tokens, want)
+	}
+
+	if tokens, err := loadAdminTokens(""); err != nil || len(tokens) != 0 {
+		t.Errorf("tokens without a file = %v, %v, want none", tokens, err)
+	}
+	if err := ioutil.WriteFile(path, []byte("ops:\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadAdminTokens(path); err == nil {
+		t.Error("expected an error for an empty token")
+	}
+	if _, err := loadAdminTokens(filepath.Join(dir, "missing")); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
+
+func TestAdminAuthorize(t *testing.T) {
+	a := &adminAPI{write: true, tokens: map[string]string{"s3cret": "ops"}}
+	for _, tc := range []struct {
+		name, token string
+		code        int
+	}{
+		{"valid token", "s3cret", http.StatusOK},
+		{"no token", "", http.StatusForbidden},
+		{"wrong token", "guess", http.StatusForbidden},
+		{"token prefix", "s3c", http.StatusForbidden},
+	} {
+		if code := adminRequest(a, tc.token); code != tc.code {
+			t.Errorf("%s: status = %d, want %d", tc.name, code, tc.code)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/-/hwm/reset", nil)
+	req.Header.Set("Authorization", "Basic s3cret")
+	if _, ok := a.identity(req); ok {
+		t.Error("a token outside a Bearer authorization was accepted")
+	}
+	req.Header.Set("Authorization", "Bearer s3cret")
+	if identity, ok := a.identity(req); !ok || identity != "ops" {
+		t.Errorf("identity = %q, %v, want \"ops\", true", identity, ok)
+	}
+}
+
+func TestChaosInjectDisabled(t *testing.T) {
+	for _, h := range []http.HandlerFunc{chaosInjectHandler, chaosClearHandler} {
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest(http.MethodPost, "/-/chaos?type=up&ttl=1m", nil))
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("status without --chaos.enable = %d, want %d", rec.Code, http.StatusForbidden)
+		}
+	}
+	if faults := collector.Chaos.Faults(); len(faults) != 0 {
+		t.Errorf("faults injected without --chaos.enable: %+v", faults)
+	}
+}
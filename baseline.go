@@ -0,0 +1,233 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// baselineMaxSize bounds the baseline document read from the config service.
+const baselineMaxSize = 1 << 20
+
+var (
+	configMatchesBaselineDesc = prometheus.NewDesc(
+		"cubrid_exporter_config_matches_baseline",
+		"Whether the effective configuration matches the fleet baseline (1 for match, 0 for drift, -1 while no verified baseline was fetched).",
+		nil, nil,
+	)
+	baselineAgeDesc = prometheus.NewDesc(
+		"cubrid_exporter_baseline_age_seconds",
+		"Time since the verified baseline was issued.",
+		nil, nil,
+	)
+	baselineFailuresDesc = prometheus.NewDesc(
+		"cubrid_exporter_baseline_fetch_failures_total",
+		"Total number of baseline fetches that failed or carried an invalid signature.",
+		[]string{"reason"}, nil,
+	)
+)
+
+// baselineEnvelope is the document served at --baseline.url. Baseline holds
+// the base64 of the JSON encoded baseline, Signature the base64 of its
+// ed25519 signature.
+type baselineEnvelope struct {
+	Baseline  string `json:"baseline"`
+	Signature string `json:"signature"`
+}
+
+// baseline is the fleet-wide expected configuration.
+type baseline struct {
+	IssuedAt time.Time `json:"issued_at"`
+	// ConfigHash is the expected SHA-256 of --config.file; empty accepts any.
+	ConfigHash string `json:"config_hash"`
+	// Settings are the expected values of flags by name.
+	Settings map[string]string `json:"settings"`
+}
+
+// baselineChecker compares the effective configuration with the baseline.
+// It implements prometheus.Collector.
+type baselineChecker struct {
+	url       string
+	auth      remoteWriteAuth
+	publicKey ed25519.PublicKey
+	client    *http.Client
+	now       func() time.Time
+
+	configHash string
+	settings   map[string]string
+
+	mu sync.Mutex
+	// current is the last verified baseline, nil before the first one.
+	current  *baseline
+	matches  bool
+	failures map[string]float64
+}
+
+func newBaselineChecker(url, bearerTokenFile, publicKeyFile, configHash string) (*baselineChecker, error) {
+	data, err := ioutil.ReadFile(publicKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("%s does not hold a base64 encoded ed25519 public key", publicKeyFile)
+	}
+	return &baselineChecker{
+		url:        url,
+		auth:       remoteWriteAuth{bearerTokenFile: bearerTokenFile},
+		publicKey:  ed25519.PublicKey(key),
+		client:     &http.Client{Timeout: 30 * time.Second},
+		now:        time.Now,
+		configHash: configHash,
+		settings:   effectiveSettings(kingpin.CommandLine),
+		failures:   map[string]float64{"fetch": 0, "signature": 0},
+	}, nil
+}
+
+// effectiveSettings returns the value of every flag of app.
+func effectiveSettings(app *kingpin.Application) map[string]string {
+	settings := map[string]string{}
+	for _, flag := range app.Model().Flags {
+		settings[flag.Name] = flag.String()
+	}
+	return settings
+}
+
+// fetch downloads and verifies the baseline.
+func (b *baselineChecker) fetch(ctx context.Context) (*baseline, string, error) {
+	req, err := http.NewRequest(http.MethodGet, b.url, nil)
+	if err != nil {
+		return nil, "fetch", err
+	}
+	req = req.WithContext(ctx)
+	if err := b.auth.apply(req); err != nil {
+		return nil, "fetch", err
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, "fetch", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return nil, "fetch", fmt.Errorf("baseline returned HTTP status %s", resp.Status)
+	}
+	var envelope baselineEnvelope
+	if err := json.NewDecoder(io.LimitReader(resp.Body, baselineMaxSize)).Decode(&envelope); err != nil {
+		return nil, "fetch", fmt.Errorf("invalid baseline document: %s", err)
+	}
+
+	payload, err := base64.StdEncoding.DecodeString(envelope.Baseline)
+	if err != nil {
+		return nil, "signature", fmt.Errorf("invalid baseline encoding: %s", err)
+	}
+	signature, err := base64.StdEncoding.DecodeString(envelope.Signature)
+	if err != nil || !ed25519.Verify(b.publicKey, payload, signature) {
+		return nil, "signature", fmt.Errorf("baseline signature does not verify")
+	}
+	var bl baseline
+	if err := json.Unmarshal(payload, &bl); err != nil {
+		return nil, "fetch", fmt.Errorf("invalid baseline: %s", err)
+	}
+	return &bl, "", nil
+}
+
+// compare returns the settings deviating from bl, sorted.
+func (b *baselineChecker) compare(bl *baseline) []string {
+	var drift []string
+	if bl.ConfigHash != "" && bl.ConfigHash != b.configHash {
+		drift = append(drift, "config.file")
+	}
+	for name, expected := range bl.Settings {
+		if actual, ok := b.settings[name]; !ok || actual != expected {
+			drift = append(drift, name)
+		}
+	}
+	sort.Strings(drift)
+	return drift
+}
+
+// refresh fetches the baseline once. A failed fetch keeps the last verified
+// baseline, whose growing age shows it is stale.
+func (b *baselineChecker) refresh(ctx context.Context) {
+	bl, reason, err := b.fetch(ctx)
+	if err != nil {
+		log.Warnf("Error fetching configuration baseline from %s: %s", b.url, err)
+		b.mu.Lock()
+		b.failures[reason]++
+		b.mu.Unlock()
+		return
+	}
+	drift := b.compare(bl)
+	if len(drift) > 0 {
+		log.Warnf("Configuration drifts from the baseline issued at %s in: %s", bl.IssuedAt, strings.Join(drift, ", "))
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.current = bl
+	b.matches = len(drift) == 0
+}
+
+// run refreshes the baseline every interval until ctx is done.
+func (b *baselineChecker) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		b.refresh(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (b *baselineChecker) Describe(ch chan<- *prometheus.Desc) {
+	ch <- configMatchesBaselineDesc
+	ch <- baselineAgeDesc
+	ch <- baselineFailuresDesc
+}
+
+// Collect implements prometheus.Collector.
+func (b *baselineChecker) Collect(ch chan<- prometheus.Metric) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	matches := -1.0
+	if b.current != nil {
+		matches = 0
+		if b.matches {
+			matches = 1
+		}
+		ch <- prometheus.MustNewConstMetric(baselineAgeDesc, prometheus.GaugeValue, b.now().Sub(b.current.IssuedAt).Seconds())
+	}
+	ch <- prometheus.MustNewConstMetric(configMatchesBaselineDesc, prometheus.GaugeValue, matches)
+	for reason, v := range b.failures {
+		ch <- prometheus.MustNewConstMetric(baselineFailuresDesc, prometheus.CounterValue, v, reason)
+	}
+}
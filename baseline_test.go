@@ -0,0 +1,156 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var baselineIssuedAt = time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+
+// newTestBaselineChecker returns a checker trusting the returned key, with
+// --web.listen-address=:9116 as its only setting.
+func newTestBaselineChecker(t *testing.T, url string) (*baselineChecker, ed25519.PrivateKey) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "baseline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	keyFile := filepath.Join(dir, "key.pub")
+	if err := ioutil.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString(public)+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := newBaselineChecker(url, "", keyFile, "c0ffee")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.settings = map[string]string{"web.listen-address": ":9116"}
+	b.now = func() time.Time { return baselineIssuedAt.Add(time.Hour) }
+	return b, private
+}
+
+// serveBaseline returns a config service serving bl signed with key.
+func serveBaseline(t *testing.T, bl baseline, key ed25519.PrivateKey) *httptest.Server {
+	payload, err := json.Marshal(bl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelope := baselineEnvelope{
+		Baseline:  base64.StdEncoding.EncodeToString(payload),
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(key, payload)),
+	}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(envelope)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// baselineMetrics is the exposition of a checker after a fetch.
+func baselineMetrics(matches string, age bool, fetchFailures, signatureFailures string) string {
+	metrics := `
+# HELP cubrid_exporter_baseline_fetch_failures_total Total number of baseline fetches that failed or carried an invalid signature.
+# TYPE cubrid_exporter_baseline_fetch_failures_total counter
+cubrid_exporter_baseline_fetch_failures_total{reason="fetch"} ` + fetchFailures + `
+cubrid_exporter_baseline_fetch_failures_total{reason="signature"} ` + signatureFailures + `
+# HELP cubrid_exporter_config_matches_baseline Whether the effective configuration matches the fleet baseline (1 for match, 0 for drift, -1 while no verified baseline was fetched).
+# TYPE cubrid_exporter_config_matches_baseline gauge
+cubrid_exporter_config_matches_baseline ` + matches + "\n"
+	if age {
+		metrics += `# HELP cubrid_exporter_baseline_age_seconds Time since the verified baseline was issued.
+# TYPE cubrid_exporter_baseline_age_seconds gauge
+cubrid_exporter_baseline_age_seconds 3600
+`
+	}
+	return metrics
+}
+
+func TestBaselineChecker(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		baseline baseline
+		expected string
+	}{
+		{"match", baseline{IssuedAt: baselineIssuedAt, ConfigHash: "c0ffee", Settings: map[string]string{"web.listen-address": ":9116"}},
+			baselineMetrics("1", true, "0", "0")},
+		{"setting drift", baseline{IssuedAt: baselineIssuedAt, Settings: map[string]string{"web.listen-address": ":9999"}},
+			baselineMetrics("0", true, "0", "0")},
+		{"config file drift", baseline{IssuedAt: baselineIssuedAt, ConfigHash: "decaf"},
+			baselineMetrics("0", true, "0", "0")},
+		{"unknown setting", baseline{IssuedAt: baselineIssuedAt, Settings: map[string]string{"unheard-of": "1"}},
+			baselineMetrics("0", true, "0", "0")},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b, key := newTestBaselineChecker(t, "")
+			b.url = serveBaseline(t, tc.baseline, key).URL
+			b.refresh(context.Background())
+			if err := testutil.CollectAndCompare(b, strings.NewReader(tc.expected)); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestBaselineCheckerBadSignature(t *testing.T) {
+	b, _ := newTestBaselineChecker(t, "")
+	_, forger, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A forged baseline blessing the drift must not be trusted.
+	b.url = serveBaseline(t, baseline{IssuedAt: baselineIssuedAt}, forger).URL
+	b.refresh(context.Background())
+	if err := testutil.CollectAndCompare(b, strings.NewReader(baselineMetrics("-1", false, "0", "1"))); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestBaselineCheckerUnreachable checks that a failed fetch keeps the last
+// verified baseline.
+func TestBaselineCheckerUnreachable(t *testing.T) {
+	b, key := newTestBaselineChecker(t, "")
+	s := serveBaseline(t, baseline{IssuedAt: baselineIssuedAt}, key)
+	b.url = s.URL
+	b.refresh(context.Background())
+	s.Close()
+	b.refresh(context.Background())
+	if err := testutil.CollectAndCompare(b, strings.NewReader(baselineMetrics("1", true, "1", "0"))); err != nil {
+		t.Error(err)
+	}
+
+	unreachable, _ := newTestBaselineChecker(t, s.URL)
+	unreachable.refresh(context.Background())
+	if err := testutil.CollectAndCompare(unreachable, strings.NewReader(baselineMetrics("-1", false, "1", "0"))); err != nil {
+		t.Error(err)
+	}
+}
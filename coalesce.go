@@ -0,0 +1,85 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/cubrid/cubrid-exporter/collector"
+)
+
+// gatherCall is an in-flight collection shared by concurrent identical requests.
+type gatherCall struct {
+	done chan struct{}
+	mfs  []*dto.MetricFamily
+	err  error
+}
+
+// coalescer deduplicates concurrent identical scrapes into one collection.
+// The gathered families are shared read-only; each response is serialized
+// from them independently.
+type coalescer struct {
+	mu    sync.Mutex
+	calls map[string]*gatherCall
+}
+
+func newCoalescer() *coalescer {
+	return &coalescer{calls: map[string]*gatherCall{}}
+}
+
+// coalesceKey identifies requests that may share a collection: the same
+// target and the same resolved scraper set.
+func coalesceKey(target string, scrapers []collector.Scraper) string {
+	names := make([]string, 0, len(scrapers))
+	for _, scraper := range scrapers {
+		names = append(names, scraper.Name())
+	}
+	sort.Strings(names)
+	return target + "\x00" + strings.Join(names, ",")
+}
+
+// gather runs g for the first request with key and lets concurrent requests
+// with the same key wait for its result. A waiting request gives up when its
+// own context is done.
+func (c *coalescer) gather(ctx context.Context, key string, g prometheus.Gatherer, metrics collector.Metrics) ([]*dto.MetricFamily, error) {
+	c.mu.Lock()
+	if call, ok := c.calls[key]; ok {
+		c.mu.Unlock()
+		metrics.TotalScrapes.Inc()
+		metrics.CoalescedScrapes.Inc()
+		select {
+		case <-call.done:
+			return call.mfs, call.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	call := &gatherCall{done: make(chan struct{})}
+	c.calls[key] = call
+	c.mu.Unlock()
+
+	call.mfs, call.err = g.Gather()
+	close(call.done)
+
+	c.mu.Lock()
+	delete(c.calls, key)
+	c.mu.Unlock()
+	return call.mfs, call.err
+}
@@ -0,0 +1,179 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/cubrid/cubrid-exporter/collector"
+)
+
+// queryGatherer queries the database on Gather once released, like a
+// collection scraping it.
+type queryGatherer struct {
+	db      *sql.DB
+	started chan struct{}
+	release chan struct{}
+}
+
+func newQueryGatherer(db *sql.DB) *queryGatherer {
+	return &queryGatherer{db: db, started: make(chan struct{}, 10), release: make(chan struct{})}
+}
+
+func (g *queryGatherer) Gather() ([]*dto.MetricFamily, error) {
+	g.started <- struct{}{}
+	<-g.release
+	var value float64
+	if err := g.db.QueryRow("SELECT value FROM stub").Scan(&value); err != nil {
+		return nil, err
+	}
+	reg := prometheus.NewRegistry()
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "cubrid_stub_value", Help: "Stub value."})
+	gauge.Set(value)
+	reg.MustRegister(gauge)
+	return reg.Gather()
+}
+
+// gatherResult is the result of a coalesced gather.
+type gatherResult struct {
+	mfs []*dto.MetricFamily
+	err error
+}
+
+// gatherAsync gathers key through c in the background.
+func gatherAsync(c *coalescer, key string, g prometheus.Gatherer, metrics collector.Metrics) <-chan gatherResult {
+	result := make(chan gatherResult, 1)
+	go func() {
+		mfs, err := c.gather(context.Background(), key, g, metrics)
+		result <- gatherResult{mfs, err}
+	}()
+	return result
+}
+
+// waitFor polls cond until it holds or a second passed.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func newStubDB(t *testing.T) (*sql.DB, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("error opening a stub database connection: %s", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db, mock
+}
+
+// TestCoalescerIdenticalRequests checks that concurrent identical requests
+// share one query and count as coalesced.
+func TestCoalescerIdenticalRequests(t *testing.T) {
+	db, mock := newStubDB(t)
+	mock.ExpectQuery("SELECT value FROM stub").WillReturnRows(sqlmock.NewRows([]string{"value"}).AddRow(42))
+	c, metrics, g := newCoalescer(), collector.NewMetrics(), newQueryGatherer(db)
+
+	var results []<-chan gatherResult
+	for i := 0; i < 3; i++ {
+		results = append(results, gatherAsync(c, "target\x00statdump", g, metrics))
+	}
+	waitFor(t, "the coalesced requests", func() bool { return testutil.ToFloat64(metrics.CoalescedScrapes) == 2 })
+	close(g.release)
+
+	for i, result := range results {
+		r := <-result
+		if r.err != nil {
+			t.Fatalf("request %d failed: %s", i, r.err)
+		}
+		if len(r.mfs) != 1 || r.mfs[0].GetMetric()[0].GetGauge().GetValue() != 42 {
+			t.Errorf("request %d gathered %v, want cubrid_stub_value 42", i, r.mfs)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+	if got := len(g.started); got != 1 {
+		t.Errorf("collections = %d, want 1", got)
+	}
+
+	// A later request collects anew.
+	<-g.started
+	mock.ExpectQuery("SELECT value FROM stub").WillReturnRows(sqlmock.NewRows([]string{"value"}).AddRow(43))
+	if r := <-gatherAsync(c, "target\x00statdump", g, metrics); r.err != nil {
+		t.Fatalf("later request failed: %s", r.err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations of the later request: %s", err)
+	}
+	if got := testutil.ToFloat64(metrics.CoalescedScrapes); got != 2 {
+		t.Errorf("coalesced scrapes = %v, want 2", got)
+	}
+}
+
+// TestCoalescerDifferentRequests checks that concurrent requests for
+// different targets or scrapers each query the database.
+func TestCoalescerDifferentRequests(t *testing.T) {
+	db, mock := newStubDB(t)
+	mock.MatchExpectationsInOrder(false)
+	for _, value := range []float64{1, 2, 3} {
+		mock.ExpectQuery("SELECT value FROM stub").WillReturnRows(sqlmock.NewRows([]string{"value"}).AddRow(value))
+	}
+	c, metrics, g := newCoalescer(), collector.NewMetrics(), newQueryGatherer(db)
+
+	var results []<-chan gatherResult
+	for _, key := range []string{"target1\x00statdump", "target2\x00statdump", "target1\x00spacedb,statdump"} {
+		results = append(results, gatherAsync(c, key, g, metrics))
+	}
+	for i := 0; i < 3; i++ {
+		<-g.started
+	}
+	close(g.release)
+
+	for i, result := range results {
+		if r := <-result; r.err != nil {
+			t.Fatalf("request %d failed: %s", i, r.err)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+	if got := testutil.ToFloat64(metrics.CoalescedScrapes); got != 0 {
+		t.Errorf("coalesced scrapes = %v, want 0", got)
+	}
+}
+
+func TestCoalesceKey(t *testing.T) {
+	statdump, spacedb := collector.ScrapeStatdump{}, collector.ScrapeSpaceDBStatus{}
+	if coalesceKey("db1", []collector.Scraper{statdump, spacedb}) != coalesceKey("db1", []collector.Scraper{spacedb, statdump}) {
+		t.Error("the order of the scrapers changes the key")
+	}
+	if coalesceKey("db1", []collector.Scraper{statdump}) == coalesceKey("db2", []collector.Scraper{statdump}) {
+		t.Error("different targets share a key")
+	}
+	if coalesceKey("db1", []collector.Scraper{statdump}) == coalesceKey("db1", []collector.Scraper{statdump, spacedb}) {
+		t.Error("different scrapers share a key")
+	}
+}
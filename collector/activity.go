@@ -0,0 +1,121 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Subsystem activity fingerprint derived from statdump counter deltas.
+
+package collector
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// activitySubsystemsVersion is the version of activitySubsystems. Increase it
+// whenever a key moves between subsystems, as the fingerprints before and
+// after are not comparable.
+const activitySubsystemsVersion = 1
+
+// activitySubsystem groups the statdump keys of a subsystem by key prefix.
+type activitySubsystem struct {
+	name     string
+	prefixes []string
+}
+
+// activitySubsystems maps statdump keys to the subsystem they belong to. A
+// key belongs to the first subsystem with a matching prefix.
+var activitySubsystems = []activitySubsystem{
+	{"io", []string{"Num_file_", "Num_data_page_ioreads", "Num_data_page_iowrites", "Num_log_page_ioreads", "Num_log_page_iowrites"}},
+	{"locking", []string{"Num_lk_"}},
+	{"vacuum", []string{"Num_vacuum_"}},
+	{"replication", []string{"Num_ha_", "Num_repl_", "Num_log_ha_"}},
+	{"query", []string{"Num_query_", "Num_btree_", "Num_heap_", "Num_sort_", "Num_plan_cache_"}},
+}
+
+// subsystemOfStatdumpKey returns the subsystem of the statdump key, empty if
+// none.
+func subsystemOfStatdumpKey(key string) string {
+	for _, subsystem := range activitySubsystems {
+		for _, prefix := range subsystem.prefixes {
+			if strings.HasPrefix(key, prefix) {
+				return subsystem.name
+			}
+		}
+	}
+	return ""
+}
+
+var (
+	activityDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "activity"),
+		"Whether any statdump counter of the subsystem increased since the previous scrape (1 for active).",
+		[]string{"database", "subsystem"}, prometheus.Labels{"mapping_version": strconv.Itoa(activitySubsystemsVersion)},
+	)
+	activitySubsystemsDesc = newDatabaseDesc("", "activity_subsystems",
+		"Number of subsystems with a statdump counter that increased since the previous scrape.")
+)
+
+// statdumpPrevious holds the counter values of the previous statdump scrape
+// per target and database, shared between scrapes as the Exporter is created
+// per request.
+var statdumpPrevious = struct {
+	sync.Mutex
+	values map[string]map[string]float64
+}{values: map[string]map[string]float64{}}
+
+// activeSubsystems returns whether each subsystem had a counter increase from
+// previous to current. Keys of gauges and keys missing from previous are
+// ignored, as is a counter reset by a server restart.
+func activeSubsystems(previous, current map[string]float64) map[string]bool {
+	active := make(map[string]bool, len(activitySubsystems))
+	for _, subsystem := range activitySubsystems {
+		active[subsystem.name] = false
+	}
+	for key, v := range current {
+		plan := statdumpPlan(key)
+		if plan.subsystem == "" {
+			continue
+		}
+		if before, ok := previous[key]; ok && v > before {
+			active[plan.subsystem] = true
+		}
+	}
+	return active
+}
+
+// scrapeActivity sends the activity of the database since the previous
+// scrape. Nothing is sent on the first scrape of a database.
+func scrapeActivity(ctx context.Context, database string, values map[string]float64, ch chan<- prometheus.Metric) {
+	key := targetScoped(ctx, database)
+	statdumpPrevious.Lock()
+	previous, ok := statdumpPrevious.values[key]
+	statdumpPrevious.values[key] = values
+	statdumpPrevious.Unlock()
+	if !ok {
+		return
+	}
+
+	count := 0
+	for subsystem, active := range activeSubsystems(previous, values) {
+		v := 0.0
+		if active {
+			v = 1
+			count++
+		}
+		ch <- prometheus.MustNewConstMetric(activityDesc, prometheus.GaugeValue, v, database, subsystem)
+	}
+	ch <- prometheus.MustNewConstMetric(activitySubsystemsDesc, prometheus.GaugeValue, float64(count), database)
+}
@@ -0,0 +1,132 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// activityCollector sends the activity of a statdump scrape with values on
+// Collect.
+type activityCollector struct {
+	ctx      context.Context
+	database string
+	values   map[string]float64
+}
+
+func (c activityCollector) Describe(ch chan<- *prometheus.Desc) {}
+
+func (c activityCollector) Collect(ch chan<- prometheus.Metric) {
+	scrapeActivity(c.ctx, c.database, c.values, ch)
+}
+
+func TestActiveSubsystems(t *testing.T) {
+	previous := map[string]float64{
+		"Num_file_creates":         10,
+		"Num_lk_acquired_on_pages": 5,
+		"Num_vacuum_log_pages":     7,
+		"Num_query_selects":        100,
+		"Num_data_page_fetches":    1000,
+	}
+	for _, tc := range []struct {
+		name    string
+		current map[string]float64
+		want    map[string]bool
+	}{
+		{
+			name:    "idle",
+			current: previous,
+			want:    map[string]bool{},
+		},
+		{
+			name: "io and locking",
+			current: map[string]float64{
+				"Num_file_creates":         11,
+				"Num_lk_acquired_on_pages": 6,
+				"Num_vacuum_log_pages":     7,
+				"Num_query_selects":        100,
+			},
+			want: map[string]bool{"io": true, "locking": true},
+		},
+		{
+			name:    "counter reset by a restart",
+			current: map[string]float64{"Num_vacuum_log_pages": 1, "Num_query_selects": 101},
+			want:    map[string]bool{"query": true},
+		},
+		{
+			name:    "key missing from the previous scrape",
+			current: map[string]float64{"Num_ha_repl_delay": 3, "Num_repl_commits": 4},
+			want:    map[string]bool{},
+		},
+		{
+			name:    "key of no subsystem",
+			current: map[string]float64{"Num_data_page_fetches": 2000},
+			want:    map[string]bool{},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := activeSubsystems(previous, tc.current)
+			if len(got) != len(activitySubsystems) {
+				t.Errorf("got %d subsystems, want %d", len(got), len(activitySubsystems))
+			}
+			for _, subsystem := range activitySubsystems {
+				if got[subsystem.name] != tc.want[subsystem.name] {
+					t.Errorf("subsystem %s active = %v, want %v", subsystem.name, got[subsystem.name], tc.want[subsystem.name])
+				}
+			}
+		})
+	}
+}
+
+func TestScrapeActivity(t *testing.T) {
+	ctx := withTarget(context.Background(), "activity:33000:activitydb")
+	statdumpPrevious.Lock()
+	delete(statdumpPrevious.values, targetScoped(ctx, "activitydb"))
+	statdumpPrevious.Unlock()
+
+	first := activityCollector{ctx, "activitydb", map[string]float64{
+		"Num_file_creates":         10,
+		"Num_lk_acquired_on_pages": 5,
+		"Num_repl_commits":         2,
+	}}
+	if n := testutil.CollectAndCount(first); n != 0 {
+		t.Errorf("first scrape sent %d metrics, want none", n)
+	}
+
+	second := activityCollector{ctx, "activitydb", map[string]float64{
+		"Num_file_creates":         12,
+		"Num_lk_acquired_on_pages": 5,
+		"Num_repl_commits":         3,
+	}}
+	expected := `
+# HELP cubrid_activity Whether any statdump counter of the subsystem increased since the previous scrape (1 for active).
+# TYPE cubrid_activity gauge
+cubrid_activity{database="activitydb",mapping_version="1",subsystem="io"} 1
+cubrid_activity{database="activitydb",mapping_version="1",subsystem="locking"} 0
+cubrid_activity{database="activitydb",mapping_version="1",subsystem="query"} 0
+cubrid_activity{database="activitydb",mapping_version="1",subsystem="replication"} 1
+cubrid_activity{database="activitydb",mapping_version="1",subsystem="vacuum"} 0
+# HELP cubrid_activity_subsystems Number of subsystems with a statdump counter that increased since the previous scrape.
+# TYPE cubrid_activity_subsystems gauge
+cubrid_activity_subsystems{database="activitydb"} 2
+`
+	if err := testutil.CollectAndCompare(second, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
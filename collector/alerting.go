@@ -0,0 +1,342 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !noembeddedalerts
+// +build !noembeddedalerts
+
+// Minimal threshold alerting for sites without Alertmanager.
+
+package collector
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/log"
+)
+
+func init() {
+	RegisterBuildFeature(buildFeatureEmbeddedAlerts, true)
+}
+
+// Alert statuses.
+const (
+	alertFiring   = "firing"
+	alertResolved = "resolved"
+)
+
+const (
+	defaultAlertInterval = 30 * time.Second
+	// alertRetries bounds the delivery attempts of a notification.
+	alertRetries = 5
+)
+
+// alertRetryBackoff is the delay before the first retry; it doubles with
+// every retry. It is shortened to test the retries.
+var alertRetryBackoff = time.Second
+
+// defaultAlertPayload is the webhook body of rules without a payload template.
+const defaultAlertPayload = `{"alert":{{json .Name}},"status":{{json .Status}},"labels":{{json .Labels}},"value":{{.Value}}}`
+
+var alertComparisons = map[string]func(v, threshold float64) bool{
+	">":  func(v, t float64) bool { return v > t },
+	">=": func(v, t float64) bool { return v >= t },
+	"<":  func(v, t float64) bool { return v < t },
+	"<=": func(v, t float64) bool { return v <= t },
+	"==": func(v, t float64) bool { return v == t },
+	"!=": func(v, t float64) bool { return v != t },
+}
+
+// AlertNotification is sent to the webhook of a rule.
+type AlertNotification struct {
+	Name   string
+	Status string
+	Labels map[string]string
+	Value  float64
+}
+
+// alertRule is a validated rule and the state of its instances.
+type alertRule struct {
+	AlertRule
+	compare  func(v, threshold float64) bool
+	payload  *template.Template
+	instance map[string]*alertInstance
+}
+
+type alertInstance struct {
+	labels  map[string]string
+	pending int
+	firing  bool
+	value   float64
+}
+
+// Alerting evaluates the rules against the latest gathered metrics. It
+// implements prometheus.Collector.
+type Alerting struct {
+	interval time.Duration
+	rules    []alertRule
+	derived  *DerivedMetrics
+	client   *http.Client
+
+	mu sync.Mutex
+	// latest holds the families of the last gather.
+	latest []*dto.MetricFamily
+
+	firing        *prometheus.GaugeVec
+	transitions   *prometheus.CounterVec
+	notifyFailure *prometheus.CounterVec
+}
+
+// NewAlerting validates the rules. Without rules it returns nil.
+func NewAlerting(cfg AlertingConfig) (*Alerting, error) {
+	if len(cfg.Rules) == 0 {
+		return nil, nil
+	}
+	a := &Alerting{
+		interval: cfg.Interval,
+		rules:    make([]alertRule, len(cfg.Rules)),
+		client:   &http.Client{Timeout: 10 * time.Second},
+		firing: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: exporter,
+			Name:      "alert_firing",
+			Help:      "Number of firing instances of the alert rule.",
+		}, []string{"alert"}),
+		transitions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: exporter,
+			Name:      "alert_transitions_total",
+			Help:      "Total number of alert instances that started firing or resolved.",
+		}, []string{"alert", "status"}),
+		notifyFailure: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: exporter,
+			Name:      "alert_notification_failures_total",
+			Help:      "Total number of webhook notifications given up after all retries.",
+		}, []string{"alert"}),
+	}
+	if a.interval <= 0 {
+		a.interval = defaultAlertInterval
+	}
+	var exprs []DerivedMetric
+	names := map[string]bool{}
+	for i := range a.rules {
+		rule := &a.rules[i]
+		rule.AlertRule = cfg.Rules[i]
+		if !labelNameRE.MatchString(rule.Name) || names[rule.Name] {
+			return nil, fmt.Errorf("alert rule %d: invalid or duplicate name %q", i, rule.Name)
+		}
+		names[rule.Name] = true
+		var ok bool
+		if rule.compare, ok = alertComparisons[rule.Op]; !ok {
+			return nil, fmt.Errorf("alert rule %s: unknown op %q", rule.Name, rule.Op)
+		}
+		if rule.For < 1 {
+			rule.For = 1
+		}
+		if rule.Webhook == "" {
+			return nil, fmt.Errorf("alert rule %s: webhook is required", rule.Name)
+		}
+		payload := rule.Payload
+		if payload == "" {
+			payload = defaultAlertPayload
+		}
+		var err error
+		if rule.payload, err = template.New(rule.Name).Funcs(template.FuncMap{"json": alertJSON}).Parse(payload); err != nil {
+			return nil, fmt.Errorf("alert rule %s: invalid payload: %s", rule.Name, err)
+		}
+		rule.instance = map[string]*alertInstance{}
+		exprs = append(exprs, DerivedMetric{Name: rule.Name, Expr: rule.Expr, On: rule.On})
+	}
+	var err error
+	if a.derived, err = NewDerivedMetrics(exprs); err != nil {
+		return nil, fmt.Errorf("alert rules: %s", err)
+	}
+	return a, nil
+}
+
+func alertJSON(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	return string(data), err
+}
+
+// Wrap returns a Gatherer remembering everything g gathers for the next
+// evaluation. A nil Alerting returns g unchanged.
+func (a *Alerting) Wrap(g prometheus.Gatherer) prometheus.Gatherer {
+	if a == nil {
+		return g
+	}
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		mfs, err := g.Gather()
+		a.mu.Lock()
+		a.latest = mfs
+		a.mu.Unlock()
+		return mfs, err
+	})
+}
+
+// Run evaluates the rules every interval until ctx is done.
+func (a *Alerting) Run(ctx context.Context) {
+	if a == nil {
+		return
+	}
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.evaluate(ctx)
+		}
+	}
+}
+
+func (a *Alerting) evaluate(ctx context.Context) {
+	a.mu.Lock()
+	families := make(map[string]*dto.MetricFamily, len(a.latest))
+	for _, mf := range a.latest {
+		families[mf.GetName()] = mf
+	}
+	a.mu.Unlock()
+	if len(families) == 0 {
+		// Nothing was gathered yet.
+		return
+	}
+
+	for i := range a.rules {
+		rule := &a.rules[i]
+		seen := map[string]bool{}
+		for _, m := range a.derived.evaluate(&a.derived.metrics[i], families).Metric {
+			key := labelSignature(m)
+			seen[key] = true
+			instance, ok := rule.instance[key]
+			if !ok {
+				instance = &alertInstance{labels: map[string]string{}}
+				for _, lp := range m.Label {
+					instance.labels[lp.GetName()] = lp.GetValue()
+				}
+				rule.instance[key] = instance
+			}
+			instance.value = metricValue(m)
+			if !rule.compare(instance.value, rule.Threshold) {
+				a.resolve(ctx, rule, key, instance)
+				continue
+			}
+			instance.pending++
+			if !instance.firing && instance.pending >= rule.For {
+				instance.firing = true
+				log.Infof("audit: alert %s%v firing with value %g", rule.Name, instance.labels, instance.value)
+				a.transitions.WithLabelValues(rule.Name, alertFiring).Inc()
+				a.notify(ctx, rule, instance, alertFiring)
+			}
+		}
+		// Instances without a sample anymore resolve.
+		for key, instance := range rule.instance {
+			if !seen[key] {
+				a.resolve(ctx, rule, key, instance)
+			}
+		}
+
+		firing := 0
+		for _, instance := range rule.instance {
+			if instance.firing {
+				firing++
+			}
+		}
+		a.firing.WithLabelValues(rule.Name).Set(float64(firing))
+	}
+}
+
+func (a *Alerting) resolve(ctx context.Context, rule *alertRule, key string, instance *alertInstance) {
+	delete(rule.instance, key)
+	if !instance.firing {
+		return
+	}
+	log.Infof("audit: alert %s%v resolved", rule.Name, instance.labels)
+	a.transitions.WithLabelValues(rule.Name, alertResolved).Inc()
+	a.notify(ctx, rule, instance, alertResolved)
+}
+
+// notify sends the notification in the background, retrying with backoff.
+func (a *Alerting) notify(ctx context.Context, rule *alertRule, instance *alertInstance, status string) {
+	var body bytes.Buffer
+	n := AlertNotification{Name: rule.Name, Status: status, Labels: instance.labels, Value: instance.value}
+	if err := rule.payload.Execute(&body, n); err != nil {
+		log.Errorf("Error rendering notification of alert %s: %s", rule.Name, err)
+		a.notifyFailure.WithLabelValues(rule.Name).Inc()
+		return
+	}
+
+	go func() {
+		backoff := alertRetryBackoff
+		for attempt := 1; ; attempt++ {
+			err := a.post(ctx, rule.Webhook, body.Bytes())
+			if err == nil {
+				return
+			}
+			if attempt == alertRetries {
+				log.Errorf("Giving up notifying %s of alert %s after %d attempts: %s", status, rule.Name, attempt, err)
+				a.notifyFailure.WithLabelValues(rule.Name).Inc()
+				return
+			}
+			log.Warnf("Error notifying %s of alert %s, retrying in %s: %s", status, rule.Name, backoff, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+	}()
+}
+
+func (a *Alerting) post(ctx context.Context, url string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook returned HTTP status %s", resp.Status)
+	}
+	return nil
+}
+
+// Describe implements prometheus.Collector.
+func (a *Alerting) Describe(ch chan<- *prometheus.Desc) {
+	a.firing.Describe(ch)
+	a.transitions.Describe(ch)
+	a.notifyFailure.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (a *Alerting) Collect(ch chan<- prometheus.Metric) {
+	a.firing.Collect(ch)
+	a.transitions.Collect(ch)
+	a.notifyFailure.Collect(ch)
+}
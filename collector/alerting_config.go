@@ -0,0 +1,41 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Configuration of the embedded alerting.
+
+package collector
+
+import "time"
+
+// AlertingConfig is the alerting section of the config file.
+type AlertingConfig struct {
+	// Interval between evaluations, 30s by default.
+	Interval time.Duration `yaml:"interval"`
+	Rules    []AlertRule   `yaml:"rules"`
+}
+
+// AlertRule fires while the value of Expr compares to Threshold with Op for
+// For consecutive evaluations.
+type AlertRule struct {
+	Name string `yaml:"name"`
+	// Expr and On are evaluated like a derived metric.
+	Expr      string   `yaml:"expr"`
+	On        []string `yaml:"on"`
+	Op        string   `yaml:"op"`
+	Threshold float64  `yaml:"threshold"`
+	For       int      `yaml:"for"`
+	Webhook   string   `yaml:"webhook"`
+	// Payload is a text/template of the webhook body, executed with an
+	// AlertNotification. The json function encodes a value as JSON.
+	Payload string `yaml:"payload"`
+}
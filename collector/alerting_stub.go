@@ -0,0 +1,56 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build noembeddedalerts
+// +build noembeddedalerts
+
+// Placeholder of the embedded alerting for builds excluding it.
+
+package collector
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func init() {
+	RegisterBuildFeature(buildFeatureEmbeddedAlerts, false)
+}
+
+// Alerting is not available in this build.
+type Alerting struct{}
+
+// NewAlerting fails if rules are configured, as this build excludes the
+// embedded alerting. Without rules it returns nil.
+func NewAlerting(cfg AlertingConfig) (*Alerting, error) {
+	if len(cfg.Rules) == 0 {
+		return nil, nil
+	}
+	return nil, fmt.Errorf("alert rules are configured, but the exporter was built without embedded alerting (build tag no%s)", buildFeatureEmbeddedAlerts)
+}
+
+// Wrap returns g unchanged.
+func (a *Alerting) Wrap(g prometheus.Gatherer) prometheus.Gatherer {
+	return g
+}
+
+// Run returns immediately.
+func (a *Alerting) Run(ctx context.Context) {}
+
+// Describe implements prometheus.Collector.
+func (a *Alerting) Describe(ch chan<- *prometheus.Desc) {}
+
+// Collect implements prometheus.Collector.
+func (a *Alerting) Collect(ch chan<- prometheus.Metric) {}
@@ -0,0 +1,37 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build noembeddedalerts
+// +build noembeddedalerts
+
+package collector
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAlertingStub(t *testing.T) {
+	if alerting, err := NewAlerting(AlertingConfig{}); alerting != nil || err != nil {
+		t.Errorf("NewAlerting without rules = %v, %v, want nil, nil", alerting, err)
+	}
+	_, err := NewAlerting(AlertingConfig{Rules: []AlertRule{{Name: "high_load"}}})
+	if err == nil || !strings.Contains(err.Error(), "no"+buildFeatureEmbeddedAlerts) {
+		t.Errorf("NewAlerting with rules error = %v, want one naming the build tag", err)
+	}
+	for _, feature := range BuildFeatures() {
+		if feature == buildFeatureEmbeddedAlerts {
+			t.Errorf("build features %v include the excluded %s", BuildFeatures(), feature)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !noembeddedalerts
+// +build !noembeddedalerts
+
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// alertReceiver is a webhook failing the first failures requests and
+// passing the notifications of the others on.
+type alertReceiver struct {
+	*httptest.Server
+	requests      int32
+	notifications chan map[string]interface{}
+}
+
+func newAlertReceiver(t *testing.T, failures int32) *alertReceiver {
+	r := &alertReceiver{notifications: make(chan map[string]interface{}, 10)}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&r.requests, 1) <= failures {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := ioutil.ReadAll(req.Body)
+		var n map[string]interface{}
+		if err := json.Unmarshal(body, &n); err != nil {
+			t.Errorf("invalid notification %q: %s", body, err)
+		}
+		r.notifications <- n
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+// next returns the next notification received.
+func (r *alertReceiver) next(t *testing.T) map[string]interface{} {
+	select {
+	case n := <-r.notifications:
+		return n
+	case <-time.After(5 * time.Second):
+		t.Fatal("no notification received")
+		return nil
+	}
+}
+
+// withAlertRetryBackoff shortens the delay between delivery attempts.
+func withAlertRetryBackoff(t *testing.T) {
+	backoff := alertRetryBackoff
+	alertRetryBackoff = time.Millisecond
+	t.Cleanup(func() { alertRetryBackoff = backoff })
+}
+
+// newTestAlerting returns alerting with a rule firing on cubrid_fake_load
+// above 10 for two evaluations, and the gauge it watches.
+func newTestAlerting(t *testing.T, webhook string) (*Alerting, prometheus.Gatherer, prometheus.Gauge) {
+	a, err := NewAlerting(AlertingConfig{Rules: []AlertRule{{
+		Name:      "high_load",
+		Expr:      "cubrid_fake_load",
+		Op:        ">",
+		Threshold: 10,
+		For:       2,
+		Webhook:   webhook,
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	load := prometheus.NewGauge(prometheus.GaugeOpts{Name: "cubrid_fake_load", Help: "Fake load."})
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(load)
+	return a, a.Wrap(registry), load
+}
+
+// evaluateAlerts gathers g and evaluates the rules.
+func evaluateAlerts(t *testing.T, a *Alerting, g prometheus.Gatherer) {
+	if _, err := g.Gather(); err != nil {
+		t.Fatal(err)
+	}
+	a.evaluate(context.Background())
+}
+
+func TestAlertingFiresAndResolves(t *testing.T) {
+	receiver := newAlertReceiver(t, 0)
+	a, g, load := newTestAlerting(t, receiver.URL)
+
+	load.Set(5)
+	evaluateAlerts(t, a, g)
+	load.Set(20)
+	evaluateAlerts(t, a, g)
+	if got := testutil.ToFloat64(a.firing.WithLabelValues("high_load")); got != 0 {
+		t.Errorf("firing after one evaluation above the threshold = %v, want 0", got)
+	}
+	evaluateAlerts(t, a, g)
+	if got := testutil.ToFloat64(a.firing.WithLabelValues("high_load")); got != 1 {
+		t.Errorf("firing after two evaluations above the threshold = %v, want 1", got)
+	}
+	if n := receiver.next(t); n["status"] != alertFiring || n["value"] != 20.0 {
+		t.Errorf("notification = %v, want firing with value 20", n)
+	}
+
+	// Staying above the threshold notifies only once.
+	evaluateAlerts(t, a, g)
+	load.Set(10)
+	evaluateAlerts(t, a, g)
+	if n := receiver.next(t); n["status"] != alertResolved {
+		t.Errorf("notification = %v, want resolved", n)
+	}
+	if got := testutil.ToFloat64(a.firing.WithLabelValues("high_load")); got != 0 {
+		t.Errorf("firing after resolving = %v, want 0", got)
+	}
+	for status, want := range map[string]float64{alertFiring: 1, alertResolved: 1} {
+		if got := testutil.ToFloat64(a.transitions.WithLabelValues("high_load", status)); got != want {
+			t.Errorf("%s transitions = %v, want %v", status, got, want)
+		}
+	}
+	select {
+	case n := <-receiver.notifications:
+		t.Errorf("unexpected notification %v", n)
+	default:
+	}
+}
+
+// TestAlertingPendingResets checks that dropping below the threshold
+// before the for-duration passed does not fire.
+func TestAlertingPendingResets(t *testing.T) {
+	receiver := newAlertReceiver(t, 0)
+	a, g, load := newTestAlerting(t, receiver.URL)
+
+	for _, v := range []float64{20, 5, 20, 5} {
+		load.Set(v)
+		evaluateAlerts(t, a, g)
+	}
+	if got := testutil.ToFloat64(a.transitions.WithLabelValues("high_load", alertFiring)); got != 0 {
+		t.Errorf("firing transitions = %v, want 0", got)
+	}
+}
+
+func TestAlertingWebhookRetries(t *testing.T) {
+	withAlertRetryBackoff(t)
+	receiver := newAlertReceiver(t, alertRetries-1)
+	a, g, load := newTestAlerting(t, receiver.URL)
+
+	load.Set(20)
+	evaluateAlerts(t, a, g)
+	evaluateAlerts(t, a, g)
+	if n := receiver.next(t); n["status"] != alertFiring {
+		t.Errorf("notification = %v, want firing", n)
+	}
+	if got := atomic.LoadInt32(&receiver.requests); got != alertRetries {
+		t.Errorf("delivery attempts = %d, want %d", got, alertRetries)
+	}
+	if got := testutil.ToFloat64(a.notifyFailure.WithLabelValues("high_load")); got != 0 {
+		t.Errorf("notification failures = %v, want 0", got)
+	}
+}
+
+func TestAlertingWebhookGivesUp(t *testing.T) {
+	withAlertRetryBackoff(t)
+	receiver := newAlertReceiver(t, alertRetries)
+	a, g, load := newTestAlerting(t, receiver.URL)
+
+	load.Set(20)
+	evaluateAlerts(t, a, g)
+	evaluateAlerts(t, a, g)
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if testutil.ToFloat64(a.notifyFailure.WithLabelValues("high_load")) == 1 {
+			break
+		}
+	}
+	if got := testutil.ToFloat64(a.notifyFailure.WithLabelValues("high_load")); got != 1 {
+		t.Errorf("notification failures = %v, want 1", got)
+	}
+	if got := atomic.LoadInt32(&receiver.requests); got != alertRetries {
+		t.Errorf("delivery attempts = %d, want %d", got, alertRetries)
+	}
+}
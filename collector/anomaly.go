@@ -0,0 +1,91 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Accounting of tolerated anomalies in scraped data.
+
+package collector
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+var strictMode = kingpin.Flag(
+	"strict",
+	"Fail a collector on any anomaly in the scraped data instead of skipping and counting it. Meant for acceptance testing.",
+).Default("false").Bool()
+
+// Anomaly kinds.
+const (
+	anomalyUnparsedValue  = "unparsed_value"
+	anomalyDuplicateKey   = "duplicate_key"
+	anomalySanitizedLabel = "sanitized_label"
+	anomalyUnknownEnum    = "unknown_enum"
+)
+
+// maxStrictAnomalies bounds the anomalies enumerated in a strict mode error.
+const maxStrictAnomalies = 10
+
+type anomaly struct {
+	kind, detail string
+}
+
+// anomalyRecorder collects the anomalies reported during one scraper run.
+type anomalyRecorder struct {
+	mu        sync.Mutex
+	anomalies []anomaly
+}
+
+type anomalyRecorderKey struct{}
+
+func withAnomalyRecorder(ctx context.Context) (context.Context, *anomalyRecorder) {
+	rec := &anomalyRecorder{}
+	return context.WithValue(ctx, anomalyRecorderKey{}, rec), rec
+}
+
+// reportAnomaly records a tolerated anomaly of the running scraper.
+func reportAnomaly(ctx context.Context, kind, detail string) {
+	if rec, ok := ctx.Value(anomalyRecorderKey{}).(*anomalyRecorder); ok {
+		rec.mu.Lock()
+		rec.anomalies = append(rec.anomalies, anomaly{kind: kind, detail: detail})
+		rec.mu.Unlock()
+	}
+}
+
+// account counts the recorded anomalies of the collector and, in strict mode,
+// returns an error enumerating them.
+func (rec *anomalyRecorder) account(collector string, counter *prometheus.CounterVec) error {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	for _, a := range rec.anomalies {
+		counter.WithLabelValues(collector, a.kind).Inc()
+	}
+	if !*strictMode || len(rec.anomalies) == 0 {
+		return nil
+	}
+
+	var details []string
+	for i, a := range rec.anomalies {
+		if i == maxStrictAnomalies {
+			details = append(details, fmt.Sprintf("and %d more", len(rec.anomalies)-i))
+			break
+		}
+		details = append(details, a.kind+": "+a.detail)
+	}
+	return fmt.Errorf("%w: %d anomalies: %s", errStrict, len(rec.anomalies), strings.Join(details, "; "))
+}
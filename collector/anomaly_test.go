@@ -0,0 +1,81 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// scrapeAnomalies runs scraper like the Exporter does, in strict mode if
+// strict, and returns the number of samples, the number of anomalies counted
+// and the resulting error.
+func scrapeAnomalies(scraper Scraper, db *sql.DB, strict bool) (int, float64, error) {
+	saved := *strictMode
+	*strictMode = strict
+	defer func() { *strictMode = saved }()
+
+	ctx, rec := withAnomalyRecorder(context.Background())
+	ch := make(chan prometheus.Metric)
+	samples := make(chan int)
+	go func() {
+		n := 0
+		for range ch {
+			n++
+		}
+		samples <- n
+	}()
+	err := scraper.Scrape(ctx, db, ch)
+	close(ch)
+	n := <-samples
+
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "anomalies_total"}, []string{"collector", "kind"})
+	if strictErr := rec.account("collect."+scraper.Name(), counter); err == nil {
+		err = strictErr
+	}
+	return n, testutil.ToFloat64(counter.WithLabelValues("collect."+scraper.Name(), anomalyUnparsedValue)), err
+}
+
+func TestAnomalyRecorderAccount(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		saved := *strictMode
+		*strictMode = strict
+
+		ctx, rec := withAnomalyRecorder(context.Background())
+		for i := 0; i < maxStrictAnomalies+2; i++ {
+			reportAnomaly(ctx, anomalyDuplicateKey, "key")
+		}
+		counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "anomalies_total"}, []string{"collector", "kind"})
+		err := rec.account("collect.test", counter)
+		*strictMode = saved
+
+		if got := testutil.ToFloat64(counter.WithLabelValues("collect.test", anomalyDuplicateKey)); got != maxStrictAnomalies+2 {
+			t.Errorf("strict %v: counted anomalies = %v, want %d", strict, got, maxStrictAnomalies+2)
+		}
+		if strict && !errors.Is(err, errStrict) {
+			t.Errorf("strict: error = %v, want a strict mode error", err)
+		}
+		if !strict && err != nil {
+			t.Errorf("lenient: error = %v, want nil", err)
+		}
+	}
+
+	// Without a recorder, e.g. outside a scrape, anomalies are ignored.
+	reportAnomaly(context.Background(), anomalyDuplicateKey, "key")
+}
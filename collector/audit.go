@@ -0,0 +1,367 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Audit of the statements sent to the database against the approved queries.
+
+package collector
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+var slowQueryThreshold = kingpin.Flag(
+	"exporter.slow-query-threshold",
+	"Statements sent by the exporter that run longer than this are counted as slow.",
+).Default("1s").Duration()
+
+// maxUnapprovedQueries bounds the distinct unapproved statements remembered.
+const maxUnapprovedQueries = 100
+
+// unapprovedQueryName is the query name of statements missing from the manifest.
+const unapprovedQueryName = "unapproved"
+
+// errUnapprovedStatement is returned for statements the audit blocked.
+var errUnapprovedStatement = errors.New("statement not in the approved query manifest")
+
+// queryDurationBuckets cover statements from 1ms to 30s.
+var queryDurationBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+
+// approvedQuery is a statement a collector is allowed to send. A %s in the
+// query stands for a database name. The name identifies the statement in
+// metrics instead of its text. write is set for statements modifying the
+// database.
+type approvedQuery struct {
+	collector string
+	name      string
+	query     string
+	write     bool
+}
+
+// approvedQueries is the manifest of every statement sent to the database.
+// Collectors adding a query must add it here; statements missing from it are
+// blocked.
+var approvedQueries = []approvedQuery{
+	{exporter, "version", versionQuery, false},
+	{brokerStatus, "broker_status", brokerStatusQuery, false},
+	{brokerServerPing, "broker_server_ping", brokerStatusQuery, false},
+	{statdump, "statdump", statdumpQuery, false},
+	{statdump, "database", inventoryDatabaseQuery, false},
+	{statdump, "database_list", databasesQuery, false},
+	{spacedbStatus, "spacedb", spacedbQuery, false},
+	{spacedbStatus, "database", inventoryDatabaseQuery, false},
+	{spacedbStatus, "database_list", databasesQuery, false},
+	{replicationApply, "replication_apply", replicationApplyQuery, false},
+	{sessionsByProgram, "sessions_by_program", sessionsByProgramQuery, false},
+	{inventory, "brokers", brokerStatusQuery, false},
+	{inventory, "databases", inventoryDatabaseQuery, false},
+	{inventory, "volumes", spacedbQuery, false},
+	{haStatus, "ha_status", haStatusQuery, false},
+	{haStatus, "ha_apply_delay", haApplyDelayQuery, false},
+}
+
+var whitespaceRE = regexp.MustCompile(`\s+`)
+
+// normalizeQuery collapses whitespace, so formatting does not matter.
+func normalizeQuery(query string) string {
+	return whitespaceRE.ReplaceAllString(strings.TrimSpace(query), " ")
+}
+
+// approvedQueryRE returns the pattern matching the normalized statements of
+// an approved query, with %s templated as a database name.
+func approvedQueryRE(query string) *regexp.Regexp {
+	parts := strings.Split(normalizeQuery(query), "%s")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	return regexp.MustCompile("(?i)^" + strings.Join(parts, `[A-Za-z0-9_.-]+`) + "$")
+}
+
+// QueryAuditEntry is an approved or unapproved statement and how often it ran.
+type QueryAuditEntry struct {
+	Collector  string `json:"collector"`
+	Name       string `json:"name,omitempty"`
+	Query      string `json:"query"`
+	Approved   bool   `json:"approved"`
+	Executions int    `json:"executions"`
+}
+
+// QueryAudit counts the statements sent through the database connections.
+// It implements prometheus.Collector for the unapproved statements and the
+// statement durations.
+type QueryAudit struct {
+	mu         sync.Mutex
+	queries    []approvedQuery
+	patterns   []*regexp.Regexp
+	approved   []int
+	unapproved map[QueryAuditEntry]int
+	// blocked counts the statements kept from the driver.
+	blocked    uint64
+	rejections *prometheus.CounterVec
+	duration   *prometheus.HistogramVec
+	slow       *prometheus.CounterVec
+}
+
+// Audit is the query audit of all connections.
+var Audit = newQueryAudit(approvedQueries)
+
+func newQueryAudit(queries []approvedQuery) *QueryAudit {
+	a := &QueryAudit{
+		queries:    append([]approvedQuery(nil), queries...),
+		approved:   make([]int, len(queries)),
+		unapproved: map[QueryAuditEntry]int{},
+		rejections: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: exporter,
+			Name:      "unapproved_queries_total",
+			Help:      "Total number of statements blocked from the database as they are not in the approved query manifest.",
+		}, []string{"collector"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: exporter,
+			Name:      "query_duration_seconds",
+			Help:      "Duration of the statements sent to the database, until their rows were read.",
+			Buckets:   queryDurationBuckets,
+		}, []string{"collector", "query_name"}),
+		slow: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: exporter,
+			Name:      "slow_queries_total",
+			Help:      "Total number of statements sent to the database that ran longer than --exporter.slow-query-threshold.",
+		}, []string{"collector", "query_name"}),
+	}
+	for _, q := range queries {
+		a.patterns = append(a.patterns, approvedQueryRE(q.query))
+	}
+	return a
+}
+
+// ApproveQuery adds a read statement sent outside the collectors, e.g. by
+// background work in package main, to the approved queries under name. A %s
+// in the query stands for a name like in the manifest.
+func (a *QueryAudit) ApproveQuery(collector, name, query string) {
+	a.approve(approvedQuery{collector, name, query, false})
+}
+
+// ApproveWrite adds a statement modifying the database like ApproveQuery.
+// The exporter is no longer read-only once a write is approved.
+func (a *QueryAudit) ApproveWrite(collector, name, query string) {
+	a.approve(approvedQuery{collector, name, query, true})
+}
+
+func (a *QueryAudit) approve(q approvedQuery) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.queries = append(a.queries, q)
+	a.patterns = append(a.patterns, approvedQueryRE(q.query))
+	a.approved = append(a.approved, 0)
+}
+
+// ReadOnly reports whether no approved statement modifies the database, so
+// that the wrapper blocks every write.
+func (a *QueryAudit) ReadOnly() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, q := range a.queries {
+		if q.write {
+			return false
+		}
+	}
+	return true
+}
+
+// Blocked returns the number of statements blocked so far.
+func (a *QueryAudit) Blocked() uint64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.blocked
+}
+
+// check records a statement sent by the collector running in ctx. It returns
+// the collector and name of the statement for its metrics, or an error
+// wrapping errUnapprovedStatement if the statement must not reach the driver.
+func (a *QueryAudit) check(ctx context.Context, query string) (string, string, error) {
+	normalized := normalizeQuery(query)
+	collector := "unknown"
+	if name, ok := ctx.Value(loggerKey{}).(string); ok {
+		collector = name
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	// A statement shared by several collectors is attributed to the running
+	// one if it approved it.
+	match := -1
+	for i, pattern := range a.patterns {
+		if !pattern.MatchString(normalized) {
+			continue
+		}
+		if match < 0 || a.queries[i].collector == collector {
+			match = i
+		}
+		if a.queries[i].collector == collector {
+			break
+		}
+	}
+	if match >= 0 {
+		a.approved[match]++
+		return a.queries[match].collector, a.queries[match].name, nil
+	}
+
+	log.Warnf("Blocked an unapproved query of collector %s: %s", collector, normalized)
+	a.blocked++
+	a.rejections.WithLabelValues(collector).Inc()
+	key := QueryAuditEntry{Collector: collector, Query: normalized}
+	if _, ok := a.unapproved[key]; ok || len(a.unapproved) < maxUnapprovedQueries {
+		a.unapproved[key]++
+	}
+	return collector, unapprovedQueryName, fmt.Errorf("%w: %s", errUnapprovedStatement, normalized)
+}
+
+// timed returns release extended to observe the duration of a statement
+// sent in ctx and started at start.
+func (a *QueryAudit) timed(ctx context.Context, collector, name string, start time.Time, release func()) func() {
+	target := targetFrom(ctx)
+	return func() {
+		d := time.Since(start)
+		a.duration.WithLabelValues(collector, name).Observe(d.Seconds())
+		if d > *slowQueryThreshold {
+			a.slow.WithLabelValues(collector, name).Inc()
+		}
+		Throttle.observeStatement(target, d)
+		release()
+	}
+}
+
+// Entries returns the approved queries followed by the unapproved
+// statements seen, with their execution counts.
+func (a *QueryAudit) Entries() []QueryAuditEntry {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	entries := make([]QueryAuditEntry, 0, len(a.queries)+len(a.unapproved))
+	for i, q := range a.queries {
+		entries = append(entries, QueryAuditEntry{Collector: q.collector, Name: q.name, Query: normalizeQuery(q.query), Approved: true, Executions: a.approved[i]})
+	}
+	var unapproved []QueryAuditEntry
+	for entry, n := range a.unapproved {
+		entry.Executions = n
+		unapproved = append(unapproved, entry)
+	}
+	sort.Slice(unapproved, func(i, j int) bool { return unapproved[i].Executions > unapproved[j].Executions })
+	return append(entries, unapproved...)
+}
+
+// Describe implements prometheus.Collector.
+func (a *QueryAudit) Describe(ch chan<- *prometheus.Desc) {
+	a.rejections.Describe(ch)
+	a.duration.Describe(ch)
+	a.slow.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (a *QueryAudit) Collect(ch chan<- prometheus.Metric) {
+	a.rejections.Collect(ch)
+	a.duration.Collect(ch)
+	a.slow.Collect(ch)
+}
+
+// auditConn checks every statement before passing it to the driver
+// connection, blocking unapproved ones, holds a slot of the query budget
+// while it runs and times it.
+type auditConn struct {
+	driver.Conn
+}
+
+func (c auditConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c auditConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	collector, name, err := Audit.check(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	release, err := QueryBudget.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	release = Audit.timed(ctx, collector, name, time.Now(), release)
+	var stmt driver.Stmt
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		stmt, err = p.PrepareContext(ctx, query)
+	} else {
+		stmt, err = c.Conn.Prepare(query)
+	}
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return budgetStmt{Stmt: stmt, release: release}, nil
+}
+
+func (c auditConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	q, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		// database/sql falls back to PrepareContext, which audits the query.
+		return nil, driver.ErrSkip
+	}
+	collector, name, err := Audit.check(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	release, err := QueryBudget.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	release = Audit.timed(ctx, collector, name, time.Now(), release)
+	rows, err := q.QueryContext(ctx, query, args)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return budgetRows{Rows: rows, release: release}, nil
+}
+
+func (c auditConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	e, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	collector, name, err := Audit.check(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	release, err := QueryBudget.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer Audit.timed(ctx, collector, name, time.Now(), release)()
+	return e.ExecContext(ctx, query, args)
+}
+
+func (c auditConn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// stubConn is a driver connection answering every query with no rows and
+// remembering the queries that reached it.
+type stubConn struct {
+	queries *[]string
+}
+
+func (c stubConn) Prepare(query string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c stubConn) Close() error                              { return nil }
+func (c stubConn) Begin() (driver.Tx, error)                 { return nil, errors.New("not supported") }
+
+func (c stubConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	*c.queries = append(*c.queries, query)
+	return stubRows{}, nil
+}
+
+type stubRows struct{}
+
+func (stubRows) Columns() []string              { return []string{"value"} }
+func (stubRows) Close() error                   { return nil }
+func (stubRows) Next(dest []driver.Value) error { return io.EOF }
+
+// stubConnector connects to a stubConn through the audit wrapper.
+type stubConnector struct {
+	queries *[]string
+}
+
+func (c stubConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return auditConn{stubConn{c.queries}}, nil
+}
+
+func (c stubConnector) Driver() driver.Driver { return nil }
+
+func TestAuditConn(t *testing.T) {
+	audit := Audit
+	Audit = newQueryAudit(approvedQueries)
+	defer func() { Audit = audit }()
+
+	var reached []string
+	db := sql.OpenDB(stubConnector{&reached})
+	defer db.Close()
+	ctx := withLogger(context.Background(), "audit_test")
+
+	rows, err := db.QueryContext(ctx, "SHOW  BROKERS")
+	if err != nil {
+		t.Fatalf("approved query failed: %s", err)
+	}
+	rows.Close()
+	if _, err := db.QueryContext(ctx, "SELECT password FROM db_user"); !errors.Is(err, errUnapprovedStatement) {
+		t.Errorf("unapproved query error = %v, want %v", err, errUnapprovedStatement)
+	}
+
+	if len(reached) != 1 || reached[0] != "SHOW  BROKERS" {
+		t.Errorf("queries reaching the driver = %q, want only the approved one", reached)
+	}
+	if Audit.Blocked() != 1 {
+		t.Errorf("blocked statements = %d, want 1", Audit.Blocked())
+	}
+	var unapproved []QueryAuditEntry
+	for _, entry := range Audit.Entries() {
+		switch {
+		case !entry.Approved:
+			unapproved = append(unapproved, entry)
+		case entry.Name == "broker_status" && entry.Executions != 1:
+			t.Errorf("executions of the approved query = %d, want 1", entry.Executions)
+		}
+	}
+	if want := (QueryAuditEntry{Collector: "audit_test", Query: "SELECT password FROM db_user", Executions: 1}); len(unapproved) != 1 || unapproved[0] != want {
+		t.Errorf("unapproved entries = %+v, want %+v", unapproved, want)
+	}
+}
+
+// durationBuckets returns the cumulative bucket counts of the duration
+// histogram of the broker status query by upper bound.
+func durationBuckets(t *testing.T) map[float64]uint64 {
+	var m dto.Metric
+	if err := Audit.duration.WithLabelValues(brokerStatus, "broker_status").(prometheus.Metric).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	buckets := map[float64]uint64{}
+	for _, b := range m.Histogram.Bucket {
+		buckets[b.GetUpperBound()] = b.GetCumulativeCount()
+	}
+	return buckets
+}
+
+// TestAuditQueryDuration runs a fast and a slow statement through the stub
+// driver and checks their buckets and the slow statement counter.
+func TestAuditQueryDuration(t *testing.T) {
+	d := &countingDriver{}
+	_, db := withCountingPool(t, d, 0)
+	threshold := *slowQueryThreshold
+	*slowQueryThreshold = 50 * time.Millisecond
+	defer func() { *slowQueryThreshold = threshold }()
+
+	for _, delay := range []time.Duration{0, 60 * time.Millisecond} {
+		d.delay = delay
+		rows, err := db.QueryContext(context.Background(), brokerStatusQuery)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows.Close()
+	}
+
+	buckets := durationBuckets(t)
+	if buckets[.025] != 1 || buckets[.05] != 1 || buckets[.1] != 2 {
+		t.Errorf("cumulative counts of the 25ms, 50ms and 100ms buckets = %d, %d, %d, want 1, 1, 2",
+			buckets[.025], buckets[.05], buckets[.1])
+	}
+	if got := testutil.ToFloat64(Audit.slow.WithLabelValues(brokerStatus, "broker_status")); got != 1 {
+		t.Errorf("slow_queries_total = %v, want 1", got)
+	}
+}
+
+// TestAuditAttribution scrapes collectors sharing statements through the
+// pool's countingConnector and checks that each execution is attributed to
+// the collector that ran it.
+func TestAuditAttribution(t *testing.T) {
+	d := &countingDriver{}
+	dsn, _ := withCountingPool(t, d, 0)
+
+	scrapers := []Scraper{ScrapeBrokerStatus{}, ScrapeBrokerServerPing{}, ScrapeInventory{}, ScrapeStatdump{}, ScrapeSpaceDBStatus{}}
+	collectExporter(New(context.Background(), dsn, NewMetrics(), scrapers, nil))
+
+	executions := map[string]int{}
+	for _, entry := range Audit.Entries() {
+		if !entry.Approved {
+			t.Errorf("unapproved statement of %s: %s", entry.Collector, entry.Query)
+		}
+		if entry.Executions > 0 {
+			executions[entry.Collector+"/"+entry.Name] += entry.Executions
+		}
+	}
+	// The stub answers the database lookups with no rows, so statdump and
+	// spacedb stop after them and the inventory skips the volumes.
+	want := map[string]int{
+		"exporter/version":                      1,
+		"broker_status/broker_status":           1,
+		"broker_server_ping/broker_server_ping": 1,
+		"inventory/brokers":                     1,
+		"inventory/databases":                   1,
+		"statdump/database":                     1,
+		"spacedb/database":                      1,
+	}
+	if !reflect.DeepEqual(executions, want) {
+		t.Errorf("executions = %v, want %v", executions, want)
+	}
+	if reached, _ := d.counts(); reached != len(want) {
+		t.Errorf("statements reaching the driver = %d, want %d", reached, len(want))
+	}
+}
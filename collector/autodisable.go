@@ -0,0 +1,201 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Temporary disabling of collectors that keep failing.
+
+package collector
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	autoDisableAfter = kingpin.Flag(
+		"exporter.auto-disable-after",
+		"Disable a collector for a cool-down period after this many consecutive failures or timeouts. 0 never disables collectors.",
+	).Default("0").Int()
+	autoDisableCooldown = kingpin.Flag(
+		"exporter.auto-disable-cooldown",
+		"Initial cool-down period of an auto-disabled collector. It doubles with every failed probe run.",
+	).Default("1m").Duration()
+	autoDisableMaxCooldown = kingpin.Flag(
+		"exporter.auto-disable-max-cooldown",
+		"Maximum cool-down period of an auto-disabled collector.",
+	).Default("30m").Duration()
+)
+
+// essentialCollectors are never auto-disabled, as basic broker health relies on them.
+var essentialCollectors = map[string]bool{
+	brokerStatus: true,
+}
+
+// Metric descriptors.
+var (
+	autoDisabledDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, exporter, "collector_auto_disabled"),
+		"Whether the collector is disabled after consecutive failures (1 for disabled).",
+		[]string{"collector"}, nil,
+	)
+	autoDisableCooldownDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, exporter, "collector_auto_disable_cooldown_seconds"),
+		"Remaining cool-down time until the auto-disabled collector is probed again.",
+		[]string{"collector"}, nil,
+	)
+)
+
+type autoDisableEntry struct {
+	failures      int
+	cooldown      time.Duration
+	disabledUntil time.Time
+	probing       bool
+}
+
+// autoDisableState is shared between scrapes, as the Exporter is created per
+// request. It is kept per target, so a failing probe target does not disable
+// the collector for the other targets.
+var autoDisableState = struct {
+	sync.Mutex
+	// targets maps a target to the entries of its collectors.
+	targets map[string]map[string]*autoDisableEntry
+	// disables counts how often each collector was disabled on any target.
+	disables map[string]int
+}{targets: map[string]map[string]*autoDisableEntry{}, disables: map[string]int{}}
+
+// autoDisableAllows reports whether the collector may run against the target.
+// Once the cool-down expired the collector runs as a probe.
+func autoDisableAllows(target, collector string, now time.Time) bool {
+	autoDisableState.Lock()
+	defer autoDisableState.Unlock()
+	entry, ok := autoDisableState.targets[target][collector]
+	if !ok || entry.disabledUntil.IsZero() {
+		return true
+	}
+	if now.Before(entry.disabledUntil) {
+		return false
+	}
+	entry.probing = true
+	return true
+}
+
+// autoDisableRecord records the outcome of a collector run against the target.
+func autoDisableRecord(target, collector string, err error, now time.Time) {
+	if *autoDisableAfter <= 0 || essentialCollectors[collector] {
+		return
+	}
+	autoDisableState.Lock()
+	defer autoDisableState.Unlock()
+	collectors, ok := autoDisableState.targets[target]
+	if !ok {
+		collectors = map[string]*autoDisableEntry{}
+		autoDisableState.targets[target] = collectors
+	}
+	entry, ok := collectors[collector]
+	if !ok {
+		entry = &autoDisableEntry{}
+		collectors[collector] = entry
+	}
+
+	if err == nil {
+		if !entry.disabledUntil.IsZero() {
+			log.Infof("Collector %s succeeded again on %s, re-enabling it", collector, target)
+		}
+		*entry = autoDisableEntry{}
+		return
+	}
+
+	entry.failures++
+	switch {
+	case entry.probing:
+		entry.cooldown *= 2
+		if entry.cooldown > *autoDisableMaxCooldown {
+			entry.cooldown = *autoDisableMaxCooldown
+		}
+	case entry.failures >= *autoDisableAfter:
+		entry.cooldown = *autoDisableCooldown
+	default:
+		return
+	}
+	entry.probing = false
+	entry.disabledUntil = now.Add(entry.cooldown)
+	autoDisableState.disables[collector]++
+	log.Warnf("Collector %s failed %d consecutive times on %s, disabling it for %s", collector, entry.failures, target, entry.cooldown)
+}
+
+// ReenableCollector clears the auto-disable state of the collector on every
+// target and reports whether it was disabled on any of them.
+func ReenableCollector(collector string) bool {
+	autoDisableState.Lock()
+	defer autoDisableState.Unlock()
+	disabled := false
+	for _, collectors := range autoDisableState.targets {
+		entry, ok := collectors[collector]
+		if !ok {
+			continue
+		}
+		delete(collectors, collector)
+		disabled = disabled || !entry.disabledUntil.IsZero()
+	}
+	return disabled
+}
+
+// AutoDisabledCollectors returns the number of collectors currently
+// auto-disabled, counting each target separately.
+func AutoDisabledCollectors() int {
+	autoDisableState.Lock()
+	defer autoDisableState.Unlock()
+	now := time.Now()
+	n := 0
+	for _, collectors := range autoDisableState.targets {
+		for _, entry := range collectors {
+			if now.Before(entry.disabledUntil) {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// autoDisableCounts returns how often each collector was auto-disabled.
+func autoDisableCounts() map[string]int {
+	autoDisableState.Lock()
+	defer autoDisableState.Unlock()
+	counts := make(map[string]int, len(autoDisableState.disables))
+	for collector, n := range autoDisableState.disables {
+		counts[collector] = n
+	}
+	return counts
+}
+
+// collectAutoDisable sends the auto-disable state of the scrapers on the target.
+func collectAutoDisable(target string, scrapers []Scraper, ch chan<- prometheus.Metric) {
+	if *autoDisableAfter <= 0 {
+		return
+	}
+	autoDisableState.Lock()
+	defer autoDisableState.Unlock()
+	now := time.Now()
+	for _, scraper := range scrapers {
+		label := "collect." + scraper.Name()
+		disabled, remaining := 0.0, 0.0
+		if entry, ok := autoDisableState.targets[target][scraper.Name()]; ok && now.Before(entry.disabledUntil) {
+			disabled, remaining = 1, entry.disabledUntil.Sub(now).Seconds()
+		}
+		ch <- prometheus.MustNewConstMetric(autoDisabledDesc, prometheus.GaugeValue, disabled, label)
+		ch <- prometheus.MustNewConstMetric(autoDisableCooldownDesc, prometheus.GaugeValue, remaining, label)
+	}
+}
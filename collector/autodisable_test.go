@@ -0,0 +1,156 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// withAutoDisable enables auto-disabling after two failures with a cool-down
+// of a minute, starting from a clean state.
+func withAutoDisable(t *testing.T) {
+	after, cooldown, maxCooldown := *autoDisableAfter, *autoDisableCooldown, *autoDisableMaxCooldown
+	*autoDisableAfter, *autoDisableCooldown, *autoDisableMaxCooldown = 2, time.Minute, 3*time.Minute
+	reset := func() {
+		autoDisableState.Lock()
+		autoDisableState.targets = map[string]map[string]*autoDisableEntry{}
+		autoDisableState.disables = map[string]int{}
+		autoDisableState.Unlock()
+	}
+	reset()
+	t.Cleanup(func() {
+		*autoDisableAfter, *autoDisableCooldown, *autoDisableMaxCooldown = after, cooldown, maxCooldown
+		reset()
+	})
+}
+
+func TestAutoDisableConsecutiveFailures(t *testing.T) {
+	withAutoDisable(t)
+	const target = "db1:33000:demodb"
+	now := time.Now()
+	failure := errors.New("failed")
+
+	autoDisableRecord(target, "spacedb", failure, now)
+	autoDisableRecord(target, "spacedb", nil, now)
+	autoDisableRecord(target, "spacedb", failure, now)
+	if !autoDisableAllows(target, "spacedb", now) {
+		t.Fatal("collector disabled although its failures were not consecutive")
+	}
+	autoDisableRecord(target, "spacedb", failure, now)
+	if autoDisableAllows(target, "spacedb", now) {
+		t.Fatal("collector still enabled after two consecutive failures")
+	}
+	if got := autoDisableCounts()["spacedb"]; got != 1 {
+		t.Errorf("disable count = %d, want 1", got)
+	}
+}
+
+func TestAutoDisableCooldownProbe(t *testing.T) {
+	withAutoDisable(t)
+	const target = "db1:33000:demodb"
+	now := time.Now()
+	failure := errors.New("failed")
+
+	autoDisableRecord(target, "spacedb", failure, now)
+	autoDisableRecord(target, "spacedb", failure, now)
+
+	// The failed probe after the cool-down doubles it.
+	now = now.Add(time.Minute)
+	if !autoDisableAllows(target, "spacedb", now) {
+		t.Fatal("collector not probed after the cool-down")
+	}
+	autoDisableRecord(target, "spacedb", failure, now)
+	if autoDisableAllows(target, "spacedb", now.Add(time.Minute)) {
+		t.Fatal("collector probed before the doubled cool-down expired")
+	}
+
+	// The cool-down is capped at the maximum.
+	now = now.Add(2 * time.Minute)
+	autoDisableAllows(target, "spacedb", now)
+	autoDisableRecord(target, "spacedb", failure, now)
+	if !autoDisableAllows(target, "spacedb", now.Add(3*time.Minute)) {
+		t.Fatal("cool-down exceeds the maximum")
+	}
+
+	// A successful probe re-enables the collector.
+	autoDisableRecord(target, "spacedb", nil, now)
+	if !autoDisableAllows(target, "spacedb", now) {
+		t.Fatal("collector still disabled after a successful probe")
+	}
+}
+
+func TestAutoDisableReenable(t *testing.T) {
+	withAutoDisable(t)
+	now := time.Now()
+	failure := errors.New("failed")
+
+	for _, target := range []string{"db1:33000:demodb", "db2:33000:demodb"} {
+		autoDisableRecord(target, "spacedb", failure, now)
+		autoDisableRecord(target, "spacedb", failure, now)
+	}
+	if got := AutoDisabledCollectors(); got != 2 {
+		t.Errorf("auto-disabled collectors = %d, want 2", got)
+	}
+	if !ReenableCollector("spacedb") {
+		t.Error("ReenableCollector did not report the disabled collector")
+	}
+	if got := AutoDisabledCollectors(); got != 0 {
+		t.Errorf("auto-disabled collectors after re-enabling = %d, want 0", got)
+	}
+	if ReenableCollector("spacedb") {
+		t.Error("ReenableCollector reported a collector that is not disabled")
+	}
+}
+
+func TestAutoDisableEssential(t *testing.T) {
+	withAutoDisable(t)
+	const target = "db1:33000:demodb"
+	now := time.Now()
+
+	for i := 0; i < 5; i++ {
+		autoDisableRecord(target, brokerStatus, errors.New("failed"), now)
+	}
+	if !autoDisableAllows(target, brokerStatus, now) {
+		t.Error("essential collector was auto-disabled")
+	}
+}
+
+func TestAutoDisablePerTarget(t *testing.T) {
+	withAutoDisable(t)
+	now := time.Now()
+
+	autoDisableRecord("probed:33000:demodb", "spacedb", errors.New("failed"), now)
+	autoDisableRecord("probed:33000:demodb", "spacedb", errors.New("failed"), now)
+	if autoDisableAllows("probed:33000:demodb", "spacedb", now) {
+		t.Error("collector still enabled on the failing target")
+	}
+	if !autoDisableAllows("main:33000:demodb", "spacedb", now) {
+		t.Error("failures of one target disabled the collector on another")
+	}
+}
+
+func TestDSNTarget(t *testing.T) {
+	for dsn, want := range map[string]string{
+		"cci:cubrid:DB1:33000:demodb:dba:secret:": "db1:33000:demodb",
+		"cci:cubrid:db1:33000:demodb:::":          "db1:33000:demodb",
+		SimulatedDSN:                              SimulatedDSN,
+		"":                                        "",
+	} {
+		if got := dsnTarget(dsn); got != want {
+			t.Errorf("dsnTarget(%q) = %q, want %q", dsn, got, want)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Scrape CUBRID broker-to-server ping failures.
+
+package collector
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	brokerServerPing = "broker_server_ping"
+
+	// brokerPingFailuresColumn is the column of brokerStatusQuery counting
+	// the failed keepalive pings from the broker to the database server.
+	// Brokers not reporting their server connectivity lack it.
+	brokerPingFailuresColumn = "ping_failures"
+)
+
+// Metric descriptors.
+var (
+	BrokerServerPingFailures = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "broker", "server_ping_failures_total"),
+		"Total number of failed keepalive pings from the broker to the database server.",
+		[]string{"broker_name"}, nil,
+	)
+)
+
+// ScrapeBrokerServerPing collects broker-to-server keepalive failures.
+type ScrapeBrokerServerPing struct{}
+
+// Name of the Scraper. Should be unique.
+func (ScrapeBrokerServerPing) Name() string {
+	return brokerServerPing
+}
+
+// Help describes the role of the Scraper.
+func (ScrapeBrokerServerPing) Help() string {
+	return "Scrape broker-to-server ping failures from brokerStatusQuery"
+}
+
+// Version of CUBRID from which scraper is available.
+func (ScrapeBrokerServerPing) Version() float64 {
+	return 11.0
+}
+
+// Scrape collects data from database connection and sends it over channel as
+// prometheus metric. It reads the broker status like ScrapeBrokerStatus and
+// sends nothing if the brokers do not report their ping failures.
+func (ScrapeBrokerServerPing) Scrape(ctx context.Context, db *sql.DB, ch chan<- prometheus.Metric) error {
+
+	pingRows, err := db.QueryContext(ctx, brokerStatusQuery)
+	if err != nil {
+		return err
+	}
+
+	defer pingRows.Close()
+
+	columns, err := pingRows.Columns()
+	if err != nil {
+		return err
+	}
+	failuresColumn := -1
+	for i, column := range columns {
+		if strings.ToLower(column) == brokerPingFailuresColumn {
+			failuresColumn = i
+		}
+	}
+	if failuresColumn < 0 {
+		loggerFrom(ctx).Debugf("The brokers do not report %s", brokerPingFailuresColumn)
+		return nil
+	}
+
+	// The broker name is the first column, as for ScrapeBrokerStatus.
+	values := make([]sql.NullString, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+
+	for pingRows.Next() {
+
+		err := pingRows.Scan(dest...)
+		if err != nil {
+			return err
+		}
+		broker_name, ping_failures := brokerLabel(values[0].String), values[failuresColumn].String
+		if ping_failures == brokerStatusUnavailable {
+			continue
+		}
+
+		count, err := strconv.ParseFloat(ping_failures, 64)
+		if err != nil {
+			reportAnomaly(ctx, anomalyUnparsedValue, fmt.Sprintf("ping failures of broker %s: %q", broker_name, ping_failures))
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(BrokerServerPingFailures, prometheus.CounterValue, count, broker_name)
+	}
+
+	return pingRows.Err()
+}
+
+// check interface
+var _ Scraper = ScrapeBrokerServerPing{}
@@ -0,0 +1,141 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// pingTestRows returns the broker status of brokers reporting their ping
+// failures, given as pairs of broker name and failure count.
+func pingTestRows(failures ...string) *sqlmock.Rows {
+	rows := sqlmock.NewRows(append(brokerTestColumns(), brokerPingFailuresColumn))
+	for i := 0; i < len(failures); i += 2 {
+		row := []driver.Value{failures[i]}
+		for range brokerStatusFields {
+			row = append(row, "1")
+		}
+		rows.AddRow(append(row, failures[i+1])...)
+	}
+	return rows
+}
+
+func TestScrapeBrokerServerPing(t *testing.T) {
+	db, mock := newMock(t)
+	defer db.Close()
+
+	mock.ExpectQuery(brokerStatusQuery).WillReturnRows(pingTestRows(" query_editor ", "3", "broker1", "0", "stopped", "-"))
+
+	expected := `
+# HELP cubrid_broker_server_ping_failures_total Total number of failed keepalive pings from the broker to the database server.
+# TYPE cubrid_broker_server_ping_failures_total counter
+cubrid_broker_server_ping_failures_total{broker_name="broker1"} 0
+cubrid_broker_server_ping_failures_total{broker_name="query_editor"} 3
+`
+	if err := testutil.CollectAndCompare(scrapeCollector{t, ScrapeBrokerServerPing{}, db}, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+// TestScrapeBrokerServerPingNotReported checks that nothing is sent for
+// brokers not reporting their ping failures.
+func TestScrapeBrokerServerPingNotReported(t *testing.T) {
+	db, mock := newMock(t)
+	defer db.Close()
+
+	mock.ExpectQuery(brokerStatusQuery).WillReturnRows(sqlmock.NewRows(brokerTestColumns()).
+		AddRow("broker1", "1", "1", "30000", "0", "0", "0", "0", "0", "0", "0", "0", "0", "0", "0"))
+
+	if n := testutil.CollectAndCount(scrapeCollector{t, ScrapeBrokerServerPing{}, db}); n != 0 {
+		t.Errorf("samples = %d, want 0", n)
+	}
+}
+
+// TestScrapeBrokerStatusPingColumn checks that the broker status collector
+// accepts the ping failures column.
+func TestScrapeBrokerStatusPingColumn(t *testing.T) {
+	db, mock := newMock(t)
+	defer db.Close()
+
+	mock.ExpectQuery(brokerStatusQuery).WillReturnRows(pingTestRows("broker1", "3"))
+
+	if err := drainScrape(ScrapeBrokerStatus{}, db); err != nil {
+		t.Errorf("error scraping the broker status with ping failures: %s", err)
+	}
+}
+
+// TestScrapeBrokerServerPingUnparsedValue checks that a failure count that
+// does not parse is counted as an anomaly, failing the collector only in
+// strict mode, and that the other brokers are still sent.
+func TestScrapeBrokerServerPingUnparsedValue(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		db, mock := newMock(t)
+		mock.ExpectQuery(brokerStatusQuery).WillReturnRows(pingTestRows("query_editor", "3", "broker1", "n/a"))
+
+		samples, anomalies, err := scrapeAnomalies(ScrapeBrokerServerPing{}, db, strict)
+		db.Close()
+		if samples != 1 {
+			t.Errorf("strict %v: samples = %d, want 1", strict, samples)
+		}
+		if anomalies != 1 {
+			t.Errorf("strict %v: anomalies = %v, want 1", strict, anomalies)
+		}
+		if strict && !errors.Is(err, errStrict) {
+			t.Errorf("strict: error = %v, want a strict mode error", err)
+		}
+		if !strict && err != nil {
+			t.Errorf("lenient: error = %v, want nil", err)
+		}
+	}
+}
+
+// TestAuditSharedQuery checks that the broker status statement is attributed
+// to the collector sending it.
+func TestAuditSharedQuery(t *testing.T) {
+	audit := Audit
+	Audit = newQueryAudit(approvedQueries)
+	defer func() { Audit = audit }()
+
+	var reached []string
+	db := sql.OpenDB(stubConnector{&reached})
+	defer db.Close()
+	for _, collector := range []string{brokerStatus, brokerServerPing, brokerServerPing} {
+		rows, err := db.QueryContext(withLogger(context.Background(), collector), brokerStatusQuery)
+		if err != nil {
+			t.Fatalf("query of %s failed: %s", collector, err)
+		}
+		rows.Close()
+	}
+
+	executions := map[string]int{}
+	for _, entry := range Audit.Entries() {
+		if entry.Approved {
+			executions[entry.Collector] += entry.Executions
+		}
+	}
+	if executions[brokerStatus] != 1 || executions[brokerServerPing] != 2 {
+		t.Errorf("executions by collector = %v, want %s 1 and %s 2", executions, brokerStatus, brokerServerPing)
+	}
+}
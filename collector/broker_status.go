@@ -18,8 +18,13 @@ package collector
 import (
 	"context"
 	"database/sql"
-
+	"encoding/json"
+	"fmt"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
@@ -30,21 +35,186 @@ const (
 	brokerStatusQuery = "show brokers"
 )
 
-// Metric descriptors.
-var (
-	BrokersInfo = prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, "broker_status", "info"),
-		"Information about CUBRID Broker Status",
-		[]string{"broker_name", "num_as", "pid", "port", "qsize", "num_select", "num_insert", "num_update", "num_delete", "num_trans", "num_conns"}, nil,
-	)
+// brokerStatusField is a numeric column of the broker status output.
+type brokerStatusField struct {
+	column    string
+	desc      *prometheus.Desc
+	valueType prometheus.ValueType
+}
 
-	BrokerInfo = prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, "broker_status", "info"),
-		"Information about CUBRID Broker Status",
-		[]string{"broker_name", "key"}, nil,
+func newBrokerStatusDesc(column, help string) *prometheus.Desc {
+	return prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "broker_status", column),
+		help, []string{"broker_name"}, nil,
 	)
+}
+
+// brokerStatusFields lists the numeric columns following the broker name, in
+// the order brokerStatusQuery returns them.
+var brokerStatusFields = []brokerStatusField{
+	{"num_as", newBrokerStatusDesc("num_as", "Number of CAS processes of the broker."), prometheus.GaugeValue},
+	{"pid", newBrokerStatusDesc("pid", "Process ID of the broker."), prometheus.GaugeValue},
+	{"port", newBrokerStatusDesc("port", "Port the broker listens on."), prometheus.GaugeValue},
+	{"qsize", newBrokerStatusDesc("qsize", "Number of requests waiting in the broker job queue."), prometheus.GaugeValue},
+	{"num_select", newBrokerStatusDesc("num_select", "Number of SELECT statements processed by the broker."), prometheus.CounterValue},
+	{"num_insert", newBrokerStatusDesc("num_insert", "Number of INSERT statements processed by the broker."), prometheus.CounterValue},
+	{"num_update", newBrokerStatusDesc("num_update", "Number of UPDATE statements processed by the broker."), prometheus.CounterValue},
+	{"num_delete", newBrokerStatusDesc("num_delete", "Number of DELETE statements processed by the broker."), prometheus.CounterValue},
+	{"num_trans", newBrokerStatusDesc("num_trans", "Number of transactions processed by the broker."), prometheus.CounterValue},
+	{"num_query", newBrokerStatusDesc("num_query", "Number of queries processed by the broker."), prometheus.CounterValue},
+	{"num_conns", newBrokerStatusDesc("num_conns", "Number of client connections accepted by the broker."), prometheus.CounterValue},
+	{"num_long_query", newBrokerStatusDesc("num_long_query", "Number of queries exceeding the long query time."), prometheus.CounterValue},
+	{"num_error_query", newBrokerStatusDesc("num_error_query", "Number of queries that failed with an error."), prometheus.CounterValue},
+	{"num_uniq_error", newBrokerStatusDesc("num_uniq_error", "Number of unique constraint violations."), prometheus.CounterValue},
+}
+
+// brokerStatementsDesc counts the statements of a broker by class. A broker
+// reports either every class or, when only the combined count is available,
+// class="all", never a mixture of both.
+var brokerStatementsDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "broker", "statements_total"),
+	"Number of statements processed by the broker by class; class=\"all\" if the broker reports only the combined count.",
+	[]string{"broker_name", "class"}, nil,
+)
+
+var brokerPortChangedDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "broker", "port_changed_total"),
+	"Number of times the broker was seen on a port other than the one it used before.",
+	[]string{"broker_name"}, nil,
 )
 
+// brokerPorts remembers the last port of every broker, including brokers that
+// disappeared, when it was last seen and how often it changed its port. It is
+// shared between scrapes, as the Exporter is created per request, and
+// persisted in the state file. Brokers are keyed by target and name, as
+// brokers of different targets may share a name.
+var brokerPorts = struct {
+	sync.Mutex
+	ports   map[string]float64
+	seen    map[string]time.Time
+	changes map[string]float64
+}{ports: map[string]float64{}, seen: map[string]time.Time{}, changes: map[string]float64{}}
+
+// observeBrokerPort records the port of broker and returns how often the
+// broker changed its port. A broker seen for the first time did not change it.
+func observeBrokerPort(ctx context.Context, broker string, port float64) float64 {
+	key := targetScoped(ctx, broker)
+	brokerPorts.Lock()
+	last, known := brokerPorts.ports[key]
+	changed := known && last != port
+	if changed {
+		brokerPorts.changes[key]++
+	}
+	brokerPorts.ports[key] = port
+	brokerPorts.seen[key] = time.Now()
+	changes := brokerPorts.changes[key]
+	brokerPorts.Unlock()
+
+	if changed {
+		loggerFrom(ctx).Warnf("Broker %s changed its port from %.0f to %.0f", broker, last, port)
+	}
+	if !known || changed {
+		markStateDirty()
+	}
+	return changes
+}
+
+// brokerPortState persists the remembered broker ports. It evicts the brokers
+// not seen for the longest time first and forgets them.
+type brokerPortState struct{}
+
+// brokerPortEntry is the persisted port of a broker.
+type brokerPortEntry struct {
+	Port float64 `json:"port"`
+	// Seen is the Unix time the broker was last seen.
+	Seen int64 `json:"seen"`
+}
+
+// Section implements StateSaver.
+func (brokerPortState) Section() string {
+	return "broker_ports"
+}
+
+// Version implements StateSaver. Version 2 keys the brokers by target.
+func (brokerPortState) Version() uint16 {
+	return 2
+}
+
+// MarshalState implements StateSaver.
+func (brokerPortState) MarshalState(maxBytes int) ([]byte, int, error) {
+	brokerPorts.Lock()
+	entries := make(map[string]json.RawMessage, len(brokerPorts.ports))
+	evict := make([]string, 0, len(brokerPorts.ports))
+	for broker, port := range brokerPorts.ports {
+		entry, err := json.Marshal(brokerPortEntry{Port: port, Seen: brokerPorts.seen[broker].Unix()})
+		if err != nil {
+			brokerPorts.Unlock()
+			return nil, 0, err
+		}
+		entries[broker] = entry
+		evict = append(evict, broker)
+	}
+	sort.Slice(evict, func(i, j int) bool { return brokerPorts.seen[evict[i]].Before(brokerPorts.seen[evict[j]]) })
+	brokerPorts.Unlock()
+
+	data, evicted, err := marshalWithinBudget(entries, evict, maxBytes)
+	// Evicted brokers are forgotten, so they are not evicted again on every save.
+	brokerPorts.Lock()
+	defer brokerPorts.Unlock()
+	for _, broker := range evict[:evicted] {
+		delete(brokerPorts.ports, broker)
+		delete(brokerPorts.seen, broker)
+		delete(brokerPorts.changes, broker)
+	}
+	return data, evicted, err
+}
+
+// UnmarshalState implements StateSaver.
+func (brokerPortState) UnmarshalState(data []byte) error {
+	var entries map[string]brokerPortEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+	brokerPorts.Lock()
+	defer brokerPorts.Unlock()
+	brokerPorts.ports = make(map[string]float64, len(entries))
+	brokerPorts.seen = make(map[string]time.Time, len(entries))
+	for broker, entry := range entries {
+		brokerPorts.ports[broker] = entry.Port
+		brokerPorts.seen[broker] = time.Unix(entry.Seen, 0)
+	}
+	return nil
+}
+
+// brokerStatementClasses maps the statement classes to the columns counting them.
+var brokerStatementClasses = []struct {
+	class, column string
+}{
+	{"select", "num_select"},
+	{"insert", "num_insert"},
+	{"update", "num_update"},
+	{"delete", "num_delete"},
+}
+
+// brokerStatusDesc returns the descriptor of a broker status column.
+func brokerStatusDesc(column string) *prometheus.Desc {
+	for _, field := range brokerStatusFields {
+		if field.column == column {
+			return field.desc
+		}
+	}
+	return nil
+}
+
+// brokerStatusUnavailable is reported instead of a number by brokers that are OFF.
+const brokerStatusUnavailable = "-"
+
+// brokerLabel returns the broker_name label value for a broker as reported by
+// the server. Every broker series must use it so they join in PromQL.
+func brokerLabel(name string) string {
+	return strings.TrimSpace(name)
+}
+
 // ScrapeBrokerStatus
 type ScrapeBrokerStatus struct{}
 
@@ -74,72 +244,88 @@ func (ScrapeBrokerStatus) Scrape(ctx context.Context, db *sql.DB, ch chan<- prom
 	defer brokerStatusRows.Close()
 
 	var broker_name string
-	var num_as string
-	var pid string
-	var port string
-	var qsize string
-	var num_select string
-	var num_insert string
-	var num_update string
-	var num_delete string
-	var num_trans string
-	var num_query string
-	var num_conns string
-	var num_long_query string
-	var num_error_query string
-	var num_uniq_error string
+	values := make([]string, len(brokerStatusFields))
+	dest := []interface{}{&broker_name}
+	for i := range values {
+		dest = append(dest, &values[i])
+	}
+
+	// Columns beyond the broker name and the known fields fail the Scan
+	// below, but still show up in the coverage report. The ping failures of
+	// brokers reporting them are sent by broker_server_ping.
+	if columns, err := brokerStatusRows.Columns(); err == nil {
+		consumed := 1 + len(brokerStatusFields)
+		if len(columns) == consumed+1 && columns[consumed] == brokerPingFailuresColumn {
+			consumed++
+			dest = append(dest, new(sql.RawBytes))
+		}
+		Coverage.observeColumns(coverageBroker, columns, consumed)
+	}
 
 	for brokerStatusRows.Next() {
 
-		err := brokerStatusRows.Scan(&broker_name, &num_as, &pid, &port, &qsize, &num_select, &num_insert, &num_update, &num_delete, &num_trans, &num_query, &num_conns, &num_long_query, &num_error_query, &num_uniq_error)
+		err := brokerStatusRows.Scan(dest...)
 		if err != nil {
 			return err
 		}
+		broker_name = brokerLabel(broker_name)
+
+		parsed := make(map[string]float64, len(brokerStatusFields))
+		for i, field := range brokerStatusFields {
+			if values[i] == brokerStatusUnavailable {
+				continue
+			}
+			value, err := strconv.ParseFloat(values[i], 64)
+			if err != nil {
+				reportAnomaly(ctx, anomalyUnparsedValue, fmt.Sprintf("%s of broker %s: %q", field.column, broker_name, values[i]))
+				continue
+			}
+			parsed[field.column] = value
+			ch <- prometheus.MustNewConstMetric(field.desc, field.valueType, value, broker_name)
+		}
+		sendBrokerStatements(ctx, broker_name, parsed, ch)
+		if port, ok := parsed["port"]; ok {
+			ch <- prometheus.MustNewConstMetric(brokerPortChangedDesc, prometheus.CounterValue, observeBrokerPort(ctx, broker_name, port), broker_name)
+		}
+	}
 
-		count, _ := strconv.ParseFloat(num_as, 64)
-		ch <- prometheus.MustNewConstMetric(BrokerInfo, prometheus.GaugeValue, count, broker_name, "num_as")
-
-		count, _ = strconv.ParseFloat(pid, 64)
-		ch <- prometheus.MustNewConstMetric(BrokerInfo, prometheus.GaugeValue, count, broker_name, "pid")
-
-		count, _ = strconv.ParseFloat(port, 64)
-		ch <- prometheus.MustNewConstMetric(BrokerInfo, prometheus.GaugeValue, count, broker_name, "port")
-
-		count, _ = strconv.ParseFloat(qsize, 64)
-		ch <- prometheus.MustNewConstMetric(BrokerInfo, prometheus.GaugeValue, count, broker_name, "qsize")
-
-		count, _ = strconv.ParseFloat(num_select, 64)
-		ch <- prometheus.MustNewConstMetric(BrokerInfo, prometheus.GaugeValue, count, broker_name, "num_select")
-
-		count, _ = strconv.ParseFloat(num_insert, 64)
-		ch <- prometheus.MustNewConstMetric(BrokerInfo, prometheus.GaugeValue, count, broker_name, "num_insert")
-
-		count, _ = strconv.ParseFloat(num_update, 64)
-		ch <- prometheus.MustNewConstMetric(BrokerInfo, prometheus.GaugeValue, count, broker_name, "num_update")
-
-		count, _ = strconv.ParseFloat(num_delete, 64)
-		ch <- prometheus.MustNewConstMetric(BrokerInfo, prometheus.GaugeValue, count, broker_name, "num_delete")
-
-		count, _ = strconv.ParseFloat(num_trans, 64)
-		ch <- prometheus.MustNewConstMetric(BrokerInfo, prometheus.GaugeValue, count, broker_name, "num_trans")
-
-		count, _ = strconv.ParseFloat(num_query, 64)
-		ch <- prometheus.MustNewConstMetric(BrokerInfo, prometheus.GaugeValue, count, broker_name, "num_query")
-
-		count, _ = strconv.ParseFloat(num_conns, 64)
-		ch <- prometheus.MustNewConstMetric(BrokerInfo, prometheus.GaugeValue, count, broker_name, "num_conns")
-
-		count, _ = strconv.ParseFloat(num_long_query, 64)
-		ch <- prometheus.MustNewConstMetric(BrokerInfo, prometheus.GaugeValue, count, broker_name, "num_long_query")
-
-		count, _ = strconv.ParseFloat(num_error_query, 64)
-		ch <- prometheus.MustNewConstMetric(BrokerInfo, prometheus.GaugeValue, count, broker_name, "num_error_query")
+	return brokerStatusRows.Err()
+}
 
-		count, _ = strconv.ParseFloat(num_uniq_error, 64)
-		ch <- prometheus.MustNewConstMetric(BrokerInfo, prometheus.GaugeValue, count, broker_name, "num_uniq_error")
+// sendBrokerStatements sends the statement counts of a broker by class if
+// every class column is available, and the combined count as class="all"
+// otherwise. "other" holds the statements of num_query outside the classes.
+func sendBrokerStatements(ctx context.Context, broker string, values map[string]float64, ch chan<- prometheus.Metric) {
+	total, hasTotal := values["num_query"]
+	perClass := true
+	for _, c := range brokerStatementClasses {
+		if _, ok := values[c.column]; !ok {
+			perClass = false
+		}
 	}
 
-	return nil
+	switch {
+	case perClass:
+		loggerFrom(ctx).Debugf("Reporting statements of broker %s per class", broker)
+		sum := 0.0
+		for _, c := range brokerStatementClasses {
+			sum += values[c.column]
+			ch <- prometheus.MustNewConstMetric(brokerStatementsDesc, prometheus.CounterValue, values[c.column], broker, c.class)
+		}
+		if hasTotal {
+			// The counters are read one after another, so num_query may lag behind the classes.
+			other := total - sum
+			if other < 0 {
+				other = 0
+			}
+			ch <- prometheus.MustNewConstMetric(brokerStatementsDesc, prometheus.CounterValue, other, broker, "other")
+		}
+	case hasTotal:
+		loggerFrom(ctx).Debugf("Reporting statements of broker %s combined, per class counts are unavailable", broker)
+		ch <- prometheus.MustNewConstMetric(brokerStatementsDesc, prometheus.CounterValue, total, broker, "all")
+	default:
+		loggerFrom(ctx).Debugf("Statement counts of broker %s are unavailable", broker)
+	}
 }
 
 // check interface
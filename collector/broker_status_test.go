@@ -0,0 +1,356 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// brokerTestColumns are the columns of SHOW BROKERS.
+func brokerTestColumns() []string {
+	columns := []string{"name"}
+	for _, field := range brokerStatusFields {
+		columns = append(columns, field.column)
+	}
+	return columns
+}
+
+func TestScrapeBrokerStatus(t *testing.T) {
+	db, mock := newMock(t)
+	defer db.Close()
+
+	mock.ExpectQuery(brokerStatusQuery).WillReturnRows(sqlmock.NewRows(brokerTestColumns()).
+		AddRow(" query_editor ", "5", "1234", "30000", "0", "10", "20", "30", "40", "100", "120", "7", "1", "2", "3").
+		AddRow("broker1", "-", "-", "-", "-", "-", "-", "-", "-", "-", "-", "-", "-", "-", "-"))
+
+	expected := `
+# HELP cubrid_broker_status_num_as Number of CAS processes of the broker.
+# TYPE cubrid_broker_status_num_as gauge
+cubrid_broker_status_num_as{broker_name="query_editor"} 5
+# HELP cubrid_broker_status_port Port the broker listens on.
+# TYPE cubrid_broker_status_port gauge
+cubrid_broker_status_port{broker_name="query_editor"} 30000
+# HELP cubrid_broker_status_num_query Number of queries processed by the broker.
+# TYPE cubrid_broker_status_num_query counter
+cubrid_broker_status_num_query{broker_name="query_editor"} 120
+# HELP cubrid_broker_status_num_uniq_error Number of unique constraint violations.
+# TYPE cubrid_broker_status_num_uniq_error counter
+cubrid_broker_status_num_uniq_error{broker_name="query_editor"} 3
+# HELP cubrid_broker_statements_total Number of statements processed by the broker by class; class="all" if the broker reports only the combined count.
+# TYPE cubrid_broker_statements_total counter
+cubrid_broker_statements_total{broker_name="query_editor",class="delete"} 40
+cubrid_broker_statements_total{broker_name="query_editor",class="insert"} 20
+cubrid_broker_statements_total{broker_name="query_editor",class="other"} 20
+cubrid_broker_statements_total{broker_name="query_editor",class="select"} 10
+cubrid_broker_statements_total{broker_name="query_editor",class="update"} 30
+# HELP cubrid_broker_port_changed_total Number of times the broker was seen on a port other than the one it used before.
+# TYPE cubrid_broker_port_changed_total counter
+cubrid_broker_port_changed_total{broker_name="query_editor"} 0
+`
+	if err := testutil.CollectAndCompare(scrapeCollector{t, ScrapeBrokerStatus{}, db}, strings.NewReader(expected),
+		"cubrid_broker_status_num_as", "cubrid_broker_status_port", "cubrid_broker_status_num_query",
+		"cubrid_broker_status_num_uniq_error", "cubrid_broker_statements_total", "cubrid_broker_port_changed_total"); err != nil {
+		t.Error(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+// TestScrapeBrokerStatusCombined checks that a broker without per-class
+// counts reports its statements as class="all".
+func TestScrapeBrokerStatusCombined(t *testing.T) {
+	db, mock := newMock(t)
+	defer db.Close()
+
+	mock.ExpectQuery(brokerStatusQuery).WillReturnRows(sqlmock.NewRows(brokerTestColumns()).
+		AddRow("combined", "1", "1", "30001", "0", "-", "-", "-", "-", "9", "50", "0", "0", "0", "0"))
+
+	expected := `
+# HELP cubrid_broker_statements_total Number of statements processed by the broker by class; class="all" if the broker reports only the combined count.
+# TYPE cubrid_broker_statements_total counter
+cubrid_broker_statements_total{broker_name="combined",class="all"} 50
+`
+	if err := testutil.CollectAndCompare(scrapeCollector{t, ScrapeBrokerStatus{}, db}, strings.NewReader(expected),
+		"cubrid_broker_statements_total"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestScrapeBrokerStatusRowError(t *testing.T) {
+	db, mock := newMock(t)
+	defer db.Close()
+
+	mock.ExpectQuery(brokerStatusQuery).WillReturnRows(sqlmock.NewRows(brokerTestColumns()).
+		AddRow("failing", "1", "1", "30002", "0", "0", "0", "0", "0", "0", "0", "0", "0", "0", "0").
+		RowError(0, errTestRow))
+
+	if err := drainScrape(ScrapeBrokerStatus{}, db); err == nil {
+		t.Error("expected an error for the failing row")
+	}
+}
+
+// TestObserveBrokerPortPerTarget checks that brokers of the same name on
+// different targets do not share their remembered port.
+func TestObserveBrokerPortPerTarget(t *testing.T) {
+	mainCtx := withTarget(context.Background(), "main:33000:demodb")
+	probedCtx := withTarget(context.Background(), "probed:33000:demodb")
+
+	observeBrokerPort(mainCtx, "shared_broker", 30000)
+	if changes := observeBrokerPort(probedCtx, "shared_broker", 30001); changes != 0 {
+		t.Errorf("port changes of the probed broker = %v, want 0", changes)
+	}
+	if changes := observeBrokerPort(mainCtx, "shared_broker", 30000); changes != 0 {
+		t.Errorf("port changes of the main broker = %v, want 0", changes)
+	}
+	if changes := observeBrokerPort(mainCtx, "shared_broker", 30002); changes != 1 {
+		t.Errorf("port changes after a change = %v, want 1", changes)
+	}
+}
+
+// withStateFile points --exporter.state-file to a temporary file, starting
+// without remembered broker ports.
+func withStateFile(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := *stateFile
+	*stateFile = filepath.Join(dir, "state")
+	forgetBrokerPorts()
+	t.Cleanup(func() {
+		*stateFile = file
+		forgetBrokerPorts()
+		os.RemoveAll(dir)
+	})
+	return *stateFile
+}
+
+// forgetBrokerPorts drops the remembered broker ports, like a restart.
+func forgetBrokerPorts() {
+	brokerPorts.Lock()
+	defer brokerPorts.Unlock()
+	brokerPorts.ports = map[string]float64{}
+	brokerPorts.seen = map[string]time.Time{}
+	brokerPorts.changes = map[string]float64{}
+}
+
+func TestObserveBrokerPort(t *testing.T) {
+	withStateFile(t)
+	ctx := withTarget(context.Background(), "ports:33000:demodb")
+
+	if changes := observeBrokerPort(ctx, "broker1", 30000); changes != 0 {
+		t.Errorf("port changes of a new broker = %v, want 0", changes)
+	}
+	if changes := observeBrokerPort(ctx, "broker2", 30001); changes != 0 {
+		t.Errorf("port changes of a second new broker = %v, want 0", changes)
+	}
+	if changes := observeBrokerPort(ctx, "broker1", 30005); changes != 1 {
+		t.Errorf("port changes after a change = %v, want 1", changes)
+	}
+
+	// broker2 disappears for some scrapes and comes back on its port.
+	for i := 0; i < 3; i++ {
+		observeBrokerPort(ctx, "broker1", 30005)
+	}
+	if changes := observeBrokerPort(ctx, "broker2", 30001); changes != 0 {
+		t.Errorf("port changes of a broker reappearing on its port = %v, want 0", changes)
+	}
+}
+
+// TestObserveBrokerPortRestart checks that the ports remembered before a
+// restart detect a change after it.
+func TestObserveBrokerPortRestart(t *testing.T) {
+	withStateFile(t)
+	ctx := withTarget(context.Background(), "restart:33000:demodb")
+	observeBrokerPort(ctx, "broker1", 30000)
+	observeBrokerPort(ctx, "broker2", 30001)
+	if err := saveStateIfDirty(); err != nil {
+		t.Fatalf("error saving the state file: %s", err)
+	}
+
+	forgetBrokerPorts()
+	if err := LoadState(); err != nil {
+		t.Fatalf("error loading the state file: %s", err)
+	}
+	if changes := observeBrokerPort(ctx, "broker1", 30002); changes != 1 {
+		t.Errorf("port changes across a restart = %v, want 1", changes)
+	}
+	if changes := observeBrokerPort(ctx, "broker2", 30001); changes != 0 {
+		t.Errorf("port changes of an unchanged broker across a restart = %v, want 0", changes)
+	}
+}
+
+// portScraper observes the ports of its brokers on every scrape.
+type portScraper map[string]float64
+
+func (s portScraper) Name() string     { return "ports" }
+func (s portScraper) Help() string     { return "Observes broker ports" }
+func (s portScraper) Version() float64 { return 10.2 }
+
+func (s portScraper) Scrape(ctx context.Context, db *sql.DB, ch chan<- prometheus.Metric) error {
+	for broker, port := range s {
+		observeBrokerPort(ctx, broker, port)
+	}
+	return nil
+}
+
+// countingState is a state section counting how often it is saved.
+type countingState struct {
+	memoryState
+	saves int
+}
+
+func (s *countingState) MarshalState(maxBytes int) ([]byte, int, error) {
+	s.saves++
+	return s.memoryState.MarshalState(maxBytes)
+}
+
+// TestObserveBrokerPortSavesOncePerScrape checks that new and changed ports
+// are saved once at the end of the scrape, not once per broker, and that
+// unchanged ports are not saved at all.
+func TestObserveBrokerPortSavesOncePerScrape(t *testing.T) {
+	withStateFile(t)
+	state := &countingState{memoryState: memoryState{id: "counting"}}
+	withStateSavers(t, brokerPortState{}, state)
+	scraper := portScraper{"broker1": 30000, "broker2": 30001, "broker3": 30002}
+
+	collectExporter(New(context.Background(), SimulatedDSN, NewMetrics(), []Scraper{scraper}, nil))
+	if state.saves != 1 {
+		t.Errorf("saves after a scrape with three new brokers = %d, want 1", state.saves)
+	}
+	collectExporter(New(context.Background(), SimulatedDSN, NewMetrics(), []Scraper{scraper}, nil))
+	if state.saves != 1 {
+		t.Errorf("saves after a scrape with unchanged brokers = %d, want 1", state.saves)
+	}
+	scraper["broker2"] = 30005
+	scraper["broker3"] = 30006
+	collectExporter(New(context.Background(), SimulatedDSN, NewMetrics(), []Scraper{scraper}, nil))
+	if state.saves != 2 {
+		t.Errorf("saves after a scrape with two changed brokers = %d, want 2", state.saves)
+	}
+
+	forgetBrokerPorts()
+	if err := LoadState(); err != nil {
+		t.Fatalf("error loading the state file: %s", err)
+	}
+	ctx := withTarget(context.Background(), dsnTarget(SimulatedDSN))
+	if changes := observeBrokerPort(ctx, "broker2", 30001); changes != 1 {
+		t.Errorf("port changes of the saved broker2 = %v, want 1", changes)
+	}
+}
+
+// statementsCollector sends the statement counts of broker1 with values on
+// Collect.
+type statementsCollector map[string]float64
+
+func (c statementsCollector) Describe(ch chan<- *prometheus.Desc) {}
+
+func (c statementsCollector) Collect(ch chan<- prometheus.Metric) {
+	sendBrokerStatements(context.Background(), "broker1", c, ch)
+}
+
+// TestSendBrokerStatements checks the shape of the statement counts for each
+// combination of available columns.
+func TestSendBrokerStatements(t *testing.T) {
+	const header = `
+# HELP cubrid_broker_statements_total Number of statements processed by the broker by class; class="all" if the broker reports only the combined count.
+# TYPE cubrid_broker_statements_total counter
+`
+	for _, tc := range []struct {
+		name   string
+		values map[string]float64
+		want   string
+	}{
+		{
+			name:   "per class with total",
+			values: map[string]float64{"num_select": 1, "num_insert": 2, "num_update": 3, "num_delete": 4, "num_query": 15},
+			want: header + `cubrid_broker_statements_total{broker_name="broker1",class="delete"} 4
+cubrid_broker_statements_total{broker_name="broker1",class="insert"} 2
+cubrid_broker_statements_total{broker_name="broker1",class="other"} 5
+cubrid_broker_statements_total{broker_name="broker1",class="select"} 1
+cubrid_broker_statements_total{broker_name="broker1",class="update"} 3
+`,
+		},
+		{
+			name:   "per class without total",
+			values: map[string]float64{"num_select": 1, "num_insert": 2, "num_update": 3, "num_delete": 4},
+			want: header + `cubrid_broker_statements_total{broker_name="broker1",class="delete"} 4
+cubrid_broker_statements_total{broker_name="broker1",class="insert"} 2
+cubrid_broker_statements_total{broker_name="broker1",class="select"} 1
+cubrid_broker_statements_total{broker_name="broker1",class="update"} 3
+`,
+		},
+		{
+			name:   "total lagging behind the classes",
+			values: map[string]float64{"num_select": 5, "num_insert": 0, "num_update": 0, "num_delete": 0, "num_query": 4},
+			want: header + `cubrid_broker_statements_total{broker_name="broker1",class="delete"} 0
+cubrid_broker_statements_total{broker_name="broker1",class="insert"} 0
+cubrid_broker_statements_total{broker_name="broker1",class="other"} 0
+cubrid_broker_statements_total{broker_name="broker1",class="select"} 5
+cubrid_broker_statements_total{broker_name="broker1",class="update"} 0
+`,
+		},
+		{
+			name:   "some classes with total",
+			values: map[string]float64{"num_select": 1, "num_insert": 2, "num_query": 15},
+			want:   header + `cubrid_broker_statements_total{broker_name="broker1",class="all"} 15` + "\n",
+		},
+		{
+			name:   "total only",
+			values: map[string]float64{"num_query": 15},
+			want:   header + `cubrid_broker_statements_total{broker_name="broker1",class="all"} 15` + "\n",
+		},
+		{
+			name:   "some classes without total",
+			values: map[string]float64{"num_select": 1},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := testutil.CollectAndCompare(statementsCollector(tc.values), strings.NewReader(tc.want)); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// BenchmarkScrapeBrokerStatus scrapes the 100-broker fixture.
+func BenchmarkScrapeBrokerStatus(b *testing.B) {
+	fixture := benchFixture(b, brokerStatus)
+	db, mock := newMock(b)
+	defer db.Close()
+	defer forgetBrokerPorts()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		mock.ExpectQuery(fixture.Query).WillReturnRows(fixtureRows(fixture))
+		b.StartTimer()
+		if err := drainScrape(ScrapeBrokerStatus{}, db); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
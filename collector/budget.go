@@ -0,0 +1,234 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Global limit of the statements in flight against the database.
+
+package collector
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+var maxInflightQueries = kingpin.Flag(
+	"cubrid.max-inflight-queries",
+	"Maximum number of statements in flight against the database across all scrapes and background work. 0 is unlimited.",
+).Default("0").Int()
+
+// Query tiers. Scrape queries take precedence over background ones.
+const (
+	tierScrape     = "scrape"
+	tierBackground = "background"
+)
+
+type queryTierKey struct{}
+
+// WithBackgroundQueries marks the queries run with ctx as background work,
+// which yields to scrapes when the query budget is exhausted.
+func WithBackgroundQueries(ctx context.Context) context.Context {
+	return context.WithValue(ctx, queryTierKey{}, tierBackground)
+}
+
+func queryTier(ctx context.Context) string {
+	if tier, ok := ctx.Value(queryTierKey{}).(string); ok {
+		return tier
+	}
+	return tierScrape
+}
+
+// QueryBudgetLimiter bounds the statements in flight. Background statements
+// leave one slot to scrapes and wait while scrape statements are waiting.
+// It implements prometheus.Collector.
+type QueryBudgetLimiter struct {
+	mu       sync.Mutex
+	inflight map[string]int
+	waiting  map[string]int
+	// wake is closed and replaced whenever a slot is released.
+	wake chan struct{}
+
+	waitSeconds *prometheus.HistogramVec
+	rejections  *prometheus.CounterVec
+}
+
+// QueryBudget is the query budget of all connections.
+var QueryBudget = newQueryBudget()
+
+var (
+	queryBudgetInflightDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, exporter, "query_budget_inflight"),
+		"Number of statements in flight against the database.",
+		[]string{"tier"}, nil,
+	)
+	queryBudgetLimitDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, exporter, "query_budget_limit"),
+		"Maximum number of statements in flight, 0 for unlimited.",
+		nil, nil,
+	)
+)
+
+func newQueryBudget() *QueryBudgetLimiter {
+	return &QueryBudgetLimiter{
+		inflight: map[string]int{},
+		waiting:  map[string]int{},
+		wake:     make(chan struct{}),
+		waitSeconds: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: exporter,
+			Name:      "query_budget_wait_seconds",
+			Help:      "Time statements waited for a slot of the query budget.",
+			Buckets:   []float64{.001, .01, .1, .5, 1, 5},
+		}, []string{"tier"}),
+		rejections: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: exporter,
+			Name:      "query_budget_rejections_total",
+			Help:      "Total number of statements not sent because their context ended while waiting for a slot.",
+		}, []string{"tier"}),
+	}
+}
+
+// admits reports whether a statement of tier may start. b.mu must be held.
+func (b *QueryBudgetLimiter) admits(tier string, max int) bool {
+	total := b.inflight[tierScrape] + b.inflight[tierBackground]
+	if tier == tierScrape {
+		return total < max
+	}
+	reserved := 0
+	if max > 1 {
+		reserved = 1
+	}
+	return total < max-reserved && b.waiting[tierScrape] == 0
+}
+
+// acquire waits for a slot until ctx ends and returns the func releasing it.
+func (b *QueryBudgetLimiter) acquire(ctx context.Context) (func(), error) {
+	tier := queryTier(ctx)
+	max := *maxInflightQueries
+	start := time.Now()
+	b.mu.Lock()
+	for max > 0 && !b.admits(tier, max) {
+		b.waiting[tier]++
+		wake := b.wake
+		b.mu.Unlock()
+		var err error
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		b.mu.Lock()
+		b.waiting[tier]--
+		if err != nil {
+			b.mu.Unlock()
+			b.rejections.WithLabelValues(tier).Inc()
+			return nil, err
+		}
+	}
+	b.inflight[tier]++
+	b.mu.Unlock()
+	b.waitSeconds.WithLabelValues(tier).Observe(time.Since(start).Seconds())
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			b.inflight[tier]--
+			close(b.wake)
+			b.wake = make(chan struct{})
+		})
+	}, nil
+}
+
+// Describe implements prometheus.Collector.
+func (b *QueryBudgetLimiter) Describe(ch chan<- *prometheus.Desc) {
+	ch <- queryBudgetInflightDesc
+	ch <- queryBudgetLimitDesc
+	b.waitSeconds.Describe(ch)
+	b.rejections.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (b *QueryBudgetLimiter) Collect(ch chan<- prometheus.Metric) {
+	b.mu.Lock()
+	for _, tier := range []string{tierScrape, tierBackground} {
+		ch <- prometheus.MustNewConstMetric(queryBudgetInflightDesc, prometheus.GaugeValue, float64(b.inflight[tier]), tier)
+	}
+	b.mu.Unlock()
+	ch <- prometheus.MustNewConstMetric(queryBudgetLimitDesc, prometheus.GaugeValue, float64(*maxInflightQueries))
+	b.waitSeconds.Collect(ch)
+	b.rejections.Collect(ch)
+}
+
+// budgetStmt holds a slot of the query budget until the statement is closed.
+type budgetStmt struct {
+	driver.Stmt
+	release func()
+}
+
+func (s budgetStmt) Close() error {
+	defer s.release()
+	return s.Stmt.Close()
+}
+
+// budgetRows holds a slot of the query budget until the rows are closed.
+type budgetRows struct {
+	driver.Rows
+	release func()
+}
+
+func (r budgetRows) Close() error {
+	defer r.release()
+	return r.Rows.Close()
+}
+
+func (s budgetStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	if q, ok := s.Stmt.(driver.StmtQueryContext); ok {
+		return q.QueryContext(ctx, args)
+	}
+	values, err := driverValues(args)
+	if err != nil {
+		return nil, err
+	}
+	return s.Stmt.Query(values)
+}
+
+func (s budgetStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	if e, ok := s.Stmt.(driver.StmtExecContext); ok {
+		return e.ExecContext(ctx, args)
+	}
+	values, err := driverValues(args)
+	if err != nil {
+		return nil, err
+	}
+	return s.Stmt.Exec(values)
+}
+
+// driverValues converts arguments for drivers without context support,
+// which only take positional arguments.
+func driverValues(args []driver.NamedValue) ([]driver.Value, error) {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		if arg.Name != "" {
+			return nil, fmt.Errorf("driver does not support named argument %s", arg.Name)
+		}
+		values[i] = arg.Value
+	}
+	return values, nil
+}
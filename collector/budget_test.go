@@ -0,0 +1,194 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// countingDriver opens connections answering every query with no rows after
+// delay. It counts the statements reaching it and the most in flight at once.
+type countingDriver struct {
+	delay time.Duration
+
+	mu                             sync.Mutex
+	queries, inflight, maxInflight int
+}
+
+func (d *countingDriver) Open(name string) (driver.Conn, error) { return countingConn{d}, nil }
+
+func (d *countingDriver) counts() (int, int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.queries, d.maxInflight
+}
+
+type countingConn struct {
+	d *countingDriver
+}
+
+func (c countingConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+func (c countingConn) Close() error              { return nil }
+func (c countingConn) Begin() (driver.Tx, error) { return nil, errors.New("not supported") }
+
+func (c countingConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.d.mu.Lock()
+	c.d.queries++
+	c.d.inflight++
+	if c.d.inflight > c.d.maxInflight {
+		c.d.maxInflight = c.d.inflight
+	}
+	c.d.mu.Unlock()
+	time.Sleep(c.d.delay)
+	return countingRows{c.d}, nil
+}
+
+// countingRows ends the statement in flight when closed.
+type countingRows struct {
+	d *countingDriver
+}
+
+func (r countingRows) Columns() []string              { return []string{"value"} }
+func (r countingRows) Next(dest []driver.Value) error { return io.EOF }
+
+func (r countingRows) Close() error {
+	r.d.mu.Lock()
+	r.d.inflight--
+	r.d.mu.Unlock()
+	return nil
+}
+
+// withCountingPool installs a connection pool for a DSN that opens its
+// connections through d, like getPool does through the CUBRID driver, and
+// starts from a fresh query budget and audit.
+func withCountingPool(t *testing.T, d *countingDriver, maxInflight int) (string, *sql.DB) {
+	const dsn = "cci:cubrid:127.0.0.1:33000:budgetdb:::"
+	connector := &countingConnector{driver: d, dsn: dsn}
+	db := sql.OpenDB(connector)
+	db.SetMaxOpenConns(16)
+
+	connectionPools.Lock()
+	connectionPools.pools[dsn] = &connectionPool{db: db, connector: connector, lastUsed: poolNow()}
+	connectionPools.Unlock()
+	budget, audit, limit := QueryBudget, Audit, *maxInflightQueries
+	QueryBudget, Audit, *maxInflightQueries = newQueryBudget(), newQueryAudit(approvedQueries), maxInflight
+	t.Cleanup(func() {
+		connectionPools.Lock()
+		delete(connectionPools.pools, dsn)
+		connectionPools.Unlock()
+		db.Close()
+		QueryBudget, Audit, *maxInflightQueries = budget, audit, limit
+	})
+	return dsn, db
+}
+
+// TestQueryBudgetEnforced checks that every statement of a scrape reaching
+// the driver passed the audit wrapper, which holds the query budget.
+func TestQueryBudgetEnforced(t *testing.T) {
+	d := &countingDriver{}
+	dsn, _ := withCountingPool(t, d, 0)
+
+	scrapers := []Scraper{ScrapeBrokerStatus{}, ScrapeStatdump{}, ScrapeSpaceDBStatus{}}
+	collectExporter(New(context.Background(), dsn, NewMetrics(), scrapers, nil))
+
+	reached, _ := d.counts()
+	if reached == 0 {
+		t.Fatal("no statement reached the driver")
+	}
+	var wrapped int
+	for _, entry := range Audit.Entries() {
+		wrapped += entry.Executions
+	}
+	if reached != wrapped {
+		t.Errorf("statements reaching the driver = %d, but %d passed the wrapper", reached, wrapped)
+	}
+}
+
+// TestQueryBudgetCeiling runs scrape and background statements at once and
+// checks that no more than the budget reach the driver concurrently.
+func TestQueryBudgetCeiling(t *testing.T) {
+	d := &countingDriver{delay: 5 * time.Millisecond}
+	_, db := withCountingPool(t, d, 2)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 12)
+	for i := 0; i < 12; i++ {
+		ctx := withLogger(context.Background(), "budget_test")
+		if i%3 == 0 {
+			ctx = WithBackgroundQueries(ctx)
+		}
+		wg.Add(1)
+		go func(ctx context.Context) {
+			defer wg.Done()
+			rows, err := db.QueryContext(ctx, brokerStatusQuery)
+			if err != nil {
+				errs <- err
+				return
+			}
+			rows.Close()
+		}(ctx)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("query failed: %s", err)
+	}
+
+	if reached, max := d.counts(); reached != 12 || max > 2 {
+		t.Errorf("statements reaching the driver = %d with at most %d in flight, want 12 with at most 2", reached, max)
+	}
+}
+
+// TestQueryBudgetBackgroundYields checks that background statements leave a
+// slot to scrapes and give up when their context ends.
+func TestQueryBudgetBackgroundYields(t *testing.T) {
+	limit := *maxInflightQueries
+	*maxInflightQueries = 2
+	defer func() { *maxInflightQueries = limit }()
+	b := newQueryBudget()
+
+	release, err := b.acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(WithBackgroundQueries(context.Background()), 10*time.Millisecond)
+	defer cancel()
+	if _, err := b.acquire(ctx); err == nil {
+		t.Error("a background statement took the slot reserved for scrapes")
+	}
+	if got := testutil.ToFloat64(b.rejections.WithLabelValues(tierBackground)); got != 1 {
+		t.Errorf("background rejections = %v, want 1", got)
+	}
+
+	scrapeRelease, err := b.acquire(context.Background())
+	if err != nil {
+		t.Fatalf("a scrape statement was refused the reserved slot: %s", err)
+	}
+	scrapeRelease()
+	release()
+	if _, err := b.acquire(WithBackgroundQueries(context.Background())); err != nil {
+		t.Errorf("a background statement was refused a free budget: %s", err)
+	}
+}
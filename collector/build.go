@@ -0,0 +1,85 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Optional features selectable at build time.
+
+package collector
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Optional features. Building with the tag no<feature> replaces a feature
+// with a stub that refuses to be enabled.
+const (
+	buildFeatureEmbeddedAlerts = "embeddedalerts"
+)
+
+var buildFeatureDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, exporter, "build_feature"),
+	"Whether the optional feature was compiled into the binary (1) or excluded by its build tag (0).",
+	[]string{"feature"}, nil,
+)
+
+var buildFeatures = struct {
+	sync.Mutex
+	built map[string]bool
+}{built: map[string]bool{}}
+
+// RegisterBuildFeature records whether the optional feature name was
+// compiled in. It is called from the init functions of tagged files.
+func RegisterBuildFeature(name string, built bool) {
+	buildFeatures.Lock()
+	defer buildFeatures.Unlock()
+	buildFeatures.built[name] = built
+}
+
+// BuildFeatures returns the optional features compiled into the binary, sorted.
+func BuildFeatures() []string {
+	buildFeatures.Lock()
+	defer buildFeatures.Unlock()
+	var names []string
+	for name, built := range buildFeatures.built {
+		if built {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+type buildFeatureCollector struct{}
+
+// BuildFeatureCollector exports which optional features the binary was built with.
+var BuildFeatureCollector prometheus.Collector = buildFeatureCollector{}
+
+// Describe implements prometheus.Collector.
+func (buildFeatureCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- buildFeatureDesc
+}
+
+// Collect implements prometheus.Collector.
+func (buildFeatureCollector) Collect(ch chan<- prometheus.Metric) {
+	buildFeatures.Lock()
+	defer buildFeatures.Unlock()
+	for name, built := range buildFeatures.built {
+		v := 0.0
+		if built {
+			v = 1
+		}
+		ch <- prometheus.MustNewConstMetric(buildFeatureDesc, prometheus.GaugeValue, v, name)
+	}
+}
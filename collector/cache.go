@@ -0,0 +1,113 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type cacheKey struct {
+	database  string
+	collector string
+}
+
+type cacheEntry struct {
+	metrics []prometheus.Metric
+	expires time.Time
+}
+
+// ScrapeCache keeps the metrics of a scraper for a database for a fixed TTL,
+// so scrapes within the TTL are served without querying the database.
+// It is safe for concurrent use. A nil *ScrapeCache caches nothing.
+type ScrapeCache struct {
+	ttl time.Duration
+	// collectorTTLs replace ttl for the collectors they name.
+	collectorTTLs map[string]time.Duration
+
+	mu      sync.Mutex
+	entries map[cacheKey]cacheEntry
+}
+
+// NewScrapeCache returns a cache keeping entries for ttl, or nil if ttl is not positive.
+func NewScrapeCache(ttl time.Duration) *ScrapeCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &ScrapeCache{
+		ttl:     ttl,
+		entries: map[cacheKey]cacheEntry{},
+	}
+}
+
+// NewCollectorScrapeCache returns a cache keeping entries for ttl, or for the
+// TTL given in collectorTTLs for their collector. It returns nil if no TTL is
+// positive.
+func NewCollectorScrapeCache(ttl time.Duration, collectorTTLs map[string]time.Duration) *ScrapeCache {
+	positive := ttl > 0
+	for _, t := range collectorTTLs {
+		positive = positive || t > 0
+	}
+	if !positive {
+		return nil
+	}
+	return &ScrapeCache{
+		ttl:           ttl,
+		collectorTTLs: collectorTTLs,
+		entries:       map[cacheKey]cacheEntry{},
+	}
+}
+
+// Get returns the unexpired metrics cached for the collector on database.
+func (c *ScrapeCache) Get(database, collector string) ([]prometheus.Metric, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := cacheKey{database: database, collector: collector}
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return entry.metrics, true
+}
+
+// Set caches the metrics of the collector on database.
+func (c *ScrapeCache) Set(database, collector string, metrics []prometheus.Metric) {
+	if c == nil {
+		return
+	}
+	ttl := c.ttl
+	if t, ok := c.collectorTTLs[collector]; ok {
+		ttl = t
+	}
+	if ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[cacheKey{database: database, collector: collector}] = cacheEntry{
+		metrics: metrics,
+		expires: time.Now().Add(ttl),
+	}
+}
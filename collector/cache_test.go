@@ -0,0 +1,129 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var cacheTestDesc = prometheus.NewDesc("cubrid_cache_test", "Cache test.", []string{"database"}, nil)
+
+// cacheTestMetrics returns n metrics of database with value.
+func cacheTestMetrics(database string, value float64, n int) []prometheus.Metric {
+	metrics := make([]prometheus.Metric, n)
+	for i := range metrics {
+		metrics[i] = prometheus.MustNewConstMetric(cacheTestDesc, prometheus.GaugeValue, value, database)
+	}
+	return metrics
+}
+
+func TestScrapeCache(t *testing.T) {
+	if NewScrapeCache(0) != nil || NewCollectorScrapeCache(0, map[string]time.Duration{statdump: 0}) != nil {
+		t.Error("expected no cache without a positive TTL")
+	}
+	var disabled *ScrapeCache
+	disabled.Set("demodb", statdump, cacheTestMetrics("demodb", 1, 1))
+	if _, ok := disabled.Get("demodb", statdump); ok {
+		t.Error("a nil cache returned metrics")
+	}
+
+	c := NewCollectorScrapeCache(time.Hour, map[string]time.Duration{spacedbStatus: time.Millisecond, brokerStatus: 0})
+	for _, collector := range []string{statdump, spacedbStatus, brokerStatus} {
+		c.Set("demodb", collector, cacheTestMetrics("demodb", 1, 1))
+	}
+	time.Sleep(5 * time.Millisecond)
+	for _, tc := range []struct {
+		database, collector string
+		cached              bool
+	}{
+		{"demodb", statdump, true},
+		{"otherdb", statdump, false},
+		// Expired after its own TTL.
+		{"demodb", spacedbStatus, false},
+		// Not cached with a zero TTL.
+		{"demodb", brokerStatus, false},
+	} {
+		if _, ok := c.Get(tc.database, tc.collector); ok != tc.cached {
+			t.Errorf("%s of %s cached = %v, want %v", tc.collector, tc.database, ok, tc.cached)
+		}
+	}
+}
+
+// TestScrapeCacheConcurrent runs readers and writers of the same keys in
+// parallel. Run with -race; readers must only see complete entries.
+func TestScrapeCacheConcurrent(t *testing.T) {
+	const (
+		databases = 4
+		workers   = 8
+		rounds    = 200
+		size      = 5
+	)
+	c := NewScrapeCache(time.Hour)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		w := w
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				database := "db" + strconv.Itoa((w+i)%databases)
+				c.Set(database, statdump, cacheTestMetrics(database, float64(w*rounds+i), size))
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				database := "db" + strconv.Itoa((w+i)%databases)
+				metrics, ok := c.Get(database, statdump)
+				if !ok {
+					continue
+				}
+				if len(metrics) != size {
+					t.Errorf("%s: %d cached metrics, want %d", database, len(metrics), size)
+					return
+				}
+				var value float64
+				for j, metric := range metrics {
+					var m dto.Metric
+					if err := metric.Write(&m); err != nil {
+						t.Error(err)
+						return
+					}
+					if got := m.Label[0].GetValue(); got != database {
+						t.Errorf("%s: cached a metric of %s", database, got)
+					}
+					if j == 0 {
+						value = m.Gauge.GetValue()
+					} else if m.Gauge.GetValue() != value {
+						t.Errorf("%s: cached metrics of different writes", database)
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i := 0; i < databases; i++ {
+		if _, ok := c.Get("db"+strconv.Itoa(i), statdump); !ok {
+			t.Errorf("db%d is not cached", i)
+		}
+	}
+}
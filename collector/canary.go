@@ -0,0 +1,100 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Synthetic client query measuring end-to-end latency through the broker.
+
+package collector
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// canary names the synthetic query in the query manifest.
+const canary = "probe_query"
+
+var (
+	canaryEnable = kingpin.Flag(
+		"probe.query.enable",
+		"Run a synthetic read query on every scrape and export its end-to-end latency.",
+	).Default("false").Bool()
+	canaryQuery = kingpin.Flag(
+		"probe.query",
+		"Read-only statement of the synthetic query. It is added to the approved query manifest.",
+	).Default("SELECT 1").String()
+	canaryFreshConnection = kingpin.Flag(
+		"probe.fresh-connection",
+		"Run the synthetic query on a new connection each time, including the connection cost short-lived clients pay.",
+	).Default("false").Bool()
+)
+
+// openCanaryDB opens the new connection of --probe.fresh-connection. It is
+// replaced to test that mode without a server.
+var openCanaryDB = OpenDB
+
+// ApproveCanaryQuery validates the statement of --probe.query and adds it to
+// the approved query manifest. Only a single SELECT statement is accepted.
+func ApproveCanaryQuery() error {
+	if !*canaryEnable {
+		return nil
+	}
+	query := normalizeQuery(*canaryQuery)
+	if !strings.HasPrefix(strings.ToUpper(query), "SELECT ") {
+		return errors.New("the statement must be a SELECT")
+	}
+	if strings.Contains(strings.TrimSuffix(query, ";"), ";") {
+		return errors.New("the statement must be a single statement")
+	}
+	Audit.ApproveQuery(canary, canary, query)
+	return nil
+}
+
+// runCanary runs the synthetic query against db, or against a new connection
+// to the DSN with --probe.fresh-connection, reading every row like a client.
+func (e *Exporter) runCanary(ctx context.Context, db *sql.DB) {
+	if !*canaryEnable {
+		return
+	}
+	ctx = withLogger(ctx, canary)
+	start := time.Now()
+	err := func() error {
+		if *canaryFreshConnection {
+			fresh, err := openCanaryDB(e.dsn)
+			if err != nil {
+				return err
+			}
+			defer fresh.Close()
+			db = fresh
+		}
+		rows, err := db.QueryContext(ctx, *canaryQuery)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+		}
+		return rows.Err()
+	}()
+	e.metrics.ProbeQueryDuration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		loggerFrom(ctx).Warnln("Synthetic query failed:", err)
+		e.metrics.ProbeQuerySuccess.Set(0)
+		return
+	}
+	e.metrics.ProbeQuerySuccess.Set(1)
+}
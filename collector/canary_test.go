@@ -0,0 +1,104 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// withCanary enables the synthetic query, on fresh connections opened by
+// open if not nil.
+func withCanary(t *testing.T, open func(dsn string) (*sql.DB, error)) {
+	enable, fresh := *canaryEnable, *canaryFreshConnection
+	*canaryEnable, *canaryFreshConnection = true, open != nil
+	if open != nil {
+		openCanaryDB = open
+	}
+	t.Cleanup(func() {
+		*canaryEnable, *canaryFreshConnection = enable, fresh
+		openCanaryDB = OpenDB
+	})
+	if err := ApproveCanaryQuery(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// runTestCanary runs the synthetic query once against db and returns the
+// observed duration and whether it succeeded.
+func runTestCanary(t *testing.T, dsn string, db *sql.DB) (time.Duration, float64) {
+	metrics := NewMetrics()
+	New(context.Background(), dsn, metrics, nil, nil).runCanary(context.Background(), db)
+	var m dto.Metric
+	if err := metrics.ProbeQueryDuration.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Histogram.GetSampleCount() != 1 {
+		t.Fatalf("observed durations = %d, want 1", m.Histogram.GetSampleCount())
+	}
+	return time.Duration(m.Histogram.GetSampleSum() * float64(time.Second)), testutil.ToFloat64(metrics.ProbeQuerySuccess)
+}
+
+func TestCanaryPooled(t *testing.T) {
+	d := &countingDriver{delay: 30 * time.Millisecond}
+	dsn, db := withCountingPool(t, d, 0)
+	withCanary(t, nil)
+
+	duration, success := runTestCanary(t, dsn, db)
+	if duration < 30*time.Millisecond || success != 1 {
+		t.Errorf("synthetic query took %s with success %v, want at least 30ms and 1", duration, success)
+	}
+	if queries, _ := d.counts(); queries != 1 {
+		t.Errorf("statements on the pool = %d, want 1", queries)
+	}
+}
+
+// TestCanaryFreshConnection checks that the duration includes the cost of
+// connecting and that the pool is not used.
+func TestCanaryFreshConnection(t *testing.T) {
+	pooled := &countingDriver{}
+	dsn, db := withCountingPool(t, pooled, 0)
+	fresh := &delayedDriver{delay: 40 * time.Millisecond}
+	withCanary(t, func(dsn string) (*sql.DB, error) {
+		return sql.OpenDB(&countingConnector{driver: fresh, dsn: dsn}), nil
+	})
+
+	duration, success := runTestCanary(t, dsn, db)
+	if duration < 40*time.Millisecond || success != 1 {
+		t.Errorf("synthetic query took %s with success %v, want at least 40ms and 1", duration, success)
+	}
+	if queries, _ := pooled.counts(); queries != 0 {
+		t.Errorf("statements on the pool = %d, want 0", queries)
+	}
+	if fresh.dsn == "" {
+		t.Error("no fresh connection was opened")
+	}
+}
+
+func TestCanaryFailure(t *testing.T) {
+	dsn, db := withCountingPool(t, &countingDriver{}, 0)
+	withCanary(t, func(dsn string) (*sql.DB, error) {
+		return nil, errors.New("connection refused")
+	})
+
+	if _, success := runTestCanary(t, dsn, db); success != 0 {
+		t.Errorf("success of a failed synthetic query = %v, want 0", success)
+	}
+}
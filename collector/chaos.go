@@ -0,0 +1,238 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Fault injection for validating alerting pipelines.
+
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+var chaosEnable = kingpin.Flag(
+	"chaos.enable",
+	"Allow injecting faults through the admin write API to test alerting. Never enable in production.",
+).Default("false").Bool()
+
+// Fault types.
+const (
+	// FaultUp reports cubrid_up 0 although the database is reachable.
+	FaultUp = "up"
+	// FaultError fails a collector after it scraped.
+	FaultError = "error"
+	// FaultLatency delays a collector after it scraped.
+	FaultLatency = "latency"
+	// FaultMultiply multiplies the values of a family.
+	FaultMultiply = "multiply"
+)
+
+var faultTypes = []string{FaultUp, FaultError, FaultLatency, FaultMultiply}
+
+// errChaosDisabled rejects injections without --chaos.enable.
+var errChaosDisabled = errors.New("fault injection requires --chaos.enable")
+
+var chaosActiveDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, exporter, "chaos_active"),
+	"Number of active injected faults by type.",
+	[]string{"fault"}, nil,
+)
+
+// Fault is an injected fault. Every fault expires.
+type Fault struct {
+	Type string `json:"type"`
+	// Collector is the target of error and latency faults.
+	Collector string `json:"collector,omitempty"`
+	// Family and Factor configure multiply faults.
+	Family  string        `json:"family,omitempty"`
+	Factor  float64       `json:"factor,omitempty"`
+	Latency time.Duration `json:"latency,omitempty"`
+	Expires time.Time     `json:"expires"`
+}
+
+func (f Fault) validate() error {
+	switch f.Type {
+	case FaultUp:
+	case FaultError:
+		if f.Collector == "" {
+			return fmt.Errorf("%s fault requires a collector", f.Type)
+		}
+	case FaultLatency:
+		if f.Collector == "" || f.Latency <= 0 {
+			return fmt.Errorf("%s fault requires a collector and a positive latency", f.Type)
+		}
+	case FaultMultiply:
+		if f.Family == "" {
+			return fmt.Errorf("%s fault requires a family", f.Type)
+		}
+	default:
+		return fmt.Errorf("unknown fault type %q", f.Type)
+	}
+	return nil
+}
+
+// chaosNow is replaced to expire faults deterministically.
+var chaosNow = time.Now
+
+// ChaosInjector holds the active faults. It implements prometheus.Collector.
+type ChaosInjector struct {
+	mu     sync.Mutex
+	faults []Fault
+}
+
+// Chaos is the fault injector of the collection pipeline.
+var Chaos = &ChaosInjector{}
+
+// Inject adds a fault active for ttl.
+func (c *ChaosInjector) Inject(f Fault, ttl time.Duration) (Fault, error) {
+	if !*chaosEnable {
+		return Fault{}, errChaosDisabled
+	}
+	if ttl <= 0 {
+		return Fault{}, fmt.Errorf("ttl must be positive")
+	}
+	if err := f.validate(); err != nil {
+		return Fault{}, err
+	}
+	f.Expires = chaosNow().Add(ttl)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.faults = append(c.faults, f)
+	return f, nil
+}
+
+// Clear removes all faults and returns how many were active.
+func (c *ChaosInjector) Clear() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expire()
+	n := len(c.faults)
+	c.faults = nil
+	return n
+}
+
+// Faults returns the active faults.
+func (c *ChaosInjector) Faults() []Fault {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expire()
+	return append([]Fault{}, c.faults...)
+}
+
+// expire drops expired faults. c.mu must be held.
+func (c *ChaosInjector) expire() {
+	now := chaosNow()
+	active := c.faults[:0]
+	for _, f := range c.faults {
+		if now.Before(f.Expires) {
+			active = append(active, f)
+		}
+	}
+	c.faults = active
+}
+
+// forceDown reports whether a fault forces cubrid_up to 0.
+func (c *ChaosInjector) forceDown() bool {
+	for _, f := range c.Faults() {
+		if f.Type == FaultUp {
+			return true
+		}
+	}
+	return false
+}
+
+// collectorFault returns the latency and the error injected into the collector.
+func (c *ChaosInjector) collectorFault(collector string) (time.Duration, error) {
+	var err error
+	var latency time.Duration
+	for _, f := range c.Faults() {
+		if f.Collector != collector {
+			continue
+		}
+		switch f.Type {
+		case FaultError:
+			err = fmt.Errorf("injected fault until %s", f.Expires.Format(time.RFC3339))
+		case FaultLatency:
+			latency += f.Latency
+		}
+	}
+	return latency, err
+}
+
+// Wrap returns a Gatherer applying the multiply faults to everything g
+// gathers. Without --chaos.enable g is returned unchanged.
+func (c *ChaosInjector) Wrap(g prometheus.Gatherer) prometheus.Gatherer {
+	if c == nil || !*chaosEnable {
+		return g
+	}
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		mfs, err := g.Gather()
+		factors := map[string]float64{}
+		for _, f := range c.Faults() {
+			if f.Type == FaultMultiply {
+				if _, ok := factors[f.Family]; !ok {
+					factors[f.Family] = 1
+				}
+				factors[f.Family] *= f.Factor
+			}
+		}
+		for _, mf := range mfs {
+			factor, ok := factors[mf.GetName()]
+			if !ok {
+				continue
+			}
+			for _, m := range mf.Metric {
+				switch {
+				case m.Counter != nil:
+					m.Counter.Value = proto.Float64(m.GetCounter().GetValue() * factor)
+				case m.Gauge != nil:
+					m.Gauge.Value = proto.Float64(m.GetGauge().GetValue() * factor)
+				case m.Untyped != nil:
+					m.Untyped.Value = proto.Float64(m.GetUntyped().GetValue() * factor)
+				}
+			}
+		}
+		return mfs, err
+	})
+}
+
+// Describe implements prometheus.Collector.
+func (c *ChaosInjector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- chaosActiveDesc
+}
+
+// Collect implements prometheus.Collector. Nothing is reported without --chaos.enable.
+func (c *ChaosInjector) Collect(ch chan<- prometheus.Metric) {
+	if !*chaosEnable {
+		return
+	}
+	active := map[string]int{}
+	for _, f := range c.Faults() {
+		active[f.Type]++
+	}
+	for _, fault := range faultTypes {
+		ch <- prometheus.MustNewConstMetric(chaosActiveDesc, prometheus.GaugeValue, float64(active[fault]), fault)
+	}
+}
+
+// ChaosEnabled reports whether faults can be injected.
+func ChaosEnabled() bool {
+	return *chaosEnable
+}
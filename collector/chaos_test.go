@@ -0,0 +1,156 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// withChaos enables fault injection into a fresh injector whose clock is
+// returned.
+func withChaos(t *testing.T) *time.Time {
+	enable, chaos := *chaosEnable, Chaos
+	now := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	*chaosEnable, Chaos = true, &ChaosInjector{}
+	chaosNow = func() time.Time { return now }
+	t.Cleanup(func() {
+		*chaosEnable, Chaos = enable, chaos
+		chaosNow = time.Now
+	})
+	return &now
+}
+
+func TestChaosDisabled(t *testing.T) {
+	withChaos(t)
+	*chaosEnable = false
+	if _, err := Chaos.Inject(Fault{Type: FaultUp}, time.Minute); err != errChaosDisabled {
+		t.Errorf("injection without --chaos.enable = %v, want %v", err, errChaosDisabled)
+	}
+	g := prometheus.NewRegistry()
+	if Chaos.Wrap(g) != prometheus.Gatherer(g) {
+		t.Error("the gatherer was wrapped without --chaos.enable")
+	}
+}
+
+func TestChaosInvalidFaults(t *testing.T) {
+	withChaos(t)
+	for _, fault := range []Fault{
+		{Type: "unheard_of"},
+		{Type: FaultError},
+		{Type: FaultLatency, Collector: "fake_ok"},
+		{Type: FaultMultiply},
+	} {
+		if _, err := Chaos.Inject(fault, time.Minute); err == nil {
+			t.Errorf("fault %+v was accepted", fault)
+		}
+	}
+	if _, err := Chaos.Inject(Fault{Type: FaultUp}, 0); err == nil {
+		t.Error("a fault without a ttl was accepted")
+	}
+}
+
+func TestChaosFaults(t *testing.T) {
+	withChaos(t)
+	scrapers := []Scraper{fakeScraper{name: "fake_ok"}, fakeScraper{name: "fake_slow"}}
+
+	if _, err := Chaos.Inject(Fault{Type: FaultError, Collector: "fake_ok"}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Chaos.Inject(Fault{Type: FaultLatency, Collector: "fake_slow", Latency: 20 * time.Millisecond}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	metrics := NewMetrics()
+	start := time.Now()
+	collectExporter(New(context.Background(), SimulatedDSN, metrics, scrapers, nil))
+	if d := time.Since(start); d < 20*time.Millisecond {
+		t.Errorf("scrape with the latency fault took %s, want at least 20ms", d)
+	}
+	if got := testutil.ToFloat64(metrics.ScrapeErrors.WithLabelValues("collect.fake_ok")); got != 1 {
+		t.Errorf("scrape errors of the faulted collector = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(metrics.ScrapeErrors.WithLabelValues("collect.fake_slow")); got != 0 {
+		t.Errorf("scrape errors of the delayed collector = %v, want 0", got)
+	}
+
+	if _, err := Chaos.Inject(Fault{Type: FaultUp}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	collectExporter(New(context.Background(), SimulatedDSN, metrics, scrapers, nil))
+	if got := testutil.ToFloat64(metrics.CubridUp); got != 0 {
+		t.Errorf("cubrid_up with the up fault = %v, want 0", got)
+	}
+}
+
+func TestChaosMultiply(t *testing.T) {
+	withChaos(t)
+	registry := prometheus.NewRegistry()
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "cubrid_fake_gauge", Help: "Fake gauge."})
+	gauge.Set(3)
+	registry.MustRegister(gauge)
+
+	for _, factor := range []float64{2, 5} {
+		if _, err := Chaos.Inject(Fault{Type: FaultMultiply, Family: "cubrid_fake_gauge", Factor: factor}, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mfs, err := Chaos.Wrap(registry).Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mfs) != 1 || mfs[0].Metric[0].GetGauge().GetValue() != 30 {
+		t.Errorf("multiplied families = %v, want cubrid_fake_gauge 30", mfs)
+	}
+}
+
+// TestChaosExpiry checks that faults are reported while active and dropped
+// once their ttl passed.
+func TestChaosExpiry(t *testing.T) {
+	now := withChaos(t)
+	if _, err := Chaos.Inject(Fault{Type: FaultUp}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Chaos.Inject(Fault{Type: FaultError, Collector: "fake_ok"}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `
+# HELP cubrid_exporter_chaos_active Number of active injected faults by type.
+# TYPE cubrid_exporter_chaos_active gauge
+cubrid_exporter_chaos_active{fault="error"} 1
+cubrid_exporter_chaos_active{fault="latency"} 0
+cubrid_exporter_chaos_active{fault="multiply"} 0
+cubrid_exporter_chaos_active{fault="up"} 1
+`
+	if err := testutil.CollectAndCompare(Chaos, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+
+	*now = now.Add(time.Minute)
+	if Chaos.forceDown() {
+		t.Error("the up fault is active after its ttl")
+	}
+	if faults := Chaos.Faults(); len(faults) != 1 || faults[0].Type != FaultError {
+		t.Errorf("faults after the first ttl = %+v, want the error fault", faults)
+	}
+	*now = now.Add(time.Hour)
+	if n := Chaos.Clear(); n != 0 {
+		t.Errorf("cleared faults after every ttl = %d, want 0", n)
+	}
+}
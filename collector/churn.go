@@ -0,0 +1,255 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Limiting of label value churn per metric family.
+
+package collector
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/log"
+)
+
+const (
+	// overflowValue replaces new label values while churn is limited.
+	overflowValue = "overflow"
+	// maxChurnTracked bounds the label values remembered per limit.
+	maxChurnTracked = 10000
+)
+
+// ChurnLimit bounds the rate of new values of one label of one family.
+type ChurnLimit struct {
+	Family string `yaml:"family"`
+	Label  string `yaml:"label"`
+	// MaxNewValues is the number of new values accepted per window.
+	MaxNewValues int           `yaml:"max_new_values"`
+	Window       time.Duration `yaml:"window"`
+}
+
+type churnTracker struct {
+	limit ChurnLimit
+	// seen maps accepted values to the time they were last seen.
+	seen map[string]time.Time
+	// rejected maps values folded into the overflow bucket to the time they were first rejected.
+	rejected map[string]time.Time
+	// newTimes holds the times new values appeared within the window.
+	newTimes []time.Time
+	limited  bool
+}
+
+var churnLimitedDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, exporter, "label_churn_limited"),
+	"Whether new values of the label are currently folded into the overflow bucket (1 for limited).",
+	[]string{"family", "label"}, nil,
+)
+
+var churnDroppedDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, exporter, "label_churn_dropped_series_total"),
+	"Total number of series dropped because folding their label values into the overflow bucket repeated another series of the family.",
+	[]string{"family"}, nil,
+)
+
+// ChurnGuard maps new label values into an overflow bucket while a family
+// produces new values faster than its limit. It implements
+// prometheus.Collector for its limiting state.
+type ChurnGuard struct {
+	mu       sync.Mutex
+	trackers map[string][]*churnTracker
+	// dropped counts the series dropped by family.
+	dropped map[string]float64
+}
+
+// NewChurnGuard validates the limits and returns a guard enforcing them.
+func NewChurnGuard(limits []ChurnLimit) (*ChurnGuard, error) {
+	g := &ChurnGuard{trackers: map[string][]*churnTracker{}, dropped: map[string]float64{}}
+	for i, limit := range limits {
+		if limit.Family == "" || limit.Label == "" {
+			return nil, fmt.Errorf("churn limit %d: family and label are required", i)
+		}
+		if limit.MaxNewValues <= 0 || limit.Window <= 0 {
+			return nil, fmt.Errorf("churn limit %d: max_new_values and window must be positive", i)
+		}
+		g.dropped[limit.Family] = 0
+		g.trackers[limit.Family] = append(g.trackers[limit.Family], &churnTracker{
+			limit:    limit,
+			seen:     map[string]time.Time{},
+			rejected: map[string]time.Time{},
+		})
+	}
+	return g, nil
+}
+
+// Describe implements prometheus.Collector.
+func (g *ChurnGuard) Describe(ch chan<- *prometheus.Desc) {
+	ch <- churnLimitedDesc
+	ch <- churnDroppedDesc
+}
+
+// Collect implements prometheus.Collector.
+func (g *ChurnGuard) Collect(ch chan<- prometheus.Metric) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, trackers := range g.trackers {
+		for _, t := range trackers {
+			v := 0.0
+			if t.limited {
+				v = 1
+			}
+			ch <- prometheus.MustNewConstMetric(churnLimitedDesc, prometheus.GaugeValue, v, t.limit.Family, t.limit.Label)
+		}
+	}
+	for family, dropped := range g.dropped {
+		ch <- prometheus.MustNewConstMetric(churnDroppedDesc, prometheus.CounterValue, dropped, family)
+	}
+}
+
+// Wrap returns a Gatherer applying the limits to everything gw gathers.
+// Without limits gw is returned unchanged.
+func (g *ChurnGuard) Wrap(gw prometheus.Gatherer) prometheus.Gatherer {
+	if g == nil || len(g.trackers) == 0 {
+		return gw
+	}
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		mfs, err := gw.Gather()
+		g.apply(mfs, time.Now())
+		return mfs, err
+	})
+}
+
+func (g *ChurnGuard) apply(mfs []*dto.MetricFamily, now time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for _, mf := range mfs {
+		trackers, ok := g.trackers[mf.GetName()]
+		if !ok {
+			continue
+		}
+		for _, t := range trackers {
+			t.expire(now)
+			for _, m := range mf.Metric {
+				for _, lp := range m.Label {
+					if lp.GetName() == t.limit.Label && !t.admit(lp.GetValue(), now) {
+						overflow := overflowValue
+						lp.Value = &overflow
+					}
+				}
+			}
+		}
+		n := len(mf.Metric)
+		mf.Metric = dedupMetrics(mf.Metric)
+		g.dropped[mf.GetName()] += float64(n - len(mf.Metric))
+	}
+}
+
+// expire forgets state older than the window and re-evaluates the limiting.
+func (t *churnTracker) expire(now time.Time) {
+	cutoff := now.Add(-t.limit.Window)
+	i := 0
+	for i < len(t.newTimes) && t.newTimes[i].Before(cutoff) {
+		i++
+	}
+	t.newTimes = t.newTimes[i:]
+	for value, rejected := range t.rejected {
+		if rejected.Before(cutoff) {
+			delete(t.rejected, value)
+		}
+	}
+
+	limited := len(t.newTimes) > t.limit.MaxNewValues
+	if limited != t.limited {
+		if limited {
+			log.Warnf("Label churn limit reached for %s{%s}, folding new values into %q", t.limit.Family, t.limit.Label, overflowValue)
+		} else {
+			log.Infof("Label churn for %s{%s} subsided", t.limit.Family, t.limit.Label)
+		}
+	}
+	t.limited = limited
+}
+
+// admit records a label value and reports whether it may be exported as is.
+func (t *churnTracker) admit(value string, now time.Time) bool {
+	if _, ok := t.seen[value]; ok {
+		t.seen[value] = now
+		return true
+	}
+	if _, ok := t.rejected[value]; ok {
+		return false
+	}
+
+	t.newTimes = append(t.newTimes, now)
+	if len(t.newTimes) > maxChurnTracked {
+		t.newTimes = t.newTimes[1:]
+	}
+	if len(t.newTimes) > t.limit.MaxNewValues {
+		if !t.limited {
+			log.Warnf("Label churn limit reached for %s{%s}, folding new values into %q", t.limit.Family, t.limit.Label, overflowValue)
+		}
+		t.limited = true
+	}
+	if t.limited {
+		if len(t.rejected) < maxChurnTracked {
+			t.rejected[value] = now
+		}
+		return false
+	}
+
+	if len(t.seen) >= maxChurnTracked {
+		t.evictOldest()
+	}
+	t.seen[value] = now
+	return true
+}
+
+func (t *churnTracker) evictOldest() {
+	var oldest string
+	var oldestTime time.Time
+	for value, seen := range t.seen {
+		if oldestTime.IsZero() || seen.Before(oldestTime) {
+			oldest, oldestTime = value, seen
+		}
+	}
+	delete(t.seen, oldest)
+}
+
+// dedupMetrics drops metrics whose label set repeats an earlier one,
+// which folding values into the overflow bucket can produce.
+func dedupMetrics(metrics []*dto.Metric) []*dto.Metric {
+	seen := make(map[string]bool, len(metrics))
+	result := metrics[:0]
+	for _, m := range metrics {
+		sig := labelSignature(m)
+		if seen[sig] {
+			continue
+		}
+		seen[sig] = true
+		result = append(result, m)
+	}
+	return result
+}
+
+func labelSignature(m *dto.Metric) string {
+	pairs := make([]string, 0, len(m.Label))
+	for _, lp := range m.Label {
+		pairs = append(pairs, lp.GetName()+"\xff"+lp.GetValue())
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "\xfe")
+}
@@ -0,0 +1,143 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const churnTestFamily = "cubrid_broker_as_busy"
+
+// churnTestFamilies returns the gathered family with a series per as_id value.
+func churnTestFamilies(asIDs ...string) []*dto.MetricFamily {
+	mf := &dto.MetricFamily{Name: proto.String(churnTestFamily), Type: dto.MetricType_GAUGE.Enum()}
+	for _, id := range asIDs {
+		mf.Metric = append(mf.Metric, &dto.Metric{
+			Label: []*dto.LabelPair{{Name: proto.String("broker_name"), Value: proto.String("broker1")}, {Name: proto.String("as_id"), Value: proto.String(id)}},
+			Gauge: &dto.Gauge{Value: proto.Float64(1)},
+		})
+	}
+	return []*dto.MetricFamily{mf}
+}
+
+// applyChurn applies the guard at now and returns the resulting as_id values.
+func applyChurn(g *ChurnGuard, now time.Time, asIDs ...string) []string {
+	mfs := churnTestFamilies(asIDs...)
+	g.apply(mfs, now)
+	var values []string
+	for _, m := range mfs[0].Metric {
+		values = append(values, labelValue(m, "as_id"))
+	}
+	return values
+}
+
+func newTestChurnGuard(t *testing.T) *ChurnGuard {
+	g, err := NewChurnGuard([]ChurnLimit{{Family: churnTestFamily, Label: "as_id", MaxNewValues: 3, Window: time.Minute}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+func TestChurnGuardLimit(t *testing.T) {
+	g := newTestChurnGuard(t)
+	start := time.Unix(1600000000, 0)
+
+	for _, tc := range []struct {
+		step    string
+		at      time.Duration
+		asIDs   []string
+		want    []string
+		limited bool
+	}{
+		{"normal workload", 0, []string{"1", "2"}, []string{"1", "2"}, false},
+		{"repeated values", 10 * time.Second, []string{"1", "2"}, []string{"1", "2"}, false},
+		{"last new value", 20 * time.Second, []string{"1", "2", "3"}, []string{"1", "2", "3"}, false},
+		// Known values stay, new ones fold into one overflow series.
+		{"high churn", 30 * time.Second, []string{"1", "4", "5", "6"}, []string{"1", "overflow"}, true},
+		// A rejected value stays in the bucket while limited.
+		{"deterministic bucket", 40 * time.Second, []string{"4", "2"}, []string{"overflow", "2"}, true},
+		// Once the new values leave the window, the limiting disengages.
+		{"subsided", 2 * time.Minute, []string{"1", "7"}, []string{"1", "7"}, false},
+	} {
+		if got := applyChurn(g, start.Add(tc.at), tc.asIDs...); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: as_id values = %q, want %q", tc.step, got, tc.want)
+		}
+		if limited := g.trackers[churnTestFamily][0].limited; limited != tc.limited {
+			t.Errorf("%s: limited = %v, want %v", tc.step, limited, tc.limited)
+		}
+	}
+}
+
+func TestChurnGuardDroppedSeries(t *testing.T) {
+	g := newTestChurnGuard(t)
+	now := time.Unix(1600000000, 0)
+
+	applyChurn(g, now, "1", "2", "3")
+	// 4 to 7 fold into one overflow series, dropping three.
+	applyChurn(g, now, "1", "4", "5", "6", "7")
+	// 8 folds into the overflow series of 4, dropping one more.
+	applyChurn(g, now, "4", "8")
+
+	expected := `
+# HELP cubrid_exporter_label_churn_dropped_series_total Total number of series dropped because folding their label values into the overflow bucket repeated another series of the family.
+# TYPE cubrid_exporter_label_churn_dropped_series_total counter
+cubrid_exporter_label_churn_dropped_series_total{family="cubrid_broker_as_busy"} 4
+# HELP cubrid_exporter_label_churn_limited Whether new values of the label are currently folded into the overflow bucket (1 for limited).
+# TYPE cubrid_exporter_label_churn_limited gauge
+cubrid_exporter_label_churn_limited{family="cubrid_broker_as_busy",label="as_id"} 1
+`
+	if err := testutil.CollectAndCompare(g, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestChurnGuardMemoryBound(t *testing.T) {
+	g, err := NewChurnGuard([]ChurnLimit{{Family: churnTestFamily, Label: "as_id", MaxNewValues: maxChurnTracked * 2, Window: time.Hour}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1600000000, 0)
+	asIDs := make([]string, maxChurnTracked+100)
+	for i := range asIDs {
+		asIDs[i] = strconv.Itoa(i)
+	}
+	applyChurn(g, now, asIDs...)
+
+	tracker := g.trackers[churnTestFamily][0]
+	if len(tracker.seen) > maxChurnTracked || len(tracker.newTimes) > maxChurnTracked {
+		t.Errorf("tracking %d values and %d new times, want at most %d", len(tracker.seen), len(tracker.newTimes), maxChurnTracked)
+	}
+}
+
+func TestNewChurnGuardInvalid(t *testing.T) {
+	for _, limit := range []ChurnLimit{
+		{Label: "as_id", MaxNewValues: 3, Window: time.Minute},
+		{Family: churnTestFamily, MaxNewValues: 3, Window: time.Minute},
+		{Family: churnTestFamily, Label: "as_id", Window: time.Minute},
+		{Family: churnTestFamily, Label: "as_id", MaxNewValues: 3},
+	} {
+		if _, err := NewChurnGuard([]ChurnLimit{limit}); err == nil {
+			t.Errorf("NewChurnGuard(%+v): expected an error", limit)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Classification of the errors of the driver and the collectors.
+
+package collector
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ErrorCategory is the kind of an error.
+type ErrorCategory string
+
+// Error categories.
+const (
+	CategoryConnection        ErrorCategory = "connection"
+	CategoryTimeout           ErrorCategory = "timeout"
+	CategoryCanceled          ErrorCategory = "canceled"
+	CategoryAuth              ErrorCategory = "auth"
+	CategoryPrivilege         ErrorCategory = "privilege"
+	CategoryCredentialExpired ErrorCategory = "credential_expired"
+	// CategoryRestrictedAccess is the database refusing access for
+	// maintenance, e.g. a utility in standalone mode.
+	CategoryRestrictedAccess ErrorCategory = "restricted_access"
+	// CategoryUnsupported is a statement the server does not support in its
+	// current mode, e.g. HA statements without HA.
+	CategoryUnsupported ErrorCategory = "unsupported"
+	CategorySyntax      ErrorCategory = "syntax"
+	CategoryData        ErrorCategory = "data"
+	// CategoryPanic and CategoryStrict are raised by the exporter itself.
+	CategoryPanic   ErrorCategory = "panic"
+	CategoryStrict  ErrorCategory = "strict"
+	CategoryUnknown ErrorCategory = "unknown"
+)
+
+// Errors raised by the exporter itself.
+var (
+	errPanic  = errors.New("panic")
+	errStrict = errors.New("strict mode")
+)
+
+// Classification describes an error for the features reacting to it.
+type Classification struct {
+	Category ErrorCategory
+	// Code is the CUBRID error code, 0 if the error carries none.
+	Code int
+	// Retryable tells whether repeating the operation may succeed.
+	Retryable bool
+	// Hint tells the user how to resolve the error.
+	Hint string
+}
+
+// errorClass maps CUBRID error codes and message fragments to a category.
+type errorClass struct {
+	category  ErrorCategory
+	codes     []int
+	fragments []string
+	retryable bool
+	hint      string
+}
+
+// errorClasses is the single table mapping driver errors to categories,
+// checked in order. Codes take precedence over fragments, which are matched
+// against the lower-cased message for errors without a known code.
+var errorClasses = []errorClass{
+	{
+		category:  CategoryCredentialExpired,
+		fragments: []string{"password expired", "password has expired"},
+		hint:      "the password of the monitoring user expired; set a new one",
+	},
+	{
+		category:  CategoryAuth,
+		fragments: []string{"incorrect or missing password", "invalid user", "user is invalid", "authentication failed"},
+		hint:      "check the user and password of the DSN",
+	},
+	{
+		category:  CategoryPrivilege,
+		fragments: []string{"not authorized", "authorization failure", "no privilege", "permission denied"},
+		hint:      "grant the monitoring user the privileges the collector needs, or disable the collector",
+	},
+	{
+		category:  CategoryRestrictedAccess,
+		fragments: []string{"standalone mode", "restricted access", "restricted mode", "is being used by"},
+		retryable: true,
+		hint:      "the database is in maintenance; collection resumes once normal access returns",
+	},
+	{
+		category:  CategoryUnsupported,
+		fragments: []string{"not in ha mode", "ha mode is off"},
+		hint:      "the server does not run in the mode the statement requires",
+	},
+	{
+		category:  CategorySyntax,
+		codes:     []int{-493, -494},
+		fragments: []string{"syntax error", "semantic error"},
+		hint:      "the server version does not support a statement of the collector; check the collector's minimum version",
+	},
+	{
+		category:  CategoryConnection,
+		codes:     []int{-353, -677, -20003, -20004},
+		fragments: []string{"connection", "broken pipe", "failed to connect", "cannot connect", "no route to host", "i/o timeout", "eof"},
+		retryable: true,
+		hint:      "check that the broker and the database server are running and reachable",
+	},
+	{
+		category:  CategoryData,
+		fragments: []string{"converting", "unsupported scan", "parsing"},
+		hint:      "the server returned values in an unexpected format; please report the server version",
+	},
+}
+
+// errorCodeRE extracts the CUBRID error code from a driver error message.
+var errorCodeRE = regexp.MustCompile(`(?:^|[^0-9A-Za-z])(-[1-9][0-9]{1,4})(?:[^0-9]|$)`)
+
+// ClassifyError returns the classification of err. It is the only place
+// inspecting error messages; features must use it instead.
+func ClassifyError(err error) Classification {
+	switch {
+	case err == nil:
+		return Classification{Category: CategoryUnknown}
+	case errors.Is(err, context.DeadlineExceeded):
+		return Classification{Category: CategoryTimeout, Retryable: true, Hint: "raise the scrape timeout or disable slow collectors"}
+	case errors.Is(err, context.Canceled):
+		return Classification{Category: CategoryCanceled, Retryable: true}
+	case errors.Is(err, errPanic):
+		return Classification{Category: CategoryPanic, Hint: "a collector crashed; please report it with the log"}
+	case errors.Is(err, errStrict):
+		return Classification{Category: CategoryStrict, Hint: "the collector found data anomalies in strict mode"}
+	case errors.Is(err, driver.ErrBadConn):
+		return Classification{Category: CategoryConnection, Retryable: true}
+	}
+
+	msg := strings.ToLower(err.Error())
+	code := 0
+	if m := errorCodeRE.FindStringSubmatch(msg); m != nil {
+		code, _ = strconv.Atoi(m[1])
+	}
+	if code != 0 {
+		for _, class := range errorClasses {
+			for _, c := range class.codes {
+				if c == code {
+					return class.classification(code)
+				}
+			}
+		}
+	}
+	for _, class := range errorClasses {
+		for _, fragment := range class.fragments {
+			if strings.Contains(msg, fragment) {
+				return class.classification(code)
+			}
+		}
+	}
+	// The context error may only survive in the message of a driver error.
+	switch {
+	case strings.Contains(msg, "deadline exceeded"):
+		return Classification{Category: CategoryTimeout, Code: code, Retryable: true}
+	case strings.Contains(msg, "context canceled"):
+		return Classification{Category: CategoryCanceled, Code: code, Retryable: true}
+	}
+	return Classification{Category: CategoryUnknown, Code: code}
+}
+
+func (c errorClass) classification(code int) Classification {
+	return Classification{Category: c.category, Code: code, Retryable: c.retryable, Hint: c.hint}
+}
@@ -0,0 +1,95 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestClassifyError(t *testing.T) {
+	for _, tc := range []struct {
+		err       error
+		category  ErrorCategory
+		code      int
+		retryable bool
+	}{
+		{nil, CategoryUnknown, 0, false},
+		{context.DeadlineExceeded, CategoryTimeout, 0, true},
+		{fmt.Errorf("query: %w", context.Canceled), CategoryCanceled, 0, true},
+		{fmt.Errorf("%w: index out of range", errPanic), CategoryPanic, 0, false},
+		{fmt.Errorf("%w: 2 anomalies", errStrict), CategoryStrict, 0, false},
+		{driver.ErrBadConn, CategoryConnection, 0, true},
+		{errors.New("Password expired for user MONITOR"), CategoryCredentialExpired, 0, false},
+		{errors.New("-165 Incorrect or missing password."), CategoryAuth, -165, false},
+		{errors.New("error -1329: Not authorized on db_serial."), CategoryPrivilege, -1329, false},
+		{errors.New("demodb is being used by a utility in standalone mode"), CategoryRestrictedAccess, 0, true},
+		{errors.New("Server is not in HA mode."), CategoryUnsupported, 0, false},
+		{errors.New("-493 Syntax: unknown statement"), CategorySyntax, -493, false},
+		{errors.New("cci error -677: the broker is gone"), CategoryConnection, -677, true},
+		{errors.New("dial tcp 10.0.0.1:33000: i/o timeout"), CategoryConnection, 0, true},
+		{errors.New("sql: Scan error on column index 1: converting \"x\" to float64"), CategoryData, 0, false},
+		{errors.New("driver: context deadline exceeded"), CategoryTimeout, 0, true},
+		{errors.New("driver: context canceled"), CategoryCanceled, 0, true},
+		{errors.New("error -9999: unheard of"), CategoryUnknown, -9999, false},
+		// A code outside an error code position is not extracted.
+		{errors.New("table t-1234x is missing"), CategoryUnknown, 0, false},
+	} {
+		got := ClassifyError(tc.err)
+		if got.Category != tc.category || got.Code != tc.code || got.Retryable != tc.retryable {
+			t.Errorf("ClassifyError(%v) = %s, code %d, retryable %t; want %s, code %d, retryable %t",
+				tc.err, got.Category, got.Code, got.Retryable, tc.category, tc.code, tc.retryable)
+		}
+	}
+}
+
+// errorMessageMatchRE matches inspecting the message of an error.
+var errorMessageMatchRE = regexp.MustCompile(`strings\.\w+\([^)]*\.Error\(\)`)
+
+// TestErrorMessagesOnlyClassified checks that no code but ClassifyError
+// inspects error messages.
+func TestErrorMessagesOnlyClassified(t *testing.T) {
+	err := filepath.Walk("..", func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && strings.HasPrefix(info.Name(), ".") && info.Name() != ".." {
+			return filepath.SkipDir
+		}
+		if info.IsDir() || !strings.HasSuffix(path, ".go") || strings.HasSuffix(path, "_test.go") || info.Name() == "classify.go" {
+			return nil
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		for i, line := range strings.Split(string(data), "\n") {
+			if errorMessageMatchRE.MatchString(line) {
+				t.Errorf("%s:%d inspects an error message, use ClassifyError: %s", path, i+1, strings.TrimSpace(line))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -15,9 +15,14 @@ package collector
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
+	"fmt"
 	"regexp"
 	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
@@ -38,6 +43,43 @@ func newDesc(subsystem, name, help string) *prometheus.Desc {
 	)
 }
 
+// newDatabaseDesc returns a descriptor for a per-database metric.
+func newDatabaseDesc(subsystem, name, help string) *prometheus.Desc {
+	return prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, subsystem, name),
+		help, []string{"database"}, nil,
+	)
+}
+
+// maxLabelValueLength bounds label values taken from client-controlled strings.
+const maxLabelValueLength = 128
+
+// sanitizeLabelValue makes an untrusted string safe to use as a label value:
+// invalid UTF-8 and control characters are replaced and the length is bounded.
+// Altered values are reported as anomalies.
+func sanitizeLabelValue(ctx context.Context, original string) string {
+	value := strings.Map(func(r rune) rune {
+		if r == utf8.RuneError || unicode.IsControl(r) {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(original))
+	if len(value) > maxLabelValueLength {
+		value = value[:maxLabelValueLength]
+		// Do not cut a multi-byte character in half.
+		for !utf8.ValidString(value) {
+			value = value[:len(value)-1]
+		}
+	}
+	if value == "" {
+		value = "unknown"
+	}
+	if value != strings.TrimSpace(original) {
+		reportAnomaly(ctx, anomalySanitizedLabel, fmt.Sprintf("label value sanitized to %q", value))
+	}
+	return value
+}
+
 func parseStatus(data sql.RawBytes) (float64, bool) {
 	if bytes.Equal(data, []byte("Yes")) || bytes.Equal(data, []byte("ON")) {
 		return 1, true
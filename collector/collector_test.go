@@ -0,0 +1,119 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// errTestRow fails a row of a mocked result set.
+var errTestRow = errors.New("row failed")
+
+func TestMain(m *testing.M) {
+	// Apply the flag defaults, as the tests read them like the scrapers do.
+	if _, err := kingpin.CommandLine.Parse([]string{}); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+// scrapeCollector runs a scraper against db on every Collect, so its metrics
+// can be compared with testutil. Describe sends nothing, making it unchecked.
+type scrapeCollector struct {
+	t       *testing.T
+	scraper Scraper
+	db      *sql.DB
+}
+
+func (c scrapeCollector) Describe(ch chan<- *prometheus.Desc) {}
+
+func (c scrapeCollector) Collect(ch chan<- prometheus.Metric) {
+	if err := c.scraper.Scrape(context.Background(), c.db, ch); err != nil {
+		c.t.Errorf("error calling Scrape: %s", err)
+	}
+}
+
+// newMock returns a mock database matching queries literally.
+func newMock(t testing.TB) (*sql.DB, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("error opening a stub database connection: %s", err)
+	}
+	return db, mock
+}
+
+// expectDatabase expects the lookup of the connection's database by the
+// per-database scrapers.
+func expectDatabase(mock sqlmock.Sqlmock, database string) {
+	mock.ExpectQuery(inventoryDatabaseQuery).WillReturnRows(sqlmock.NewRows([]string{"database()"}).AddRow(database))
+}
+
+// drainScrape runs the scraper against db, discarding its metrics.
+func drainScrape(scraper Scraper, db *sql.DB) error {
+	ch := make(chan prometheus.Metric)
+	done := make(chan struct{})
+	go func() {
+		for range ch {
+		}
+		close(done)
+	}()
+	err := scraper.Scrape(context.Background(), db, ch)
+	close(ch)
+	<-done
+	return err
+}
+
+func TestSanitizeLabelValue(t *testing.T) {
+	long := strings.Repeat("a", maxLabelValueLength+10)
+	wide := strings.Repeat("é", maxLabelValueLength)
+	for _, tc := range []struct {
+		name, original, want string
+		sanitized            bool
+	}{
+		{"plain", "app", "app", false},
+		{"padded", "  app  ", "app", false},
+		{"empty", " ", "unknown", true},
+		{"newlines", "app\nname\r\n", "app_name", true},
+		{"quotes", `app" name="x`, `app" name="x`, false},
+		{"backslash", `app\n`, `app\n`, false},
+		{"invalid UTF-8", "app\xff\xfe", "app__", true},
+		{"oversize", long, long[:maxLabelValueLength], true},
+		{"oversize multi-byte", wide, wide[:maxLabelValueLength], true},
+	} {
+		ctx, rec := withAnomalyRecorder(context.Background())
+		got := sanitizeLabelValue(ctx, tc.original)
+		if got != tc.want {
+			t.Errorf("%s: sanitizeLabelValue(%q) = %q, want %q", tc.name, tc.original, got, tc.want)
+		}
+		if !utf8.ValidString(got) || len(got) > maxLabelValueLength {
+			t.Errorf("%s: %q is not a valid label value", tc.name, got)
+		}
+		counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "anomalies_total"}, []string{"collector", "kind"})
+		rec.account("test", counter)
+		if sanitized := testutil.ToFloat64(counter.WithLabelValues("test", anomalySanitizedLabel)) > 0; sanitized != tc.sanitized {
+			t.Errorf("%s: sanitized anomaly reported = %v, want %v", tc.name, sanitized, tc.sanitized)
+		}
+	}
+}
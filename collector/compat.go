@@ -0,0 +1,187 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Legacy metric names for dashboards built for other exporters.
+
+package collector
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// CompatMysqld exports mysqld_exporter names.
+const CompatMysqld = "mysqld"
+
+// compatMapping copies the samples of a CUBRID family, optionally only those
+// with a label value, to a legacy family name.
+type compatMapping struct {
+	source string
+	// matchLabel and matchValue restrict the copied samples, if set.
+	matchLabel, matchValue string
+	target                 string
+	targetType             dto.MetricType
+	// labels are added to the copied samples; matchLabel is removed.
+	labels map[string]string
+}
+
+// compatTables lists the reviewed mappings per compatibility mode. Concepts
+// without a CUBRID equivalent, e.g. uptime, are deliberately absent.
+var compatTables = map[string][]compatMapping{
+	CompatMysqld: {
+		{source: "cubrid_up", target: "mysql_up", targetType: dto.MetricType_GAUGE},
+		{source: "cubrid_sessions_total", target: "mysql_global_status_threads_connected", targetType: dto.MetricType_GAUGE},
+		{source: "cubrid_rows_inserted_total", target: "mysql_global_status_innodb_row_ops_total", targetType: dto.MetricType_COUNTER,
+			labels: map[string]string{"operation": "inserted"}},
+		{source: "cubrid_rows_updated_total", target: "mysql_global_status_innodb_row_ops_total", targetType: dto.MetricType_COUNTER,
+			labels: map[string]string{"operation": "updated"}},
+		{source: "cubrid_rows_deleted_total", target: "mysql_global_status_innodb_row_ops_total", targetType: dto.MetricType_COUNTER,
+			labels: map[string]string{"operation": "deleted"}},
+		{source: "cubrid_statdump_query_selects_total",
+			target: "mysql_global_status_commands_total", targetType: dto.MetricType_COUNTER,
+			labels: map[string]string{"command": "select"}},
+		{source: "cubrid_statdump_query_inserts_total",
+			target: "mysql_global_status_commands_total", targetType: dto.MetricType_COUNTER,
+			labels: map[string]string{"command": "insert"}},
+		{source: "cubrid_statdump_query_updates_total",
+			target: "mysql_global_status_commands_total", targetType: dto.MetricType_COUNTER,
+			labels: map[string]string{"command": "update"}},
+		{source: "cubrid_statdump_query_deletes_total",
+			target: "mysql_global_status_commands_total", targetType: dto.MetricType_COUNTER,
+			labels: map[string]string{"command": "delete"}},
+		// Buffer hit ratio dashboards divide reads by read requests.
+		{source: "cubrid_statdump_data_page_fetches_total",
+			target: "mysql_global_status_innodb_buffer_pool_read_requests", targetType: dto.MetricType_COUNTER},
+		{source: "cubrid_statdump_data_page_ioreads_total",
+			target: "mysql_global_status_innodb_buffer_pool_reads", targetType: dto.MetricType_COUNTER},
+	},
+}
+
+// Compat adds legacy-named copies of mapped families, labeled
+// compat="<mode>" so they are easy to identify and drop.
+type Compat struct {
+	mode     string
+	mappings []compatMapping
+}
+
+// NewCompat returns the mapping for mode. An empty mode disables it.
+func NewCompat(mode string) (*Compat, error) {
+	if mode == "" {
+		return nil, nil
+	}
+	mappings, ok := compatTables[mode]
+	if !ok {
+		return nil, fmt.Errorf("unknown compatibility mode %q", mode)
+	}
+	return &Compat{mode: mode, mappings: mappings}, nil
+}
+
+// Wrap returns a Gatherer adding the legacy families to everything g gathers.
+// A nil Compat returns g unchanged.
+func (c *Compat) Wrap(g prometheus.Gatherer) prometheus.Gatherer {
+	if c == nil {
+		return g
+	}
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		mfs, err := g.Gather()
+		return c.apply(mfs), err
+	})
+}
+
+func (c *Compat) apply(mfs []*dto.MetricFamily) []*dto.MetricFamily {
+	sources := make(map[string]*dto.MetricFamily, len(mfs))
+	for _, mf := range mfs {
+		sources[mf.GetName()] = mf
+	}
+
+	targets := map[string]*dto.MetricFamily{}
+	for _, mapping := range c.mappings {
+		source, ok := sources[mapping.source]
+		if !ok {
+			continue
+		}
+		if _, ok := sources[mapping.target]; ok {
+			// Never shadow a family that is already exported.
+			continue
+		}
+		target, ok := targets[mapping.target]
+		if !ok {
+			target = &dto.MetricFamily{
+				Name: proto.String(mapping.target),
+				Help: proto.String(fmt.Sprintf("Compatibility copy of %s.", mapping.source)),
+				Type: mapping.targetType.Enum(),
+			}
+			targets[mapping.target] = target
+		}
+		for _, m := range source.Metric {
+			if mapping.matchLabel != "" && labelValue(m, mapping.matchLabel) != mapping.matchValue {
+				continue
+			}
+			target.Metric = append(target.Metric, c.copyMetric(m, mapping))
+		}
+	}
+
+	for _, target := range targets {
+		if len(target.Metric) > 0 {
+			sort.Slice(target.Metric, func(i, j int) bool {
+				return labelSignature(target.Metric[i]) < labelSignature(target.Metric[j])
+			})
+			mfs = append(mfs, target)
+		}
+	}
+	sort.Slice(mfs, func(i, j int) bool { return mfs[i].GetName() < mfs[j].GetName() })
+	return mfs
+}
+
+func (c *Compat) copyMetric(m *dto.Metric, mapping compatMapping) *dto.Metric {
+	labels := map[string]string{"compat": c.mode}
+	for _, lp := range m.Label {
+		if lp.GetName() != mapping.matchLabel {
+			labels[lp.GetName()] = lp.GetValue()
+		}
+	}
+	for name, value := range mapping.labels {
+		labels[name] = value
+	}
+
+	value := metricValue(m)
+	metric := &dto.Metric{TimestampMs: m.TimestampMs}
+	for name, value := range labels {
+		metric.Label = append(metric.Label, &dto.LabelPair{Name: proto.String(name), Value: proto.String(value)})
+	}
+	sort.Slice(metric.Label, func(i, j int) bool { return metric.Label[i].GetName() < metric.Label[j].GetName() })
+	switch mapping.targetType {
+	case dto.MetricType_COUNTER:
+		metric.Counter = &dto.Counter{Value: proto.Float64(value)}
+	case dto.MetricType_GAUGE:
+		metric.Gauge = &dto.Gauge{Value: proto.Float64(value)}
+	default:
+		metric.Untyped = &dto.Untyped{Value: proto.Float64(value)}
+	}
+	return metric
+}
+
+// metricValue returns the value of a counter, gauge or untyped sample.
+func metricValue(m *dto.Metric) float64 {
+	switch {
+	case m.Counter != nil:
+		return m.GetCounter().GetValue()
+	case m.Gauge != nil:
+		return m.GetGauge().GetValue()
+	}
+	return m.GetUntyped().GetValue()
+}
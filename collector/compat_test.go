@@ -0,0 +1,106 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// compatTestRegistry returns a registry with the sources of the mysqld
+// mappings and a family without a mapping.
+func compatTestRegistry() *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	gauge := func(name string, value float64) {
+		g := prometheus.NewGauge(prometheus.GaugeOpts{Name: name, Help: "Source."})
+		g.Set(value)
+		reg.MustRegister(g)
+	}
+	counter := func(name string, value float64) {
+		c := prometheus.NewCounterVec(prometheus.CounterOpts{Name: name, Help: "Source."}, []string{"database"})
+		c.WithLabelValues("demodb").Add(value)
+		reg.MustRegister(c)
+	}
+	gauge("cubrid_up", 1)
+	gauge("cubrid_sessions_total", 4)
+	counter("cubrid_rows_inserted_total", 5)
+	counter("cubrid_rows_updated_total", 6)
+	counter("cubrid_rows_deleted_total", 7)
+	counter("cubrid_statdump_query_selects_total", 100)
+	counter("cubrid_statdump_query_inserts_total", 10)
+	counter("cubrid_statdump_query_updates_total", 20)
+	counter("cubrid_statdump_query_deletes_total", 30)
+	counter("cubrid_statdump_data_page_fetches_total", 1000)
+	counter("cubrid_statdump_data_page_ioreads_total", 50)
+	brokers := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "cubrid_broker_status_num_as", Help: "Source."}, []string{"broker_name"})
+	brokers.WithLabelValues("broker1").Set(5)
+	reg.MustRegister(brokers)
+	return reg
+}
+
+// TestCompatMysqldGolden compares the CUBRID names and their mysqld_exporter
+// copies with testdata/compat/mysqld.prom.
+func TestCompatMysqldGolden(t *testing.T) {
+	c, err := NewCompat(CompatMysqld)
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden, err := os.Open(filepath.Join("testdata", "compat", "mysqld.prom"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer golden.Close()
+	if err := testutil.GatherAndCompare(c.Wrap(compatTestRegistry()), golden); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCompatNoShadowing(t *testing.T) {
+	c, err := NewCompat(CompatMysqld)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := compatTestRegistry()
+	up := prometheus.NewGauge(prometheus.GaugeOpts{Name: "mysql_up", Help: "Already exported."})
+	reg.MustRegister(up)
+
+	// An exported family of the legacy name is left alone.
+	expected := `
+# HELP mysql_up Already exported.
+# TYPE mysql_up gauge
+mysql_up 0
+`
+	if err := testutil.GatherAndCompare(c.Wrap(reg), strings.NewReader(expected), "mysql_up"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNewCompat(t *testing.T) {
+	if c, err := NewCompat(""); c != nil || err != nil {
+		t.Errorf("NewCompat(\"\") = %v, %v, want no mapping", c, err)
+	}
+	reg := compatTestRegistry()
+	var disabled *Compat
+	if g := disabled.Wrap(reg); g != prometheus.Gatherer(reg) {
+		t.Error("expected the gatherer unchanged without a mode")
+	}
+	if _, err := NewCompat("postgres"); err == nil {
+		t.Error("expected an error for an unknown mode")
+	}
+}
@@ -0,0 +1,264 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Instrumentation of database connection establishment.
+
+package collector
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	maxOpenConns = kingpin.Flag(
+		"exporter.max-open-conns",
+		"Maximum number of open database connections.",
+	).Default("1").Int()
+	connMaxLifetime = kingpin.Flag(
+		"exporter.conn-max-lifetime",
+		"Maximum amount of time a database connection may be reused.",
+	).Default("1m").Duration()
+	poolIdleTimeout = kingpin.Flag(
+		"exporter.pool-idle-timeout",
+		"Close the connection pool of a target not scraped for this long, e.g. of a /probe target no longer probed.",
+	).Default("10m").Duration()
+	maxPools = kingpin.Flag(
+		"exporter.max-pools",
+		"Maximum number of idle connection pools kept open. The least recently used pool is closed beyond it.",
+	).Default("32").Int()
+)
+
+// Reasons a pooled connection was closed.
+const (
+	closedMaxLifetime = "max_lifetime"
+	closedMaxIdle     = "max_idle"
+	closedError       = "error"
+)
+
+// Stages of connection establishment. The driver dials, hands the
+// connection to a CAS and authenticates in one call, so these steps are
+// timed together as stageDriverOpen.
+const (
+	stageResolve    = "resolve"
+	stageDriverOpen = "tcp_handshake_auth"
+)
+
+var connectStageDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: namespace,
+	Subsystem: exporter,
+	Name:      "connect_stage_duration_seconds",
+	Help:      "Duration of the stages of establishing a database connection, including failed attempts.",
+	Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+}, []string{"stage"})
+
+// ConnectStages exports the durations of the connection establishment stages.
+var ConnectStages prometheus.Collector = connectStageDuration
+
+// timeConnectStage observes the duration of stage started at start.
+func timeConnectStage(stage string, start time.Time) {
+	connectStageDuration.WithLabelValues(stage).Observe(time.Since(start).Seconds())
+}
+
+// countingConnector opens connections through the driver and counts them,
+// as database/sql does not report when a connection is established. The
+// broker host is resolved through DNSCache for every new connection.
+type countingConnector struct {
+	driver driver.Driver
+	dsn    string
+	opened int64
+}
+
+// Connect implements driver.Connector.
+func (c *countingConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	start := time.Now()
+	dsn, err := resolveDSN(ctx, c.dsn)
+	timeConnectStage(stageResolve, start)
+	if err != nil {
+		return nil, err
+	}
+	start = time.Now()
+	conn, err := c.driver.Open(dsn)
+	timeConnectStage(stageDriverOpen, start)
+	if err != nil {
+		return nil, err
+	}
+	atomic.AddInt64(&c.opened, 1)
+	return auditConn{conn}, nil
+}
+
+// Driver implements driver.Connector.
+func (c *countingConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// connectionPool is a database handle shared by all scrapes of a DSN.
+type connectionPool struct {
+	db        *sql.DB
+	connector *countingConnector
+
+	// users and lastUsed are guarded by connectionPools. A pool with users
+	// is never evicted.
+	users    int
+	lastUsed time.Time
+
+	// mu guards the totals accounted so far.
+	mu                                 sync.Mutex
+	opened, lifetimeClosed, idleClosed int64
+	errorClosed                        int64
+}
+
+// connectionPools is shared between scrapes, as the Exporter is created per request.
+var connectionPools = struct {
+	sync.Mutex
+	pools map[string]*connectionPool
+}{pools: map[string]*connectionPool{}}
+
+// poolNow is replaced to test the eviction of idle pools.
+var poolNow = time.Now
+
+func newConnector(dsn string) (*countingConnector, error) {
+	// sql.Open does not connect; it only looks up the registered driver.
+	db, err := sql.Open("cubrid", dsn)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	return &countingConnector{driver: db.Driver(), dsn: dsn}, nil
+}
+
+// OpenDB returns a database handle outside the shared pools, e.g. for
+// startup checks. Its statements are audited and count against the query
+// budget like those of the collectors.
+func OpenDB(dsn string) (*sql.DB, error) {
+	connector, err := newConnector(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return sql.OpenDB(connector), nil
+}
+
+// getPool returns the connection pool for dsn, creating it on first use.
+// The pool must be returned with releasePool once the scrape is done.
+func getPool(dsn string) (*connectionPool, error) {
+	connectionPools.Lock()
+	defer connectionPools.Unlock()
+	evictPools(poolNow())
+	if pool, ok := connectionPools.pools[dsn]; ok {
+		pool.users++
+		return pool, nil
+	}
+
+	connector, err := newConnector(dsn)
+	if err != nil {
+		return nil, err
+	}
+	db := sql.OpenDB(connector)
+	db.SetMaxOpenConns(*maxOpenConns)
+	db.SetMaxIdleConns(*maxOpenConns)
+	db.SetConnMaxLifetime(*connMaxLifetime)
+
+	pool := &connectionPool{db: db, connector: connector, users: 1}
+	connectionPools.pools[dsn] = pool
+	return pool, nil
+}
+
+// releasePool returns a pool obtained from getPool.
+func releasePool(pool *connectionPool) {
+	connectionPools.Lock()
+	defer connectionPools.Unlock()
+	pool.users--
+	pool.lastUsed = poolNow()
+}
+
+// evictPools closes the pools unused since --exporter.pool-idle-timeout and
+// the least recently used ones beyond --exporter.max-pools, so that probing
+// many targets does not hold their connections forever. Pools in use are
+// kept. connectionPools must be locked.
+func evictPools(now time.Time) {
+	var idle []string
+	for dsn, pool := range connectionPools.pools {
+		if pool.users > 0 {
+			continue
+		}
+		if *poolIdleTimeout > 0 && now.Sub(pool.lastUsed) > *poolIdleTimeout {
+			closePool(dsn)
+			continue
+		}
+		idle = append(idle, dsn)
+	}
+	if len(idle) <= *maxPools {
+		return
+	}
+	sort.Slice(idle, func(i, j int) bool {
+		return connectionPools.pools[idle[i]].lastUsed.Before(connectionPools.pools[idle[j]].lastUsed)
+	})
+	for _, dsn := range idle[:len(idle)-*maxPools] {
+		closePool(dsn)
+	}
+}
+
+func closePool(dsn string) {
+	log.Debugf("Closing the idle connection pool of %s", dsnTarget(dsn))
+	connectionPools.pools[dsn].db.Close()
+	delete(connectionPools.pools, dsn)
+}
+
+// ClosePools closes all connection pools.
+func ClosePools() error {
+	connectionPools.Lock()
+	defer connectionPools.Unlock()
+	for dsn, pool := range connectionPools.pools {
+		pool.db.Close()
+		delete(connectionPools.pools, dsn)
+	}
+	return nil
+}
+
+// recordConnections accounts the connections the pool opened and closed since
+// the last call. Connections closed neither for their lifetime nor for
+// idleness that are no longer open were discarded after an error.
+func (m Metrics) recordConnections(pool *connectionPool) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	stats := pool.db.Stats()
+	opened := atomic.LoadInt64(&pool.connector.opened)
+	errorClosed := opened - stats.MaxLifetimeClosed - stats.MaxIdleClosed - int64(stats.OpenConnections)
+
+	newConns := opened - pool.opened
+	m.ConnectionsOpened.Add(float64(newConns))
+	m.ConnectionsClosed.WithLabelValues(closedMaxLifetime).Add(float64(stats.MaxLifetimeClosed - pool.lifetimeClosed))
+	m.ConnectionsClosed.WithLabelValues(closedMaxIdle).Add(float64(stats.MaxIdleClosed - pool.idleClosed))
+	if errorClosed > pool.errorClosed {
+		m.ConnectionsClosed.WithLabelValues(closedError).Add(float64(errorClosed - pool.errorClosed))
+		pool.errorClosed = errorClosed
+	}
+	pool.opened, pool.lifetimeClosed, pool.idleClosed = opened, stats.MaxLifetimeClosed, stats.MaxIdleClosed
+
+	if newConns > 0 {
+		m.EstablishedNewConnection.Set(1)
+		m.NewConnectionScrapes.Inc()
+	} else {
+		m.EstablishedNewConnection.Set(0)
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// delayedResolver resolves every host to 10.0.0.1 after delay.
+type delayedResolver struct {
+	delay time.Duration
+}
+
+func (r delayedResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	time.Sleep(r.delay)
+	return []string{"10.0.0.1"}, nil
+}
+
+// delayedDriver opens connections after delay, remembering the last DSN.
+type delayedDriver struct {
+	delay time.Duration
+	dsn   string
+}
+
+func (d *delayedDriver) Open(dsn string) (driver.Conn, error) {
+	time.Sleep(d.delay)
+	d.dsn = dsn
+	return countingConn{&countingDriver{}}, nil
+}
+
+// stageBuckets returns the cumulative bucket counts of the duration
+// histogram of stage by upper bound.
+func stageBuckets(t *testing.T, stage string) map[float64]uint64 {
+	var m dto.Metric
+	if err := connectStageDuration.WithLabelValues(stage).(prometheus.Metric).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	buckets := map[float64]uint64{}
+	for _, b := range m.Histogram.Bucket {
+		buckets[b.GetUpperBound()] = b.GetCumulativeCount()
+	}
+	return buckets
+}
+
+// TestConnectStages delays the resolution and then the driver and checks
+// that each delay is attributed to its stage.
+func TestConnectStages(t *testing.T) {
+	cache := DNSCache
+	DNSCache = NewResolverCache(delayedResolver{delay: 60 * time.Millisecond})
+	defer func() { DNSCache = cache }()
+
+	d := &delayedDriver{}
+	connector := &countingConnector{driver: d, dsn: "cci:cubrid:stages.example:33000:demodb:::"}
+	for _, tc := range []struct {
+		name string
+		// driverDelay is the delay of the driver; the host name is only
+		// resolved inline by the first connection.
+		driverDelay time.Duration
+		slow, fast  string
+	}{
+		{"slow resolution", 0, stageResolve, stageDriverOpen},
+		{"slow driver", 60 * time.Millisecond, stageDriverOpen, stageResolve},
+	} {
+		slowBefore, fastBefore := stageBuckets(t, tc.slow), stageBuckets(t, tc.fast)
+		d.delay = tc.driverDelay
+		if _, err := connector.Connect(context.Background()); err != nil {
+			t.Fatalf("%s: %s", tc.name, err)
+		}
+		slow, fast := stageBuckets(t, tc.slow), stageBuckets(t, tc.fast)
+		if slow[.05]-slowBefore[.05] != 0 || slow[.25]-slowBefore[.25] != 1 {
+			t.Errorf("%s: %s was not observed between 50ms and 250ms", tc.name, tc.slow)
+		}
+		if fast[.025]-fastBefore[.025] != 1 {
+			t.Errorf("%s: %s was not observed below 25ms", tc.name, tc.fast)
+		}
+	}
+	if d.dsn != "cci:cubrid:10.0.0.1:33000:demodb:::" {
+		t.Errorf("DSN opened by the driver = %q, want the resolved address", d.dsn)
+	}
+}
+
+// withTestPools replaces the connection pools by idle ones for the DSNs,
+// last used at the given times, and restores them after the test.
+func withTestPools(t *testing.T, lastUsed map[string]time.Time) map[string]*connectionPool {
+	connectionPools.Lock()
+	saved := connectionPools.pools
+	pools := map[string]*connectionPool{}
+	connectionPools.pools = map[string]*connectionPool{}
+	for dsn, used := range lastUsed {
+		connector := &countingConnector{driver: &countingDriver{}, dsn: dsn}
+		pools[dsn] = &connectionPool{db: sql.OpenDB(connector), connector: connector, lastUsed: used}
+		connectionPools.pools[dsn] = pools[dsn]
+	}
+	connectionPools.Unlock()
+	t.Cleanup(func() {
+		connectionPools.Lock()
+		for _, pool := range connectionPools.pools {
+			pool.db.Close()
+		}
+		connectionPools.pools = saved
+		connectionPools.Unlock()
+	})
+	return pools
+}
+
+// openPools returns the DSNs with an open pool, in order.
+func openPools() []string {
+	connectionPools.Lock()
+	defer connectionPools.Unlock()
+	var dsns []string
+	for dsn := range connectionPools.pools {
+		dsns = append(dsns, dsn)
+	}
+	sort.Strings(dsns)
+	return dsns
+}
+
+func TestEvictPoolsIdle(t *testing.T) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	pools := withTestPools(t, map[string]time.Time{
+		"cci:cubrid:idle:33000:demodb:::":   now.Add(-*poolIdleTimeout - time.Second),
+		"cci:cubrid:in-use:33000:demodb:::": now.Add(-*poolIdleTimeout - time.Second),
+		"cci:cubrid:recent:33000:demodb:::": now.Add(-time.Minute),
+	})
+	pools["cci:cubrid:in-use:33000:demodb:::"].users = 1
+
+	connectionPools.Lock()
+	evictPools(now)
+	connectionPools.Unlock()
+
+	want := []string{"cci:cubrid:in-use:33000:demodb:::", "cci:cubrid:recent:33000:demodb:::"}
+	if got := openPools(); !reflect.DeepEqual(got, want) {
+		t.Errorf("open pools = %v, want %v", got, want)
+	}
+	if err := pools["cci:cubrid:idle:33000:demodb:::"].db.Ping(); err == nil {
+		t.Error("the evicted pool is still open")
+	}
+}
+
+func TestEvictPoolsLeastRecentlyUsed(t *testing.T) {
+	defer func(max int) { *maxPools = max }(*maxPools)
+	*maxPools = 2
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	withTestPools(t, map[string]time.Time{
+		"cci:cubrid:oldest:33000:demodb:::": now.Add(-3 * time.Minute),
+		"cci:cubrid:older:33000:demodb:::":  now.Add(-2 * time.Minute),
+		"cci:cubrid:newest:33000:demodb:::": now.Add(-time.Minute),
+	})
+
+	connectionPools.Lock()
+	evictPools(now)
+	connectionPools.Unlock()
+
+	want := []string{"cci:cubrid:newest:33000:demodb:::", "cci:cubrid:older:33000:demodb:::"}
+	if got := openPools(); !reflect.DeepEqual(got, want) {
+		t.Errorf("open pools = %v, want %v", got, want)
+	}
+}
+
+// TestReleasePool checks that a pool is kept while in use and evicted once
+// released and idle.
+func TestReleasePool(t *testing.T) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	defer func() { poolNow = time.Now }()
+	poolNow = func() time.Time { return now }
+	const dsn = "cci:cubrid:probed:33000:demodb:::"
+	withTestPools(t, map[string]time.Time{dsn: now})
+
+	pool, err := getPool(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(2 * *poolIdleTimeout)
+	connectionPools.Lock()
+	evictPools(now)
+	connectionPools.Unlock()
+	if got := openPools(); len(got) != 1 {
+		t.Fatalf("pool in use was evicted")
+	}
+
+	releasePool(pool)
+	now = now.Add(*poolIdleTimeout + time.Second)
+	connectionPools.Lock()
+	evictPools(now)
+	connectionPools.Unlock()
+	if got := openPools(); len(got) != 0 {
+		t.Errorf("open pools = %v after the idle timeout, want none", got)
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Coverage of the server statistics by the metrics the exporter exports.
+
+package collector
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Coverage sources.
+const (
+	coverageStatdump = "statdump"
+	coverageBroker   = "broker"
+	coverageSpacedb  = "spacedb"
+)
+
+var coverageSources = []string{coverageStatdump, coverageBroker, coverageSpacedb}
+
+// maxCoverageItems bounds the items remembered per source.
+const maxCoverageItems = 1000
+
+var coverageRatioDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, exporter, "coverage_ratio"),
+	"Share of the statdump keys or status columns observed from the server that a collector consumes.",
+	[]string{"source"}, nil,
+)
+
+// CoverageItem is a statdump key or column observed from the server.
+type CoverageItem struct {
+	Name     string `json:"name"`
+	Consumed bool   `json:"consumed"`
+	// Subsystem is the activity subsystem of a statdump key, if any.
+	Subsystem string `json:"subsystem,omitempty"`
+}
+
+// CoverageSource summarizes the coverage of one source.
+type CoverageSource struct {
+	Source   string  `json:"source"`
+	Observed int     `json:"observed"`
+	Consumed int     `json:"consumed"`
+	Ratio    float64 `json:"ratio"`
+	// SubsystemsVersion is the version of the mapping of the items to
+	// subsystems, 0 for sources without one.
+	SubsystemsVersion int            `json:"subsystems_version,omitempty"`
+	Items             []CoverageItem `json:"items"`
+}
+
+// CoverageReport holds the items observed by the scrapes so far. It
+// implements prometheus.Collector for the coverage ratios.
+type CoverageReport struct {
+	mu sync.Mutex
+	// items maps source and name to whether the name is consumed.
+	items map[string]map[string]bool
+}
+
+// Coverage is the coverage of all scrapes.
+var Coverage = &CoverageReport{items: map[string]map[string]bool{}}
+
+// observe records a statdump key or column of source.
+func (c *CoverageReport) observe(source, name string, consumed bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	items, ok := c.items[source]
+	if !ok {
+		items = map[string]bool{}
+		c.items[source] = items
+	}
+	if _, ok := items[name]; ok || len(items) < maxCoverageItems {
+		items[name] = consumed
+	}
+}
+
+// observeColumns records the columns of a result set of which the scraper
+// consumes the first consumed ones by position.
+func (c *CoverageReport) observeColumns(source string, columns []string, consumed int) {
+	for i, column := range columns {
+		c.observe(source, strings.ToLower(column), i < consumed)
+	}
+}
+
+// statdumpConsumed reports whether a dedicated metric or a derived counter uses the key.
+func statdumpConsumed(key string) bool {
+	if _, ok := statdumpDescs[key]; ok || key == statdumpCommitsKey {
+		return true
+	}
+	for _, counter := range statdumpRowCounters {
+		for _, k := range counter.keys {
+			if k == key {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Sources returns the coverage per source, with the unconsumed items first.
+func (c *CoverageReport) Sources() []CoverageSource {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var sources []CoverageSource
+	for _, source := range coverageSources {
+		s := CoverageSource{Source: source, Items: []CoverageItem{}}
+		if source == coverageStatdump {
+			s.SubsystemsVersion = activitySubsystemsVersion
+		}
+		for name, consumed := range c.items[source] {
+			item := CoverageItem{Name: name, Consumed: consumed}
+			if source == coverageStatdump {
+				item.Subsystem = subsystemOfStatdumpKey(name)
+			}
+			s.Items = append(s.Items, item)
+			s.Observed++
+			if consumed {
+				s.Consumed++
+			}
+		}
+		if s.Observed > 0 {
+			s.Ratio = float64(s.Consumed) / float64(s.Observed)
+		}
+		sort.Slice(s.Items, func(i, j int) bool {
+			if s.Items[i].Consumed != s.Items[j].Consumed {
+				return !s.Items[i].Consumed
+			}
+			return s.Items[i].Name < s.Items[j].Name
+		})
+		sources = append(sources, s)
+	}
+	return sources
+}
+
+// Describe implements prometheus.Collector.
+func (c *CoverageReport) Describe(ch chan<- *prometheus.Desc) {
+	ch <- coverageRatioDesc
+}
+
+// Collect implements prometheus.Collector. Sources nothing was observed
+// from yet are omitted.
+func (c *CoverageReport) Collect(ch chan<- prometheus.Metric) {
+	for _, s := range c.Sources() {
+		if s.Observed > 0 {
+			ch <- prometheus.MustNewConstMetric(coverageRatioDesc, prometheus.GaugeValue, s.Ratio, s.Source)
+		}
+	}
+}
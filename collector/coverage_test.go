@@ -0,0 +1,74 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestCoverage(t *testing.T) {
+	coverage := Coverage
+	Coverage = &CoverageReport{items: map[string]map[string]bool{}}
+	defer func() { Coverage = coverage }()
+
+	db, mock := newMock(t)
+	defer db.Close()
+	expectDatabase(mock, "coveragetest")
+	mock.ExpectQuery("show statdump coveragetest").WillReturnRows(sqlmock.NewRows(statdumpTestColumns).
+		AddRow("Num_data_page_fetches", "10").
+		AddRow("Num_tran_commits", "7").
+		AddRow("Num_unheard_of", "11").
+		AddRow("Num_never_consumed", "12"))
+	mock.ExpectQuery(brokerStatusQuery).WillReturnRows(sqlmock.NewRows(append(brokerTestColumns(), "EXTRA_COLUMN")).
+		AddRow("broker1", "1", "1", "30000", "0", "0", "0", "0", "0", "0", "0", "0", "0", "0", "0", "9"))
+	if err := drainScrape(ScrapeStatdump{}, db); err != nil {
+		t.Fatalf("error scraping statdump: %s", err)
+	}
+	// The unknown column fails the scan, but is still reported.
+	if err := drainScrape(ScrapeBrokerStatus{}, db); err == nil {
+		t.Error("expected an error for the unknown broker column")
+	}
+
+	sources := map[string]CoverageSource{}
+	for _, s := range Coverage.Sources() {
+		sources[s.Source] = s
+	}
+	statdump := sources[coverageStatdump]
+	// Num_tran_commits feeds a derived counter, so it counts as consumed.
+	if statdump.Observed != 4 || statdump.Consumed != 2 || statdump.Ratio != 0.5 {
+		t.Errorf("statdump coverage = %d of %d (%v), want 2 of 4 (0.5)", statdump.Consumed, statdump.Observed, statdump.Ratio)
+	}
+	var listed []string
+	for _, item := range statdump.Items {
+		listed = append(listed, fmt.Sprintf("%s=%v", item.Name, item.Consumed))
+	}
+	want := "[Num_never_consumed=false Num_unheard_of=false Num_data_page_fetches=true Num_tran_commits=true]"
+	if got := fmt.Sprint(listed); got != want {
+		t.Errorf("statdump items = %s, want the unconsumed first: %s", got, want)
+	}
+
+	broker := sources[coverageBroker]
+	if broker.Observed != len(brokerTestColumns())+1 || broker.Consumed != len(brokerTestColumns()) {
+		t.Errorf("broker coverage = %d of %d, want %d of %d", broker.Consumed, broker.Observed, len(brokerTestColumns()), len(brokerTestColumns())+1)
+	}
+	if len(broker.Items) == 0 || broker.Items[0].Name != "extra_column" || broker.Items[0].Consumed {
+		t.Errorf("first broker item = %+v, want the unconsumed extra_column", broker.Items)
+	}
+	if s := sources[coverageSpacedb]; s.Observed != 0 || s.Ratio != 0 {
+		t.Errorf("spacedb coverage without a scrape = %+v", s)
+	}
+}
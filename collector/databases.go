@@ -0,0 +1,154 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Selection of the databases scraped by per-database scrapers.
+
+package collector
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+const (
+	// databasesQuery lists the databases of the server.
+	databasesQuery = "show databases"
+	// databasesCacheTTL is how long an auto-discovered database list is reused.
+	databasesCacheTTL = time.Minute
+)
+
+var (
+	collectDatabases = kingpin.Flag(
+		"cubrid.databases",
+		"Comma-separated databases scraped by the per-database scrapers. Can be repeated. Defaults to the database of the connection.",
+	).Strings()
+	collectDatabasesAutoDiscover = kingpin.Flag(
+		"cubrid.databases.auto-discover",
+		"Scrape every database the server lists instead of --cubrid.databases.",
+	).Default("false").Bool()
+)
+
+// databaseNameRE matches the database names that can be passed to SHOW statements.
+var databaseNameRE = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+type discoveredList struct {
+	names   []string
+	fetched time.Time
+}
+
+// discoveredDatabases caches the discovered database lists by target.
+var discoveredDatabases = struct {
+	sync.Mutex
+	lists map[string]discoveredList
+}{lists: map[string]discoveredList{}}
+
+// targetDatabases returns the databases the per-database scrapers iterate over.
+func targetDatabases(ctx context.Context, db *sql.DB) ([]string, error) {
+	var names []string
+	switch {
+	case *collectDatabasesAutoDiscover:
+		var err error
+		if names, err = discoverDatabases(ctx, db); err != nil {
+			return nil, err
+		}
+	case len(*collectDatabases) > 0:
+		for _, flag := range *collectDatabases {
+			for _, name := range strings.Split(flag, ",") {
+				if name = strings.TrimSpace(name); name != "" {
+					names = append(names, name)
+				}
+			}
+		}
+	default:
+		var name string
+		if err := db.QueryRowContext(ctx, inventoryDatabaseQuery).Scan(&name); err != nil {
+			return nil, err
+		}
+		names = []string{name}
+	}
+
+	for _, name := range names {
+		if !databaseNameRE.MatchString(name) {
+			return nil, fmt.Errorf("invalid database name %q", name)
+		}
+	}
+	return names, nil
+}
+
+// discoverDatabases queries the server for its database names, caching them
+// per target for databasesCacheTTL.
+func discoverDatabases(ctx context.Context, db *sql.DB) ([]string, error) {
+	key := targetScoped(ctx, "databases")
+	discoveredDatabases.Lock()
+	list, ok := discoveredDatabases.lists[key]
+	discoveredDatabases.Unlock()
+	if ok && time.Since(list.fetched) < databasesCacheTTL {
+		return list.names, nil
+	}
+
+	rows, err := db.QueryContext(ctx, databasesQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, strings.TrimSpace(name))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	discoveredDatabases.Lock()
+	defer discoveredDatabases.Unlock()
+	for k, list := range discoveredDatabases.lists {
+		if now.Sub(list.fetched) >= databasesCacheTTL {
+			delete(discoveredDatabases.lists, k)
+		}
+	}
+	discoveredDatabases.lists[key] = discoveredList{names: names, fetched: now}
+	return names, nil
+}
+
+// scrapeDatabases runs scrape for every target database. A failing database
+// is recorded as a sub-collector and does not stop the remaining ones.
+func scrapeDatabases(ctx context.Context, db *sql.DB, scrape func(database string) (int, error)) error {
+	databases, err := targetDatabases(ctx, db)
+	if err != nil {
+		return err
+	}
+	var failed []string
+	for _, database := range databases {
+		database := database
+		if err := runSubCollector(ctx, database, func() (int, error) { return scrape(database) }); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", database, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d databases failed: %s", len(failed), len(databases), strings.Join(failed, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// withAutoDiscover enables the auto-discovery of databases with an empty
+// cache for the test.
+func withAutoDiscover(t *testing.T) {
+	autoDiscover := *collectDatabasesAutoDiscover
+	*collectDatabasesAutoDiscover = true
+	forget := func() {
+		discoveredDatabases.Lock()
+		discoveredDatabases.lists = map[string]discoveredList{}
+		discoveredDatabases.Unlock()
+	}
+	forget()
+	t.Cleanup(func() {
+		*collectDatabasesAutoDiscover = autoDiscover
+		forget()
+	})
+}
+
+func TestTargetDatabasesAutoDiscover(t *testing.T) {
+	withAutoDiscover(t)
+	db, mock := newMock(t)
+	defer db.Close()
+
+	// Each target queries its server once within databasesCacheTTL.
+	mock.ExpectQuery(databasesQuery).WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("demodb").AddRow(" testdb "))
+	mock.ExpectQuery(databasesQuery).WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("otherdb"))
+	for _, tc := range []struct {
+		target string
+		want   []string
+	}{
+		{"first:33000", []string{"demodb", "testdb"}},
+		{"first:33000", []string{"demodb", "testdb"}},
+		{"second:33000", []string{"otherdb"}},
+		{"second:33000", []string{"otherdb"}},
+	} {
+		names, err := targetDatabases(withTarget(context.Background(), tc.target), db)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.target, err)
+		}
+		if len(names) != len(tc.want) {
+			t.Fatalf("%s: databases = %q, want %q", tc.target, names, tc.want)
+		}
+		for i := range names {
+			if names[i] != tc.want[i] {
+				t.Errorf("%s: databases = %q, want %q", tc.target, names, tc.want)
+			}
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestTargetDatabasesAutoDiscoverErrors(t *testing.T) {
+	withAutoDiscover(t)
+	db, mock := newMock(t)
+	defer db.Close()
+	ctx := withTarget(context.Background(), "errors:33000")
+
+	// A failed discovery is not cached, and invalid names are rejected.
+	mock.ExpectQuery(databasesQuery).WillReturnError(errTestRow)
+	mock.ExpectQuery(databasesQuery).WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("demodb; drop"))
+	if _, err := targetDatabases(ctx, db); err == nil {
+		t.Error("expected the error of the query")
+	}
+	if _, err := targetDatabases(ctx, db); err == nil {
+		t.Error("expected an error for an invalid database name")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,462 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Metrics computed from gathered families by arithmetic expressions.
+
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// DerivedMetric defines a family computed from other families of the same scrape.
+type DerivedMetric struct {
+	// Name of the computed family.
+	Name string `yaml:"name"`
+	Help string `yaml:"help"`
+	// Expr is an arithmetic expression with + - * /, parentheses, numbers
+	// and selectors like cubrid_spacedb_info{key="used_pages"}.
+	Expr string `yaml:"expr"`
+	// On are the labels joining the samples of the selectors. Samples of a
+	// selector with the same values of these labels are summed.
+	On []string `yaml:"on"`
+	// Type is gauge (default), counter or untyped.
+	Type string `yaml:"type"`
+
+	expr     derivedExpr
+	selected []*derivedSelector
+	typ      dto.MetricType
+}
+
+// derivedNameRE matches valid metric names.
+var derivedNameRE = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+// errDivisionByZero drops the sample whose evaluation divided by zero.
+var errDivisionByZero = errors.New("division by zero")
+
+// derivedValues holds the summed samples of each selector by join key.
+type derivedValues map[*derivedSelector]map[string]float64
+
+// derivedExpr is a node of a parsed expression.
+type derivedExpr interface {
+	eval(values derivedValues, key string) (float64, error)
+}
+
+type derivedLiteral float64
+
+func (l derivedLiteral) eval(derivedValues, string) (float64, error) {
+	return float64(l), nil
+}
+
+type derivedNeg struct {
+	x derivedExpr
+}
+
+func (n derivedNeg) eval(values derivedValues, key string) (float64, error) {
+	v, err := n.x.eval(values, key)
+	return -v, err
+}
+
+type derivedBinary struct {
+	op   byte
+	x, y derivedExpr
+}
+
+func (b derivedBinary) eval(values derivedValues, key string) (float64, error) {
+	x, err := b.x.eval(values, key)
+	if err != nil {
+		return 0, err
+	}
+	y, err := b.y.eval(values, key)
+	if err != nil {
+		return 0, err
+	}
+	switch b.op {
+	case '+':
+		return x + y, nil
+	case '-':
+		return x - y, nil
+	case '*':
+		return x * y, nil
+	}
+	if y == 0 {
+		return 0, errDivisionByZero
+	}
+	return x / y, nil
+}
+
+// derivedSelector selects the samples of a family with the given label values.
+type derivedSelector struct {
+	family   string
+	matchers map[string]string
+}
+
+func (s *derivedSelector) eval(values derivedValues, key string) (float64, error) {
+	return values[s][key], nil
+}
+
+// derivedParser is a recursive descent parser of the grammar
+//
+//	expr   = term { ("+" | "-") term }
+//	term   = factor { ("*" | "/") factor }
+//	factor = number | selector | "(" expr ")" | "-" factor
+//	selector = name [ "{" label "=" string { "," label "=" string } "}" ]
+type derivedParser struct {
+	input     string
+	pos       int
+	selectors []*derivedSelector
+}
+
+func parseDerivedExpr(input string) (derivedExpr, []*derivedSelector, error) {
+	p := &derivedParser{input: input}
+	expr, err := p.expr()
+	if err != nil {
+		return nil, nil, err
+	}
+	if p.skipSpace(); p.pos < len(p.input) {
+		return nil, nil, p.errorf("unexpected %q", p.input[p.pos])
+	}
+	return expr, p.selectors, nil
+}
+
+func (p *derivedParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("position %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *derivedParser) skipSpace() {
+	for p.pos < len(p.input) && strings.IndexByte(" \t\n", p.input[p.pos]) >= 0 {
+		p.pos++
+	}
+}
+
+// accept consumes c if it is the next non-space character.
+func (p *derivedParser) accept(c byte) bool {
+	p.skipSpace()
+	if p.pos < len(p.input) && p.input[p.pos] == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *derivedParser) expr() (derivedExpr, error) {
+	x, err := p.term()
+	for err == nil {
+		var op byte
+		switch {
+		case p.accept('+'):
+			op = '+'
+		case p.accept('-'):
+			op = '-'
+		default:
+			return x, nil
+		}
+		var y derivedExpr
+		if y, err = p.term(); err == nil {
+			x = derivedBinary{op: op, x: x, y: y}
+		}
+	}
+	return nil, err
+}
+
+func (p *derivedParser) term() (derivedExpr, error) {
+	x, err := p.factor()
+	for err == nil {
+		var op byte
+		switch {
+		case p.accept('*'):
+			op = '*'
+		case p.accept('/'):
+			op = '/'
+		default:
+			return x, nil
+		}
+		var y derivedExpr
+		if y, err = p.factor(); err == nil {
+			x = derivedBinary{op: op, x: x, y: y}
+		}
+	}
+	return nil, err
+}
+
+func (p *derivedParser) factor() (derivedExpr, error) {
+	switch {
+	case p.accept('('):
+		x, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(')') {
+			return nil, p.errorf("missing )")
+		}
+		return x, nil
+	case p.accept('-'):
+		x, err := p.factor()
+		return derivedNeg{x}, err
+	}
+
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.input) && isDerivedNameChar(p.input[p.pos], p.pos == start) {
+		p.pos++
+	}
+	if p.pos > start {
+		return p.selector(p.input[start:p.pos])
+	}
+	for p.pos < len(p.input) && strings.IndexByte("0123456789.eE", p.input[p.pos]) >= 0 {
+		p.pos++
+	}
+	if p.pos == start {
+		return nil, p.errorf("expected number, name or (")
+	}
+	v, err := strconv.ParseFloat(p.input[start:p.pos], 64)
+	if err != nil {
+		return nil, p.errorf("invalid number %q", p.input[start:p.pos])
+	}
+	return derivedLiteral(v), nil
+}
+
+func (p *derivedParser) selector(family string) (derivedExpr, error) {
+	s := &derivedSelector{family: family, matchers: map[string]string{}}
+	p.selectors = append(p.selectors, s)
+	if !p.accept('{') {
+		return s, nil
+	}
+	for !p.accept('}') {
+		if len(s.matchers) > 0 && !p.accept(',') {
+			return nil, p.errorf("expected , or }")
+		}
+		p.skipSpace()
+		start := p.pos
+		for p.pos < len(p.input) && isDerivedNameChar(p.input[p.pos], p.pos == start) && p.input[p.pos] != ':' {
+			p.pos++
+		}
+		label := p.input[start:p.pos]
+		if label == "" || !p.accept('=') || !p.accept('"') {
+			return nil, p.errorf(`expected label="value"`)
+		}
+		end := strings.IndexByte(p.input[p.pos:], '"')
+		if end < 0 {
+			return nil, p.errorf("unterminated label value")
+		}
+		s.matchers[label] = p.input[p.pos : p.pos+end]
+		p.pos += end + 1
+	}
+	return s, nil
+}
+
+func isDerivedNameChar(c byte, first bool) bool {
+	return c == '_' || c == ':' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || !first && c >= '0' && c <= '9'
+}
+
+// compile validates the definition and parses its expression.
+func (d *DerivedMetric) compile() error {
+	if !derivedNameRE.MatchString(d.Name) {
+		return fmt.Errorf("invalid name %q", d.Name)
+	}
+	switch d.Type {
+	case "", "gauge":
+		d.typ = dto.MetricType_GAUGE
+	case "counter":
+		d.typ = dto.MetricType_COUNTER
+	case "untyped":
+		d.typ = dto.MetricType_UNTYPED
+	default:
+		return fmt.Errorf("unknown type %q", d.Type)
+	}
+	for _, label := range d.On {
+		if !labelNameRE.MatchString(label) {
+			return fmt.Errorf("invalid join label %q", label)
+		}
+	}
+	var err error
+	if d.expr, d.selected, err = parseDerivedExpr(d.Expr); err != nil {
+		return fmt.Errorf("invalid expr %q: %s", d.Expr, err)
+	}
+	for _, s := range d.selected {
+		if s.family == d.Name {
+			return fmt.Errorf("expr must not refer to the metric itself")
+		}
+	}
+	return nil
+}
+
+// DerivedMetrics adds the configured derived families to gathered metrics.
+// It implements prometheus.Collector for its evaluation metrics.
+type DerivedMetrics struct {
+	metrics        []DerivedMetric
+	divisionByZero *prometheus.CounterVec
+}
+
+// NewDerivedMetrics validates the definitions and returns the evaluator.
+func NewDerivedMetrics(metrics []DerivedMetric) (*DerivedMetrics, error) {
+	d := &DerivedMetrics{
+		metrics: make([]DerivedMetric, len(metrics)),
+		divisionByZero: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: exporter,
+			Name:      "derived_metric_division_by_zero_total",
+			Help:      "Total number of derived samples dropped because the expression divided by zero.",
+		}, []string{"metric"}),
+	}
+	copy(d.metrics, metrics)
+	names := map[string]bool{}
+	for i := range d.metrics {
+		if err := d.metrics[i].compile(); err != nil {
+			return nil, fmt.Errorf("derived metric %d: %s", i, err)
+		}
+		if names[d.metrics[i].Name] {
+			return nil, fmt.Errorf("derived metric %d: duplicate name %q", i, d.metrics[i].Name)
+		}
+		names[d.metrics[i].Name] = true
+	}
+	return d, nil
+}
+
+// Describe implements prometheus.Collector.
+func (d *DerivedMetrics) Describe(ch chan<- *prometheus.Desc) {
+	d.divisionByZero.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (d *DerivedMetrics) Collect(ch chan<- prometheus.Metric) {
+	d.divisionByZero.Collect(ch)
+}
+
+// Wrap returns a Gatherer adding the derived families to everything g gathers.
+// Without definitions g is returned unchanged.
+func (d *DerivedMetrics) Wrap(g prometheus.Gatherer) prometheus.Gatherer {
+	if d == nil || len(d.metrics) == 0 {
+		return g
+	}
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		mfs, err := g.Gather()
+		return d.apply(mfs), err
+	})
+}
+
+func (d *DerivedMetrics) apply(mfs []*dto.MetricFamily) []*dto.MetricFamily {
+	families := make(map[string]*dto.MetricFamily, len(mfs))
+	for _, mf := range mfs {
+		families[mf.GetName()] = mf
+	}
+
+	for i := range d.metrics {
+		dm := &d.metrics[i]
+		if _, ok := families[dm.Name]; ok {
+			// Never shadow a family that is already exported.
+			continue
+		}
+		mf := d.evaluate(dm, families)
+		if len(mf.Metric) > 0 {
+			mfs = append(mfs, mf)
+			families[dm.Name] = mf
+		}
+	}
+	sort.Slice(mfs, func(i, j int) bool { return mfs[i].GetName() < mfs[j].GetName() })
+	return mfs
+}
+
+// evaluate computes one sample per join key present in all selectors.
+func (d *DerivedMetrics) evaluate(dm *DerivedMetric, families map[string]*dto.MetricFamily) *dto.MetricFamily {
+	mf := &dto.MetricFamily{
+		Name: proto.String(dm.Name),
+		Help: proto.String(dm.Help),
+		Type: dm.typ.Enum(),
+	}
+	if dm.Help == "" {
+		mf.Help = proto.String(fmt.Sprintf("Derived from %s.", dm.Expr))
+	}
+
+	values := derivedValues{}
+	var keys map[string][]*dto.LabelPair
+	for _, s := range dm.selected {
+		values[s] = map[string]float64{}
+		found := map[string][]*dto.LabelPair{}
+		if source, ok := families[s.family]; ok {
+			for _, m := range source.Metric {
+				if !s.matches(m) {
+					continue
+				}
+				key, labels := joinKey(m, dm.On)
+				values[s][key] += metricValue(m)
+				found[key] = labels
+			}
+		}
+		if keys == nil {
+			keys = found
+			continue
+		}
+		for key := range keys {
+			if _, ok := found[key]; !ok {
+				delete(keys, key)
+			}
+		}
+	}
+	if dm.selected == nil {
+		// An expression of literals only yields one sample without labels.
+		keys = map[string][]*dto.LabelPair{"": nil}
+	}
+
+	for key, labels := range keys {
+		v, err := dm.expr.eval(values, key)
+		if err == errDivisionByZero {
+			d.divisionByZero.WithLabelValues(dm.Name).Inc()
+			continue
+		}
+		m := &dto.Metric{Label: labels}
+		switch dm.typ {
+		case dto.MetricType_COUNTER:
+			m.Counter = &dto.Counter{Value: proto.Float64(v)}
+		case dto.MetricType_GAUGE:
+			m.Gauge = &dto.Gauge{Value: proto.Float64(v)}
+		default:
+			m.Untyped = &dto.Untyped{Value: proto.Float64(v)}
+		}
+		mf.Metric = append(mf.Metric, m)
+	}
+	sort.Slice(mf.Metric, func(i, j int) bool {
+		return labelSignature(mf.Metric[i]) < labelSignature(mf.Metric[j])
+	})
+	return mf
+}
+
+func (s *derivedSelector) matches(m *dto.Metric) bool {
+	for name, value := range s.matchers {
+		if labelValue(m, name) != value {
+			return false
+		}
+	}
+	return true
+}
+
+// joinKey returns the values of the on labels of m as a key and as label pairs.
+func joinKey(m *dto.Metric, on []string) (string, []*dto.LabelPair) {
+	values := make([]string, len(on))
+	var labels []*dto.LabelPair
+	for i, name := range on {
+		values[i] = labelValue(m, name)
+		labels = append(labels, &dto.LabelPair{Name: proto.String(name), Value: proto.String(values[i])})
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i].GetName() < labels[j].GetName() })
+	return strings.Join(values, "\xff"), labels
+}
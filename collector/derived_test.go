@@ -0,0 +1,195 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParseDerivedExprPrecedence(t *testing.T) {
+	for _, tc := range []struct {
+		expr string
+		want float64
+	}{
+		{"1 + 2 * 3", 7},
+		{"(1 + 2) * 3", 9},
+		{"10 - 4 - 3", 3},
+		{"12 / 3 / 2", 2},
+		{"2 * 3 + 4 * 5", 26},
+		{"1 + 2 * 3 - 4 / 2", 5},
+		{"-2 * 3", -6},
+		{"2 * -3", -6},
+		{"-(1 + 2)", -3},
+		{"1.5e2 / 3", 50},
+	} {
+		expr, selectors, err := parseDerivedExpr(tc.expr)
+		if err != nil {
+			t.Errorf("error parsing %q: %s", tc.expr, err)
+			continue
+		}
+		if len(selectors) != 0 {
+			t.Errorf("%q has %d selectors, want none", tc.expr, len(selectors))
+		}
+		if got, err := expr.eval(derivedValues{}, ""); err != nil || got != tc.want {
+			t.Errorf("%q = %v (error %v), want %v", tc.expr, got, err, tc.want)
+		}
+	}
+}
+
+func TestParseDerivedExprErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"1 +",
+		"(1 + 2",
+		"1 2",
+		"1 + * 2",
+		"1..2",
+		`used{key="x"`,
+		`used{key=x}`,
+		`used{="x"}`,
+		`used{key="x" kind="y"}`,
+		`used{key="x}`,
+		"used )",
+	} {
+		if _, _, err := parseDerivedExpr(expr); err == nil {
+			t.Errorf("expected an error parsing %q", expr)
+		}
+	}
+}
+
+func TestParseDerivedExprSelectors(t *testing.T) {
+	_, selectors, err := parseDerivedExpr(`cubrid_pages{kind="used", vol="0"} / cubrid_pages_total`)
+	if err != nil {
+		t.Fatalf("error parsing: %s", err)
+	}
+	if len(selectors) != 2 {
+		t.Fatalf("got %d selectors, want 2", len(selectors))
+	}
+	if s := selectors[0]; s.family != "cubrid_pages" || len(s.matchers) != 2 || s.matchers["kind"] != "used" || s.matchers["vol"] != "0" {
+		t.Errorf("first selector = %+v", s)
+	}
+	if s := selectors[1]; s.family != "cubrid_pages_total" || len(s.matchers) != 0 {
+		t.Errorf("second selector = %+v", s)
+	}
+}
+
+func TestNewDerivedMetricsInvalid(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		metric DerivedMetric
+	}{
+		{"invalid name", DerivedMetric{Name: "1ratio", Expr: "1"}},
+		{"unknown type", DerivedMetric{Name: "ratio", Expr: "1", Type: "histogram"}},
+		{"invalid join label", DerivedMetric{Name: "ratio", Expr: "1", On: []string{"vol-no"}}},
+		{"parse error", DerivedMetric{Name: "ratio", Expr: "1 +"}},
+		{"self reference", DerivedMetric{Name: "ratio", Expr: "ratio * 2"}},
+	} {
+		if _, err := NewDerivedMetrics([]DerivedMetric{tc.metric}); err == nil {
+			t.Errorf("%s: expected an error", tc.name)
+		}
+	}
+	if _, err := NewDerivedMetrics([]DerivedMetric{{Name: "ratio", Expr: "1"}, {Name: "ratio", Expr: "2"}}); err == nil {
+		t.Error("expected an error for a duplicate name")
+	}
+}
+
+// derivedTestRegistry returns a registry with cubrid_test_pages{kind,vol,extra}
+// set to pages, keyed by kind, vol and extra separated by "/".
+func derivedTestRegistry(pages map[string]float64) *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "cubrid_test_pages", Help: "Test pages."},
+		[]string{"kind", "vol", "extra"})
+	reg.MustRegister(gauge)
+	for key, v := range pages {
+		gauge.WithLabelValues(strings.Split(key, "/")...).Set(v)
+	}
+	return reg
+}
+
+func TestDerivedMetricsJoin(t *testing.T) {
+	reg := derivedTestRegistry(map[string]float64{
+		"used/0/":   25,
+		"total/0/":  100,
+		"used/1/":   10,
+		"used/2/":   0,
+		"total/2/":  0,
+		"used/3/a":  10,
+		"used/3/b":  20,
+		"total/3/a": 60,
+		// Samples without the join label join only with each other.
+		"used//": 5,
+	})
+	d, err := NewDerivedMetrics([]DerivedMetric{{
+		Name: "cubrid_test_used_percent",
+		Help: "Used share of the pages in percent.",
+		Expr: `cubrid_test_pages{kind="used"} / cubrid_test_pages{kind="total"} * 100`,
+		On:   []string{"vol"},
+	}, {
+		Name: "cubrid_test_missing",
+		Expr: "cubrid_test_absent + 1",
+	}, {
+		Name: "cubrid_test_pages",
+		Expr: "2",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Volume 1 lacks a total and the samples without vol lack a total, so
+	// neither yields a sample; volume 2 divides by zero; the extra labels
+	// of volume 3 are summed.
+	expected := `
+# HELP cubrid_test_used_percent Used share of the pages in percent.
+# TYPE cubrid_test_used_percent gauge
+cubrid_test_used_percent{vol="0"} 25
+cubrid_test_used_percent{vol="3"} 50
+`
+	if err := testutil.GatherAndCompare(d.Wrap(reg), strings.NewReader(expected),
+		"cubrid_test_used_percent", "cubrid_test_missing"); err != nil {
+		t.Error(err)
+	}
+	if got := testutil.ToFloat64(d.divisionByZero.WithLabelValues("cubrid_test_used_percent")); got != 1 {
+		t.Errorf("division by zero count = %v, want 1", got)
+	}
+
+	// A derived metric never shadows an exported family.
+	mfs, err := d.Wrap(reg).Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() == "cubrid_test_pages" && len(mf.Metric) != 9 {
+			t.Errorf("cubrid_test_pages has %d samples, want the 9 exported ones", len(mf.Metric))
+		}
+	}
+}
+
+func TestDerivedMetricsLiteral(t *testing.T) {
+	d, err := NewDerivedMetrics([]DerivedMetric{{Name: "cubrid_test_constant", Expr: "(1 + 1) * 2", Type: "counter"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `
+# HELP cubrid_test_constant Derived from (1 + 1) * 2.
+# TYPE cubrid_test_constant counter
+cubrid_test_constant 4
+`
+	if err := testutil.GatherAndCompare(d.Wrap(prometheus.NewRegistry()), strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// withErrorLog tracks the error log files matching pattern, in a temporary
+// directory, with no state from earlier scrapes.
+func withErrorLog(t *testing.T, pattern string) string {
+	dir, err := ioutil.TempDir("", "error_log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := *errorLogPaths
+	*errorLogPaths = []string{filepath.Join(dir, pattern)}
+	forget := func() {
+		errorLogState.Lock()
+		errorLogState.files = map[string]*errorLogFile{}
+		errorLogState.Unlock()
+	}
+	forget()
+	t.Cleanup(func() {
+		*errorLogPaths = saved
+		forget()
+		os.RemoveAll(dir)
+	})
+	return dir
+}
+
+// errorLogCounts are the counters of an error log file.
+type errorLogCounts struct {
+	bytes, entries, readErrors float64
+}
+
+// scrapeErrorLog scrapes the error logs and returns the counters by file.
+func scrapeErrorLog(t *testing.T) map[string]errorLogCounts {
+	ch := make(chan prometheus.Metric)
+	done := make(chan map[string]errorLogCounts)
+	go func() {
+		counts := map[string]errorLogCounts{}
+		for metric := range ch {
+			var m dto.Metric
+			if err := metric.Write(&m); err != nil {
+				t.Error(err)
+			}
+			file := m.Label[0].GetValue()
+			c := counts[file]
+			switch metric.Desc() {
+			case ErrorLogBytes:
+				c.bytes = m.Counter.GetValue()
+			case ErrorLogEntries:
+				c.entries = m.Counter.GetValue()
+			case ErrorLogReadErrors:
+				c.readErrors = m.Counter.GetValue()
+			}
+			counts[file] = c
+		}
+		done <- counts
+	}()
+	err := ScrapeErrorLog{}.Scrape(context.Background(), nil, ch)
+	close(ch)
+	counts := <-done
+	if err != nil {
+		t.Fatal(err)
+	}
+	return counts
+}
+
+// appendFile appends data to the file at path, creating it if needed.
+func appendFile(t *testing.T, path, data string) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// checkErrorLog scrapes the error logs and checks the counters of path.
+func checkErrorLog(t *testing.T, step, path string, want errorLogCounts) {
+	t.Helper()
+	if got := scrapeErrorLog(t)[path]; got != want {
+		t.Errorf("%s: counters = %+v, want %+v", step, got, want)
+	}
+}
+
+func TestScrapeErrorLogPartialLine(t *testing.T) {
+	path := filepath.Join(withErrorLog(t, "*.err"), "server.err")
+
+	// A partial trailing line counts its bytes, and the entry once its
+	// newline is written.
+	appendFile(t, path, "first\nsec")
+	checkErrorLog(t, "partial line", path, errorLogCounts{bytes: 9, entries: 1})
+	appendFile(t, path, "ond\n")
+	checkErrorLog(t, "completed line", path, errorLogCounts{bytes: 13, entries: 2})
+	checkErrorLog(t, "unchanged", path, errorLogCounts{bytes: 13, entries: 2})
+}
+
+func TestScrapeErrorLogRotated(t *testing.T) {
+	dir := withErrorLog(t, "*.err")
+	path := filepath.Join(dir, "server.err")
+
+	appendFile(t, path, "one\ntwo\n")
+	checkErrorLog(t, "before rotation", path, errorLogCounts{bytes: 8, entries: 2})
+
+	// The rotated file is replaced by a new, here larger, one, which is read
+	// from the start. The counters keep what was counted before.
+	if err := os.Rename(path, filepath.Join(dir, "server.err.1")); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, path, "three\nfour\nfive\n")
+	checkErrorLog(t, "after rotation", path, errorLogCounts{bytes: 24, entries: 5})
+}
+
+func TestScrapeErrorLogTruncated(t *testing.T) {
+	path := filepath.Join(withErrorLog(t, "*.err"), "server.err")
+
+	appendFile(t, path, "one\ntwo\nthree\n")
+	checkErrorLog(t, "before truncation", path, errorLogCounts{bytes: 14, entries: 3})
+
+	// Truncated in place, the file is read again from the start.
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, path, "four\n")
+	checkErrorLog(t, "after truncation", path, errorLogCounts{bytes: 19, entries: 4})
+}
+
+func TestScrapeErrorLogReadError(t *testing.T) {
+	dir := withErrorLog(t, "*.err")
+	// A directory matching the pattern cannot be read as a log.
+	path := filepath.Join(dir, "broker.err")
+	if err := os.Mkdir(path, 0755); err != nil {
+		t.Fatal(err)
+	}
+	checkErrorLog(t, "first scrape", path, errorLogCounts{readErrors: 1})
+	checkErrorLog(t, "second scrape", path, errorLogCounts{readErrors: 2})
+}
@@ -16,9 +16,11 @@ package collector
 import (
 	"context"
 	"database/sql"
-	"regexp"
-	"strconv"
+	"fmt"
+	"runtime/debug"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	_ "github.com/cubrid/cubrid-go"
@@ -38,10 +40,6 @@ const (
 	versionQuery = `SELECT @@version`
 )
 
-var (
-	versionRE = regexp.MustCompile(`^\d+\.\d+`)
-)
-
 // Metric descriptors.
 var (
 	scrapeDurationDesc = prometheus.NewDesc(
@@ -49,6 +47,33 @@ var (
 		"Collector time duration.",
 		[]string{"collector"}, nil,
 	)
+	versionInfoDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "version", "info"),
+		"CUBRID version reported by the server.",
+		[]string{"version"}, nil,
+	)
+	collectorSkippedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, exporter, "collector_skipped"),
+		"Enabled collectors not run in the scrape, by reason: version, auto_disabled or stats_level.",
+		[]string{"collector", "reason"}, nil,
+	)
+)
+
+// Reasons for skipping an enabled collector.
+const (
+	skipReasonVersion      = "version"
+	skipReasonAutoDisabled = "auto_disabled"
+	skipReasonStatsLevel   = "stats_level"
+)
+
+// Connection mode. The CCI driver always connects through a broker; there is
+// no direct connection to the database server.
+const connectionMode = "broker"
+
+var connectionModeDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, exporter, "connection_mode"),
+	"How the exporter connects to CUBRID, always 1.",
+	[]string{"mode"}, nil,
 )
 
 // Verify if Exporter implements prometheus.Collector
@@ -58,17 +83,21 @@ var _ prometheus.Collector = (*Exporter)(nil)
 type Exporter struct {
 	ctx      context.Context
 	dsn      string
+	target   string
 	scrapers []Scraper
 	metrics  Metrics
+	cache    *ScrapeCache
 }
 
 // New returns a new CUBRID exporter for the provided DSN.
-func New(ctx context.Context, dsn string, metrics Metrics, scrapers []Scraper) *Exporter {
+func New(ctx context.Context, dsn string, metrics Metrics, scrapers []Scraper, cache *ScrapeCache) *Exporter {
 	return &Exporter{
 		ctx:      ctx,
 		dsn:      dsn,
+		target:   dsnTarget(dsn),
 		scrapers: scrapers,
 		metrics:  metrics,
+		cache:    cache,
 	}
 }
 
@@ -78,82 +107,385 @@ func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
 	ch <- e.metrics.Error.Desc()
 	e.metrics.ScrapeErrors.Describe(ch)
 	ch <- e.metrics.CubridUp.Desc()
+	ch <- e.metrics.CoalescedScrapes.Desc()
+	ch <- e.metrics.ConnectionsOpened.Desc()
+	e.metrics.ConnectionsClosed.Describe(ch)
+	ch <- e.metrics.NewConnectionScrapes.Desc()
+	ch <- e.metrics.EstablishedNewConnection.Desc()
+	ch <- e.metrics.UsefulScrape.Desc()
+	e.metrics.CollectorPanics.Describe(ch)
+	e.metrics.ParseAnomalies.Describe(ch)
+	e.metrics.DescriptorMismatches.Describe(ch)
+	e.metrics.MaintenanceTransitions.Describe(ch)
+	ch <- e.metrics.ConnectionErrors.Desc()
+	ch <- e.metrics.LastSuccessfulScrape.Desc()
+	ch <- e.metrics.ProbeQueryDuration.Desc()
+	ch <- e.metrics.ProbeQuerySuccess.Desc()
+	ch <- connectionModeDesc
 }
 
 // Collect implements prometheus.Collector.
 func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
-	e.scrape(e.ctx, ch)
+	e.scrape(withTarget(e.ctx, e.target), ch)
 
 	ch <- e.metrics.TotalScrapes
 	ch <- e.metrics.Error
 	e.metrics.ScrapeErrors.Collect(ch)
 	ch <- e.metrics.CubridUp
+	ch <- e.metrics.CoalescedScrapes
+	ch <- e.metrics.ConnectionsOpened
+	e.metrics.ConnectionsClosed.Collect(ch)
+	ch <- e.metrics.NewConnectionScrapes
+	ch <- e.metrics.EstablishedNewConnection
+	ch <- e.metrics.UsefulScrape
+	e.metrics.CollectorPanics.Collect(ch)
+	e.metrics.ParseAnomalies.Collect(ch)
+	e.metrics.DescriptorMismatches.Collect(ch)
+	e.metrics.MaintenanceTransitions.Collect(ch)
+	ch <- e.metrics.ConnectionErrors
+	ch <- e.metrics.LastSuccessfulScrape
+	if *canaryEnable {
+		ch <- e.metrics.ProbeQueryDuration
+		ch <- e.metrics.ProbeQuerySuccess
+	}
+	ch <- prometheus.MustNewConstMetric(connectionModeDesc, prometheus.GaugeValue, 1, connectionMode)
+	collectAutoDisable(e.target, e.scrapers, ch)
 }
 
 func (e *Exporter) scrape(ctx context.Context, ch chan<- prometheus.Metric) {
 	e.metrics.TotalScrapes.Inc()
-	var err error
+	scrapeID := nextScrapeID()
+	// Set to 1 once any scraper emitted a sample.
+	e.metrics.UsefulScrape.Set(0)
 
 	scrapeTime := time.Now()
 
-	db, err := sql.Open("cubrid", e.dsn)
-	if err != nil {
-		log.Errorln("Error opening connection to database:", err)
+	// A scrape is complete once every runnable collector ran fresh and succeeded.
+	complete := false
+	defer func() { collectFreshness(e.dsn, complete, ch) }()
+
+	if e.dsn == "" {
+		// No valid target, e.g. a probe with an unknown auth module.
+		e.metrics.CubridUp.Set(0)
 		e.metrics.Error.Set(1)
 		return
 	}
-	defer db.Close()
 
-	// By design exporter should use maximum one connection per request.
-	db.SetMaxOpenConns(1)
-	db.SetMaxIdleConns(1)
-	// Set max lifetime for a connection.
-	db.SetConnMaxLifetime(1 * time.Minute)
+	// Simulated scrapers never touch the database.
+	var db *sql.DB
+	if e.dsn != SimulatedDSN {
+		defer collectMaintenance(e.dsn, ch)
+		if !maintenanceProbeDue(e.dsn, time.Now()) {
+			// Nothing to collect until the next probe of the database in maintenance.
+			e.metrics.CubridUp.Set(0)
+			e.metrics.Error.Set(0)
+			return
+		}
+		pool, err := getPool(e.dsn)
+		if err != nil {
+			log.Errorln("Error opening connection to database:", err)
+			e.metrics.Error.Set(1)
+			return
+		}
+		defer releasePool(pool)
+		db = pool.db
+		defer e.metrics.recordConnections(pool)
+		e.runCanary(ctx, db)
+
+		err = db.PingContext(ctx)
+		if recordMaintenance(e.dsn, err, time.Now(), e.metrics.MaintenanceTransitions) {
+			// Maintenance is no outage; the database is only reported down.
+			log.Debugln("Database in maintenance:", err)
+			journalError("connection", err, scrapeID, time.Now())
+			e.metrics.CubridUp.Set(0)
+			e.metrics.Error.Set(0)
+			return
+		}
+		Throttle.evaluate(e.target, err != nil)
+		if err != nil {
+			log.Errorln("Error pinging database:", err)
+			journalError("connection", err, scrapeID, time.Now())
+			e.metrics.ConnectionErrors.Inc()
+			e.metrics.CubridUp.Set(0)
+			e.metrics.Error.Set(1)
+			return
+		}
+	}
+
+	if Chaos.forceDown() {
+		log.Warnln("Injected fault: reporting the database as down")
+		e.metrics.CubridUp.Set(0)
+		e.metrics.Error.Set(1)
+		return
+	}
 
 	e.metrics.CubridUp.Set(1)
 	e.metrics.Error.Set(0)
 
 	ch <- prometheus.MustNewConstMetric(scrapeDurationDesc, prometheus.GaugeValue, time.Since(scrapeTime).Seconds(), "connection")
 
+	var version cubridVersion
+	if db != nil {
+		var versionStr string
+		version, versionStr = getCubridVersion(ctx, db)
+		if versionStr != "" {
+			ch <- prometheus.MustNewConstMetric(versionInfoDesc, prometheus.GaugeValue, 1, sanitizeLabelValue(ctx, versionStr))
+		}
+	}
+
+	observation := scrapeObservation{collectors: map[string]time.Duration{}}
+	if deadline, ok := ctx.Deadline(); ok {
+		observation.budget = deadline.Sub(scrapeTime)
+	}
+	var observationMu sync.Mutex
+
+	validator := newMetricValidator(e.metrics.DescriptorMismatches)
 	var wg sync.WaitGroup
-	defer wg.Wait()
+	var samples int64
+	var failed, cached int32
+	skipped, disabled := 0, 0
 	for _, scraper := range e.scrapers {
+		label := "collect." + scraper.Name()
+		if !version.supports(scraper.Version()) {
+			log.Debugf("Skipping collect.%s: requires CUBRID %s, server is %s", scraper.Name(), scraperVersion(scraper.Version()), version)
+			ch <- prometheus.MustNewConstMetric(collectorSkippedDesc, prometheus.GaugeValue, 1, label, skipReasonVersion)
+			skipped++
+			continue
+		}
+		if extendedStatsCollectors[scraper.Name()] && extendedStatsMissing(e.target) {
+			log.Debugf("Skipping collect.%s: the server does not collect extended statdump statistics", scraper.Name())
+			ch <- prometheus.MustNewConstMetric(collectorSkippedDesc, prometheus.GaugeValue, 1, label, skipReasonStatsLevel)
+			continue
+		}
+		if !autoDisableAllows(e.target, scraper.Name(), time.Now()) {
+			log.Debugf("Skipping collect.%s: auto-disabled after consecutive failures", scraper.Name())
+			ch <- prometheus.MustNewConstMetric(collectorSkippedDesc, prometheus.GaugeValue, 1, label, skipReasonAutoDisabled)
+			disabled++
+			continue
+		}
 
 		wg.Add(1)
 		go func(scraper Scraper) {
 			defer wg.Done()
 			label := "collect." + scraper.Name()
 			scrapeTime := time.Now()
-			if err := scraper.Scrape(ctx, db, ch); err != nil {
+			ctx, subResults := withSubRecorder(ctx)
+			ctx, anomalies := withAnomalyRecorder(ctx)
+			ctx = withLogger(ctx, scraper.Name())
+			scrapeCh, done := countSamples(ch, &samples)
+			validCh, validated := validator.proxy(scrapeCh, scraper.Name())
+			labeledCh, labeled := withCollectorLabels(validCh, scraper.Name())
+			fromCache, err := e.scrapeCached(ctx, db, scraper, labeledCh)
+			if fromCache {
+				atomic.StoreInt32(&cached, 1)
+			}
+			if latency, injected := Chaos.collectorFault(scraper.Name()); latency > 0 || injected != nil {
+				select {
+				case <-time.After(latency):
+				case <-ctx.Done():
+				}
+				if err == nil {
+					err = injected
+				}
+			}
+			labeled()
+			validated()
+			done()
+			if strictErr := anomalies.account(label, e.metrics.ParseAnomalies); err == nil {
+				err = strictErr
+			}
+			autoDisableRecord(e.target, scraper.Name(), err, time.Now())
+			if err != nil {
 				log.Errorln("Error scraping for "+label+":", err)
+				journalError(scraper.Name(), err, scrapeID, time.Now())
 				e.metrics.ScrapeErrors.WithLabelValues(label).Inc()
 				e.metrics.Error.Set(1)
+				atomic.StoreInt32(&failed, 1)
+			}
+			duration := time.Since(scrapeTime)
+			if !fromCache {
+				observationMu.Lock()
+				observation.collectors[scraper.Name()] = duration
+				observationMu.Unlock()
 			}
-			ch <- prometheus.MustNewConstMetric(scrapeDurationDesc, prometheus.GaugeValue, time.Since(scrapeTime).Seconds(), label)
+			ch <- prometheus.MustNewConstMetric(scrapeDurationDesc, prometheus.GaugeValue, duration.Seconds(), label)
+			subResults.collect(label, ch)
 		}(scraper)
 	}
+	wg.Wait()
+	observation.duration = time.Since(scrapeTime)
+	recordScrape(observation)
+	if err := saveStateIfDirty(); err != nil {
+		log.Errorln("Error saving state file:", err)
+	}
+
+	if skipped > 0 && skipped == len(e.scrapers) {
+		log.Warnf("None of the enabled collectors supports CUBRID %s", version)
+	}
+	if atomic.LoadInt64(&samples) > 0 {
+		e.metrics.UsefulScrape.Set(1)
+	}
+	if atomic.LoadInt32(&failed) == 0 {
+		e.metrics.LastSuccessfulScrape.SetToCurrentTime()
+		complete = disabled == 0 && atomic.LoadInt32(&cached) == 0
+	}
+}
+
+// countSamples returns a channel forwarding to ch that adds the number of
+// forwarded metrics to count. done must be called once nothing is sent anymore.
+func countSamples(ch chan<- prometheus.Metric, count *int64) (chan<- prometheus.Metric, func()) {
+	forward := make(chan prometheus.Metric)
+	finished := make(chan struct{})
+	go func() {
+		for metric := range forward {
+			atomic.AddInt64(count, 1)
+			ch <- metric
+		}
+		close(finished)
+	}()
+	return forward, func() {
+		close(forward)
+		<-finished
+	}
+}
+
+// scrapeCached serves the scraper's metrics from the warm-start store or the
+// cache if possible, otherwise runs the scraper and caches its metrics on
+// success. While the throttle is active, non-essential scrapers use its
+// longer-lived cache. It reports whether the metrics came from the cache.
+func (e *Exporter) scrapeCached(ctx context.Context, db *sql.DB, scraper Scraper, ch chan<- prometheus.Metric) (bool, error) {
+	if WarmStart.serve(e, db, scraper, ch) {
+		return true, nil
+	}
+	cache := e.cache
+	if throttled := Throttle.cacheFor(e.target, scraper.Name()); throttled != nil {
+		cache = throttled
+	}
+	if cache == nil && !WarmStart.recording() {
+		return false, e.runScraper(ctx, db, scraper, ch)
+	}
+
+	database := dsnDatabase(e.dsn)
+	if metrics, ok := cache.Get(database, scraper.Name()); ok {
+		for _, metric := range metrics {
+			ch <- metric
+		}
+		return true, nil
+	}
+
+	var metrics []prometheus.Metric
+	scrapeCh := make(chan prometheus.Metric)
+	done := make(chan struct{})
+	go func() {
+		for metric := range scrapeCh {
+			metrics = append(metrics, metric)
+			ch <- metric
+		}
+		close(done)
+	}()
+	err := e.runScraper(ctx, db, scraper, scrapeCh)
+	close(scrapeCh)
+	<-done
+
+	if err == nil {
+		cache.Set(database, scraper.Name(), metrics)
+		WarmStart.remember(e.target, scraper.Name(), metrics)
+	}
+	return false, err
+}
+
+// runScraper runs the scraper, converting a panic into an error so that it
+// neither kills the process nor the other scrapers.
+func (e *Exporter) runScraper(ctx context.Context, db *sql.DB, scraper Scraper, ch chan<- prometheus.Metric) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			e.metrics.CollectorPanics.WithLabelValues("collect." + scraper.Name()).Inc()
+			log.Errorf("Panic in collector %s: %v\n%s", scraper.Name(), r, debug.Stack())
+			err = fmt.Errorf("%w: %v", errPanic, r)
+		}
+	}()
+	return scraper.Scrape(ctx, db, ch)
+}
+
+// dsnDatabase returns the database name of a cci:cubrid:host:port:db:user:password: DSN.
+func dsnDatabase(dsn string) string {
+	fields := strings.Split(dsn, ":")
+	if len(fields) < 5 {
+		return ""
+	}
+	return fields[4]
+}
+
+// dsnTarget returns the target of a cci:cubrid:host:port:db:user:password: DSN
+// as host:port:db, keying the state kept between scrapes of the target. Unlike
+// the DSN it holds no credentials. Other DSNs, such as SimulatedDSN, are their
+// own target.
+func dsnTarget(dsn string) string {
+	fields := strings.Split(dsn, ":")
+	if len(fields) < 5 {
+		return dsn
+	}
+	host := strings.ToLower(strings.TrimSpace(fields[2]))
+	return host + ":" + strings.TrimSpace(fields[3]) + ":" + fields[4]
+}
+
+type targetKey struct{}
+
+// withTarget records the target of the scrape in ctx, so that the state the
+// scrapers keep between scrapes, such as the throttle's statement latency,
+// is kept per target.
+func withTarget(ctx context.Context, target string) context.Context {
+	return context.WithValue(ctx, targetKey{}, target)
 }
 
-// get DBMS version
-func getCubridVersion(db *sql.DB) float64 {
+// targetFrom returns the target of the scrape running in ctx.
+func targetFrom(ctx context.Context) string {
+	target, _ := ctx.Value(targetKey{}).(string)
+	return target
+}
+
+// targetScoped returns key prefixed with the target of the scrape running in
+// ctx, keying state kept between scrapes so that targets do not share it.
+func targetScoped(ctx context.Context, key string) string {
+	return targetFrom(ctx) + "/" + key
+}
+
+// get DBMS version and the version string it was parsed from
+func getCubridVersion(ctx context.Context, db *sql.DB) (cubridVersion, string) {
 	var versionStr string
-	var versionNum float64
-	if err := db.QueryRow(versionQuery).Scan(&versionStr); err == nil {
-		versionNum, _ = strconv.ParseFloat(versionRE.FindString(versionStr), 64)
+	if err := db.QueryRowContext(ctx, versionQuery).Scan(&versionStr); err != nil {
+		log.Debugln("Error querying CUBRID version, running all scrapers:", err)
+		return nil, ""
 	}
-	// If we can't match/parse the version, set it some big value that matches all versions.
-	if versionNum == 0 {
-		versionNum = 999
+	// If we can't parse the version, run all scrapers.
+	version, ok := parseCubridVersion(versionStr)
+	if !ok {
+		log.Debugf("Unparsable CUBRID version %q, running all scrapers", versionStr)
 	}
-	return versionNum
+	return version, versionStr
 }
 
 // Metrics represents exporter metrics which values can be carried between http requests.
 type Metrics struct {
-	TotalScrapes prometheus.Counter
-	ScrapeErrors *prometheus.CounterVec
-	Error        prometheus.Gauge
-	CubridUp     prometheus.Gauge
+	TotalScrapes     prometheus.Counter
+	ScrapeErrors     *prometheus.CounterVec
+	Error            prometheus.Gauge
+	CubridUp         prometheus.Gauge
+	CoalescedScrapes prometheus.Counter
+
+	ConnectionsOpened        prometheus.Counter
+	ConnectionsClosed        *prometheus.CounterVec
+	NewConnectionScrapes     prometheus.Counter
+	EstablishedNewConnection prometheus.Gauge
+	UsefulScrape             prometheus.Gauge
+	CollectorPanics          *prometheus.CounterVec
+	ParseAnomalies           *prometheus.CounterVec
+	DescriptorMismatches     *prometheus.CounterVec
+	MaintenanceTransitions   *prometheus.CounterVec
+	ConnectionErrors         prometheus.Counter
+	LastSuccessfulScrape     prometheus.Gauge
+	ProbeQueryDuration       prometheus.Histogram
+	ProbeQuerySuccess        prometheus.Gauge
 }
 
 // NewMetrics creates new Metrics instance.
@@ -183,5 +515,90 @@ func NewMetrics() Metrics {
 			Name:      "up",
 			Help:      "Whether the CUBRID server is up.",
 		}),
+		CoalescedScrapes: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "coalesced_scrapes_total",
+			Help:      "Total number of scrapes served from a concurrent identical scrape's collection.",
+		}),
+		ConnectionsOpened: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "connections_opened_total",
+			Help:      "Total number of database connections established.",
+		}),
+		ConnectionsClosed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "connections_closed_total",
+			Help:      "Total number of database connections closed, by reason.",
+		}, []string{"reason"}),
+		NewConnectionScrapes: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "scrapes_established_new_connection_total",
+			Help:      "Total number of scrapes that had to establish a new database connection.",
+		}),
+		EstablishedNewConnection: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "scrape_established_new_connection",
+			Help:      "Whether the last scrape had to establish a new database connection (1 for new).",
+		}),
+		UsefulScrape: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "useful_scrape",
+			Help:      "Whether at least one collector of the last scrape ran and emitted a sample (1 for useful).",
+		}),
+		CollectorPanics: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "collector_panics_total",
+			Help:      "Total number of panics recovered in a collector.",
+		}, []string{"collector"}),
+		ParseAnomalies: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "parse_anomalies_total",
+			Help:      "Total number of anomalies in scraped data that were skipped or corrected.",
+		}, []string{"collector", "kind"}),
+		DescriptorMismatches: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "descriptor_mismatches_total",
+			Help:      "Total number of metrics dropped because their labels did not match their family.",
+		}, []string{"collector"}),
+		MaintenanceTransitions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "maintenance_transitions_total",
+			Help:      "Total number of times the database was detected entering or leaving maintenance.",
+		}, []string{"direction"}),
+		ConnectionErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "connection_errors_total",
+			Help:      "Total number of scrapes that could not reach the database.",
+		}),
+		LastSuccessfulScrape: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "last_successful_scrape_timestamp_seconds",
+			Help:      "Time of the last scrape without any collector error in unix seconds.",
+		}),
+		ProbeQueryDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "probe",
+			Name:      "query_duration_seconds",
+			Help:      "End-to-end duration of the synthetic query, including failed attempts.",
+			Buckets:   queryDurationBuckets,
+		}),
+		ProbeQuerySuccess: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "probe",
+			Name:      "query_success",
+			Help:      "Whether the last synthetic query succeeded (1 for success).",
+		}),
 	}
 }
@@ -0,0 +1,203 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var fakeScraperDesc = prometheus.NewDesc("cubrid_fake_value", "Value of a fake scraper.", []string{"scraper"}, nil)
+
+// fakeScraper sends one sample and fails with err.
+type fakeScraper struct {
+	name string
+	err  error
+}
+
+func (s fakeScraper) Name() string     { return s.name }
+func (s fakeScraper) Help() string     { return "Fake scraper " + s.name }
+func (s fakeScraper) Version() float64 { return 10.2 }
+
+func (s fakeScraper) Scrape(ctx context.Context, db *sql.DB, ch chan<- prometheus.Metric) error {
+	ch <- prometheus.MustNewConstMetric(fakeScraperDesc, prometheus.GaugeValue, 1, s.name)
+	return s.err
+}
+
+// countingScraper sends one sample and counts its runs.
+type countingScraper struct {
+	name string
+	runs int32
+}
+
+func (s *countingScraper) Name() string     { return s.name }
+func (s *countingScraper) Help() string     { return "Counting scraper " + s.name }
+func (s *countingScraper) Version() float64 { return 10.2 }
+
+func (s *countingScraper) Scrape(ctx context.Context, db *sql.DB, ch chan<- prometheus.Metric) error {
+	atomic.AddInt32(&s.runs, 1)
+	ch <- prometheus.MustNewConstMetric(fakeScraperDesc, prometheus.GaugeValue, 1, s.name)
+	return nil
+}
+
+// collectExporter runs a scrape of e, discarding the metrics.
+func collectExporter(e *Exporter) {
+	ch := make(chan prometheus.Metric)
+	done := make(chan struct{})
+	go func() {
+		for range ch {
+		}
+		close(done)
+	}()
+	e.Collect(ch)
+	close(ch)
+	<-done
+}
+
+func TestExporterScraperError(t *testing.T) {
+	metrics := NewMetrics()
+	scrapers := []Scraper{fakeScraper{name: "fake_ok"}, fakeScraper{name: "fake_failing", err: errors.New("failed")}}
+	collectExporter(New(context.Background(), SimulatedDSN, metrics, scrapers, nil))
+
+	if got := testutil.ToFloat64(metrics.CubridUp); got != 1 {
+		t.Errorf("cubrid_up = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(metrics.Error); got != 1 {
+		t.Errorf("last_scrape_error = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(metrics.ScrapeErrors.WithLabelValues("collect.fake_failing")); got != 1 {
+		t.Errorf("scrape_errors_total of the failing scraper = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(metrics.ScrapeErrors.WithLabelValues("collect.fake_ok")); got != 0 {
+		t.Errorf("scrape_errors_total of the working scraper = %v, want 0", got)
+	}
+
+	// The next scrape without the failing scraper clears the error.
+	collectExporter(New(context.Background(), SimulatedDSN, metrics, scrapers[:1], nil))
+	if got := testutil.ToFloat64(metrics.Error); got != 0 {
+		t.Errorf("last_scrape_error after a successful scrape = %v, want 0", got)
+	}
+	if got := testutil.ToFloat64(metrics.TotalScrapes); got != 2 {
+		t.Errorf("scrapes_total = %v, want 2", got)
+	}
+}
+
+func TestExporterNoTarget(t *testing.T) {
+	metrics := NewMetrics()
+	collectExporter(New(context.Background(), "", metrics, []Scraper{fakeScraper{name: "fake_ok"}}, nil))
+
+	if got := testutil.ToFloat64(metrics.CubridUp); got != 0 {
+		t.Errorf("cubrid_up = %v, want 0", got)
+	}
+	if got := testutil.ToFloat64(metrics.Error); got != 1 {
+		t.Errorf("last_scrape_error = %v, want 1", got)
+	}
+}
+
+// emptyScraper succeeds without sending a sample.
+type emptyScraper struct{}
+
+func (emptyScraper) Name() string     { return "fake_empty" }
+func (emptyScraper) Help() string     { return "Fake scraper sending nothing" }
+func (emptyScraper) Version() float64 { return 10.2 }
+
+func (emptyScraper) Scrape(ctx context.Context, db *sql.DB, ch chan<- prometheus.Metric) error {
+	return nil
+}
+
+func TestExporterUsefulScrape(t *testing.T) {
+	metrics := NewMetrics()
+	for _, tc := range []struct {
+		name     string
+		scrapers []Scraper
+		useful   float64
+	}{
+		{"sample", []Scraper{emptyScraper{}, fakeScraper{name: "fake_ok"}}, 1},
+		// Collectors that succeed without a sample leave the scrape empty,
+		// also right after a useful one.
+		{"empty", []Scraper{emptyScraper{}}, 0},
+		{"no collectors", nil, 0},
+		// A sample of a failing collector still counts.
+		{"failing with a sample", []Scraper{fakeScraper{name: "fake_failing", err: errors.New("failed")}}, 1},
+	} {
+		collectExporter(New(context.Background(), SimulatedDSN, metrics, tc.scrapers, nil))
+		if got := testutil.ToFloat64(metrics.UsefulScrape); got != tc.useful {
+			t.Errorf("%s: useful_scrape = %v, want %v", tc.name, got, tc.useful)
+		}
+	}
+}
+
+// panicScraper panics after sending a sample.
+type panicScraper struct{}
+
+func (panicScraper) Name() string     { return "fake_panicking" }
+func (panicScraper) Help() string     { return "Fake scraper panicking" }
+func (panicScraper) Version() float64 { return 10.2 }
+
+func (panicScraper) Scrape(ctx context.Context, db *sql.DB, ch chan<- prometheus.Metric) error {
+	ch <- prometheus.MustNewConstMetric(fakeScraperDesc, prometheus.GaugeValue, 1, "fake_panicking")
+	panic("index out of range")
+}
+
+func TestExporterScraperPanic(t *testing.T) {
+	metrics := NewMetrics()
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(New(context.Background(), SimulatedDSN, metrics,
+		[]Scraper{panicScraper{}, fakeScraper{name: "fake_ok"}, fakeScraper{name: "fake_other"}}, nil))
+
+	// The other scrapers still emit, as does the panicking one before it panicked.
+	expected := `
+# HELP cubrid_fake_value Value of a fake scraper.
+# TYPE cubrid_fake_value gauge
+cubrid_fake_value{scraper="fake_ok"} 1
+cubrid_fake_value{scraper="fake_other"} 1
+cubrid_fake_value{scraper="fake_panicking"} 1
+# HELP cubrid_exporter_collector_panics_total Total number of panics recovered in a collector.
+# TYPE cubrid_exporter_collector_panics_total counter
+cubrid_exporter_collector_panics_total{collector="collect.fake_panicking"} 1
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected), "cubrid_fake_value",
+		"cubrid_exporter_collector_panics_total"); err != nil {
+		t.Error(err)
+	}
+	if got := testutil.ToFloat64(metrics.ScrapeErrors.WithLabelValues("collect.fake_panicking")); got != 1 {
+		t.Errorf("scrape_errors_total of the panicking scraper = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(metrics.ScrapeErrors.WithLabelValues("collect.fake_ok")); got != 0 {
+		t.Errorf("scrape_errors_total of the working scraper = %v, want 0", got)
+	}
+
+	// Every panic is counted.
+	if _, err := registry.Gather(); err != nil {
+		t.Fatal(err)
+	}
+	if got := testutil.ToFloat64(metrics.CollectorPanics.WithLabelValues("collect.fake_panicking")); got != 2 {
+		t.Errorf("collector_panics_total after a second scrape = %v, want 2", got)
+	}
+}
+
+func TestRunScraperPanicError(t *testing.T) {
+	e := New(context.Background(), SimulatedDSN, NewMetrics(), nil, nil)
+	ch := make(chan prometheus.Metric, 1)
+	if err := e.runScraper(context.Background(), nil, panicScraper{}, ch); !errors.Is(err, errPanic) {
+		t.Errorf("error = %v, want %v", err, errPanic)
+	}
+}
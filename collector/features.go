@@ -0,0 +1,42 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Collector settings checked against the rest of the configuration.
+
+package collector
+
+import "time"
+
+// FeatureSettings are the collector flags involved in feature compatibility rules.
+type FeatureSettings struct {
+	Strict                 bool
+	AutoDisableAfter       int
+	AutoDisableCooldown    time.Duration
+	AutoDisableMaxCooldown time.Duration
+	Databases              []string
+	AutoDiscoverDatabases  bool
+	VerifyReadOnly         bool
+}
+
+// Features returns the parsed collector flags.
+func Features() FeatureSettings {
+	return FeatureSettings{
+		Strict:                 *strictMode,
+		AutoDisableAfter:       *autoDisableAfter,
+		AutoDisableCooldown:    *autoDisableCooldown,
+		AutoDisableMaxCooldown: *autoDisableMaxCooldown,
+		Databases:              *collectDatabases,
+		AutoDiscoverDatabases:  *collectDatabasesAutoDiscover,
+		VerifyReadOnly:         *selfAuditVerifyReadOnly,
+	}
+}
@@ -0,0 +1,294 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Recording of raw query results of a real server as fixtures.
+
+package collector
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FixtureFormatVersion is the format version of recorded fixture sets.
+// Bundles of older versions are migrated when loaded; see fixtureMigrations.
+//
+// Version 1 lacked format_version and column types, and named the server
+// version "version".
+const FixtureFormatVersion = 2
+
+// fixtureMetadataFile is the file of a fixture set holding its FixtureMetadata.
+const fixtureMetadataFile = "metadata.json"
+
+// Fixture is the raw result set of one approved query.
+type Fixture struct {
+	Collector string   `json:"collector"`
+	Query     string   `json:"query"`
+	Columns   []string `json:"columns,omitempty"`
+	// ColumnTypes are the database type names of Columns.
+	ColumnTypes []string `json:"column_types,omitempty"`
+	// Rows hold the values as returned by the driver; nil is NULL.
+	Rows [][]*string `json:"rows,omitempty"`
+	// Error is the error of the query, e.g. for statements the server rejects.
+	Error string `json:"error,omitempty"`
+}
+
+// FixtureMetadata describes the server and exporter a fixture set was recorded with.
+type FixtureMetadata struct {
+	FormatVersion   int       `json:"format_version"`
+	ServerVersion   string    `json:"server_version"`
+	VersionString   string    `json:"version_string"`
+	Capabilities    []string  `json:"capabilities"`
+	RecordTime      time.Time `json:"record_time"`
+	ExporterVersion string    `json:"exporter_version"`
+}
+
+// RecordFixtures runs every query of the approved query manifest against dsn
+// and writes the result sets into a directory named for the detected server
+// version below dir. It returns that directory.
+func RecordFixtures(ctx context.Context, dsn, dir, exporterVersion string, scrapers []Scraper) (string, error) {
+	pool, err := getPool(dsn)
+	if err != nil {
+		return "", err
+	}
+	defer releasePool(pool)
+	db := pool.db
+	if err := db.PingContext(ctx); err != nil {
+		return "", err
+	}
+
+	version, versionStr := getCubridVersion(ctx, db)
+	meta := FixtureMetadata{
+		FormatVersion:   FixtureFormatVersion,
+		ServerVersion:   version.String(),
+		VersionString:   versionStr,
+		RecordTime:      time.Now().UTC(),
+		ExporterVersion: exporterVersion,
+	}
+	for _, scraper := range scrapers {
+		if version.supports(scraper.Version()) {
+			meta.Capabilities = append(meta.Capabilities, scraper.Name())
+		}
+	}
+
+	databases, err := targetDatabases(ctx, db)
+	if err != nil {
+		return "", err
+	}
+
+	dir = filepath.Join(dir, meta.ServerVersion)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	for i, approved := range approvedQueries {
+		queries := map[string]string{"": approved.query}
+		if strings.Contains(approved.query, "%s") {
+			queries = map[string]string{}
+			for _, database := range databases {
+				queries[database] = fmt.Sprintf(approved.query, database)
+			}
+		}
+		for database, query := range queries {
+			name := fmt.Sprintf("%02d-%s", i, approved.collector)
+			if database != "" {
+				name += "-" + database
+			}
+			fixture := recordFixture(ctx, db, approved.collector, query)
+			if err := writeFixtureFile(filepath.Join(dir, name+".json"), fixture); err != nil {
+				return "", err
+			}
+		}
+	}
+	if err := writeFixtureFile(filepath.Join(dir, fixtureMetadataFile), meta); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// recordFixture runs query. Errors are recorded in the fixture, so the
+// refusal of e.g. HA statements by a server without HA is captured as well.
+func recordFixture(ctx context.Context, db *sql.DB, collector, query string) Fixture {
+	fixture := Fixture{Collector: collector, Query: normalizeQuery(query)}
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		fixture.Error = err.Error()
+		return fixture
+	}
+	defer rows.Close()
+
+	if fixture.Columns, err = rows.Columns(); err != nil {
+		fixture.Error = err.Error()
+		return fixture
+	}
+	if types, err := rows.ColumnTypes(); err == nil {
+		for _, t := range types {
+			fixture.ColumnTypes = append(fixture.ColumnTypes, t.DatabaseTypeName())
+		}
+	}
+	for rows.Next() {
+		values := make([]sql.RawBytes, len(fixture.Columns))
+		dest := make([]interface{}, len(values))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			fixture.Error = err.Error()
+			return fixture
+		}
+		row := make([]*string, len(values))
+		for i, value := range values {
+			if value != nil {
+				s := string(value)
+				row[i] = &s
+			}
+		}
+		fixture.Rows = append(fixture.Rows, row)
+	}
+	if err := rows.Err(); err != nil {
+		fixture.Error = err.Error()
+	}
+	return fixture
+}
+
+func writeFixtureFile(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// fixtureDocument is a decoded fixture file before it is migrated to the
+// current format.
+type fixtureDocument map[string]interface{}
+
+// fixtureBundle is a fixture set in the format of one version.
+type fixtureBundle struct {
+	metadata fixtureDocument
+	fixtures []fixtureDocument
+}
+
+// fixtureMigrations[i] migrates a bundle of format version i+1 to i+2.
+// Migrations must not modify their argument.
+var fixtureMigrations = []func(fixtureBundle) fixtureBundle{
+	migrateFixturesV1,
+}
+
+// migrateFixturesV1 renames the server version. Column types are unknown for
+// version 1 bundles and stay empty.
+func migrateFixturesV1(b fixtureBundle) fixtureBundle {
+	meta := b.metadata.copy()
+	meta["server_version"] = meta["version"]
+	delete(meta, "version")
+	meta["format_version"] = 2
+	return fixtureBundle{metadata: meta, fixtures: b.fixtures}
+}
+
+func (d fixtureDocument) copy() fixtureDocument {
+	c := make(fixtureDocument, len(d))
+	for k, v := range d {
+		c[k] = v
+	}
+	return c
+}
+
+// formatVersion returns the format version of a bundle's metadata. Version 1
+// did not record it.
+func (d fixtureDocument) formatVersion() (int, error) {
+	v, ok := d["format_version"]
+	if !ok {
+		return 1, nil
+	}
+	n, ok := v.(float64)
+	if !ok || n != float64(int(n)) {
+		return 0, fmt.Errorf("invalid format_version %v", v)
+	}
+	return int(n), nil
+}
+
+// LoadFixtures reads the fixture set recorded into dir by any supported
+// format version and migrates it to the current one.
+func LoadFixtures(dir string) (FixtureMetadata, []Fixture, error) {
+	var meta FixtureMetadata
+	var bundle fixtureBundle
+	if err := readFixtureFile(filepath.Join(dir, fixtureMetadataFile), &bundle.metadata); err != nil {
+		return meta, nil, err
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return meta, nil, err
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if filepath.Base(path) == fixtureMetadataFile {
+			continue
+		}
+		var fixture fixtureDocument
+		if err := readFixtureFile(path, &fixture); err != nil {
+			return meta, nil, err
+		}
+		bundle.fixtures = append(bundle.fixtures, fixture)
+	}
+
+	version, err := bundle.metadata.formatVersion()
+	if err != nil {
+		return meta, nil, fmt.Errorf("fixture set %s: %s", dir, err)
+	}
+	oldest := FixtureFormatVersion - len(fixtureMigrations)
+	if version < oldest || version > FixtureFormatVersion {
+		return meta, nil, fmt.Errorf("fixture set %s has format version %d, supported are versions %d to %d",
+			dir, version, oldest, FixtureFormatVersion)
+	}
+	for _, migrate := range fixtureMigrations[version-oldest:] {
+		bundle = migrate(bundle)
+	}
+
+	// Round trip through JSON to decode the migrated documents.
+	if err := convertFixtureDocument(bundle.metadata, &meta); err != nil {
+		return meta, nil, fmt.Errorf("fixture set %s: %s", dir, err)
+	}
+	fixtures := make([]Fixture, len(bundle.fixtures))
+	for i, doc := range bundle.fixtures {
+		if err := convertFixtureDocument(doc, &fixtures[i]); err != nil {
+			return meta, nil, fmt.Errorf("fixture set %s: %s", dir, err)
+		}
+	}
+	return meta, fixtures, nil
+}
+
+func readFixtureFile(path string, v interface{}) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("invalid fixture file %s: %s", path, err)
+	}
+	return nil
+}
+
+func convertFixtureDocument(doc fixtureDocument, v interface{}) error {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
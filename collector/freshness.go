@@ -0,0 +1,120 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Share of recent scrapes that delivered complete and fresh data.
+
+package collector
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// freshnessWindow is the period the complete scrape ratio covers.
+	freshnessWindow = time.Hour
+	// freshnessBuckets bounds the memory of a window regardless of the
+	// scrape rate; each bucket covers freshnessWindow/freshnessBuckets.
+	freshnessBuckets = 60
+)
+
+// Metric descriptors.
+var (
+	completeScrapeRatioDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, exporter, "complete_scrape_ratio_1h"),
+		"Share of the scrapes of the last hour in which every enabled collector ran fresh and succeeded.",
+		nil, nil,
+	)
+	completeScrapeWindowDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, exporter, "complete_scrape_window_scrapes"),
+		"Number of scrapes the complete scrape ratio is computed from.",
+		nil, nil,
+	)
+)
+
+// freshnessNow is replaced to drive the window deterministically.
+var freshnessNow = time.Now
+
+type freshnessBucket struct {
+	// start is the beginning of the period the bucket counts.
+	start             time.Time
+	complete, scrapes int
+}
+
+// freshnessRing counts scrapes per bucket of the window.
+type freshnessRing [freshnessBuckets]freshnessBucket
+
+// freshnessState is shared between scrapes, as the Exporter is created per
+// request. Windows are kept per target, so probes do not mix.
+var freshnessState = struct {
+	sync.Mutex
+	windows map[string]*freshnessRing
+}{windows: map[string]*freshnessRing{}}
+
+// recordFreshness records a scrape of dsn and returns the complete scrape
+// ratio and the number of scrapes in the window ending now.
+func recordFreshness(dsn string, complete bool, now time.Time) (float64, int) {
+	freshnessState.Lock()
+	defer freshnessState.Unlock()
+
+	width := freshnessWindow / freshnessBuckets
+	start := now.Truncate(width)
+	oldest := now.Add(-freshnessWindow)
+	for target, ring := range freshnessState.windows {
+		if target != dsn && !ring.active(oldest) {
+			delete(freshnessState.windows, target)
+		}
+	}
+	ring, ok := freshnessState.windows[dsn]
+	if !ok {
+		ring = &freshnessRing{}
+		freshnessState.windows[dsn] = ring
+	}
+
+	bucket := &ring[start.UnixNano()/int64(width)%freshnessBuckets]
+	if !bucket.start.Equal(start) {
+		*bucket = freshnessBucket{start: start}
+	}
+	bucket.scrapes++
+	if complete {
+		bucket.complete++
+	}
+
+	var completed, scrapes int
+	for _, b := range ring {
+		if b.start.After(oldest) {
+			completed += b.complete
+			scrapes += b.scrapes
+		}
+	}
+	return float64(completed) / float64(scrapes), scrapes
+}
+
+// active reports whether the ring counted scrapes after oldest.
+func (r *freshnessRing) active(oldest time.Time) bool {
+	for _, b := range r {
+		if b.scrapes > 0 && b.start.After(oldest) {
+			return true
+		}
+	}
+	return false
+}
+
+// collectFreshness records a scrape of dsn and sends the updated ratio.
+func collectFreshness(dsn string, complete bool, ch chan<- prometheus.Metric) {
+	ratio, scrapes := recordFreshness(dsn, complete, freshnessNow())
+	ch <- prometheus.MustNewConstMetric(completeScrapeRatioDesc, prometheus.GaugeValue, ratio)
+	ch <- prometheus.MustNewConstMetric(completeScrapeWindowDesc, prometheus.GaugeValue, float64(scrapes))
+}
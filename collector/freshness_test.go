@@ -0,0 +1,75 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// TestRecordFreshness drives two hours of scrapes every 15s through the
+// window: an hour with every fourth scrape incomplete, then an hour of
+// complete scrapes.
+func TestRecordFreshness(t *testing.T) {
+	const target = "freshness:33000:demodb"
+	start := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	var ratio float64
+	var scrapes int
+	for i := 0; now.Before(start.Add(time.Hour)); i++ {
+		ratio, scrapes = recordFreshness(target, i%4 != 0, now)
+		now = now.Add(15 * time.Second)
+	}
+	if math.Abs(ratio-0.75) > 0.01 {
+		t.Errorf("ratio after an hour = %v, want 0.75", ratio)
+	}
+	// The window holds one hour less at most one bucket.
+	if scrapes < 236 || scrapes > 240 {
+		t.Errorf("scrapes in the window = %d, want about 240", scrapes)
+	}
+
+	for now.Before(start.Add(90 * time.Minute)) {
+		ratio, _ = recordFreshness(target, true, now)
+		now = now.Add(15 * time.Second)
+	}
+	if math.Abs(ratio-0.875) > 0.01 {
+		t.Errorf("ratio after half an hour of complete scrapes = %v, want 0.875", ratio)
+	}
+	for now.Before(start.Add(2 * time.Hour)) {
+		ratio, _ = recordFreshness(target, true, now)
+		now = now.Add(15 * time.Second)
+	}
+	if ratio != 1 {
+		t.Errorf("ratio after an hour of complete scrapes = %v, want 1", ratio)
+	}
+}
+
+// TestRecordFreshnessTargets checks that targets have windows of their own
+// and that windows of targets no longer scraped are dropped.
+func TestRecordFreshnessTargets(t *testing.T) {
+	now := time.Date(2020, 6, 2, 0, 0, 0, 0, time.UTC)
+	recordFreshness("failing:33000:demodb", false, now)
+	if ratio, _ := recordFreshness("working:33000:demodb", true, now); ratio != 1 {
+		t.Errorf("ratio of the working target = %v, want 1", ratio)
+	}
+
+	recordFreshness("working:33000:demodb", true, now.Add(2*time.Hour))
+	freshnessState.Lock()
+	_, kept := freshnessState.windows["failing:33000:demodb"]
+	freshnessState.Unlock()
+	if kept {
+		t.Error("the window of a target not scraped for two hours was kept")
+	}
+}
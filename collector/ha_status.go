@@ -0,0 +1,211 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Scrape CUBRID HA node state and replication delay.
+
+package collector
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	haStatus = "ha_status"
+
+	// haStatusQuery returns one row per node of the HA group, as
+	// `cubrid heartbeat status` does.
+	haStatusQuery = "show ha status"
+
+	haApplyDelayQuery = `SELECT db_name, copied_log_path,
+		append_lsa_pageid - final_lsa_pageid,
+		UNIX_TIMESTAMP(last_access_time) - UNIX_TIMESTAMP(log_record_time)
+		FROM db_ha_apply_info`
+
+	// haProcessActive is the status of a running, registered HA process.
+	haProcessActive = "registered_and_active"
+)
+
+// haNodeStates are the states a node of an HA group can be in.
+var haNodeStates = []string{"master", "to-be-master", "slave", "to-be-slave", "replica", "maintenance", "dead", "unknown"}
+
+// Metric descriptors.
+var (
+	HAEnabled = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "ha", "enabled"),
+		"Whether the server runs in HA mode (1 for HA).",
+		nil, nil,
+	)
+	HANodeState = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "ha", "node_state"),
+		"State of the HA node, 1 for the current state and 0 for all others.",
+		[]string{"node_name", "state"}, nil,
+	)
+	HAProcessUp = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "ha", "process_up"),
+		"Whether the replication process of the node is registered and active.",
+		[]string{"node_name", "process"}, nil,
+	)
+	HAApplyDelayPages = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "ha", "apply_delay_pages"),
+		"Number of copied log pages the standby has not applied yet.",
+		[]string{"database", "peer"}, nil,
+	)
+	HAApplyDelaySeconds = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "ha", "apply_delay_seconds"),
+		"Age of the last applied log record when applylogdb last ran.",
+		[]string{"database", "peer"}, nil,
+	)
+)
+
+// ScrapeHAStatus collects the HA node states and the replication apply delay.
+type ScrapeHAStatus struct{}
+
+// Name of the Scraper. Should be unique.
+func (ScrapeHAStatus) Name() string {
+	return haStatus
+}
+
+// Help describes the role of the Scraper.
+func (ScrapeHAStatus) Help() string {
+	return "Scrape HA node states and replication apply delay"
+}
+
+// Version of CUBRID from which scraper is available.
+func (ScrapeHAStatus) Version() float64 {
+	return 10.2
+}
+
+// Scrape collects data from database connection and sends it over channel as prometheus metric.
+// Servers without HA only report cubrid_ha_enabled 0. The node states and
+// the apply delay are separate sub-collectors, so one failing does not lose
+// the metrics of the other.
+func (ScrapeHAStatus) Scrape(ctx context.Context, db *sql.DB, ch chan<- prometheus.Metric) error {
+	nodes := 0
+	nodesErr := runSubCollector(ctx, "nodes", func() (int, error) {
+		var err error
+		nodes, err = scrapeHANodes(ctx, db, ch)
+		return nodes, err
+	})
+	if nodesErr == nil {
+		if nodes == 0 {
+			ch <- prometheus.MustNewConstMetric(HAEnabled, prometheus.GaugeValue, 0)
+			return nil
+		}
+		ch <- prometheus.MustNewConstMetric(HAEnabled, prometheus.GaugeValue, 1)
+	}
+
+	// Without the node states the apply delay is still scraped, as only
+	// servers in HA mode have rows in db_ha_apply_info.
+	delayErr := runSubCollector(ctx, "apply_delay", func() (int, error) {
+		return scrapeHAApplyDelay(ctx, db, ch)
+	})
+	if nodesErr != nil {
+		return nodesErr
+	}
+	return delayErr
+}
+
+// scrapeHANodes sends the state and processes of every node of the HA group
+// and returns the number of nodes, 0 for servers without HA.
+func scrapeHANodes(ctx context.Context, db *sql.DB, ch chan<- prometheus.Metric) (int, error) {
+	nodeRows, err := db.QueryContext(ctx, haStatusQuery)
+	if err != nil {
+		if ClassifyError(err).Category == CategoryUnsupported {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	defer nodeRows.Close()
+
+	var node_name string
+	var state string
+	var copylogdb string
+	var applylogdb string
+	nodes := 0
+
+	for nodeRows.Next() {
+		nodes++
+
+		err := nodeRows.Scan(&node_name, &state, &copylogdb, &applylogdb)
+		if err != nil {
+			return nodes, err
+		}
+
+		node := sanitizeLabelValue(ctx, node_name)
+		state = strings.ToLower(strings.TrimSpace(state))
+		known := false
+		for _, s := range haNodeStates {
+			known = known || s == state
+		}
+		if !known {
+			reportAnomaly(ctx, anomalyUnparsedValue, "HA node state "+state)
+			state = "unknown"
+		}
+		for _, s := range haNodeStates {
+			v := 0.0
+			if s == state {
+				v = 1
+			}
+			ch <- prometheus.MustNewConstMetric(HANodeState, prometheus.GaugeValue, v, node, s)
+		}
+		ch <- prometheus.MustNewConstMetric(HAProcessUp, prometheus.GaugeValue, haProcessUp(copylogdb), node, "copylogdb")
+		ch <- prometheus.MustNewConstMetric(HAProcessUp, prometheus.GaugeValue, haProcessUp(applylogdb), node, "applylogdb")
+	}
+
+	return nodes, nodeRows.Err()
+}
+
+func scrapeHAApplyDelay(ctx context.Context, db *sql.DB, ch chan<- prometheus.Metric) (int, error) {
+	delayRows, err := db.QueryContext(ctx, haApplyDelayQuery)
+	if err != nil {
+		return 0, err
+	}
+
+	defer delayRows.Close()
+
+	var db_name string
+	var copied_log_path string
+	var delay_pages float64
+	var delay_seconds float64
+	rows := 0
+
+	for delayRows.Next() {
+		rows++
+
+		err := delayRows.Scan(&db_name, &copied_log_path, &delay_pages, &delay_seconds)
+		if err != nil {
+			return rows, err
+		}
+
+		peer := replicationPeer(copied_log_path)
+		ch <- prometheus.MustNewConstMetric(HAApplyDelayPages, prometheus.GaugeValue, delay_pages, db_name, peer)
+		ch <- prometheus.MustNewConstMetric(HAApplyDelaySeconds, prometheus.GaugeValue, delay_seconds, db_name, peer)
+	}
+
+	return rows, delayRows.Err()
+}
+
+func haProcessUp(status string) float64 {
+	if strings.TrimSpace(status) == haProcessActive {
+		return 1
+	}
+	return 0
+}
+
+// check interface
+var _ Scraper = ScrapeHAStatus{}
@@ -0,0 +1,195 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var (
+	haStatusTestColumns     = []string{"node_name", "state", "copylogdb", "applylogdb"}
+	haApplyDelayTestColumns = []string{"db_name", "copied_log_path", "delay_pages", "delay_seconds"}
+)
+
+// haStatusCollector runs ScrapeHAStatus like the Exporter does, sending the
+// success of its sub-collectors along with its metrics.
+type haStatusCollector struct {
+	t       *testing.T
+	db      *sql.DB
+	wantErr bool
+}
+
+func (c haStatusCollector) Describe(ch chan<- *prometheus.Desc) {}
+
+func (c haStatusCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, rec := withSubRecorder(context.Background())
+	if err := (ScrapeHAStatus{}).Scrape(ctx, c.db, ch); (err != nil) != c.wantErr {
+		c.t.Errorf("Scrape() = %v, want an error: %v", err, c.wantErr)
+	}
+	for _, r := range rec.results {
+		success := 1.0
+		if r.err != nil {
+			success = 0
+		}
+		ch <- prometheus.MustNewConstMetric(subcollectorSuccessDesc, prometheus.GaugeValue, success, haStatus, r.name)
+	}
+}
+
+// haNodeStateSamples returns the node state samples of a node in state.
+func haNodeStateSamples(node, state string) string {
+	var samples strings.Builder
+	for _, s := range haNodeStates {
+		v := "0"
+		if s == state {
+			v = "1"
+		}
+		samples.WriteString(`cubrid_ha_node_state{node_name="` + node + `",state="` + s + `"} ` + v + "\n")
+	}
+	return samples.String()
+}
+
+const haStatusTestHelp = `
+# HELP cubrid_ha_enabled Whether the server runs in HA mode (1 for HA).
+# TYPE cubrid_ha_enabled gauge
+`
+
+func TestScrapeHAStatus(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		expect   func(mock sqlmock.Sqlmock)
+		wantErr  bool
+		expected string
+	}{
+		{
+			name: "master",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(haStatusQuery).WillReturnRows(sqlmock.NewRows(haStatusTestColumns).
+					AddRow("node1", "master", haProcessActive, haProcessActive))
+				mock.ExpectQuery(haApplyDelayQuery).WillReturnRows(sqlmock.NewRows(haApplyDelayTestColumns))
+			},
+			expected: haStatusTestHelp + `cubrid_ha_enabled 1
+# HELP cubrid_ha_node_state State of the HA node, 1 for the current state and 0 for all others.
+# TYPE cubrid_ha_node_state gauge
+` + haNodeStateSamples("node1", "master") + `# HELP cubrid_exporter_subcollector_success Whether the sub-collector succeeded (1 for success).
+# TYPE cubrid_exporter_subcollector_success gauge
+cubrid_exporter_subcollector_success{collector="ha_status",subcollector="apply_delay"} 1
+cubrid_exporter_subcollector_success{collector="ha_status",subcollector="nodes"} 1
+`,
+		},
+		{
+			name: "slave",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(haStatusQuery).WillReturnRows(sqlmock.NewRows(haStatusTestColumns).
+					AddRow("node2", "Slave", haProcessActive, "deregistered"))
+				mock.ExpectQuery(haApplyDelayQuery).WillReturnRows(sqlmock.NewRows(haApplyDelayTestColumns).
+					AddRow("demodb", "/home/cubrid/databases/demodb_node1", 12, 30))
+			},
+			expected: haStatusTestHelp + `cubrid_ha_enabled 1
+# HELP cubrid_ha_node_state State of the HA node, 1 for the current state and 0 for all others.
+# TYPE cubrid_ha_node_state gauge
+` + haNodeStateSamples("node2", "slave") + `# HELP cubrid_ha_process_up Whether the replication process of the node is registered and active.
+# TYPE cubrid_ha_process_up gauge
+cubrid_ha_process_up{node_name="node2",process="applylogdb"} 0
+cubrid_ha_process_up{node_name="node2",process="copylogdb"} 1
+# HELP cubrid_ha_apply_delay_pages Number of copied log pages the standby has not applied yet.
+# TYPE cubrid_ha_apply_delay_pages gauge
+cubrid_ha_apply_delay_pages{database="demodb",peer="demodb_node1"} 12
+# HELP cubrid_ha_apply_delay_seconds Age of the last applied log record when applylogdb last ran.
+# TYPE cubrid_ha_apply_delay_seconds gauge
+cubrid_ha_apply_delay_seconds{database="demodb",peer="demodb_node1"} 30
+`,
+		},
+		{
+			name: "non-HA",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(haStatusQuery).WillReturnError(errors.New("ERROR: The server is not in HA mode."))
+			},
+			expected: haStatusTestHelp + `cubrid_ha_enabled 0
+# HELP cubrid_exporter_subcollector_success Whether the sub-collector succeeded (1 for success).
+# TYPE cubrid_exporter_subcollector_success gauge
+cubrid_exporter_subcollector_success{collector="ha_status",subcollector="nodes"} 1
+`,
+		},
+		{
+			name: "no nodes",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(haStatusQuery).WillReturnRows(sqlmock.NewRows(haStatusTestColumns))
+			},
+			expected: haStatusTestHelp + "cubrid_ha_enabled 0\n",
+		},
+		{
+			// The apply delay is still sent if the node states fail.
+			name: "failing node states",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(haStatusQuery).WillReturnError(errors.New("connection reset"))
+				mock.ExpectQuery(haApplyDelayQuery).WillReturnRows(sqlmock.NewRows(haApplyDelayTestColumns).
+					AddRow("demodb", "/home/cubrid/databases/demodb_node1", 12, 30))
+			},
+			wantErr: true,
+			expected: `
+# HELP cubrid_ha_apply_delay_pages Number of copied log pages the standby has not applied yet.
+# TYPE cubrid_ha_apply_delay_pages gauge
+cubrid_ha_apply_delay_pages{database="demodb",peer="demodb_node1"} 12
+# HELP cubrid_exporter_subcollector_success Whether the sub-collector succeeded (1 for success).
+# TYPE cubrid_exporter_subcollector_success gauge
+cubrid_exporter_subcollector_success{collector="ha_status",subcollector="apply_delay"} 1
+cubrid_exporter_subcollector_success{collector="ha_status",subcollector="nodes"} 0
+`,
+		},
+		{
+			// The node states are still sent if the apply delay fails.
+			name: "failing apply delay",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(haStatusQuery).WillReturnRows(sqlmock.NewRows(haStatusTestColumns).
+					AddRow("node2", "slave", haProcessActive, haProcessActive))
+				mock.ExpectQuery(haApplyDelayQuery).WillReturnError(errors.New("connection reset"))
+			},
+			wantErr: true,
+			expected: haStatusTestHelp + `cubrid_ha_enabled 1
+# HELP cubrid_exporter_subcollector_success Whether the sub-collector succeeded (1 for success).
+# TYPE cubrid_exporter_subcollector_success gauge
+cubrid_exporter_subcollector_success{collector="ha_status",subcollector="apply_delay"} 0
+cubrid_exporter_subcollector_success{collector="ha_status",subcollector="nodes"} 1
+`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock := newMock(t)
+			defer db.Close()
+			tc.expect(mock)
+
+			// Only the families present in the expectation are compared.
+			var names []string
+			for _, line := range strings.Split(tc.expected, "\n") {
+				if strings.HasPrefix(line, "# TYPE ") {
+					names = append(names, strings.Fields(line)[2])
+				}
+			}
+			if err := testutil.CollectAndCompare(haStatusCollector{t, db, tc.wantErr}, strings.NewReader(tc.expected), names...); err != nil {
+				t.Error(err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,302 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// High-water marks of gathered gauges.
+
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/log"
+)
+
+const (
+	// hwmSuffix is appended to the family name of the high-water mark series.
+	hwmSuffix = "_hwm"
+	// maxHWMTracked bounds the label sets tracked per family.
+	maxHWMTracked = 10000
+)
+
+// HWMConfig selects the gauge families whose high-water marks are tracked.
+type HWMConfig struct {
+	Families []string `yaml:"families"`
+	// ResetInterval resets all high-water marks periodically; zero disables it.
+	ResetInterval time.Duration `yaml:"reset_interval"`
+}
+
+type hwmPeak struct {
+	labels []*dto.LabelPair
+	value  float64
+	seen   time.Time
+}
+
+var hwmResetTimeDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, exporter, "hwm_reset_time_seconds"),
+	"Time of the last high-water mark reset in unix seconds.",
+	nil, nil,
+)
+
+// HighWaterMarks tracks the maximum value of each label set of the
+// configured gauge families since the last reset and adds them as
+// <family>_hwm series. It implements prometheus.Collector for the reset time.
+type HighWaterMarks struct {
+	interval time.Duration
+
+	mu        sync.Mutex
+	resetTime time.Time
+	peaks     map[string]map[string]*hwmPeak
+}
+
+// NewHighWaterMarks validates the config and returns the tracker.
+func NewHighWaterMarks(cfg HWMConfig) (*HighWaterMarks, error) {
+	if cfg.ResetInterval < 0 {
+		return nil, fmt.Errorf("hwm reset_interval must not be negative")
+	}
+	h := &HighWaterMarks{
+		interval:  cfg.ResetInterval,
+		resetTime: time.Now(),
+		peaks:     make(map[string]map[string]*hwmPeak, len(cfg.Families)),
+	}
+	for _, family := range cfg.Families {
+		if family == "" {
+			return nil, fmt.Errorf("hwm family name must not be empty")
+		}
+		h.peaks[family] = map[string]*hwmPeak{}
+	}
+	return h, nil
+}
+
+// Reset forgets all high-water marks.
+func (h *HighWaterMarks) Reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.reset(time.Now())
+}
+
+func (h *HighWaterMarks) reset(now time.Time) {
+	for family := range h.peaks {
+		h.peaks[family] = map[string]*hwmPeak{}
+	}
+	h.resetTime = now
+	log.Debugln("High-water marks reset")
+}
+
+// WriteTo writes the current high-water marks in the text exposition format.
+func (h *HighWaterMarks) WriteTo(w io.Writer) (int64, error) {
+	mfs := h.apply(nil, time.Now())
+	var n int64
+	for _, mf := range mfs {
+		written, err := expfmt.MetricFamilyToText(w, mf)
+		n += int64(written)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Describe implements prometheus.Collector.
+func (h *HighWaterMarks) Describe(ch chan<- *prometheus.Desc) {
+	ch <- hwmResetTimeDesc
+}
+
+// Collect implements prometheus.Collector.
+func (h *HighWaterMarks) Collect(ch chan<- prometheus.Metric) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ch <- prometheus.MustNewConstMetric(hwmResetTimeDesc, prometheus.GaugeValue, float64(h.resetTime.Unix()))
+}
+
+// Wrap returns a Gatherer adding the high-water marks to everything g gathers.
+// Without configured families g is returned unchanged.
+func (h *HighWaterMarks) Wrap(g prometheus.Gatherer) prometheus.Gatherer {
+	if h == nil || len(h.peaks) == 0 {
+		return g
+	}
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		mfs, err := g.Gather()
+		return h.apply(mfs, time.Now()), err
+	})
+}
+
+func (h *HighWaterMarks) apply(mfs []*dto.MetricFamily, now time.Time) []*dto.MetricFamily {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.interval > 0 && now.Sub(h.resetTime) >= h.interval {
+		h.reset(now)
+	}
+	for _, mf := range mfs {
+		peaks, ok := h.peaks[mf.GetName()]
+		if !ok || mf.GetType() != dto.MetricType_GAUGE {
+			continue
+		}
+		for _, m := range mf.Metric {
+			sig := labelSignature(m)
+			peak, ok := peaks[sig]
+			if !ok {
+				if len(peaks) >= maxHWMTracked {
+					evictOldestPeak(peaks)
+				}
+				peak = &hwmPeak{labels: m.Label, value: m.GetGauge().GetValue()}
+				peaks[sig] = peak
+			}
+			if v := m.GetGauge().GetValue(); v > peak.value {
+				peak.value = v
+			}
+			peak.seen = now
+		}
+	}
+
+	for family, peaks := range h.peaks {
+		if len(peaks) == 0 {
+			continue
+		}
+		mf := &dto.MetricFamily{
+			Name: proto.String(family + hwmSuffix),
+			Help: proto.String(fmt.Sprintf("Highest value of %s since the last reset.", family)),
+			Type: dto.MetricType_GAUGE.Enum(),
+		}
+		for _, peak := range peaks {
+			mf.Metric = append(mf.Metric, &dto.Metric{
+				Label: peak.labels,
+				Gauge: &dto.Gauge{Value: proto.Float64(peak.value)},
+			})
+		}
+		sort.Slice(mf.Metric, func(i, j int) bool {
+			return labelSignature(mf.Metric[i]) < labelSignature(mf.Metric[j])
+		})
+		mfs = append(mfs, mf)
+	}
+	sort.Slice(mfs, func(i, j int) bool { return mfs[i].GetName() < mfs[j].GetName() })
+	return mfs
+}
+
+func evictOldestPeak(peaks map[string]*hwmPeak) {
+	var oldest string
+	var oldestTime time.Time
+	for sig, peak := range peaks {
+		if oldestTime.IsZero() || peak.seen.Before(oldestTime) {
+			oldest, oldestTime = sig, peak.seen
+		}
+	}
+	delete(peaks, oldest)
+}
+
+// hwmResetTimeKey is the key of the reset time in the state file section.
+const hwmResetTimeKey = "reset_time"
+
+// hwmEntry is a persisted high-water mark.
+type hwmEntry struct {
+	Family string            `json:"family"`
+	Labels map[string]string `json:"labels"`
+	Value  float64           `json:"value"`
+	// Seen is the Unix time the label set was last gathered.
+	Seen int64 `json:"seen"`
+}
+
+// Section implements StateSaver.
+func (h *HighWaterMarks) Section() string {
+	return "high_water_marks"
+}
+
+// Version implements StateSaver.
+func (h *HighWaterMarks) Version() uint16 {
+	return 1
+}
+
+// MarshalState implements StateSaver. The label sets gathered longest ago are
+// evicted first; the marks are kept in memory.
+func (h *HighWaterMarks) MarshalState(maxBytes int) ([]byte, int, error) {
+	h.mu.Lock()
+	resetTime, err := json.Marshal(h.resetTime.Unix())
+	if err != nil {
+		h.mu.Unlock()
+		return nil, 0, err
+	}
+	entries := map[string]json.RawMessage{hwmResetTimeKey: resetTime}
+	var evict []string
+	seen := map[string]time.Time{}
+	for family, peaks := range h.peaks {
+		for _, peak := range peaks {
+			labels := make(map[string]string, len(peak.labels))
+			for _, lp := range peak.labels {
+				labels[lp.GetName()] = lp.GetValue()
+			}
+			entry, err := json.Marshal(hwmEntry{Family: family, Labels: labels, Value: peak.value, Seen: peak.seen.Unix()})
+			if err != nil {
+				h.mu.Unlock()
+				return nil, 0, err
+			}
+			key := strconv.Itoa(len(evict))
+			entries[key] = entry
+			evict = append(evict, key)
+			seen[key] = peak.seen
+		}
+	}
+	h.mu.Unlock()
+
+	sort.Slice(evict, func(i, j int) bool { return seen[evict[i]].Before(seen[evict[j]]) })
+	return marshalWithinBudget(entries, evict, maxBytes)
+}
+
+// UnmarshalState implements StateSaver. Marks of families no longer
+// configured are dropped.
+func (h *HighWaterMarks) UnmarshalState(data []byte) error {
+	var entries map[string]json.RawMessage
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+	var resetTime int64
+	if err := json.Unmarshal(entries[hwmResetTimeKey], &resetTime); err != nil {
+		return fmt.Errorf("invalid %s: %s", hwmResetTimeKey, err)
+	}
+	delete(entries, hwmResetTimeKey)
+
+	peaks := make(map[string]map[string]*hwmPeak, len(h.peaks))
+	for family := range h.peaks {
+		peaks[family] = map[string]*hwmPeak{}
+	}
+	for _, raw := range entries {
+		var entry hwmEntry
+		if err := json.Unmarshal(raw, &entry); err != nil {
+			return err
+		}
+		if _, ok := peaks[entry.Family]; !ok {
+			continue
+		}
+		m := &dto.Metric{}
+		for name, value := range entry.Labels {
+			m.Label = append(m.Label, &dto.LabelPair{Name: proto.String(name), Value: proto.String(value)})
+		}
+		sort.Slice(m.Label, func(i, j int) bool { return m.Label[i].GetName() < m.Label[j].GetName() })
+		peaks[entry.Family][labelSignature(m)] = &hwmPeak{labels: m.Label, value: entry.Value, seen: time.Unix(entry.Seen, 0)}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.peaks = peaks
+	h.resetTime = time.Unix(resetTime, 0)
+	return nil
+}
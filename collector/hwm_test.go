@@ -0,0 +1,156 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// hwmTestGauge returns a registry with the gauge cubrid_test_sessions{broker_name}.
+func hwmTestGauge() (*prometheus.Registry, *prometheus.GaugeVec) {
+	reg := prometheus.NewRegistry()
+	gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "cubrid_test_sessions", Help: "Test sessions."},
+		[]string{"broker_name"})
+	reg.MustRegister(gauge)
+	return reg, gauge
+}
+
+func newTestHighWaterMarks(t *testing.T, cfg HWMConfig) *HighWaterMarks {
+	h, err := NewHighWaterMarks(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+const hwmTestHeader = `
+# HELP cubrid_test_sessions_hwm Highest value of cubrid_test_sessions since the last reset.
+# TYPE cubrid_test_sessions_hwm gauge
+`
+
+func TestHighWaterMarks(t *testing.T) {
+	reg, gauge := hwmTestGauge()
+	h := newTestHighWaterMarks(t, HWMConfig{Families: []string{"cubrid_test_sessions"}})
+	g := h.Wrap(reg)
+
+	for _, v := range []float64{3, 7, 2} {
+		gauge.WithLabelValues("broker1").Set(v)
+		gauge.WithLabelValues("broker2").Set(1)
+		if _, err := g.Gather(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := hwmTestHeader + `cubrid_test_sessions_hwm{broker_name="broker1"} 7
+cubrid_test_sessions_hwm{broker_name="broker2"} 1
+`
+	if err := testutil.GatherAndCompare(g, strings.NewReader(expected), "cubrid_test_sessions_hwm"); err != nil {
+		t.Error(err)
+	}
+
+	h.Reset()
+	gauge.WithLabelValues("broker1").Set(2)
+	expected = hwmTestHeader + `cubrid_test_sessions_hwm{broker_name="broker1"} 2
+cubrid_test_sessions_hwm{broker_name="broker2"} 1
+`
+	if err := testutil.GatherAndCompare(g, strings.NewReader(expected), "cubrid_test_sessions_hwm"); err != nil {
+		t.Errorf("after a reset: %s", err)
+	}
+}
+
+func TestHighWaterMarksResetInterval(t *testing.T) {
+	reg, gauge := hwmTestGauge()
+	h := newTestHighWaterMarks(t, HWMConfig{Families: []string{"cubrid_test_sessions"}, ResetInterval: time.Hour})
+	start := h.resetTime
+
+	gauge.WithLabelValues("broker1").Set(9)
+	h.apply(gatherTest(t, reg), start.Add(time.Minute))
+	gauge.WithLabelValues("broker1").Set(4)
+	mfs := h.apply(gatherTest(t, reg), start.Add(time.Hour+time.Minute))
+	for _, mf := range mfs {
+		if mf.GetName() == "cubrid_test_sessions_hwm" {
+			if got := mf.Metric[0].GetGauge().GetValue(); got != 4 {
+				t.Errorf("high-water mark after the reset interval = %v, want 4", got)
+			}
+		}
+	}
+	if !h.resetTime.Equal(start.Add(time.Hour + time.Minute)) {
+		t.Errorf("reset time = %s", h.resetTime)
+	}
+}
+
+func gatherTest(t *testing.T, g prometheus.Gatherer) []*dto.MetricFamily {
+	mfs, err := g.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mfs
+}
+
+// TestHighWaterMarksState checks that the marks survive a restart through
+// the state file, and that marks of families no longer tracked are dropped.
+func TestHighWaterMarksState(t *testing.T) {
+	withStateFile(t)
+	reg, gauge := hwmTestGauge()
+	h := newTestHighWaterMarks(t, HWMConfig{Families: []string{"cubrid_test_sessions", "cubrid_test_other"}})
+	withStateSavers(t, h)
+	gauge.WithLabelValues("broker1").Set(7)
+	gatherTest(t, h.Wrap(reg))
+	h.peaks["cubrid_test_other"]["x"] = &hwmPeak{value: 1, seen: time.Now()}
+	resetTime := h.resetTime.Truncate(time.Second)
+	if err := SaveState(); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := newTestHighWaterMarks(t, HWMConfig{Families: []string{"cubrid_test_sessions"}})
+	withStateSavers(t, restarted)
+	if err := LoadState(); err != nil {
+		t.Fatal(err)
+	}
+	if !restarted.resetTime.Equal(resetTime) {
+		t.Errorf("reset time = %s, want %s", restarted.resetTime, resetTime)
+	}
+	gauge.WithLabelValues("broker1").Set(2)
+	expected := hwmTestHeader + `cubrid_test_sessions_hwm{broker_name="broker1"} 7
+`
+	if err := testutil.GatherAndCompare(restarted.Wrap(reg), strings.NewReader(expected), "cubrid_test_sessions_hwm", "cubrid_test_other_hwm"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHighWaterMarksStateBudget(t *testing.T) {
+	h := newTestHighWaterMarks(t, HWMConfig{Families: []string{"cubrid_test_sessions"}})
+	now := time.Now()
+	for i, broker := range []string{"old", "new"} {
+		m := &dto.Metric{Label: []*dto.LabelPair{{Name: proto.String("broker_name"), Value: proto.String(broker)}}}
+		h.peaks["cubrid_test_sessions"][labelSignature(m)] = &hwmPeak{labels: m.Label, value: 1, seen: now.Add(time.Duration(i) * time.Minute)}
+	}
+	full, _, err := h.MarshalState(1 << 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, evicted, err := h.MarshalState(len(full) - 1)
+	if err != nil || evicted != 1 {
+		t.Fatalf("evicted %d entries (error %v), want 1", evicted, err)
+	}
+	if !strings.Contains(string(data), `"new"`) || strings.Contains(string(data), `"old"`) {
+		t.Errorf("the oldest mark was not evicted: %s", data)
+	}
+}
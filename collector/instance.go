@@ -0,0 +1,108 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Stable exporter instance identification.
+
+package collector
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var instanceIDRE = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// Metric descriptors.
+var (
+	instanceInfoDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, exporter, "instance_info"),
+		"Stable identifier of this exporter instance.",
+		[]string{"instance_id"}, nil,
+	)
+	instanceEphemeralDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, exporter, "instance_id_ephemeral"),
+		"Whether the instance ID could not be persisted and will change on restart (1 for ephemeral).",
+		nil, nil,
+	)
+)
+
+// newInstanceID returns a random (version 4) UUID.
+func newInstanceID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
+// ResolveInstanceID returns the exporter instance ID. A non-empty override is
+// used as is. Otherwise the ID persisted in path is loaded, or generated and
+// persisted on first start. If the file is corrupted or cannot be written, an
+// ephemeral ID is returned together with the error.
+func ResolveInstanceID(override, path string) (string, error) {
+	if override != "" {
+		return override, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err == nil {
+		if id := strings.TrimSpace(string(data)); instanceIDRE.MatchString(id) {
+			return id, nil
+		}
+	}
+
+	id, genErr := newInstanceID()
+	if genErr != nil {
+		return "", genErr
+	}
+	switch {
+	case err == nil:
+		return id, fmt.Errorf("corrupted instance ID file %s", path)
+	case !os.IsNotExist(err):
+		return id, err
+	}
+	if err := ioutil.WriteFile(path, []byte(id+"\n"), 0644); err != nil {
+		return id, err
+	}
+	return id, nil
+}
+
+// InstanceCollector exposes the exporter instance ID. It implements prometheus.Collector.
+type InstanceCollector struct {
+	ID        string
+	Ephemeral bool
+}
+
+// Describe implements prometheus.Collector.
+func (c InstanceCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- instanceInfoDesc
+	ch <- instanceEphemeralDesc
+}
+
+// Collect implements prometheus.Collector.
+func (c InstanceCollector) Collect(ch chan<- prometheus.Metric) {
+	ephemeral := 0.0
+	if c.Ephemeral {
+		ephemeral = 1
+	}
+	ch <- prometheus.MustNewConstMetric(instanceInfoDesc, prometheus.GaugeValue, 1, c.ID)
+	ch <- prometheus.MustNewConstMetric(instanceEphemeralDesc, prometheus.GaugeValue, ephemeral)
+}
@@ -0,0 +1,113 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// tempInstanceIDFile returns the path of an instance ID file in an empty directory.
+func tempInstanceIDFile(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "instance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return filepath.Join(dir, "instance_id")
+}
+
+func TestResolveInstanceIDStable(t *testing.T) {
+	path := tempInstanceIDFile(t)
+
+	id, err := ResolveInstanceID("", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A version 4 UUID with the RFC 4122 variant.
+	if !instanceIDRE.MatchString(id) || id[14] != '4' || !strings.ContainsRune("89ab", rune(id[19])) {
+		t.Errorf("generated instance ID %q is not a version 4 UUID", id)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != id+"\n" {
+		t.Errorf("persisted instance ID = %q, want %q", data, id+"\n")
+	}
+
+	// Restarts load the persisted ID.
+	for i := 0; i < 3; i++ {
+		restarted, err := ResolveInstanceID("", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if restarted != id {
+			t.Errorf("instance ID after restart %d = %q, want %q", i+1, restarted, id)
+		}
+	}
+
+	// An override is used as is and leaves the persisted ID alone.
+	if got, err := ResolveInstanceID("exporter-1", path); err != nil || got != "exporter-1" {
+		t.Errorf("overridden instance ID = %q, %v", got, err)
+	}
+	if restarted, _ := ResolveInstanceID("", path); restarted != id {
+		t.Errorf("instance ID after an override = %q, want %q", restarted, id)
+	}
+}
+
+func TestResolveInstanceIDEphemeral(t *testing.T) {
+	corrupted := tempInstanceIDFile(t)
+	if err := ioutil.WriteFile(corrupted, []byte("not-a-uuid\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	unwritable := filepath.Join(tempInstanceIDFile(t), "missing", "instance_id")
+
+	for _, path := range []string{corrupted, unwritable} {
+		first, err := ResolveInstanceID("", path)
+		if err == nil {
+			t.Errorf("%s: expected an error", path)
+		}
+		second, err := ResolveInstanceID("", path)
+		if err == nil {
+			t.Errorf("%s: expected an error after a restart", path)
+		}
+		if !instanceIDRE.MatchString(first) || !instanceIDRE.MatchString(second) || first == second {
+			t.Errorf("%s: ephemeral instance IDs %q and %q should be distinct UUIDs", path, first, second)
+		}
+	}
+	// The corrupted file is left for the operator to inspect.
+	if data, _ := ioutil.ReadFile(corrupted); string(data) != "not-a-uuid\n" {
+		t.Errorf("corrupted instance ID file was rewritten to %q", data)
+	}
+}
+
+func TestInstanceCollector(t *testing.T) {
+	expected := `
+# HELP cubrid_exporter_instance_info Stable identifier of this exporter instance.
+# TYPE cubrid_exporter_instance_info gauge
+cubrid_exporter_instance_info{instance_id="exporter-1"} 1
+# HELP cubrid_exporter_instance_id_ephemeral Whether the instance ID could not be persisted and will change on restart (1 for ephemeral).
+# TYPE cubrid_exporter_instance_id_ephemeral gauge
+cubrid_exporter_instance_id_ephemeral 1
+`
+	if err := testutil.CollectAndCompare(InstanceCollector{ID: "exporter-1", Ephemeral: true}, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
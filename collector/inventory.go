@@ -0,0 +1,153 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Scrape the inventory of CUBRID brokers, databases and volumes.
+
+package collector
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	inventory = "inventory"
+
+	inventoryDatabaseQuery = "SELECT database()"
+)
+
+// errInventoryNoDatabase fails the volumes when the database is unknown.
+var errInventoryNoDatabase = errors.New("database of the connection unknown")
+
+// Metric descriptors.
+var (
+	InventoryBroker = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "broker", "info"),
+		"A broker known to the server, always 1.",
+		[]string{"broker"}, nil,
+	)
+	InventoryDatabase = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "database", "info_enumeration"),
+		"A database known to the exporter, always 1.",
+		[]string{"database"}, nil,
+	)
+	InventoryVolume = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "volume", "info"),
+		"A volume of a database, always 1.",
+		[]string{"database", "vol"}, nil,
+	)
+)
+
+// ScrapeInventory enumerates brokers, databases and volumes as cheap,
+// always-present series for dashboard template variables.
+type ScrapeInventory struct{}
+
+// Name of the Scraper. Should be unique.
+func (ScrapeInventory) Name() string {
+	return inventory
+}
+
+// Help describes the role of the Scraper.
+func (ScrapeInventory) Help() string {
+	return "Scrape the names of brokers, databases and volumes"
+}
+
+// Version of CUBRID from which scraper is available.
+func (ScrapeInventory) Version() float64 {
+	return 10.2
+}
+
+// Scrape collects data from database connection and sends it over channel as prometheus metric.
+// It fails only if all of its queries failed.
+func (ScrapeInventory) Scrape(ctx context.Context, db *sql.DB, ch chan<- prometheus.Metric) error {
+	var failed int
+	var lastErr error
+
+	err := runSubCollector(ctx, "brokers", func() (int, error) {
+		brokers, err := queryFirstColumn(ctx, db, brokerStatusQuery)
+		for _, broker := range brokers {
+			ch <- prometheus.MustNewConstMetric(InventoryBroker, prometheus.GaugeValue, 1, brokerLabel(broker))
+		}
+		return len(brokers), err
+	})
+	if err != nil {
+		failed, lastErr = failed+1, err
+	}
+
+	var database string
+	err = runSubCollector(ctx, "database", func() (int, error) {
+		if err := db.QueryRowContext(ctx, inventoryDatabaseQuery).Scan(&database); err != nil {
+			return 0, err
+		}
+		ch <- prometheus.MustNewConstMetric(InventoryDatabase, prometheus.GaugeValue, 1, database)
+		return 1, nil
+	})
+	if err != nil {
+		failed, lastErr = failed+1, err
+	}
+
+	err = runSubCollector(ctx, "volumes", func() (int, error) {
+		if database == "" {
+			return 0, errInventoryNoDatabase
+		}
+		volumes, err := queryFirstColumn(ctx, db, fmt.Sprintf(spacedbQuery, database))
+		for _, vol := range volumes {
+			ch <- prometheus.MustNewConstMetric(InventoryVolume, prometheus.GaugeValue, 1, database, vol)
+		}
+		return len(volumes), err
+	})
+	if err != nil {
+		failed, lastErr = failed+1, err
+	}
+
+	if failed == 3 {
+		return lastErr
+	}
+	return nil
+}
+
+// queryFirstColumn returns the first column of every row of query,
+// whatever the number of columns.
+func queryFirstColumn(ctx context.Context, db *sql.DB, query string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	values := make([]sql.RawBytes, len(columns))
+	scanArgs := make([]interface{}, len(columns))
+	for i := range values {
+		scanArgs[i] = &values[i]
+	}
+
+	var result []string
+	for rows.Next() {
+		if err := rows.Scan(scanArgs...); err != nil {
+			return nil, err
+		}
+		result = append(result, string(values[0]))
+	}
+	return result, rows.Err()
+}
+
+// check interface
+var _ Scraper = ScrapeInventory{}
@@ -0,0 +1,195 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// inventoryTestRows returns the brokers and volumes the inventory tests
+// serve, with padded names as brokers report them.
+func inventoryTestRows() (brokers, volumes *sqlmock.Rows) {
+	brokers = sqlmock.NewRows(brokerTestColumns()).
+		AddRow(" query_editor ", "5", "1234", "30000", "0", "10", "20", "30", "40", "100", "120", "7", "1", "2", "3").
+		AddRow("broker1", "-", "-", "-", "-", "-", "-", "-", "-", "-", "-", "-", "-", "-", "-")
+	volumes = sqlmock.NewRows(spacedbTestColumns).
+		AddRow("0", "PERMANENT", "PERMANENT DATA", "1", "100", "300").
+		AddRow("1", "TEMPORARY", "TEMPORARY TEMP", "1", "10", "30")
+	return brokers, volumes
+}
+
+func TestScrapeInventory(t *testing.T) {
+	db, mock := newMock(t)
+	defer db.Close()
+
+	brokers, volumes := inventoryTestRows()
+	mock.ExpectQuery(brokerStatusQuery).WillReturnRows(brokers)
+	expectDatabase(mock, "demodb")
+	mock.ExpectQuery(fmt.Sprintf(spacedbQuery, "demodb")).WillReturnRows(volumes)
+
+	expected := `
+# HELP cubrid_broker_info A broker known to the server, always 1.
+# TYPE cubrid_broker_info gauge
+cubrid_broker_info{broker="broker1"} 1
+cubrid_broker_info{broker="query_editor"} 1
+# HELP cubrid_database_info_enumeration A database known to the exporter, always 1.
+# TYPE cubrid_database_info_enumeration gauge
+cubrid_database_info_enumeration{database="demodb"} 1
+# HELP cubrid_volume_info A volume of a database, always 1.
+# TYPE cubrid_volume_info gauge
+cubrid_volume_info{database="demodb",vol="0"} 1
+cubrid_volume_info{database="demodb",vol="1"} 1
+`
+	if err := testutil.CollectAndCompare(scrapeCollector{t, ScrapeInventory{}, db}, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestScrapeInventoryPartialFailure(t *testing.T) {
+	db, mock := newMock(t)
+	defer db.Close()
+
+	// The brokers failing leaves the database and volumes.
+	_, volumes := inventoryTestRows()
+	mock.ExpectQuery(brokerStatusQuery).WillReturnError(errTestRow)
+	expectDatabase(mock, "demodb")
+	mock.ExpectQuery(fmt.Sprintf(spacedbQuery, "demodb")).WillReturnRows(volumes)
+	expected := `
+# HELP cubrid_database_info_enumeration A database known to the exporter, always 1.
+# TYPE cubrid_database_info_enumeration gauge
+cubrid_database_info_enumeration{database="demodb"} 1
+# HELP cubrid_volume_info A volume of a database, always 1.
+# TYPE cubrid_volume_info gauge
+cubrid_volume_info{database="demodb",vol="0"} 1
+cubrid_volume_info{database="demodb",vol="1"} 1
+`
+	if err := testutil.CollectAndCompare(scrapeCollector{t, ScrapeInventory{}, db}, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+
+	// Only all of its queries failing fails the scraper.
+	mock.ExpectQuery(brokerStatusQuery).WillReturnError(errTestRow)
+	mock.ExpectQuery(inventoryDatabaseQuery).WillReturnError(errTestRow)
+	// Without the database, the volumes are not queried.
+	if err := drainScrape(ScrapeInventory{}, db); err != errInventoryNoDatabase {
+		t.Errorf("error = %v, want %v", err, errInventoryNoDatabase)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+// scrapeSamples returns the samples scraper sends by descriptor.
+func scrapeSamples(t *testing.T, scraper Scraper, db *sql.DB) map[*prometheus.Desc][]*dto.Metric {
+	ch := make(chan prometheus.Metric)
+	done := make(chan map[*prometheus.Desc][]*dto.Metric)
+	go func() {
+		samples := map[*prometheus.Desc][]*dto.Metric{}
+		for metric := range ch {
+			m := &dto.Metric{}
+			if err := metric.Write(m); err != nil {
+				t.Error(err)
+			}
+			samples[metric.Desc()] = append(samples[metric.Desc()], m)
+		}
+		done <- samples
+	}()
+	err := scraper.Scrape(context.Background(), db, ch)
+	close(ch)
+	samples := <-done
+	if err != nil {
+		t.Fatal(err)
+	}
+	return samples
+}
+
+// sampleLabelValues returns the sorted distinct values of label on samples.
+func sampleLabelValues(samples []*dto.Metric, label string) []string {
+	seen := map[string]bool{}
+	var values []string
+	for _, m := range samples {
+		if value := labelValue(m, label); !seen[value] {
+			seen[value] = true
+			values = append(values, value)
+		}
+	}
+	sort.Strings(values)
+	return values
+}
+
+// TestInventoryJoinLabels checks that every value of the join labels of the
+// heavy collectors is enumerated by the inventory, so that dashboards can
+// join them.
+func TestInventoryJoinLabels(t *testing.T) {
+	db, mock := newMock(t)
+	defer db.Close()
+	defer forgetBrokerPorts()
+
+	brokers, volumes := inventoryTestRows()
+	mock.ExpectQuery(brokerStatusQuery).WillReturnRows(brokers)
+	expectDatabase(mock, "demodb")
+	mock.ExpectQuery(fmt.Sprintf(spacedbQuery, "demodb")).WillReturnRows(volumes)
+	inventory := scrapeSamples(t, ScrapeInventory{}, db)
+
+	brokers, volumes = inventoryTestRows()
+	mock.ExpectQuery(brokerStatusQuery).WillReturnRows(brokers)
+	status := scrapeSamples(t, ScrapeBrokerStatus{}, db)
+	expectDatabase(mock, "demodb")
+	mock.ExpectQuery(fmt.Sprintf(spacedbQuery, "demodb")).WillReturnRows(volumes)
+	spacedb := scrapeSamples(t, ScrapeSpaceDBStatus{}, db)
+
+	for _, tc := range []struct {
+		join             string
+		inventory, heavy []string
+	}{
+		{"broker = broker_name", sampleLabelValues(inventory[InventoryBroker], "broker"),
+			sampleLabelValues(status[brokerStatusDesc("num_as")], "broker_name")},
+		{"database = database", sampleLabelValues(inventory[InventoryDatabase], "database"),
+			sampleLabelValues(spacedb[VolumeTypeCode], "database")},
+		{"database = database of the volumes", sampleLabelValues(inventory[InventoryVolume], "database"),
+			sampleLabelValues(spacedb[VolumeTypeCode], "database")},
+		{"vol = vol_no", sampleLabelValues(inventory[InventoryVolume], "vol"),
+			sampleLabelValues(spacedb[VolumeTypeCode], "vol_no")},
+	} {
+		// Brokers that are OFF are enumerated without status series.
+		enumerated := map[string]bool{}
+		for _, value := range tc.inventory {
+			enumerated[value] = true
+		}
+		for _, value := range tc.heavy {
+			if !enumerated[value] {
+				t.Errorf("%s: collector value %q is missing from the inventory values %q", tc.join, value, tc.inventory)
+			}
+		}
+		if len(tc.heavy) == 0 {
+			t.Errorf("%s: no collector values", tc.join)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
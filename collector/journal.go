@@ -0,0 +1,118 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Bounded journal of recent collector errors.
+
+package collector
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// errorJournalSize bounds the distinct errors kept in the journal.
+	errorJournalSize = 200
+	// maxJournalMessage bounds the length of a journaled error message.
+	maxJournalMessage = 512
+)
+
+// JournalEntry is a distinct collector error. Repeats of the same collector,
+// cause and message only update Last, ScrapeID and Count.
+type JournalEntry struct {
+	Collector string    `json:"collector"`
+	Cause     string    `json:"cause"`
+	Hint      string    `json:"hint,omitempty"`
+	Message   string    `json:"message"`
+	First     time.Time `json:"first"`
+	Last      time.Time `json:"last"`
+	Count     int       `json:"count"`
+	// ScrapeID identifies the last scrape the error occurred in.
+	ScrapeID uint64 `json:"scrape_id"`
+}
+
+type journalKey struct {
+	collector, cause, message string
+}
+
+// errorJournal is shared between scrapes, as the Exporter is created per request.
+var errorJournal = struct {
+	sync.Mutex
+	entries map[journalKey]*JournalEntry
+}{entries: map[journalKey]*JournalEntry{}}
+
+// scrapeSeq numbers the scrapes of this process.
+var scrapeSeq uint64
+
+func nextScrapeID() uint64 {
+	return atomic.AddUint64(&scrapeSeq, 1)
+}
+
+// journalError records a collector error in the journal.
+func journalError(collector string, err error, scrapeID uint64, now time.Time) {
+	msg := err.Error()
+	if len(msg) > maxJournalMessage {
+		msg = msg[:maxJournalMessage] + "..."
+	}
+	class := ClassifyError(err)
+	key := journalKey{collector: collector, cause: string(class.Category), message: msg}
+
+	errorJournal.Lock()
+	defer errorJournal.Unlock()
+	entry, ok := errorJournal.entries[key]
+	if !ok {
+		if len(errorJournal.entries) >= errorJournalSize {
+			evictOldestJournalEntry()
+		}
+		entry = &JournalEntry{Collector: collector, Cause: key.cause, Hint: class.Hint, Message: msg, First: now}
+		errorJournal.entries[key] = entry
+	}
+	entry.Last = now
+	entry.Count++
+	entry.ScrapeID = scrapeID
+}
+
+func evictOldestJournalEntry() {
+	var oldest journalKey
+	var oldestTime time.Time
+	for key, entry := range errorJournal.entries {
+		if oldestTime.IsZero() || entry.Last.Before(oldestTime) {
+			oldest, oldestTime = key, entry.Last
+		}
+	}
+	delete(errorJournal.entries, oldest)
+}
+
+// ErrorJournal returns the journaled errors last seen after since, optionally
+// only those of one collector, most recent first.
+func ErrorJournal(since time.Time, collector string) []JournalEntry {
+	errorJournal.Lock()
+	defer errorJournal.Unlock()
+	entries := []JournalEntry{}
+	for _, entry := range errorJournal.entries {
+		if entry.Last.After(since) && (collector == "" || entry.Collector == collector) {
+			entries = append(entries, *entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Last.After(entries[j].Last) })
+	return entries
+}
+
+// ClearErrorJournal removes all journaled errors.
+func ClearErrorJournal() {
+	errorJournal.Lock()
+	defer errorJournal.Unlock()
+	errorJournal.entries = map[journalKey]*JournalEntry{}
+}
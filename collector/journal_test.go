@@ -0,0 +1,111 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// withEmptyJournal clears the error journal before and after the test.
+func withEmptyJournal(t *testing.T) {
+	ClearErrorJournal()
+	t.Cleanup(ClearErrorJournal)
+}
+
+func TestErrorJournal(t *testing.T) {
+	withEmptyJournal(t)
+	start := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	timeout := fmt.Errorf("query: %w", context.DeadlineExceeded)
+	journalError("statdump", timeout, 1, start)
+	journalError("statdump", timeout, 2, start.Add(time.Minute))
+	journalError("statdump", errors.New("table not found"), 2, start.Add(2*time.Minute))
+	journalError("broker_status", timeout, 3, start.Add(3*time.Minute))
+
+	// Repeats update the entry of the first occurrence.
+	entries := ErrorJournal(time.Time{}, "statdump")
+	if len(entries) != 2 {
+		t.Fatalf("%d statdump entries, want 2: %+v", len(entries), entries)
+	}
+	repeated := entries[1]
+	if repeated.Message != timeout.Error() || repeated.Cause != string(CategoryTimeout) || repeated.Hint == "" {
+		t.Errorf("entry = %+v, want the classified timeout", repeated)
+	}
+	if repeated.Count != 2 || !repeated.First.Equal(start) || !repeated.Last.Equal(start.Add(time.Minute)) || repeated.ScrapeID != 2 {
+		t.Errorf("repeated entry = %+v, want 2 occurrences, the last in scrape 2", repeated)
+	}
+
+	// Most recent first, only those after since.
+	var collectors []string
+	for _, entry := range ErrorJournal(start.Add(time.Minute), "") {
+		collectors = append(collectors, entry.Collector+"/"+entry.Message)
+	}
+	if got, want := strings.Join(collectors, ","), "broker_status/"+timeout.Error()+",statdump/table not found"; got != want {
+		t.Errorf("entries = %s, want %s", got, want)
+	}
+
+	ClearErrorJournal()
+	if entries := ErrorJournal(time.Time{}, ""); len(entries) != 0 {
+		t.Errorf("entries after clearing = %+v, want none", entries)
+	}
+}
+
+func TestErrorJournalBounds(t *testing.T) {
+	withEmptyJournal(t)
+	start := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	journalError("statdump", errors.New(strings.Repeat("x", 2*maxJournalMessage)), 1, start)
+	entries := ErrorJournal(time.Time{}, "")
+	if len(entries) != 1 || len(entries[0].Message) != maxJournalMessage+len("...") {
+		t.Fatalf("entries = %+v, want one truncated message", entries)
+	}
+
+	// The least recently seen error is evicted once the journal is full.
+	for i := 1; i <= errorJournalSize; i++ {
+		journalError("statdump", fmt.Errorf("error %d", i), uint64(i), start.Add(time.Duration(i)*time.Second))
+	}
+	entries = ErrorJournal(time.Time{}, "")
+	if len(entries) != errorJournalSize {
+		t.Fatalf("%d entries, want %d", len(entries), errorJournalSize)
+	}
+	if oldest := entries[len(entries)-1]; oldest.Message != "error 1" {
+		t.Errorf("oldest entry = %q, want the truncated message evicted", oldest.Message)
+	}
+}
+
+// TestErrorJournalScrape checks that a failing collector is journaled with
+// the ID of its scrape, and a working one is not.
+func TestErrorJournalScrape(t *testing.T) {
+	withEmptyJournal(t)
+	e := New(context.Background(), SimulatedDSN, NewMetrics(),
+		[]Scraper{fakeScraper{name: "fake_failing", err: errors.New("lost")}, fakeScraper{name: "fake_ok"}}, nil)
+	collectExporter(e)
+	collectExporter(e)
+
+	last := atomic.LoadUint64(&scrapeSeq)
+
+	entries := ErrorJournal(time.Time{}, "")
+	if len(entries) != 1 || entries[0].Collector != "fake_failing" || entries[0].Message != "lost" {
+		t.Fatalf("entries = %+v, want the failing collector", entries)
+	}
+	if entries[0].Count != 2 || entries[0].ScrapeID != last {
+		t.Errorf("entry = %+v, want 2 occurrences, the last in scrape %d", entries[0], last)
+	}
+}
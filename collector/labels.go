@@ -0,0 +1,131 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Constant labels added to everything a collector emits.
+
+package collector
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var labelNameRE = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// reservedLabels are set by Prometheus itself and must not be configured.
+var reservedLabels = map[string]bool{
+	"instance": true,
+	"job":      true,
+	"le":       true,
+	"quantile": true,
+}
+
+// CollectorConfig is the per-collector section of the config file.
+type CollectorConfig struct {
+	// Labels are added to every sample of the collector, e.g. for cost
+	// attribution. Labels the collector sets itself take precedence.
+	Labels map[string]string `yaml:"labels"`
+	// Enabled enables or disables the collector, overriding the profile. A
+	// --collect.<name> flag set on the command line takes precedence.
+	Enabled *bool `yaml:"enabled"`
+}
+
+// collectorLabels holds the validated labels per collector, sorted by name.
+var collectorLabels = struct {
+	sync.RWMutex
+	labels map[string][]*dto.LabelPair
+}{labels: map[string][]*dto.LabelPair{}}
+
+// SetCollectorConfigs validates and applies the per-collector configs.
+func SetCollectorConfigs(configs map[string]CollectorConfig) error {
+	labels := make(map[string][]*dto.LabelPair, len(configs))
+	for collector, cfg := range configs {
+		var pairs []*dto.LabelPair
+		for name, value := range cfg.Labels {
+			if !labelNameRE.MatchString(name) || strings.HasPrefix(name, "__") {
+				return fmt.Errorf("collector %s: invalid label name %q", collector, name)
+			}
+			if reservedLabels[name] {
+				return fmt.Errorf("collector %s: label name %q is reserved", collector, name)
+			}
+			pairs = append(pairs, &dto.LabelPair{Name: proto.String(name), Value: proto.String(value)})
+		}
+		sort.Slice(pairs, func(i, j int) bool { return pairs[i].GetName() < pairs[j].GetName() })
+		if len(pairs) > 0 {
+			labels[collector] = pairs
+		}
+	}
+	collectorLabels.Lock()
+	defer collectorLabels.Unlock()
+	collectorLabels.labels = labels
+	return nil
+}
+
+// labeledMetric adds constant labels to a metric, unless it has them already.
+type labeledMetric struct {
+	prometheus.Metric
+	labels []*dto.LabelPair
+}
+
+func (m labeledMetric) Write(out *dto.Metric) error {
+	if err := m.Metric.Write(out); err != nil {
+		return err
+	}
+	for _, lp := range m.labels {
+		if !hasLabel(out, lp.GetName()) {
+			out.Label = append(out.Label, lp)
+		}
+	}
+	sort.Slice(out.Label, func(i, j int) bool { return out.Label[i].GetName() < out.Label[j].GetName() })
+	return nil
+}
+
+func hasLabel(m *dto.Metric, name string) bool {
+	for _, lp := range m.Label {
+		if lp.GetName() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// withCollectorLabels returns a channel adding the labels configured for the
+// collector to every metric sent to ch, and a func to call once done sending.
+func withCollectorLabels(ch chan<- prometheus.Metric, collector string) (chan<- prometheus.Metric, func()) {
+	collectorLabels.RLock()
+	labels := collectorLabels.labels[collector]
+	collectorLabels.RUnlock()
+	if len(labels) == 0 {
+		return ch, func() {}
+	}
+
+	forward := make(chan prometheus.Metric)
+	finished := make(chan struct{})
+	go func() {
+		for metric := range forward {
+			ch <- labeledMetric{Metric: metric, labels: labels}
+		}
+		close(finished)
+	}()
+	return forward, func() {
+		close(forward)
+		<-finished
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// withLobPaths sets --collect.lob_storage.path for the test.
+func withLobPaths(t *testing.T, paths ...string) {
+	saved := *lobPaths
+	*lobPaths = paths
+	t.Cleanup(func() { *lobPaths = saved })
+}
+
+// writeLobFiles creates a LOB directory with files of the given sizes, one
+// per subdirectory as the server spreads them.
+func writeLobFiles(t *testing.T, sizes ...int) string {
+	dir, err := ioutil.TempDir("", "lob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	for i, size := range sizes {
+		sub := filepath.Join(dir, fmt.Sprintf("ces_%03d", i))
+		if err := os.Mkdir(sub, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(sub, "lob.blob"), make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestScrapeLobStorage(t *testing.T) {
+	first := writeLobFiles(t, 100, 250, 0)
+	empty := writeLobFiles(t)
+	// databases.txt writes the path as a file: URL.
+	withLobPaths(t, "file:"+first, empty)
+
+	expected := fmt.Sprintf(`
+# HELP cubrid_lob_storage_bytes Total size of the external LOB files.
+# TYPE cubrid_lob_storage_bytes gauge
+cubrid_lob_storage_bytes{path=%[1]q} 350
+cubrid_lob_storage_bytes{path=%[2]q} 0
+# HELP cubrid_lob_storage_files Number of external LOB files.
+# TYPE cubrid_lob_storage_files gauge
+cubrid_lob_storage_files{path=%[1]q} 3
+cubrid_lob_storage_files{path=%[2]q} 0
+`, first, empty)
+	if err := testutil.CollectAndCompare(scrapeCollector{t, ScrapeLobStorage{}, nil}, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestScrapeLobStorageErrors(t *testing.T) {
+	withLobPaths(t, writeLobFiles(t, 10), filepath.Join(os.TempDir(), "cubrid-exporter-no-such-lob-dir"))
+	ch := make(chan prometheus.Metric, 4)
+	err := (ScrapeLobStorage{}).Scrape(context.Background(), nil, ch)
+	if !os.IsNotExist(err) {
+		t.Errorf("error of a missing directory = %v, want not exist", err)
+	}
+	// The directories before the missing one are still reported.
+	if len(ch) != 2 {
+		t.Errorf("%d samples before the missing directory, want 2", len(ch))
+	}
+
+	withLobPaths(t, writeLobFiles(t, 10))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := (ScrapeLobStorage{}).Scrape(ctx, nil, make(chan prometheus.Metric, 2)); err != context.Canceled {
+		t.Errorf("error of a canceled scrape = %v, want %v", err, context.Canceled)
+	}
+}
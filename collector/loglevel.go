@@ -0,0 +1,130 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Runtime log level overrides of single collectors.
+
+package collector
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/log"
+)
+
+// LogLevelOverride is the log level of a collector overriding --log.level.
+type LogLevelOverride struct {
+	Collector string
+	Level     string
+	// Expires is zero for overrides without a TTL.
+	Expires time.Time
+
+	logger log.Logger
+}
+
+// logLevelNow is replaced to expire overrides deterministically.
+var logLevelNow = time.Now
+
+// logLevelState is shared between scrapes, as the Exporter is created per request.
+var logLevelState = struct {
+	sync.Mutex
+	overrides map[string]*LogLevelOverride
+}{overrides: map[string]*LogLevelOverride{}}
+
+// SetCollectorLogLevel overrides the log level of the collector. A positive
+// ttl reverts the override to --log.level once it elapsed.
+func SetCollectorLogLevel(collector, level string, ttl time.Duration) error {
+	if ttl < 0 {
+		return fmt.Errorf("ttl must not be negative")
+	}
+	logger := log.NewLogger(os.Stderr)
+	if err := logger.SetLevel(level); err != nil {
+		return err
+	}
+
+	override := &LogLevelOverride{
+		Collector: collector,
+		Level:     level,
+		logger:    logger.With("collector", collector),
+	}
+	if ttl > 0 {
+		override.Expires = logLevelNow().Add(ttl)
+	}
+	logLevelState.Lock()
+	defer logLevelState.Unlock()
+	logLevelState.overrides[collector] = override
+	return nil
+}
+
+// ClearCollectorLogLevel removes the override of the collector and reports
+// whether there was one.
+func ClearCollectorLogLevel(collector string) bool {
+	logLevelState.Lock()
+	defer logLevelState.Unlock()
+	_, ok := logLevelState.overrides[collector]
+	delete(logLevelState.overrides, collector)
+	return ok
+}
+
+// CollectorLogLevels returns the active overrides sorted by collector.
+func CollectorLogLevels() []LogLevelOverride {
+	logLevelState.Lock()
+	defer logLevelState.Unlock()
+	expireLogLevels(logLevelNow())
+	var overrides []LogLevelOverride
+	for _, override := range logLevelState.overrides {
+		overrides = append(overrides, *override)
+	}
+	sort.Slice(overrides, func(i, j int) bool { return overrides[i].Collector < overrides[j].Collector })
+	return overrides
+}
+
+func expireLogLevels(now time.Time) {
+	for collector, override := range logLevelState.overrides {
+		if !override.Expires.IsZero() && !now.Before(override.Expires) {
+			log.Infof("Log level override %s of collector %s expired", override.Level, collector)
+			delete(logLevelState.overrides, collector)
+		}
+	}
+}
+
+// collectorLogger returns the logger of the collector, which uses the
+// override's level while one is active.
+func collectorLogger(collector string) log.Logger {
+	logLevelState.Lock()
+	defer logLevelState.Unlock()
+	expireLogLevels(logLevelNow())
+	if override, ok := logLevelState.overrides[collector]; ok {
+		return override.logger
+	}
+	return log.With("collector", collector)
+}
+
+type loggerKey struct{}
+
+func withLogger(ctx context.Context, collector string) context.Context {
+	return context.WithValue(ctx, loggerKey{}, collector)
+}
+
+// loggerFrom returns the logger of the running scraper. The level is looked
+// up on every call, so overrides take effect within a running scrape.
+func loggerFrom(ctx context.Context) log.Logger {
+	if collector, ok := ctx.Value(loggerKey{}).(string); ok {
+		return collectorLogger(collector)
+	}
+	return log.Base()
+}
@@ -0,0 +1,122 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// withLogLevelClock replaces the clock of the overrides and clears them
+// after the test.
+func withLogLevelClock(t *testing.T, now time.Time) *time.Time {
+	t.Helper()
+	clock := now
+	logLevelNow = func() time.Time { return clock }
+	t.Cleanup(func() {
+		logLevelNow = time.Now
+		logLevelState.Lock()
+		logLevelState.overrides = map[string]*LogLevelOverride{}
+		logLevelState.Unlock()
+	})
+	return &clock
+}
+
+func TestCollectorLogLevelTTL(t *testing.T) {
+	start := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	clock := withLogLevelClock(t, start)
+
+	if err := SetCollectorLogLevel("statdump", "debug", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetCollectorLogLevel("broker_status", "warn", 0); err != nil {
+		t.Fatal(err)
+	}
+	overrides := CollectorLogLevels()
+	if len(overrides) != 2 || overrides[0].Collector != "broker_status" || overrides[1].Collector != "statdump" {
+		t.Fatalf("overrides = %+v, want broker_status and statdump", overrides)
+	}
+	if !overrides[0].Expires.IsZero() {
+		t.Errorf("override without a TTL expires at %v", overrides[0].Expires)
+	}
+	if want := start.Add(time.Minute); !overrides[1].Expires.Equal(want) {
+		t.Errorf("expires = %v, want %v", overrides[1].Expires, want)
+	}
+	statdump := overrides[1].logger
+
+	*clock = start.Add(time.Minute - time.Second)
+	if collectorLogger("statdump") != statdump {
+		t.Error("override expired before its TTL")
+	}
+
+	// The override expires once the TTL elapsed, and only that one.
+	*clock = start.Add(time.Minute)
+	if collectorLogger("statdump") == statdump {
+		t.Error("override still active after its TTL")
+	}
+	*clock = start.Add(24 * time.Hour)
+	overrides = CollectorLogLevels()
+	if len(overrides) != 1 || overrides[0].Collector != "broker_status" {
+		t.Errorf("overrides = %+v, want broker_status", overrides)
+	}
+}
+
+func TestLoggerFromOverride(t *testing.T) {
+	start := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	clock := withLogLevelClock(t, start)
+	ctx := withLogger(context.Background(), "statdump")
+
+	// An override set within a running scrape applies to its next call.
+	if err := SetCollectorLogLevel("statdump", "debug", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	override := CollectorLogLevels()[0].logger
+	if loggerFrom(ctx) != override {
+		t.Error("override not applied within the scrape")
+	}
+	if loggerFrom(withLogger(context.Background(), "spacedb_status")) == override {
+		t.Error("override applied to another collector")
+	}
+	*clock = start.Add(time.Second)
+	if loggerFrom(ctx) == override {
+		t.Error("override still active after its TTL")
+	}
+	if overrides := CollectorLogLevels(); len(overrides) != 0 {
+		t.Errorf("overrides = %+v, want none", overrides)
+	}
+}
+
+func TestSetCollectorLogLevelInvalid(t *testing.T) {
+	withLogLevelClock(t, time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC))
+	if err := SetCollectorLogLevel("statdump", "debug", -time.Second); err == nil {
+		t.Error("negative TTL accepted")
+	}
+	if err := SetCollectorLogLevel("statdump", "verbose", 0); err == nil {
+		t.Error("unknown level accepted")
+	}
+	if overrides := CollectorLogLevels(); len(overrides) != 0 {
+		t.Errorf("invalid overrides stored: %+v", overrides)
+	}
+
+	if err := SetCollectorLogLevel("statdump", "debug", 0); err != nil {
+		t.Fatal(err)
+	}
+	if !ClearCollectorLogLevel("statdump") {
+		t.Error("ClearCollectorLogLevel of an override = false, want true")
+	}
+	if ClearCollectorLogLevel("statdump") {
+		t.Error("ClearCollectorLogLevel without an override = true, want false")
+	}
+}
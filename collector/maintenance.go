@@ -0,0 +1,101 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Suspension of collection while the database is in maintenance.
+
+package collector
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+var maintenanceProbeInterval = kingpin.Flag(
+	"exporter.maintenance-probe-interval",
+	"While the database is detected in maintenance, check at most this often whether normal access returned.",
+).Default("30s").Duration()
+
+var maintenanceDetectedDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "database", "maintenance_detected"),
+	"Whether the database refuses connections for maintenance, e.g. a utility in standalone mode (1 for maintenance).",
+	[]string{"database"}, nil,
+)
+
+type maintenanceEntry struct {
+	since     time.Time
+	lastProbe time.Time
+}
+
+// maintenanceState holds the targets detected in maintenance. It is shared
+// between scrapes, as the Exporter is created per request.
+var maintenanceState = struct {
+	sync.Mutex
+	targets map[string]*maintenanceEntry
+}{targets: map[string]*maintenanceEntry{}}
+
+// maintenanceProbeDue reports whether dsn should be contacted. Targets in
+// maintenance are only probed once per --exporter.maintenance-probe-interval.
+func maintenanceProbeDue(dsn string, now time.Time) bool {
+	maintenanceState.Lock()
+	defer maintenanceState.Unlock()
+	entry, ok := maintenanceState.targets[dsn]
+	if !ok {
+		return true
+	}
+	if now.Sub(entry.lastProbe) < *maintenanceProbeInterval {
+		return false
+	}
+	entry.lastProbe = now
+	return true
+}
+
+// recordMaintenance records whether the last connection attempt to dsn was
+// refused for maintenance and counts transitions. It reports whether dsn is
+// in maintenance.
+func recordMaintenance(dsn string, err error, now time.Time, transitions *prometheus.CounterVec) bool {
+	maintenance := err != nil && ClassifyError(err).Category == CategoryRestrictedAccess
+	maintenanceState.Lock()
+	defer maintenanceState.Unlock()
+	entry, detected := maintenanceState.targets[dsn]
+	switch {
+	case maintenance && !detected:
+		log.Warnf("Database %s is in maintenance, suspending collection: %s", dsnDatabase(dsn), err)
+		maintenanceState.targets[dsn] = &maintenanceEntry{since: now, lastProbe: now}
+		transitions.WithLabelValues("entered").Inc()
+	case !maintenance && detected:
+		log.Infof("Database %s left maintenance after %s, resuming collection", dsnDatabase(dsn), now.Sub(entry.since))
+		delete(maintenanceState.targets, dsn)
+		transitions.WithLabelValues("exited").Inc()
+	}
+	return maintenance
+}
+
+// inMaintenance reports whether dsn is currently detected in maintenance.
+func inMaintenance(dsn string) bool {
+	maintenanceState.Lock()
+	defer maintenanceState.Unlock()
+	_, ok := maintenanceState.targets[dsn]
+	return ok
+}
+
+func collectMaintenance(dsn string, ch chan<- prometheus.Metric) {
+	v := 0.0
+	if inMaintenance(dsn) {
+		v = 1
+	}
+	ch <- prometheus.MustNewConstMetric(maintenanceDetectedDesc, prometheus.GaugeValue, v, dsnDatabase(dsn))
+}
@@ -0,0 +1,76 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// TestMaintenance walks a target into maintenance, through its probes and
+// out again.
+func TestMaintenance(t *testing.T) {
+	const dsn = "cci:cubrid:maint:33000:maintdb:::"
+	transitions := NewMetrics().MaintenanceTransitions
+	standalone := errors.New("maintdb is being used by a utility in standalone mode")
+	start := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	if !maintenanceProbeDue(dsn, start) {
+		t.Fatal("a target not in maintenance must always be contacted")
+	}
+	if recordMaintenance(dsn, errors.New("connection refused"), start, transitions) {
+		t.Error("an outage was detected as maintenance")
+	}
+	if !recordMaintenance(dsn, standalone, start, transitions) {
+		t.Fatal("standalone mode was not detected as maintenance")
+	}
+	if got := testutil.ToFloat64(transitions.WithLabelValues("entered")); got != 1 {
+		t.Errorf("entered transitions = %v, want 1", got)
+	}
+	if !inMaintenance(dsn) {
+		t.Error("the target is not reported in maintenance")
+	}
+
+	// During maintenance the target is only probed once per interval.
+	interval := *maintenanceProbeInterval
+	if maintenanceProbeDue(dsn, start.Add(interval/2)) {
+		t.Error("probed before the probe interval passed")
+	}
+	if !maintenanceProbeDue(dsn, start.Add(interval)) {
+		t.Fatal("not probed after the probe interval")
+	}
+	recordMaintenance(dsn, standalone, start.Add(interval), transitions)
+	if maintenanceProbeDue(dsn, start.Add(interval+interval/2)) {
+		t.Error("probed again before the next probe interval passed")
+	}
+	if got := testutil.ToFloat64(transitions.WithLabelValues("entered")); got != 1 {
+		t.Errorf("entered transitions while in maintenance = %v, want 1", got)
+	}
+
+	if !maintenanceProbeDue(dsn, start.Add(2*interval)) {
+		t.Fatal("not probed after the second probe interval")
+	}
+	if recordMaintenance(dsn, nil, start.Add(2*interval), transitions) {
+		t.Error("still in maintenance after a successful connection")
+	}
+	if got := testutil.ToFloat64(transitions.WithLabelValues("exited")); got != 1 {
+		t.Errorf("exited transitions = %v, want 1", got)
+	}
+	if inMaintenance(dsn) || !maintenanceProbeDue(dsn, start.Add(2*interval)) {
+		t.Error("the target was not released from maintenance")
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Duplication of all families under an additional namespace.
+
+package collector
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const (
+	// originLabel marks the duplicated families.
+	originLabel          = "origin"
+	originExtraNamespace = "compat-namespace"
+)
+
+var namespaceRE = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// ExtraNamespace emits every family of the exporter's namespace a second
+// time under another namespace, labeled origin="compat-namespace", to allow
+// migrating recording rules and dashboards between namespaces.
+type ExtraNamespace struct {
+	name string
+}
+
+// NewExtraNamespace returns the duplication for name. An empty name disables it.
+func NewExtraNamespace(name string) (*ExtraNamespace, error) {
+	if name == "" {
+		return nil, nil
+	}
+	if !namespaceRE.MatchString(name) {
+		return nil, fmt.Errorf("invalid namespace %q", name)
+	}
+	if name == namespace {
+		return nil, fmt.Errorf("extra namespace must differ from %q", namespace)
+	}
+	return &ExtraNamespace{name: name}, nil
+}
+
+// Wrap returns a Gatherer adding the duplicated families to everything g
+// gathers. A nil ExtraNamespace returns g unchanged.
+func (n *ExtraNamespace) Wrap(g prometheus.Gatherer) prometheus.Gatherer {
+	if n == nil {
+		return g
+	}
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		mfs, err := g.Gather()
+		return n.apply(mfs), err
+	})
+}
+
+func (n *ExtraNamespace) apply(mfs []*dto.MetricFamily) []*dto.MetricFamily {
+	prefix := namespace + "_"
+	for _, mf := range mfs {
+		if !strings.HasPrefix(mf.GetName(), prefix) {
+			continue
+		}
+		dup := proto.Clone(mf).(*dto.MetricFamily)
+		dup.Name = proto.String(n.name + "_" + strings.TrimPrefix(mf.GetName(), prefix))
+		for _, m := range dup.Metric {
+			m.Label = append(removeLabel(m.Label, originLabel), &dto.LabelPair{
+				Name:  proto.String(originLabel),
+				Value: proto.String(originExtraNamespace),
+			})
+			sort.Slice(m.Label, func(i, j int) bool { return m.Label[i].GetName() < m.Label[j].GetName() })
+		}
+		mfs = append(mfs, dup)
+	}
+	sort.Slice(mfs, func(i, j int) bool { return mfs[i].GetName() < mfs[j].GetName() })
+	return mfs
+}
+
+func removeLabel(labels []*dto.LabelPair, name string) []*dto.LabelPair {
+	result := labels[:0]
+	for _, lp := range labels {
+		if lp.GetName() != name {
+			result = append(result, lp)
+		}
+	}
+	return result
+}
@@ -0,0 +1,135 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestExtraNamespace(t *testing.T) {
+	n, err := NewExtraNamespace("mysql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := prometheus.NewRegistry()
+	up := prometheus.NewGauge(prometheus.GaugeOpts{Name: "cubrid_up", Help: "Source."})
+	up.Set(1)
+	brokers := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "cubrid_broker_requests_total", Help: "Source."}, []string{"broker_name"})
+	brokers.WithLabelValues("broker1").Add(3)
+	// An origin label of the source is replaced, not duplicated.
+	relabeled := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "cubrid_relabeled", Help: "Source."}, []string{"origin", "zone"})
+	relabeled.WithLabelValues("rule", "a").Set(2)
+	other := prometheus.NewGauge(prometheus.GaugeOpts{Name: "process_open_fds", Help: "Source."})
+	other.Set(7)
+	reg.MustRegister(up, brokers, relabeled, other)
+
+	expected := `
+# HELP cubrid_broker_requests_total Source.
+# TYPE cubrid_broker_requests_total counter
+cubrid_broker_requests_total{broker_name="broker1"} 3
+# HELP cubrid_relabeled Source.
+# TYPE cubrid_relabeled gauge
+cubrid_relabeled{origin="rule",zone="a"} 2
+# HELP cubrid_up Source.
+# TYPE cubrid_up gauge
+cubrid_up 1
+# HELP mysql_broker_requests_total Source.
+# TYPE mysql_broker_requests_total counter
+mysql_broker_requests_total{broker_name="broker1",origin="compat-namespace"} 3
+# HELP mysql_relabeled Source.
+# TYPE mysql_relabeled gauge
+mysql_relabeled{origin="compat-namespace",zone="a"} 2
+# HELP mysql_up Source.
+# TYPE mysql_up gauge
+mysql_up{origin="compat-namespace"} 1
+# HELP process_open_fds Source.
+# TYPE process_open_fds gauge
+process_open_fds 7
+`
+	if err := testutil.GatherAndCompare(n.Wrap(reg), strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+	// The originals are left untouched for the next gather.
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP cubrid_relabeled Source.
+# TYPE cubrid_relabeled gauge
+cubrid_relabeled{origin="rule",zone="a"} 2
+`), "cubrid_relabeled"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestExtraNamespaceGatherError(t *testing.T) {
+	n, err := NewExtraNamespace("mysql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	errGather := errors.New("gather failed")
+	g := prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		up := prometheus.NewGauge(prometheus.GaugeOpts{Name: "cubrid_up", Help: "Source."})
+		reg := prometheus.NewRegistry()
+		reg.MustRegister(up)
+		mfs, _ := reg.Gather()
+		return mfs, errGather
+	})
+	mfs, err := n.Wrap(g).Gather()
+	if err != errGather {
+		t.Errorf("error = %v, want %v", err, errGather)
+	}
+	// The partial result is still duplicated.
+	var names []string
+	for _, mf := range mfs {
+		names = append(names, mf.GetName())
+	}
+	if got, want := strings.Join(names, ","), "cubrid_up,mysql_up"; got != want {
+		t.Errorf("families = %s, want %s", got, want)
+	}
+}
+
+func TestNewExtraNamespace(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		invalid bool
+	}{
+		{name: "mysql"},
+		{name: "_compat1"},
+		{name: "1mysql", invalid: true},
+		{name: "my-sql", invalid: true},
+		{name: namespace, invalid: true},
+	} {
+		n, err := NewExtraNamespace(tc.name)
+		if tc.invalid != (err != nil) {
+			t.Errorf("%q: error = %v, want invalid %v", tc.name, err, tc.invalid)
+		}
+		if err == nil && n == nil {
+			t.Errorf("%q: nil extra namespace", tc.name)
+		}
+	}
+
+	// An empty name disables the duplication.
+	n, err := NewExtraNamespace("")
+	if err != nil || n != nil {
+		t.Fatalf("NewExtraNamespace(\"\") = %v, %v, want nil, nil", n, err)
+	}
+	reg := prometheus.NewRegistry()
+	if g := n.Wrap(reg); g != prometheus.Gatherer(reg) {
+		t.Errorf("Wrap of a nil extra namespace = %v, want the registry", g)
+	}
+}
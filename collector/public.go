@@ -0,0 +1,129 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Restricted view of the collected metrics for unauthenticated consumers.
+
+package collector
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// PublicMetricsConfig is the public_metrics section of the config file.
+type PublicMetricsConfig struct {
+	// Families are regular expressions of the family names to publish,
+	// anchored at both ends.
+	Families []string `yaml:"families"`
+	// LabelValues restricts samples carrying one of the labels to the
+	// listed values, e.g. to the database of an application team.
+	LabelValues map[string][]string `yaml:"label_values"`
+}
+
+// PublicView serves an allow-listed subset of the latest metrics gathered
+// through Wrap, so publishing them causes no additional database load.
+type PublicView struct {
+	families    []*regexp.Regexp
+	labelValues map[string]map[string]bool
+
+	mu     sync.Mutex
+	latest []*dto.MetricFamily
+}
+
+// NewPublicView validates the configuration. Without families it returns
+// nil, which publishes nothing.
+func NewPublicView(cfg PublicMetricsConfig) (*PublicView, error) {
+	if len(cfg.Families) == 0 {
+		return nil, nil
+	}
+	v := &PublicView{labelValues: map[string]map[string]bool{}}
+	for _, family := range cfg.Families {
+		re, err := regexp.Compile("^(?:" + family + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid family pattern %q: %s", family, err)
+		}
+		v.families = append(v.families, re)
+	}
+	for label, values := range cfg.LabelValues {
+		if !labelNameRE.MatchString(label) {
+			return nil, fmt.Errorf("invalid label name %q", label)
+		}
+		v.labelValues[label] = map[string]bool{}
+		for _, value := range values {
+			v.labelValues[label][value] = true
+		}
+	}
+	return v, nil
+}
+
+// Wrap returns a Gatherer remembering everything g gathers for the public
+// view. A nil PublicView returns g unchanged.
+func (v *PublicView) Wrap(g prometheus.Gatherer) prometheus.Gatherer {
+	if v == nil {
+		return g
+	}
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		mfs, err := g.Gather()
+		v.mu.Lock()
+		v.latest = mfs
+		v.mu.Unlock()
+		return mfs, err
+	})
+}
+
+// Gather implements prometheus.Gatherer. It returns the allowed families and
+// samples of the latest gather, nothing before the first one.
+func (v *PublicView) Gather() ([]*dto.MetricFamily, error) {
+	v.mu.Lock()
+	latest := v.latest
+	v.mu.Unlock()
+
+	var result []*dto.MetricFamily
+	for _, mf := range latest {
+		if !v.allowsFamily(mf.GetName()) {
+			continue
+		}
+		filtered := &dto.MetricFamily{Name: mf.Name, Help: mf.Help, Type: mf.Type}
+		for _, m := range mf.Metric {
+			if v.allowsSample(m) {
+				filtered.Metric = append(filtered.Metric, m)
+			}
+		}
+		if len(filtered.Metric) > 0 {
+			result = append(result, filtered)
+		}
+	}
+	return result, nil
+}
+
+func (v *PublicView) allowsFamily(name string) bool {
+	for _, re := range v.families {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *PublicView) allowsSample(m *dto.Metric) bool {
+	for _, lp := range m.Label {
+		if values, ok := v.labelValues[lp.GetName()]; ok && !values[lp.GetValue()] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,230 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Configuration recommendations derived from the observed scrapes.
+
+package collector
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// recentScrapesSize bounds the scrapes the recommendations are based on.
+const recentScrapesSize = 100
+
+// scrapeObservation is what a scrape of the database recorded for the
+// recommendations.
+type scrapeObservation struct {
+	// budget is the time the scrape had until its deadline, 0 without one.
+	budget   time.Duration
+	duration time.Duration
+	// collectors holds the durations of the collectors that did not serve
+	// from cache.
+	collectors map[string]time.Duration
+}
+
+// recentScrapes is the ring of the latest observations. It is shared between
+// scrapes, as the Exporter is created per request.
+var recentScrapes = struct {
+	sync.Mutex
+	ring []scrapeObservation
+	next int
+}{}
+
+func recordScrape(obs scrapeObservation) {
+	recentScrapes.Lock()
+	defer recentScrapes.Unlock()
+	if len(recentScrapes.ring) < recentScrapesSize {
+		recentScrapes.ring = append(recentScrapes.ring, obs)
+		return
+	}
+	recentScrapes.ring[recentScrapes.next] = obs
+	recentScrapes.next = (recentScrapes.next + 1) % recentScrapesSize
+}
+
+// Recommendation is a configuration change suggested by the observed scrapes.
+type Recommendation struct {
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	// Setting is the flag or config key to change.
+	Setting string `json:"setting"`
+	Message string `json:"message"`
+	// Evidence holds the observed numbers the recommendation is based on.
+	Evidence map[string]float64 `json:"evidence"`
+}
+
+// Severities of recommendations.
+const (
+	severityInfo    = "info"
+	severityWarning = "warning"
+)
+
+// observations are the inputs of the recommendation rules.
+type observations struct {
+	scrapes []scrapeObservation
+	// autoDisables counts how often each collector was auto-disabled.
+	autoDisables map[string]int
+}
+
+// recommendationRule derives recommendations from observations. A rule must
+// not fire unless its thresholds are met by at least minSamples samples.
+type recommendationRule struct {
+	name       string
+	severity   string
+	minSamples int
+	// threshold and share are the evidence thresholds; their meaning depends
+	// on the rule.
+	threshold float64
+	share     float64
+	evaluate  func(rule recommendationRule, obs observations) []Recommendation
+}
+
+// recommendationRules is the rule set of the recommendations.
+var recommendationRules = []recommendationRule{
+	{
+		// threshold is the share of the scrape budget the p95 duration of a
+		// collector must reach.
+		name:       "slow_collector",
+		severity:   severityWarning,
+		minSamples: 10,
+		threshold:  0.8,
+		evaluate:   evaluateSlowCollectors,
+	},
+	{
+		// threshold is the number of times a collector was auto-disabled.
+		name:      "repeated_auto_disable",
+		severity:  severityWarning,
+		threshold: 3,
+		evaluate:  evaluateAutoDisables,
+	},
+	{
+		// threshold is the headroom in seconds below which a scrape is short,
+		// share the share of short scrapes the rule fires at.
+		name:       "low_headroom",
+		severity:   severityInfo,
+		minSamples: 10,
+		threshold:  1,
+		share:      0.3,
+		evaluate:   evaluateHeadroom,
+	},
+}
+
+func (rule recommendationRule) recommend(setting, message string, evidence map[string]float64) Recommendation {
+	return Recommendation{Rule: rule.name, Severity: rule.severity, Setting: setting, Message: message, Evidence: evidence}
+}
+
+// percentile returns the p-quantile of durations using the nearest rank.
+func percentile(durations []time.Duration, p float64) time.Duration {
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
+
+func evaluateSlowCollectors(rule recommendationRule, obs observations) []Recommendation {
+	durations := map[string][]time.Duration{}
+	var budgets []time.Duration
+	for _, scrape := range obs.scrapes {
+		if scrape.budget <= 0 {
+			continue
+		}
+		budgets = append(budgets, scrape.budget)
+		for collector, d := range scrape.collectors {
+			durations[collector] = append(durations[collector], d)
+		}
+	}
+	if len(budgets) == 0 {
+		return nil
+	}
+	budget := percentile(budgets, 0.5)
+
+	var recommendations []Recommendation
+	for collector, ds := range durations {
+		if len(ds) < rule.minSamples {
+			continue
+		}
+		p95 := percentile(ds, 0.95)
+		if p95.Seconds() < rule.threshold*budget.Seconds() {
+			continue
+		}
+		recommendations = append(recommendations, rule.recommend(
+			"--exporter.cache-ttl, profiles.<profile>.collector_cache_ttls."+collector+" or the scrape_timeout of the Prometheus job",
+			fmt.Sprintf("%s p95 duration is %.1fs against a %.1fs scrape budget: raise the scrape timeout or cache the collector", collector, p95.Seconds(), budget.Seconds()),
+			map[string]float64{"p95_seconds": p95.Seconds(), "budget_seconds": budget.Seconds(), "samples": float64(len(ds))},
+		))
+	}
+	return recommendations
+}
+
+func evaluateAutoDisables(rule recommendationRule, obs observations) []Recommendation {
+	var recommendations []Recommendation
+	for collector, n := range obs.autoDisables {
+		if float64(n) < rule.threshold {
+			continue
+		}
+		recommendations = append(recommendations, rule.recommend(
+			"--collect."+collector,
+			fmt.Sprintf("%s has been auto-disabled %d times: resolve the errors listed by /-/errors or disable the collector", collector, n),
+			map[string]float64{"auto_disables": float64(n)},
+		))
+	}
+	return recommendations
+}
+
+func evaluateHeadroom(rule recommendationRule, obs observations) []Recommendation {
+	scrapes, short := 0, 0
+	for _, scrape := range obs.scrapes {
+		if scrape.budget <= 0 {
+			continue
+		}
+		scrapes++
+		if (scrape.budget - scrape.duration).Seconds() < rule.threshold {
+			short++
+		}
+	}
+	if scrapes < rule.minSamples || float64(short) < rule.share*float64(scrapes) {
+		return nil
+	}
+	return []Recommendation{rule.recommend(
+		"--timeout-offset or the scrape_timeout of the Prometheus job",
+		fmt.Sprintf("scrape headroom below %.0fs on %.0f%% of scrapes: raise the scrape timeout or disable slow collectors", rule.threshold, 100*float64(short)/float64(scrapes)),
+		map[string]float64{"short_scrapes": float64(short), "scrapes": float64(scrapes)},
+	)}
+}
+
+// evaluateRecommendations applies rules to obs, ordered by rule and message.
+func evaluateRecommendations(rules []recommendationRule, obs observations) []Recommendation {
+	recommendations := []Recommendation{}
+	for _, rule := range rules {
+		found := rule.evaluate(rule, obs)
+		sort.Slice(found, func(i, j int) bool { return found[i].Message < found[j].Message })
+		recommendations = append(recommendations, found...)
+	}
+	return recommendations
+}
+
+// Recommendations returns the configuration changes suggested by the recent
+// scrapes of the database.
+func Recommendations() []Recommendation {
+	recentScrapes.Lock()
+	scrapes := append([]scrapeObservation(nil), recentScrapes.ring...)
+	recentScrapes.Unlock()
+	return evaluateRecommendations(recommendationRules, observations{scrapes: scrapes, autoDisables: autoDisableCounts()})
+}
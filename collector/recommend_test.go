@@ -0,0 +1,161 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"testing"
+	"time"
+)
+
+// scrapesWithBudget returns n scrape observations with a budget of 10s,
+// taking duration and spending collectorDuration in the collector "slow".
+func scrapesWithBudget(n int, duration, collectorDuration time.Duration) []scrapeObservation {
+	scrapes := make([]scrapeObservation, n)
+	for i := range scrapes {
+		scrapes[i] = scrapeObservation{
+			budget:     10 * time.Second,
+			duration:   duration,
+			collectors: map[string]time.Duration{"slow": collectorDuration},
+		}
+	}
+	return scrapes
+}
+
+// ruleNamed returns the rule of the rule set called name.
+func ruleNamed(t *testing.T, name string) recommendationRule {
+	for _, rule := range recommendationRules {
+		if rule.name == name {
+			return rule
+		}
+	}
+	t.Fatalf("no recommendation rule %q", name)
+	return recommendationRule{}
+}
+
+func TestRecommendationRules(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		rule string
+		obs  observations
+		want int
+	}{
+		{
+			name: "slow collector",
+			rule: "slow_collector",
+			obs:  observations{scrapes: scrapesWithBudget(10, 9*time.Second, 9*time.Second)},
+			want: 1,
+		},
+		{
+			name: "slow collector with too few samples",
+			rule: "slow_collector",
+			obs:  observations{scrapes: scrapesWithBudget(9, 9*time.Second, 9*time.Second)},
+		},
+		{
+			name: "collector below the budget share",
+			rule: "slow_collector",
+			obs:  observations{scrapes: scrapesWithBudget(20, 7*time.Second, 7*time.Second)},
+		},
+		{
+			name: "slow collector without a scrape budget",
+			rule: "slow_collector",
+			obs: observations{scrapes: []scrapeObservation{
+				{duration: 9 * time.Second, collectors: map[string]time.Duration{"slow": 9 * time.Second}},
+			}},
+		},
+		{
+			name: "repeated auto-disables",
+			rule: "repeated_auto_disable",
+			obs:  observations{autoDisables: map[string]int{"broker_status": 3, "spacedb": 2}},
+			want: 1,
+		},
+		{
+			name: "few auto-disables",
+			rule: "repeated_auto_disable",
+			obs:  observations{autoDisables: map[string]int{"spacedb": 2}},
+		},
+		{
+			name: "low headroom",
+			rule: "low_headroom",
+			obs: observations{scrapes: append(scrapesWithBudget(3, 9500*time.Millisecond, 0),
+				scrapesWithBudget(7, time.Second, 0)...)},
+			want: 1,
+		},
+		{
+			name: "low headroom on few scrapes",
+			rule: "low_headroom",
+			obs: observations{scrapes: append(scrapesWithBudget(2, 9500*time.Millisecond, 0),
+				scrapesWithBudget(8, time.Second, 0)...)},
+		},
+		{
+			name: "low headroom with too few samples",
+			rule: "low_headroom",
+			obs:  observations{scrapes: scrapesWithBudget(9, 9500*time.Millisecond, 0)},
+		},
+		{
+			name: "no observations",
+			rule: "low_headroom",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rule := ruleNamed(t, tc.rule)
+			got := evaluateRecommendations([]recommendationRule{rule}, tc.obs)
+			if len(got) != tc.want {
+				t.Fatalf("got %d recommendations, want %d: %+v", len(got), tc.want, got)
+			}
+			for _, r := range got {
+				if r.Rule != rule.name || r.Severity != rule.severity || r.Setting == "" || len(r.Evidence) == 0 {
+					t.Errorf("incomplete recommendation %+v", r)
+				}
+			}
+		})
+	}
+}
+
+// TestRecommendationEvidence checks the evidence of a slow collector.
+func TestRecommendationEvidence(t *testing.T) {
+	obs := observations{scrapes: scrapesWithBudget(20, 9*time.Second, 9*time.Second)}
+	got := evaluateRecommendations([]recommendationRule{ruleNamed(t, "slow_collector")}, obs)
+	if len(got) != 1 {
+		t.Fatalf("got %d recommendations, want 1", len(got))
+	}
+	for key, want := range map[string]float64{"p95_seconds": 9, "budget_seconds": 10, "samples": 20} {
+		if got := got[0].Evidence[key]; got != want {
+			t.Errorf("evidence %s = %v, want %v", key, got, want)
+		}
+	}
+}
+
+func TestRecordScrapeRing(t *testing.T) {
+	recentScrapes.Lock()
+	saved := recentScrapes.ring
+	recentScrapes.ring, recentScrapes.next = nil, 0
+	recentScrapes.Unlock()
+	t.Cleanup(func() {
+		recentScrapes.Lock()
+		recentScrapes.ring, recentScrapes.next = saved, 0
+		recentScrapes.Unlock()
+	})
+
+	for i := 0; i < recentScrapesSize+5; i++ {
+		recordScrape(scrapeObservation{duration: time.Duration(i)})
+	}
+	recentScrapes.Lock()
+	defer recentScrapes.Unlock()
+	if len(recentScrapes.ring) != recentScrapesSize {
+		t.Fatalf("ring holds %d scrapes, want %d", len(recentScrapes.ring), recentScrapesSize)
+	}
+	if got := recentScrapes.ring[0].duration; got != recentScrapesSize {
+		t.Errorf("oldest slot holds scrape %d, want %d", got, recentScrapesSize)
+	}
+}
@@ -0,0 +1,205 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Relabeling and dropping of gathered metrics.
+
+package collector
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Relabel actions.
+const (
+	RelabelDrop    = "drop"
+	RelabelKeep    = "keep"
+	RelabelReplace = "replace"
+)
+
+// RelabelRule matches samples by family name and label values and drops,
+// keeps or rewrites them.
+type RelabelRule struct {
+	// Family is a regular expression matched against the whole family name.
+	Family string `yaml:"family"`
+	// Labels maps label names to regular expressions their values must match.
+	Labels map[string]string `yaml:"labels"`
+	// Action is one of drop, keep or replace.
+	Action string `yaml:"action"`
+	// TargetLabel is the label whose value replace rewrites.
+	TargetLabel string `yaml:"target_label"`
+	// Replacement is the new value; if the target label has a matcher,
+	// its capture groups can be referenced as $1, $2, ...
+	Replacement string `yaml:"replacement"`
+
+	familyRE *regexp.Regexp
+	labelREs map[string]*regexp.Regexp
+}
+
+// compile validates the rule and compiles its regular expressions.
+func (r *RelabelRule) compile() error {
+	switch r.Action {
+	case RelabelDrop, RelabelKeep:
+	case RelabelReplace:
+		if r.TargetLabel == "" {
+			return fmt.Errorf("replace rule requires target_label")
+		}
+	default:
+		return fmt.Errorf("unknown relabel action %q", r.Action)
+	}
+
+	family := r.Family
+	if family == "" {
+		family = ".*"
+	}
+	var err error
+	if r.familyRE, err = regexp.Compile("^(?:" + family + ")$"); err != nil {
+		return fmt.Errorf("invalid family regex %q: %s", r.Family, err)
+	}
+	r.labelREs = make(map[string]*regexp.Regexp, len(r.Labels))
+	for name, expr := range r.Labels {
+		if r.labelREs[name], err = regexp.Compile("^(?:" + expr + ")$"); err != nil {
+			return fmt.Errorf("invalid regex %q for label %s: %s", expr, name, err)
+		}
+	}
+	return nil
+}
+
+func (r *RelabelRule) matches(family string, m *dto.Metric) bool {
+	if !r.familyRE.MatchString(family) {
+		return false
+	}
+	for name, re := range r.labelREs {
+		if !re.MatchString(labelValue(m, name)) {
+			return false
+		}
+	}
+	return true
+}
+
+// apply rewrites a matched sample and reports whether it is kept.
+func (r *RelabelRule) apply(m *dto.Metric) bool {
+	switch r.Action {
+	case RelabelDrop:
+		return false
+	case RelabelReplace:
+		for _, lp := range m.Label {
+			if lp.GetName() != r.TargetLabel {
+				continue
+			}
+			value := r.Replacement
+			if re, ok := r.labelREs[r.TargetLabel]; ok {
+				value = re.ReplaceAllString(lp.GetValue(), r.Replacement)
+			}
+			lp.Value = &value
+		}
+	}
+	return true
+}
+
+func labelValue(m *dto.Metric, name string) string {
+	for _, lp := range m.Label {
+		if lp.GetName() == name {
+			return lp.GetValue()
+		}
+	}
+	return ""
+}
+
+// Relabeler applies ordered relabel rules to gathered metrics, so every
+// output path sees the same result. It implements prometheus.Collector
+// for its rule evaluation metrics.
+type Relabeler struct {
+	rules   []RelabelRule
+	matches *prometheus.CounterVec
+}
+
+// NewRelabeler validates the rules and returns a Relabeler applying them in order.
+func NewRelabeler(rules []RelabelRule) (*Relabeler, error) {
+	r := &Relabeler{
+		rules: make([]RelabelRule, len(rules)),
+		matches: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: exporter,
+			Name:      "relabel_rule_matches_total",
+			Help:      "Total number of samples matched by each relabel rule.",
+		}, []string{"rule", "action"}),
+	}
+	copy(r.rules, rules)
+	for i := range r.rules {
+		if err := r.rules[i].compile(); err != nil {
+			return nil, fmt.Errorf("relabel rule %d: %s", i, err)
+		}
+	}
+	return r, nil
+}
+
+// Describe implements prometheus.Collector.
+func (r *Relabeler) Describe(ch chan<- *prometheus.Desc) {
+	r.matches.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (r *Relabeler) Collect(ch chan<- prometheus.Metric) {
+	r.matches.Collect(ch)
+}
+
+// Wrap returns a Gatherer applying the rules to everything g gathers.
+// Without rules g is returned unchanged.
+func (r *Relabeler) Wrap(g prometheus.Gatherer) prometheus.Gatherer {
+	if r == nil || len(r.rules) == 0 {
+		return g
+	}
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		mfs, err := g.Gather()
+		return r.relabel(mfs), err
+	})
+}
+
+func (r *Relabeler) relabel(mfs []*dto.MetricFamily) []*dto.MetricFamily {
+	result := mfs[:0]
+	for _, mf := range mfs {
+		metrics := mf.Metric[:0]
+		for _, m := range mf.Metric {
+			if r.keep(mf.GetName(), m) {
+				metrics = append(metrics, m)
+			}
+		}
+		mf.Metric = metrics
+		if len(mf.Metric) > 0 {
+			result = append(result, mf)
+		}
+	}
+	return result
+}
+
+func (r *Relabeler) keep(family string, m *dto.Metric) bool {
+	for i := range r.rules {
+		rule := &r.rules[i]
+		if !rule.matches(family, m) {
+			if rule.Action == RelabelKeep {
+				return false
+			}
+			continue
+		}
+		r.matches.WithLabelValues(strconv.Itoa(i), rule.Action).Inc()
+		if !rule.apply(m) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,147 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// relabelTestRegistry returns a registry with broker and statdump samples.
+func relabelTestRegistry() *prometheus.Registry {
+	brokers := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "cubrid_broker_status_num_as", Help: "Brokers."}, []string{"broker_name"})
+	brokers.WithLabelValues("broker1").Set(5)
+	brokers.WithLabelValues("query_editor").Set(3)
+	info := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "cubrid_statdump_info", Help: "Statdump."}, []string{"database", "key"})
+	info.WithLabelValues("demodb", "Num_tran_rollbacks").Set(1)
+	info.WithLabelValues("demodb", "Num_unheard_of").Set(11)
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(brokers, info)
+	return reg
+}
+
+const (
+	relabelTestBrokers = `# HELP cubrid_broker_status_num_as Brokers.
+# TYPE cubrid_broker_status_num_as gauge
+`
+	relabelTestInfo = `# HELP cubrid_statdump_info Statdump.
+# TYPE cubrid_statdump_info gauge
+`
+)
+
+func TestRelabeler(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		rules    []RelabelRule
+		expected string
+		matches  float64
+	}{
+		{
+			name:  "drop",
+			rules: []RelabelRule{{Family: "cubrid_statdump_info", Labels: map[string]string{"key": "Num_unheard.*"}, Action: RelabelDrop}},
+			expected: relabelTestBrokers + `cubrid_broker_status_num_as{broker_name="broker1"} 5
+cubrid_broker_status_num_as{broker_name="query_editor"} 3
+` + relabelTestInfo + `cubrid_statdump_info{database="demodb",key="Num_tran_rollbacks"} 1
+`,
+			matches: 1,
+		},
+		{
+			// Keep drops every sample it does not match, of any family.
+			name:  "keep",
+			rules: []RelabelRule{{Family: "cubrid_broker_.*", Labels: map[string]string{"broker_name": "broker[0-9]+"}, Action: RelabelKeep}},
+			expected: relabelTestBrokers + `cubrid_broker_status_num_as{broker_name="broker1"} 5
+`,
+			matches: 1,
+		},
+		{
+			name: "replace",
+			rules: []RelabelRule{
+				{Labels: map[string]string{"broker_name": "broker([0-9]+)"}, Action: RelabelReplace, TargetLabel: "broker_name", Replacement: "b$1"},
+				// Without a matcher of the target label, the value is replaced as is.
+				{Family: "cubrid_statdump_info", Action: RelabelReplace, TargetLabel: "database", Replacement: "db"},
+			},
+			expected: relabelTestBrokers + `cubrid_broker_status_num_as{broker_name="b1"} 5
+cubrid_broker_status_num_as{broker_name="query_editor"} 3
+` + relabelTestInfo + `cubrid_statdump_info{database="db",key="Num_tran_rollbacks"} 1
+cubrid_statdump_info{database="db",key="Num_unheard_of"} 11
+`,
+			matches: 3,
+		},
+		{
+			// Rules apply in order, later ones seeing the rewritten values.
+			name: "ordered",
+			rules: []RelabelRule{
+				{Family: "cubrid_broker_status_num_as", Action: RelabelReplace, TargetLabel: "broker_name", Replacement: "renamed"},
+				{Labels: map[string]string{"broker_name": "renamed"}, Action: RelabelDrop},
+			},
+			expected: relabelTestInfo + `cubrid_statdump_info{database="demodb",key="Num_tran_rollbacks"} 1
+cubrid_statdump_info{database="demodb",key="Num_unheard_of"} 11
+`,
+			matches: 4,
+		},
+	} {
+		r, err := NewRelabeler(tc.rules)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.name, err)
+		}
+		if err := testutil.GatherAndCompare(r.Wrap(relabelTestRegistry()), strings.NewReader(tc.expected)); err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+		}
+		matches := 0.0
+		for i, rule := range tc.rules {
+			matches += testutil.ToFloat64(r.matches.WithLabelValues(strconv.Itoa(i), rule.Action))
+		}
+		if matches != tc.matches {
+			t.Errorf("%s: %v rule matches, want %v", tc.name, matches, tc.matches)
+		}
+	}
+}
+
+func TestRelabelerWithoutRules(t *testing.T) {
+	r, err := NewRelabeler(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := relabelTestRegistry()
+	if g := r.Wrap(reg); g != prometheus.Gatherer(reg) {
+		t.Error("expected the gatherer unchanged without rules")
+	}
+}
+
+// TestNewRelabelerInvalid checks that invalid rules are rejected when the
+// configuration is loaded rather than when samples are relabeled.
+func TestNewRelabelerInvalid(t *testing.T) {
+	for _, tc := range []struct {
+		rule RelabelRule
+		err  string
+	}{
+		{RelabelRule{Family: "cubrid_(", Action: RelabelDrop}, "relabel rule 1: invalid family regex"},
+		{RelabelRule{Labels: map[string]string{"broker_name": "[a-"}, Action: RelabelKeep}, "relabel rule 1: invalid regex \"[a-\" for label broker_name"},
+		{RelabelRule{Family: "cubrid_.*", Action: "rename"}, "relabel rule 1: unknown relabel action \"rename\""},
+		{RelabelRule{Family: "cubrid_.*", Action: RelabelReplace, Replacement: "x"}, "relabel rule 1: replace rule requires target_label"},
+	} {
+		rules := []RelabelRule{{Family: "cubrid_.*", Action: RelabelKeep}, tc.rule}
+		r, err := NewRelabeler(rules)
+		if err == nil || !strings.HasPrefix(err.Error(), tc.err) {
+			t.Errorf("NewRelabeler(%+v) = %v, want an error starting with %q", tc.rule, err, tc.err)
+		}
+		if r != nil {
+			t.Errorf("NewRelabeler(%+v) returned a relabeler along with the error", tc.rule)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Scrape CUBRID HA replication apply data.
+
+package collector
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	replicationApply = "replication_apply"
+
+	replicationApplyQuery = `SELECT db_name, copied_log_path, fail_counter FROM db_ha_apply_info`
+)
+
+// Metric descriptors.
+var (
+	ReplicationApplyErrors = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "replication", "apply_errors_total"),
+		"Total number of log records the standby failed to apply.",
+		[]string{"database", "peer"}, nil,
+	)
+)
+
+// ScrapeReplicationApply collects replication apply failures from db_ha_apply_info.
+type ScrapeReplicationApply struct{}
+
+// Name of the Scraper. Should be unique.
+func (ScrapeReplicationApply) Name() string {
+	return replicationApply
+}
+
+// Help describes the role of the Scraper.
+func (ScrapeReplicationApply) Help() string {
+	return "Scrape replication apply errors from db_ha_apply_info"
+}
+
+// Version of CUBRID from which scraper is available.
+func (ScrapeReplicationApply) Version() float64 {
+	return 10.2
+}
+
+// Scrape collects data from database connection and sends it over channel as prometheus metric.
+func (ScrapeReplicationApply) Scrape(ctx context.Context, db *sql.DB, ch chan<- prometheus.Metric) error {
+
+	applyRows, err := db.QueryContext(ctx, replicationApplyQuery)
+	if err != nil {
+		return err
+	}
+
+	defer applyRows.Close()
+
+	var db_name string
+	var copied_log_path string
+	var fail_counter float64
+
+	for applyRows.Next() {
+
+		err := applyRows.Scan(&db_name, &copied_log_path, &fail_counter)
+		if err != nil {
+			return err
+		}
+
+		ch <- prometheus.MustNewConstMetric(ReplicationApplyErrors, prometheus.CounterValue, fail_counter, db_name, replicationPeer(copied_log_path))
+	}
+
+	return applyRows.Err()
+}
+
+// replicationPeer derives the peer label from the copied log directory,
+// which applylogdb names <database>_<peer host>.
+func replicationPeer(copiedLogPath string) string {
+	return filepath.Base(filepath.Clean(copiedLogPath))
+}
+
+// check interface
+var _ Scraper = ScrapeReplicationApply{}
@@ -0,0 +1,183 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Asynchronous resolution of the broker host name.
+
+package collector
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	dnsRefreshInterval = kingpin.Flag(
+		"cubrid.dns-refresh-interval",
+		"How often the broker host name is resolved in the background.",
+	).Default("30s").Duration()
+	dnsInlineTimeout = kingpin.Flag(
+		"cubrid.dns-inline-timeout",
+		"Timeout for resolving a host name that was never resolved before within a scrape.",
+	).Default("2s").Duration()
+)
+
+// Metric descriptors.
+var (
+	dnsResolutionDurationDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, exporter, "dns_resolution_duration_seconds"),
+		"Duration of the last resolution of the host name.",
+		[]string{"host"}, nil,
+	)
+	dnsResolutionFailuresDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, exporter, "dns_resolution_failures_total"),
+		"Total number of failed resolutions of the host name.",
+		[]string{"host"}, nil,
+	)
+	dnsCacheAgeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, exporter, "dns_cache_age_seconds"),
+		"Time since the cached address of the host name was last resolved.",
+		[]string{"host"}, nil,
+	)
+)
+
+// hostResolver is the subset of *net.Resolver used, so it can be replaced.
+type hostResolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+type cachedHost struct {
+	addr     string
+	resolved time.Time
+	duration float64
+	failures float64
+}
+
+// ResolverCache resolves host names in the background and keeps the last
+// successful address, so connecting never waits for a slow DNS server once
+// a host name was resolved. The resolver does not expose record TTLs, so
+// entries are refreshed every --cubrid.dns-refresh-interval.
+// It implements prometheus.Collector for its resolution metrics.
+type ResolverCache struct {
+	resolver hostResolver
+
+	mu    sync.Mutex
+	hosts map[string]*cachedHost
+}
+
+// DNSCache is the resolver cache used for all connections.
+var DNSCache = NewResolverCache(net.DefaultResolver)
+
+// NewResolverCache returns an empty cache resolving through resolver.
+func NewResolverCache(resolver hostResolver) *ResolverCache {
+	return &ResolverCache{resolver: resolver, hosts: map[string]*cachedHost{}}
+}
+
+// Address returns the cached address of host. Only a host name that was never
+// resolved is resolved inline, bounded by --cubrid.dns-inline-timeout.
+// IP addresses are returned as is.
+func (c *ResolverCache) Address(ctx context.Context, host string) (string, error) {
+	if host == "" || net.ParseIP(host) != nil {
+		return host, nil
+	}
+
+	c.mu.Lock()
+	entry, ok := c.hosts[host]
+	if !ok {
+		entry = &cachedHost{}
+		c.hosts[host] = entry
+		go c.refreshLoop(host)
+	}
+	addr := entry.addr
+	c.mu.Unlock()
+	if addr != "" {
+		return addr, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, *dnsInlineTimeout)
+	defer cancel()
+	return c.resolve(ctx, host)
+}
+
+func (c *ResolverCache) refreshLoop(host string) {
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), *dnsRefreshInterval)
+		if _, err := c.resolve(ctx, host); err != nil {
+			log.Warnf("Resolving %s failed, keeping the cached address: %s", host, err)
+		}
+		cancel()
+		time.Sleep(*dnsRefreshInterval)
+	}
+}
+
+func (c *ResolverCache) resolve(ctx context.Context, host string) (string, error) {
+	start := time.Now()
+	addrs, err := c.resolver.LookupHost(ctx, host)
+	if err == nil && len(addrs) == 0 {
+		err = fmt.Errorf("no addresses for %s", host)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := c.hosts[host]
+	entry.duration = time.Since(start).Seconds()
+	if err != nil {
+		entry.failures++
+		return entry.addr, err
+	}
+	entry.addr = addrs[0]
+	entry.resolved = time.Now()
+	return entry.addr, nil
+}
+
+// Describe implements prometheus.Collector.
+func (c *ResolverCache) Describe(ch chan<- *prometheus.Desc) {
+	ch <- dnsResolutionDurationDesc
+	ch <- dnsResolutionFailuresDesc
+	ch <- dnsCacheAgeDesc
+}
+
+// Collect implements prometheus.Collector.
+func (c *ResolverCache) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for host, entry := range c.hosts {
+		ch <- prometheus.MustNewConstMetric(dnsResolutionDurationDesc, prometheus.GaugeValue, entry.duration, host)
+		ch <- prometheus.MustNewConstMetric(dnsResolutionFailuresDesc, prometheus.CounterValue, entry.failures, host)
+		if !entry.resolved.IsZero() {
+			ch <- prometheus.MustNewConstMetric(dnsCacheAgeDesc, prometheus.GaugeValue, time.Since(entry.resolved).Seconds(), host)
+		}
+	}
+}
+
+// resolveDSN replaces the host of a cci:cubrid:host:port:db:user:password: DSN
+// with its cached address.
+func resolveDSN(ctx context.Context, dsn string) (string, error) {
+	fields := strings.SplitN(dsn, ":", 4)
+	if len(fields) < 4 {
+		return dsn, nil
+	}
+	addr, err := DNSCache.Address(ctx, fields[2])
+	if err != nil {
+		return "", err
+	}
+	fields[2] = addr
+	return strings.Join(fields, ":"), nil
+}
@@ -0,0 +1,186 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var errTestLookup = errors.New("no such host")
+
+// scriptedResolver resolves every host to addrs, or fails with err.
+type scriptedResolver struct {
+	mu    sync.Mutex
+	addrs []string
+	err   error
+	// block makes lookups wait for their context.
+	block bool
+}
+
+func (r *scriptedResolver) set(err error, addrs ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.addrs, r.err = addrs, err
+}
+
+func (r *scriptedResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	r.mu.Lock()
+	addrs, err, block := r.addrs, r.err, r.block
+	r.mu.Unlock()
+	if block {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return addrs, err
+}
+
+// resolutionFailures returns the failed resolutions of host counted by c.
+func resolutionFailures(t *testing.T, c *ResolverCache, host string) float64 {
+	ch := make(chan prometheus.Metric, 16)
+	c.Collect(ch)
+	close(ch)
+	for metric := range ch {
+		var m dto.Metric
+		if err := metric.Write(&m); err != nil {
+			t.Fatal(err)
+		}
+		if metric.Desc() == dnsResolutionFailuresDesc && labelValue(&m, "host") == host {
+			return m.Counter.GetValue()
+		}
+	}
+	return 0
+}
+
+func TestResolverCacheAddresses(t *testing.T) {
+	r := &scriptedResolver{}
+	r.set(errTestLookup)
+	c := NewResolverCache(r)
+
+	// IP addresses and empty hosts are never resolved.
+	for _, host := range []string{"", "192.0.2.1", "2001:db8::1"} {
+		if addr, err := c.Address(context.Background(), host); addr != host || err != nil {
+			t.Errorf("Address(%q) = %q, %v", host, addr, err)
+		}
+	}
+	if len(c.hosts) != 0 {
+		t.Errorf("cached %d hosts, want none", len(c.hosts))
+	}
+}
+
+func TestResolverCacheFailure(t *testing.T) {
+	r := &scriptedResolver{}
+	r.set(errTestLookup)
+	c := NewResolverCache(r)
+
+	// Without a cached address, the failure is returned and counted.
+	if addr, err := c.Address(context.Background(), "broker.example"); err == nil || addr != "" {
+		t.Errorf("Address = %q, %v, want the lookup error", addr, err)
+	}
+	if failures := resolutionFailures(t, c, "broker.example"); failures < 1 {
+		t.Errorf("resolution failures = %v, want at least 1", failures)
+	}
+
+	// No addresses is a failure too.
+	r.set(nil)
+	if _, err := c.Address(context.Background(), "broker.example"); err == nil {
+		t.Error("expected an error without addresses")
+	}
+
+	// The next scrape resolves inline again and succeeds.
+	r.set(nil, "10.0.0.1", "10.0.0.2")
+	if addr, err := c.Address(context.Background(), "broker.example"); addr != "10.0.0.1" || err != nil {
+		t.Errorf("Address = %q, %v, want 10.0.0.1", addr, err)
+	}
+}
+
+func TestResolverCacheCaching(t *testing.T) {
+	r := &scriptedResolver{}
+	r.set(nil, "10.0.0.1")
+	c := NewResolverCache(r)
+	ctx := context.Background()
+
+	if addr, err := c.Address(ctx, "broker.example"); addr != "10.0.0.1" || err != nil {
+		t.Fatalf("Address = %q, %v, want 10.0.0.1", addr, err)
+	}
+
+	// Once resolved, the cached address is returned without waiting for a
+	// lookup, even when lookups hang or fail.
+	r.mu.Lock()
+	r.block = true
+	r.mu.Unlock()
+	start := time.Now()
+	if addr, err := c.Address(ctx, "broker.example"); addr != "10.0.0.1" || err != nil {
+		t.Errorf("cached Address = %q, %v, want 10.0.0.1", addr, err)
+	}
+	if elapsed := time.Since(start); elapsed >= *dnsInlineTimeout {
+		t.Errorf("cached Address took %s", elapsed)
+	}
+	r.mu.Lock()
+	r.block = false
+	r.mu.Unlock()
+
+	// A failed refresh keeps the cached address and is counted.
+	r.set(errTestLookup)
+	failures := resolutionFailures(t, c, "broker.example")
+	if addr, err := c.resolve(ctx, "broker.example"); addr != "10.0.0.1" || err == nil {
+		t.Errorf("failed refresh = %q, %v, want the cached address and the error", addr, err)
+	}
+	if got := resolutionFailures(t, c, "broker.example"); got != failures+1 {
+		t.Errorf("resolution failures = %v, want %v", got, failures+1)
+	}
+	if addr, err := c.Address(ctx, "broker.example"); addr != "10.0.0.1" || err != nil {
+		t.Errorf("Address after a failed refresh = %q, %v, want 10.0.0.1", addr, err)
+	}
+
+	// A successful refresh replaces it.
+	r.set(nil, "10.0.0.2")
+	if _, err := c.resolve(ctx, "broker.example"); err != nil {
+		t.Fatal(err)
+	}
+	if addr, err := c.Address(ctx, "broker.example"); addr != "10.0.0.2" || err != nil {
+		t.Errorf("Address after a refresh = %q, %v, want 10.0.0.2", addr, err)
+	}
+}
+
+func TestResolveDSN(t *testing.T) {
+	r := &scriptedResolver{}
+	r.set(nil, "10.0.0.1")
+	cache := DNSCache
+	DNSCache = NewResolverCache(r)
+	defer func() { DNSCache = cache }()
+
+	for _, tc := range []struct {
+		dsn, want string
+	}{
+		{"cci:cubrid:broker.example:33000:demodb:dba:secret:", "cci:cubrid:10.0.0.1:33000:demodb:dba:secret:"},
+		{"cci:cubrid:192.0.2.1:33000:demodb:::", "cci:cubrid:192.0.2.1:33000:demodb:::"},
+		{"simulate", "simulate"},
+	} {
+		if got, err := resolveDSN(context.Background(), tc.dsn); got != tc.want || err != nil {
+			t.Errorf("resolveDSN(%q) = %q, %v, want %q", tc.dsn, got, err, tc.want)
+		}
+	}
+
+	r.set(errTestLookup)
+	if _, err := resolveDSN(context.Background(), "cci:cubrid:unknown.example:33000:demodb:::"); err == nil {
+		t.Error("expected the resolution error")
+	}
+}
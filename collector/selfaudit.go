@@ -0,0 +1,225 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Periodic self-audit of the guarantees of the statement audit.
+
+package collector
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// selfAudit names the self-audit's statements in the unapproved statements.
+const selfAudit = "self_audit"
+
+var (
+	selfAuditInterval = kingpin.Flag(
+		"security.self-audit-interval",
+		"How often the self-audit verifies that unapproved statements are blocked before reaching the driver. 0 disables it.",
+	).Default("5m").Duration()
+	selfAuditVerifyReadOnly = kingpin.Flag(
+		"security.verify-read-only",
+		"Let the self-audit also verify that the exporter is read-only: no approved statement writes, and a write statement is blocked.",
+	).Default("false").Bool()
+)
+
+// Canary statements of the self-audit. They are never approved, so the
+// statement audit must block them.
+const (
+	selfAuditCanaryQuery = "SELECT 'cubrid_exporter_self_audit' FROM db_root"
+	selfAuditCanaryWrite = "DELETE FROM cubrid_exporter_self_audit"
+)
+
+// Reasons of a failed self-audit.
+const (
+	selfAuditNotBlocked      = "unapproved_not_blocked"
+	selfAuditReachedDriver   = "reached_driver"
+	selfAuditNotAccounted    = "not_accounted"
+	selfAuditWriteApproved   = "write_approved"
+	selfAuditWriteNotBlocked = "write_not_blocked"
+)
+
+var (
+	selfAuditPassDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, exporter, "security_selfaudit_pass"),
+		"Whether the last security self-audit passed (1 for passed).",
+		nil, nil,
+	)
+	selfAuditTimestampDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, exporter, "security_selfaudit_timestamp_seconds"),
+		"Unix time of the last security self-audit.",
+		nil, nil,
+	)
+	selfAuditFailureDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, exporter, "security_selfaudit_failure_info"),
+		"The reason the last security self-audit failed.",
+		[]string{"reason"}, nil,
+	)
+)
+
+// errCanaryReached is returned by canaryConn, which no statement must reach.
+var errCanaryReached = errors.New("self-audit statement reached the driver")
+
+// canaryConn stands in for a driver connection and counts the statements
+// reaching it.
+type canaryConn struct {
+	reached int
+}
+
+func (c *canaryConn) Prepare(query string) (driver.Stmt, error) {
+	c.reached++
+	return nil, errCanaryReached
+}
+
+func (c *canaryConn) Close() error {
+	return nil
+}
+
+func (c *canaryConn) Begin() (driver.Tx, error) {
+	return nil, errCanaryReached
+}
+
+func (c *canaryConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.reached++
+	return nil, errCanaryReached
+}
+
+func (c *canaryConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.reached++
+	return nil, errCanaryReached
+}
+
+// SecuritySelfAudit sends canary statements through the wrapper of the
+// database connections and verifies that they are blocked and accounted
+// without reaching the driver. It implements prometheus.Collector.
+type SecuritySelfAudit struct {
+	// wrap wraps a driver connection like the connections to the database.
+	wrap func(driver.Conn) driver.Conn
+
+	mu        sync.Mutex
+	last      time.Time
+	reason    string
+	onFailure func(reason string)
+}
+
+// SelfAudit is the self-audit of the statement audit of all connections.
+var SelfAudit = &SecuritySelfAudit{wrap: func(conn driver.Conn) driver.Conn { return auditConn{conn} }}
+
+// OnFailure sets the function called with the reason of every failed self-audit.
+func (s *SecuritySelfAudit) OnFailure(f func(reason string)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onFailure = f
+}
+
+// Check runs the self-audit once. It returns the reason it failed, empty if
+// it passed.
+func (s *SecuritySelfAudit) Check(ctx context.Context) string {
+	reason := s.verify(withLogger(ctx, selfAudit))
+
+	s.mu.Lock()
+	s.last, s.reason = time.Now(), reason
+	onFailure := s.onFailure
+	s.mu.Unlock()
+
+	if reason != "" {
+		log.Errorf("SECURITY SELF-AUDIT FAILED (%s): statements the exporter must not send are not blocked", reason)
+		if onFailure != nil {
+			onFailure(reason)
+		}
+	}
+	return reason
+}
+
+func (s *SecuritySelfAudit) verify(ctx context.Context) string {
+	conn := &canaryConn{}
+	wrapped := s.wrap(conn)
+	blocked := Audit.Blocked()
+	queryer, ok := wrapped.(driver.QueryerContext)
+	if !ok {
+		return selfAuditNotBlocked
+	}
+	_, err := queryer.QueryContext(ctx, selfAuditCanaryQuery, nil)
+	switch {
+	case conn.reached > 0:
+		return selfAuditReachedDriver
+	case !errors.Is(err, errUnapprovedStatement):
+		return selfAuditNotBlocked
+	case Audit.Blocked() == blocked:
+		return selfAuditNotAccounted
+	}
+
+	if !*selfAuditVerifyReadOnly {
+		return ""
+	}
+	if !Audit.ReadOnly() {
+		return selfAuditWriteApproved
+	}
+	execer, ok := wrapped.(driver.ExecerContext)
+	if !ok {
+		return selfAuditWriteNotBlocked
+	}
+	if _, err := execer.ExecContext(ctx, selfAuditCanaryWrite, nil); conn.reached > 0 || !errors.Is(err, errUnapprovedStatement) {
+		return selfAuditWriteNotBlocked
+	}
+	return ""
+}
+
+// Run runs the self-audit every --security.self-audit-interval until ctx is
+// done.
+func (s *SecuritySelfAudit) Run(ctx context.Context) {
+	if *selfAuditInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(*selfAuditInterval)
+	defer ticker.Stop()
+	for {
+		s.Check(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (s *SecuritySelfAudit) Describe(ch chan<- *prometheus.Desc) {
+	ch <- selfAuditPassDesc
+	ch <- selfAuditTimestampDesc
+	ch <- selfAuditFailureDesc
+}
+
+// Collect implements prometheus.Collector.
+func (s *SecuritySelfAudit) Collect(ch chan<- prometheus.Metric) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.last.IsZero() {
+		return
+	}
+	pass := 1.0
+	if s.reason != "" {
+		pass = 0
+		ch <- prometheus.MustNewConstMetric(selfAuditFailureDesc, prometheus.GaugeValue, 1, s.reason)
+	}
+	ch <- prometheus.MustNewConstMetric(selfAuditPassDesc, prometheus.GaugeValue, pass)
+	ch <- prometheus.MustNewConstMetric(selfAuditTimestampDesc, prometheus.GaugeValue, float64(s.last.UnixNano())/1e9)
+}
@@ -0,0 +1,111 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// withSelfAudit runs the test with its own statement audit and the wrapper
+// wrap, restoring them afterwards.
+func withSelfAudit(t *testing.T, wrap func(driver.Conn) driver.Conn) *SecuritySelfAudit {
+	audit, verify := Audit, *selfAuditVerifyReadOnly
+	Audit = newQueryAudit(approvedQueries)
+	t.Cleanup(func() {
+		Audit, *selfAuditVerifyReadOnly = audit, verify
+	})
+	return &SecuritySelfAudit{wrap: wrap}
+}
+
+// passthroughConn is a broken wrapper passing every statement to the driver.
+type passthroughConn struct {
+	*canaryConn
+}
+
+// silentConn is a broken wrapper blocking statements without accounting for them.
+type silentConn struct {
+	driver.Conn
+}
+
+func (c silentConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return nil, fmt.Errorf("%w: %s", errUnapprovedStatement, query)
+}
+
+func TestSelfAuditPasses(t *testing.T) {
+	s := withSelfAudit(t, SelfAudit.wrap)
+	*selfAuditVerifyReadOnly = true
+	if reason := s.Check(context.Background()); reason != "" {
+		t.Errorf("self-audit failed: %s", reason)
+	}
+	if Audit.Blocked() != 2 {
+		t.Errorf("blocked statements = %d, want 2", Audit.Blocked())
+	}
+}
+
+func TestSelfAuditCatchesBrokenWrapper(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		wrap   func(driver.Conn) driver.Conn
+		reason string
+	}{
+		{"passthrough", func(conn driver.Conn) driver.Conn { return passthroughConn{conn.(*canaryConn)} }, selfAuditReachedDriver},
+		{"unaccounted", func(conn driver.Conn) driver.Conn { return silentConn{conn} }, selfAuditNotAccounted},
+		{"no wrapper methods", func(conn driver.Conn) driver.Conn { return struct{ driver.Conn }{conn} }, selfAuditNotBlocked},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := withSelfAudit(t, tc.wrap)
+			var failed string
+			s.OnFailure(func(reason string) { failed = reason })
+			if reason := s.Check(context.Background()); reason != tc.reason {
+				t.Errorf("self-audit reason = %q, want %q", reason, tc.reason)
+			}
+			if failed != tc.reason {
+				t.Errorf("failure callback reason = %q, want %q", failed, tc.reason)
+			}
+
+			expected := `
+# HELP cubrid_exporter_security_selfaudit_failure_info The reason the last security self-audit failed.
+# TYPE cubrid_exporter_security_selfaudit_failure_info gauge
+cubrid_exporter_security_selfaudit_failure_info{reason="` + tc.reason + `"} 1
+# HELP cubrid_exporter_security_selfaudit_pass Whether the last security self-audit passed (1 for passed).
+# TYPE cubrid_exporter_security_selfaudit_pass gauge
+cubrid_exporter_security_selfaudit_pass 0
+`
+			if err := testutil.CollectAndCompare(s, strings.NewReader(expected),
+				"cubrid_exporter_security_selfaudit_failure_info", "cubrid_exporter_security_selfaudit_pass"); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestSelfAuditReadOnly(t *testing.T) {
+	s := withSelfAudit(t, SelfAudit.wrap)
+	Audit.ApproveWrite("leader", "lease_release", "UPDATE lease SET expires = 0")
+
+	*selfAuditVerifyReadOnly = false
+	if reason := s.Check(context.Background()); reason != "" {
+		t.Errorf("self-audit without read-only verification failed: %s", reason)
+	}
+	*selfAuditVerifyReadOnly = true
+	if reason := s.Check(context.Background()); reason != selfAuditWriteApproved {
+		t.Errorf("self-audit reason = %q, want %q", reason, selfAuditWriteApproved)
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Scrape CUBRID client sessions aggregated by program name.
+
+package collector
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+const (
+	sessionsByProgram = "sessions_by_program"
+
+	sessionsByProgramQuery = "show transaction tables"
+
+	// otherProgram collects the sessions of programs outside the top-K.
+	otherProgram = "other"
+)
+
+var sessionsTopK = kingpin.Flag(
+	"collect.sessions_by_program.top-k",
+	"Number of programs with the most sessions exported individually; the rest are summed as \"other\".",
+).Default("10").Int()
+
+// Metric descriptors.
+var (
+	SessionsByProgram = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "sessions"),
+		"Number of current client sessions by program name.",
+		[]string{"program"}, nil,
+	)
+	SessionsTotal = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "sessions_total"),
+		"Number of current client sessions.",
+		nil, nil,
+	)
+)
+
+// ScrapeSessionsByProgram collects the current sessions grouped by client program.
+type ScrapeSessionsByProgram struct{}
+
+// Name of the Scraper. Should be unique.
+func (ScrapeSessionsByProgram) Name() string {
+	return sessionsByProgram
+}
+
+// Help describes the role of the Scraper.
+func (ScrapeSessionsByProgram) Help() string {
+	return "Scrape client sessions by program name from sessionsByProgramQuery"
+}
+
+// Version of CUBRID from which scraper is available.
+func (ScrapeSessionsByProgram) Version() float64 {
+	return 10.0
+}
+
+// Scrape collects data from database connection and sends it over channel as prometheus metric.
+func (ScrapeSessionsByProgram) Scrape(ctx context.Context, db *sql.DB, ch chan<- prometheus.Metric) error {
+
+	sessionRows, err := db.QueryContext(ctx, sessionsByProgramQuery)
+	if err != nil {
+		return err
+	}
+
+	defer sessionRows.Close()
+
+	columns, err := sessionRows.Columns()
+	if err != nil {
+		return err
+	}
+	programIdx := -1
+	for i, column := range columns {
+		if strings.EqualFold(column, "Client_program") {
+			programIdx = i
+		}
+	}
+
+	counts := map[string]int{}
+	total := 0
+	values := make([]sql.RawBytes, len(columns))
+	scanArgs := make([]interface{}, len(columns))
+	for i := range values {
+		scanArgs[i] = &values[i]
+	}
+
+	for sessionRows.Next() {
+
+		if err := sessionRows.Scan(scanArgs...); err != nil {
+			return err
+		}
+
+		program := ""
+		if programIdx >= 0 {
+			program = string(values[programIdx])
+		}
+		counts[sanitizeLabelValue(ctx, program)]++
+		total++
+	}
+	if err := sessionRows.Err(); err != nil {
+		return err
+	}
+
+	for program, count := range topK(counts, *sessionsTopK, otherProgram) {
+		ch <- prometheus.MustNewConstMetric(SessionsByProgram, prometheus.GaugeValue, float64(count), program)
+	}
+	ch <- prometheus.MustNewConstMetric(SessionsTotal, prometheus.GaugeValue, float64(total))
+
+	return nil
+}
+
+// topK keeps the k largest counts and sums the rest under other.
+// Ties are broken by name so the selection is stable between scrapes.
+func topK(counts map[string]int, k int, other string) map[string]int {
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if counts[names[i]] != counts[names[j]] {
+			return counts[names[i]] > counts[names[j]]
+		}
+		return names[i] < names[j]
+	})
+
+	result := map[string]int{}
+	for i, name := range names {
+		if i < k && name != other {
+			result[name] = counts[name]
+		} else {
+			result[other] += counts[name]
+		}
+	}
+	return result
+}
+
+// check interface
+var _ Scraper = ScrapeSessionsByProgram{}
@@ -0,0 +1,101 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// withSessionsTopK sets the top-K of the programs for the test.
+func withSessionsTopK(t *testing.T, k int) {
+	saved := *sessionsTopK
+	*sessionsTopK = k
+	t.Cleanup(func() { *sessionsTopK = saved })
+}
+
+func TestScrapeSessionsByProgram(t *testing.T) {
+	withSessionsTopK(t, 2)
+	db, mock := newMock(t)
+	defer db.Close()
+
+	// More programs than K, hostile names among them. "other" sent by a
+	// client is summed into the bucket rather than exported on its own.
+	rows := sqlmock.NewRows([]string{"Tran_index", "Client_program"})
+	for i, program := range []string{"batch", "batch", "batch", `web "app"`, `web "app"`, "web\n\"app\"",
+		"report", "other", "cron\xff", ""} {
+		rows.AddRow(i, program)
+	}
+	mock.ExpectQuery(sessionsByProgramQuery).WillReturnRows(rows)
+
+	expected := `
+# HELP cubrid_sessions Number of current client sessions by program name.
+# TYPE cubrid_sessions gauge
+cubrid_sessions{program="batch"} 3
+cubrid_sessions{program="web \"app\""} 2
+cubrid_sessions{program="other"} 5
+# HELP cubrid_sessions_total Number of current client sessions.
+# TYPE cubrid_sessions_total gauge
+cubrid_sessions_total 10
+`
+	if err := testutil.CollectAndCompare(scrapeCollector{t, ScrapeSessionsByProgram{}, db}, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestScrapeSessionsByProgramNoProgramColumn(t *testing.T) {
+	withSessionsTopK(t, 2)
+	db, mock := newMock(t)
+	defer db.Close()
+
+	mock.ExpectQuery(sessionsByProgramQuery).WillReturnRows(sqlmock.NewRows([]string{"Tran_index"}).AddRow(1).AddRow(2))
+
+	expected := `
+# HELP cubrid_sessions Number of current client sessions by program name.
+# TYPE cubrid_sessions gauge
+cubrid_sessions{program="unknown"} 2
+# HELP cubrid_sessions_total Number of current client sessions.
+# TYPE cubrid_sessions_total gauge
+cubrid_sessions_total 2
+`
+	if err := testutil.CollectAndCompare(scrapeCollector{t, ScrapeSessionsByProgram{}, db}, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTopK(t *testing.T) {
+	counts := map[string]int{"a": 5, "b": 3, "c": 3, "d": 1}
+	for _, tc := range []struct {
+		k    int
+		want map[string]int
+	}{
+		{0, map[string]int{"other": 12}},
+		{1, map[string]int{"a": 5, "other": 7}},
+		// Ties are broken by name.
+		{2, map[string]int{"a": 5, "b": 3, "other": 4}},
+		{4, map[string]int{"a": 5, "b": 3, "c": 3, "d": 1}},
+		{10, map[string]int{"a": 5, "b": 3, "c": 3, "d": 1}},
+	} {
+		if got := topK(counts, tc.k, "other"); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("topK(k=%d) = %v, want %v", tc.k, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Synthetic scrapers for load-simulation mode.
+
+package collector
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// SimulatedDSN is the DSN of simulate mode. Scrapes with it skip the database.
+const SimulatedDSN = "simulate"
+
+// simulatedDatabase is the database label of synthetic per-database series.
+const simulatedDatabase = "simdb"
+
+// Simulation configures the synthetic data generated in simulate mode.
+type Simulation struct {
+	Brokers      int
+	Volumes      int
+	StatdumpKeys int
+	// Seed makes the generated values reproducible.
+	Seed int64
+	// Drift is the maximum relative change of a value between scrapes.
+	Drift float64
+}
+
+type simulatedSeries struct {
+	desc *prometheus.Desc
+	// valueType is the type the real scraper sends the series with.
+	valueType prometheus.ValueType
+	labels    []string
+	// base is the initial value the drift is relative to.
+	base  float64
+	value float64
+	// counter series only ever increase.
+	counter bool
+}
+
+// simulatedScraper emits synthetic series under the name and descriptors of a real scraper.
+type simulatedScraper struct {
+	name  string
+	drift float64
+
+	mu     sync.Mutex
+	rng    *rand.Rand
+	series []simulatedSeries
+}
+
+// NewSimulatedScrapers returns synthetic replacements for the broker status,
+// spacedb and statdump scrapers. They ignore the database connection.
+func NewSimulatedScrapers(s Simulation) []Scraper {
+	rng := rand.New(rand.NewSource(s.Seed))
+	newScraper := func(name string) *simulatedScraper {
+		return &simulatedScraper{name: name, drift: s.Drift, rng: rand.New(rand.NewSource(rng.Int63()))}
+	}
+
+	brokerScraper := newScraper(brokerStatus)
+	for i := 0; i < s.Brokers; i++ {
+		broker := fmt.Sprintf("broker%d", i+1)
+		brokerScraper.add(brokerStatusDesc("num_as"), prometheus.GaugeValue, 5+float64(brokerScraper.rng.Intn(20)), false, broker)
+		brokerScraper.add(brokerStatusDesc("pid"), prometheus.GaugeValue, float64(10000+i), false, broker)
+		brokerScraper.add(brokerStatusDesc("port"), prometheus.GaugeValue, float64(30000+i), false, broker)
+		brokerScraper.add(brokerStatusDesc("qsize"), prometheus.GaugeValue, 0, false, broker)
+		for _, column := range []string{"num_select", "num_insert", "num_update", "num_delete", "num_trans", "num_query", "num_long_query", "num_error_query", "num_uniq_error"} {
+			brokerScraper.add(brokerStatusDesc(column), prometheus.CounterValue, float64(brokerScraper.rng.Intn(100000)), true, broker)
+		}
+		brokerScraper.add(brokerStatusDesc("num_conns"), prometheus.CounterValue, float64(brokerScraper.rng.Intn(50)), true, broker)
+	}
+
+	spacedbScraper := newScraper(spacedbStatus)
+	for i := 0; i < s.Volumes; i++ {
+		volNo := strconv.Itoa(i)
+		spacedbScraper.add(VolNoInfo, prometheus.GaugeValue, 1, false, simulatedDatabase, volNo, "count")
+		spacedbScraper.add(VolNoInfo, prometheus.GaugeValue, float64(spacedbScraper.rng.Intn(60000)), false, simulatedDatabase, volNo, "used_pages")
+		spacedbScraper.add(VolNoInfo, prometheus.GaugeValue, float64(spacedbScraper.rng.Intn(60000)), false, simulatedDatabase, volNo, "free_pages")
+	}
+
+	statdumpScraper := newScraper(statdump)
+	for i := 0; i < s.StatdumpKeys; i++ {
+		// StatdumpInfo is a gauge, even for the keys counting up.
+		statdumpScraper.add(StatdumpInfo, prometheus.GaugeValue, float64(statdumpScraper.rng.Intn(1000000)), true, simulatedDatabase, fmt.Sprintf("Num_simulated_%d", i+1))
+	}
+
+	return []Scraper{brokerScraper, spacedbScraper, statdumpScraper}
+}
+
+func (s *simulatedScraper) add(desc *prometheus.Desc, valueType prometheus.ValueType, value float64, counter bool, labels ...string) {
+	s.series = append(s.series, simulatedSeries{desc: desc, valueType: valueType, labels: labels, base: value, value: value, counter: counter})
+}
+
+// Name of the Scraper. Should be unique.
+func (s *simulatedScraper) Name() string {
+	return s.name
+}
+
+// Help describes the role of the Scraper.
+func (s *simulatedScraper) Help() string {
+	return "Generate synthetic " + s.name + " data"
+}
+
+// Version of CUBRID from which scraper is available.
+func (s *simulatedScraper) Version() float64 {
+	return 0
+}
+
+// Scrape advances the synthetic values and sends them over channel as prometheus metric.
+func (s *simulatedScraper) Scrape(ctx context.Context, db *sql.DB, ch chan<- prometheus.Metric) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.series {
+		series := &s.series[i]
+		if series.counter {
+			series.value += series.base*s.drift*s.rng.Float64() + 1
+		} else {
+			series.value = series.base * (1 + s.drift*(2*s.rng.Float64()-1))
+		}
+		ch <- prometheus.MustNewConstMetric(series.desc, series.valueType, series.value, series.labels...)
+	}
+	return nil
+}
+
+// check interface
+var _ Scraper = &simulatedScraper{}
@@ -0,0 +1,154 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// simulatedSample is a sample of a simulated series.
+type simulatedSample struct {
+	desc    string
+	labels  string
+	value   float64
+	counter bool
+}
+
+// scrapeSimulated scrapes the simulated scraper once.
+func scrapeSimulated(t testing.TB, scraper Scraper) []simulatedSample {
+	ch := make(chan prometheus.Metric)
+	done := make(chan []simulatedSample)
+	go func() {
+		var samples []simulatedSample
+		for metric := range ch {
+			var m dto.Metric
+			if err := metric.Write(&m); err != nil {
+				t.Error(err)
+			}
+			sample := simulatedSample{desc: metric.Desc().String()}
+			for _, label := range m.Label {
+				sample.labels += label.GetName() + "=" + label.GetValue() + ","
+			}
+			if m.Counter != nil {
+				sample.value, sample.counter = m.Counter.GetValue(), true
+			} else {
+				sample.value = m.Gauge.GetValue()
+			}
+			samples = append(samples, sample)
+		}
+		done <- samples
+	}()
+	err := scraper.Scrape(context.Background(), nil, ch)
+	close(ch)
+	samples := <-done
+	if err != nil {
+		t.Fatal(err)
+	}
+	return samples
+}
+
+func TestSimulatedScrapers(t *testing.T) {
+	sim := Simulation{Brokers: 3, Volumes: 2, StatdumpKeys: 4, Seed: 1, Drift: 0.1}
+	scrapers := NewSimulatedScrapers(sim)
+	counterColumns := 0
+	for _, field := range brokerStatusFields {
+		if field.valueType == prometheus.CounterValue {
+			counterColumns++
+		}
+	}
+
+	for i, tc := range []struct {
+		name     string
+		series   int
+		counters int
+	}{
+		{brokerStatus, sim.Brokers * len(brokerStatusFields), sim.Brokers * counterColumns},
+		{spacedbStatus, sim.Volumes * 3, 0},
+		{statdump, sim.StatdumpKeys, 0},
+	} {
+		scraper := scrapers[i].(*simulatedScraper)
+		if scraper.Name() != tc.name {
+			t.Errorf("scraper %d is %s, want %s", i, scraper.Name(), tc.name)
+			continue
+		}
+		first := scrapeSimulated(t, scraper)
+		second := scrapeSimulated(t, scraper)
+		if len(first) != tc.series || len(second) != tc.series {
+			t.Errorf("%s: %d and %d series, want %d", tc.name, len(first), len(second), tc.series)
+			continue
+		}
+		counters := 0
+		for j, series := range scraper.series {
+			// The series are sent with the types of the real scraper.
+			if first[j].counter {
+				counters++
+			}
+			if series.counter {
+				// Counting series increase by at least 1 every scrape.
+				if second[j].value < first[j].value+1 {
+					t.Errorf("%s %s: %v after %v, want an increase", tc.name, first[j].labels, second[j].value, first[j].value)
+				}
+			} else if d := second[j].value - series.base; d > series.base*sim.Drift || -d > series.base*sim.Drift {
+				t.Errorf("%s %s: %v drifted more than %v from %v", tc.name, second[j].labels, second[j].value, sim.Drift, series.base)
+			}
+		}
+		if counters != tc.counters {
+			t.Errorf("%s: %d counters, want %d", tc.name, counters, tc.counters)
+		}
+	}
+}
+
+func TestSimulatedScrapersSeed(t *testing.T) {
+	sim := Simulation{Brokers: 2, Volumes: 2, StatdumpKeys: 2, Seed: 7, Drift: 0.2}
+	first, second := NewSimulatedScrapers(sim), NewSimulatedScrapers(sim)
+	for i := range first {
+		for scrape := 0; scrape < 2; scrape++ {
+			a, b := scrapeSimulated(t, first[i]), scrapeSimulated(t, second[i])
+			if !reflect.DeepEqual(a, b) {
+				t.Errorf("%s: scrape %d differs with the same seed", first[i].Name(), scrape+1)
+			}
+		}
+	}
+	sim.Seed++
+	if a, b := scrapeSimulated(t, NewSimulatedScrapers(sim)[0]), scrapeSimulated(t, first[0]); reflect.DeepEqual(a, b) {
+		t.Error("a different seed generated the same values")
+	}
+}
+
+// BenchmarkSimulatedScrape scrapes a simulated server with 100 brokers,
+// 100 volumes and 400 statdump keys.
+func BenchmarkSimulatedScrape(b *testing.B) {
+	scrapers := NewSimulatedScrapers(Simulation{Brokers: 100, Volumes: 100, StatdumpKeys: 400, Seed: 1, Drift: 0.1})
+	ch := make(chan prometheus.Metric)
+	go func() {
+		for range ch {
+		}
+	}()
+	defer close(ch)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, scraper := range scrapers {
+			if err := scraper.Scrape(context.Background(), nil, ch); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
@@ -18,7 +18,11 @@ package collector
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
@@ -26,24 +30,95 @@ import (
 const (
 	spacedbStatus = "spacedb"
 
-	spacedbQuery = "show spacedb demodb"
+	spacedbQuery = "show spacedb %s"
 )
 
 // Metric descriptors.
 var (
-	SpaceDbInfo = prometheus.NewDesc(
+	VolNoInfo = prometheus.NewDesc(
 		prometheus.BuildFQName(namespace, "spacedb", "info"),
 		"Information about CUBRID SpaceDB",
-		[]string{"vol_no", "type", "purpose", "count", "used_pages", "free_pages"}, nil,
+		[]string{"database", "vol_no", "key"}, nil,
 	)
 
-	VolNoInfo = prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, "spacedb", "info"),
-		"Information about CUBRID SpaceDB",
-		[]string{"vol_no", "key"}, nil,
+	VolumePurposeCode = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "spacedb", "volume_purpose_code"),
+		"Stable code of the volume purpose, see volumePurposeCodes. 0 is an unknown purpose.",
+		[]string{"database", "vol_no"}, nil,
+	)
+
+	VolumeTypeCode = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "spacedb", "volume_type_code"),
+		"Stable code of the volume type, see volumeTypeCodes. 0 is an unknown type.",
+		[]string{"database", "vol_no"}, nil,
+	)
+
+	VolumeLastExtend = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "spacedb", "volume_last_extend_time_seconds"),
+		"Time the volume was last seen growing, in seconds since the epoch.",
+		[]string{"database", "vol_no"}, nil,
 	)
 )
 
+// unknownEnumCode is the code of purpose and type strings missing from the mappings.
+const unknownEnumCode = 0
+
+// volumePurposeCodes maps the purposes reported by spacedb, upper-cased, to
+// stable codes. Codes are never reused; new purposes get new codes.
+var volumePurposeCodes = map[string]float64{
+	// CUBRID 9.x and 10.x.
+	"GENERIC": 1,
+	"DATA":    2,
+	"INDEX":   3,
+	"TEMP":    4,
+	// CUBRID 10.x and later report the duration with the purpose.
+	"PERMANENT DATA": 5,
+	"TEMPORARY DATA": 6,
+	"PERMANENT TEMP": 7,
+	"TEMPORARY TEMP": 8,
+}
+
+// volumeTypeCodes maps the volume types reported by spacedb, upper-cased, to stable codes.
+var volumeTypeCodes = map[string]float64{
+	"PERMANENT": 1,
+	"TEMPORARY": 2,
+}
+
+// enumCode returns the code of value, reporting values missing from codes.
+func enumCode(ctx context.Context, codes map[string]float64, field, value string) float64 {
+	normalized := strings.ToUpper(whitespaceRE.ReplaceAllString(strings.TrimSpace(value), " "))
+	if code, ok := codes[normalized]; ok {
+		return code
+	}
+	reportAnomaly(ctx, anomalyUnknownEnum, fmt.Sprintf("%s %q", field, value))
+	return unknownEnumCode
+}
+
+// volumeExtends remembers volume sizes between scrapes to detect auto-extension,
+// which spacedb does not report directly.
+var volumeExtends = struct {
+	sync.Mutex
+	pages      map[string]float64
+	lastExtend map[string]time.Time
+}{pages: map[string]float64{}, lastExtend: map[string]time.Time{}}
+
+// observeVolumeSize records the total pages of a volume and returns when it was
+// last seen growing, or the zero time if it never was.
+func observeVolumeSize(ctx context.Context, database, volNo string, pages float64) time.Time {
+	volumeExtends.Lock()
+	defer volumeExtends.Unlock()
+
+	key := targetScoped(ctx, database+"/"+volNo)
+	if previous, ok := volumeExtends.pages[key]; ok && pages > previous {
+		volumeExtends.lastExtend[key] = time.Now()
+	}
+	volumeExtends.pages[key] = pages
+	return volumeExtends.lastExtend[key]
+}
+
+// spacedbColumns is the number of columns of spacedbQuery the scraper reads.
+const spacedbColumns = 6
+
 // ScrapeSpaceDBStatus
 type ScrapeSpaceDBStatus struct{}
 
@@ -64,54 +139,85 @@ func (ScrapeSpaceDBStatus) Version() float64 {
 
 // Scrape collects data from database connection and sends it over channel as prometheus metric.
 func (ScrapeSpaceDBStatus) Scrape(ctx context.Context, db *sql.DB, ch chan<- prometheus.Metric) error {
+	return scrapeDatabases(ctx, db, func(database string) (int, error) {
+		return scrapeSpaceDBDatabase(ctx, db, database, ch)
+	})
+}
 
-	spaceDbRows, err := db.QueryContext(ctx, spacedbQuery)
+func scrapeSpaceDBDatabase(ctx context.Context, db *sql.DB, database string, ch chan<- prometheus.Metric) (int, error) {
+	spaceDbRows, err := db.QueryContext(ctx, fmt.Sprintf(spacedbQuery, database))
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	defer spaceDbRows.Close()
 
+	if columns, err := spaceDbRows.Columns(); err == nil {
+		Coverage.observeColumns(coverageSpacedb, columns, spacedbColumns)
+	}
+
 	var vol_no string
 	var _type string
 	var purpose string
 	var count string
 	var used_pages string
 	var free_pages string
+	rows := 0
 
 	for spaceDbRows.Next() {
+		rows++
 
 		err := spaceDbRows.Scan(&vol_no, &_type, &purpose, &count, &used_pages, &free_pages)
 		if err != nil {
-			return err
+			return rows, err
 		}
 
-		fValue, _ := strconv.ParseFloat(_type, 64)
-		ch <- prometheus.MustNewConstMetric(VolNoInfo, prometheus.GaugeValue, fValue, vol_no, "_type")
+		// The type and purpose are names, exported only as codes.
+		ch <- prometheus.MustNewConstMetric(VolumePurposeCode, prometheus.GaugeValue, enumCode(ctx, volumePurposeCodes, "purpose", purpose), database, vol_no)
+		ch <- prometheus.MustNewConstMetric(VolumeTypeCode, prometheus.GaugeValue, enumCode(ctx, volumeTypeCodes, "type", _type), database, vol_no)
 
-		fValue, _ = strconv.ParseFloat(_type, 64)
-		ch <- prometheus.MustNewConstMetric(VolNoInfo, prometheus.GaugeValue, fValue, vol_no, "purpose")
-
-		fValue, _ = strconv.ParseFloat(count, 64)
-		ch <- prometheus.MustNewConstMetric(VolNoInfo, prometheus.GaugeValue, fValue, vol_no, "count")
-
-		fValue, _ = strconv.ParseFloat(used_pages, 64)
-		fUsedPagesValue := fValue
-		ch <- prometheus.MustNewConstMetric(VolNoInfo, prometheus.GaugeValue, fValue, vol_no, "used_pages")
+		if value, ok := parseSpaceDBValue(ctx, database, vol_no, "count", count); ok {
+			ch <- prometheus.MustNewConstMetric(VolNoInfo, prometheus.GaugeValue, value, database, vol_no, "count")
+		}
+		usedPages, usedOK := parseSpaceDBValue(ctx, database, vol_no, "used_pages", used_pages)
+		if usedOK {
+			ch <- prometheus.MustNewConstMetric(VolNoInfo, prometheus.GaugeValue, usedPages, database, vol_no, "used_pages")
+		}
+		freePages, freeOK := parseSpaceDBValue(ctx, database, vol_no, "free_pages", free_pages)
+		if freeOK {
+			ch <- prometheus.MustNewConstMetric(VolNoInfo, prometheus.GaugeValue, freePages, database, vol_no, "free_pages")
+		}
+		if !usedOK || !freeOK {
+			continue
+		}
 
-		fValue, _ = strconv.ParseFloat(free_pages, 64)
-		fFreePagesValue := fValue
-		ch <- prometheus.MustNewConstMetric(VolNoInfo, prometheus.GaugeValue, fValue, vol_no, "free_pages")
+		average := 0.0
+		if total := usedPages + freePages; total > 0 {
+			average = usedPages / total * 100
+		}
+		ch <- prometheus.MustNewConstMetric(VolNoInfo, prometheus.GaugeValue, average, database, vol_no, "usedPercentage")
 
-		average := fUsedPagesValue / (fUsedPagesValue + fFreePagesValue) * 100
-		if fUsedPagesValue == 0 {
-			average = 0
+		if lastExtend := observeVolumeSize(ctx, database, vol_no, usedPages+freePages); !lastExtend.IsZero() {
+			ch <- prometheus.MustNewConstMetric(VolumeLastExtend, prometheus.GaugeValue, float64(lastExtend.Unix()), database, vol_no)
 		}
-		ch <- prometheus.MustNewConstMetric(VolNoInfo, prometheus.GaugeValue, average, vol_no, "usedPercentage")
 
 	}
+	if err := spaceDbRows.Err(); err != nil {
+		return rows, err
+	}
 
-	return nil
+	return rows, nil
+}
+
+// parseSpaceDBValue parses a numeric column of a volume, reporting a value
+// that does not parse as an anomaly.
+func parseSpaceDBValue(ctx context.Context, database, volNo, column, value string) (float64, bool) {
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		reportAnomaly(ctx, anomalyUnparsedValue, fmt.Sprintf("%s of volume %s of %s: %q", column, volNo, database, value))
+		return 0, false
+	}
+	return parsed, true
 }
 
 // check interface
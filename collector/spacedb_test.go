@@ -0,0 +1,139 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var spacedbTestColumns = []string{"volid", "type", "purpose", "total_pages", "used_pages", "free_pages"}
+
+func TestScrapeSpaceDB(t *testing.T) {
+	db, mock := newMock(t)
+	defer db.Close()
+
+	expectDatabase(mock, "spacedbtest")
+	mock.ExpectQuery("show spacedb spacedbtest").WillReturnRows(sqlmock.NewRows(spacedbTestColumns).
+		AddRow("0", "PERMANENT", "PERMANENT DATA", "1", "100", "300").
+		AddRow("1", "TEMPORARY", "TEMPORARY TEMP", "1", "0", "0").
+		AddRow("2", "PERMANENT", "UNHEARD OF", "1", "0", "50"))
+
+	expected := `
+# HELP cubrid_spacedb_info Information about CUBRID SpaceDB
+# TYPE cubrid_spacedb_info gauge
+cubrid_spacedb_info{database="spacedbtest",key="count",vol_no="0"} 1
+cubrid_spacedb_info{database="spacedbtest",key="count",vol_no="1"} 1
+cubrid_spacedb_info{database="spacedbtest",key="count",vol_no="2"} 1
+cubrid_spacedb_info{database="spacedbtest",key="free_pages",vol_no="0"} 300
+cubrid_spacedb_info{database="spacedbtest",key="free_pages",vol_no="1"} 0
+cubrid_spacedb_info{database="spacedbtest",key="free_pages",vol_no="2"} 50
+cubrid_spacedb_info{database="spacedbtest",key="usedPercentage",vol_no="0"} 25
+cubrid_spacedb_info{database="spacedbtest",key="usedPercentage",vol_no="1"} 0
+cubrid_spacedb_info{database="spacedbtest",key="usedPercentage",vol_no="2"} 0
+cubrid_spacedb_info{database="spacedbtest",key="used_pages",vol_no="0"} 100
+cubrid_spacedb_info{database="spacedbtest",key="used_pages",vol_no="1"} 0
+cubrid_spacedb_info{database="spacedbtest",key="used_pages",vol_no="2"} 0
+# HELP cubrid_spacedb_volume_purpose_code Stable code of the volume purpose, see volumePurposeCodes. 0 is an unknown purpose.
+# TYPE cubrid_spacedb_volume_purpose_code gauge
+cubrid_spacedb_volume_purpose_code{database="spacedbtest",vol_no="0"} 5
+cubrid_spacedb_volume_purpose_code{database="spacedbtest",vol_no="1"} 8
+cubrid_spacedb_volume_purpose_code{database="spacedbtest",vol_no="2"} 0
+# HELP cubrid_spacedb_volume_type_code Stable code of the volume type, see volumeTypeCodes. 0 is an unknown type.
+# TYPE cubrid_spacedb_volume_type_code gauge
+cubrid_spacedb_volume_type_code{database="spacedbtest",vol_no="0"} 1
+cubrid_spacedb_volume_type_code{database="spacedbtest",vol_no="1"} 2
+cubrid_spacedb_volume_type_code{database="spacedbtest",vol_no="2"} 1
+`
+	if err := testutil.CollectAndCompare(scrapeCollector{t, ScrapeSpaceDBStatus{}, db}, strings.NewReader(expected),
+		"cubrid_spacedb_info", "cubrid_spacedb_volume_purpose_code", "cubrid_spacedb_volume_type_code"); err != nil {
+		t.Error(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+// TestScrapeSpaceDBPurpose is a regression test for the purpose code, which
+// must be read from the purpose column, not the type column.
+func TestScrapeSpaceDBPurpose(t *testing.T) {
+	db, mock := newMock(t)
+	defer db.Close()
+
+	expectDatabase(mock, "purposetest")
+	mock.ExpectQuery("show spacedb purposetest").WillReturnRows(sqlmock.NewRows(spacedbTestColumns).
+		AddRow("0", "TEMPORARY", "PERMANENT DATA", "1", "10", "10"))
+
+	expected := `
+# HELP cubrid_spacedb_volume_purpose_code Stable code of the volume purpose, see volumePurposeCodes. 0 is an unknown purpose.
+# TYPE cubrid_spacedb_volume_purpose_code gauge
+cubrid_spacedb_volume_purpose_code{database="purposetest",vol_no="0"} 5
+# HELP cubrid_spacedb_volume_type_code Stable code of the volume type, see volumeTypeCodes. 0 is an unknown type.
+# TYPE cubrid_spacedb_volume_type_code gauge
+cubrid_spacedb_volume_type_code{database="purposetest",vol_no="0"} 2
+`
+	if err := testutil.CollectAndCompare(scrapeCollector{t, ScrapeSpaceDBStatus{}, db}, strings.NewReader(expected),
+		"cubrid_spacedb_volume_purpose_code", "cubrid_spacedb_volume_type_code"); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestScrapeSpaceDBUnparsedValue checks that a column that does not parse
+// is counted as an anomaly, failing the collector only in strict mode, and
+// that the other samples of the volume are still sent.
+func TestScrapeSpaceDBUnparsedValue(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		db, mock := newMock(t)
+		expectDatabase(mock, "unparsedtest")
+		mock.ExpectQuery("show spacedb unparsedtest").WillReturnRows(sqlmock.NewRows(spacedbTestColumns).
+			AddRow("0", "PERMANENT", "PERMANENT DATA", "1", "10", "10").
+			AddRow("1", "PERMANENT", "PERMANENT DATA", "1", "n/a", "10"))
+
+		samples, anomalies, err := scrapeAnomalies(ScrapeSpaceDBStatus{}, db, strict)
+		db.Close()
+		// Volume 0 sends 2 codes and 4 info samples, volume 1 its codes, count
+		// and free pages but neither the used pages nor the percentage.
+		if samples != 10 {
+			t.Errorf("strict %v: samples = %d, want 10", strict, samples)
+		}
+		if anomalies != 1 {
+			t.Errorf("strict %v: anomalies = %v, want 1", strict, anomalies)
+		}
+		if strict && !errors.Is(err, errStrict) {
+			t.Errorf("strict: error = %v, want a strict mode error", err)
+		}
+		if !strict && err != nil {
+			t.Errorf("lenient: error = %v, want nil", err)
+		}
+	}
+}
+
+// TestScrapeSpaceDBRowError checks that a failing result set fails the database.
+func TestScrapeSpaceDBRowError(t *testing.T) {
+	db, mock := newMock(t)
+	defer db.Close()
+
+	expectDatabase(mock, "rowerrortest")
+	mock.ExpectQuery("show spacedb rowerrortest").WillReturnRows(sqlmock.NewRows(spacedbTestColumns).
+		AddRow("0", "PERMANENT", "PERMANENT DATA", "1", "10", "10").
+		RowError(0, errTestRow))
+
+	if err := drainScrape(ScrapeSpaceDBStatus{}, db); err == nil {
+		t.Error("expected an error for the failing row")
+	}
+}
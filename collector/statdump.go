@@ -18,26 +18,252 @@ package collector
 import (
 	"context"
 	"database/sql"
-
+	"fmt"
+	"regexp"
 	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
 )
 
 const (
 	statdump = "statdump"
 
-	statdumpQuery = "show statdump demodb"
+	statdumpQuery = "show statdump %s"
 )
 
 // Metric descriptors.
 var (
 	StatdumpInfo = prometheus.NewDesc(
 		prometheus.BuildFQName(namespace, "statdump", "info"),
-		"Information about CUBRID Statdump", []string{"key"}, nil,
+		"CUBRID statdump values without a dedicated metric.", []string{"database", "key"}, nil,
 	)
+
+	RowsInserted = newDatabaseDesc("", "rows_inserted_total", "Total number of heap records inserted since server start.")
+	RowsUpdated  = newDatabaseDesc("", "rows_updated_total", "Total number of heap records updated since server start.")
+	RowsDeleted  = newDatabaseDesc("", "rows_deleted_total", "Total number of heap records deleted since server start.")
+
+	CommitsTotal = newDatabaseDesc("", "commits_total", "Total number of committed transactions since server start.")
+)
+
+// statdumpMetric maps a statdump key to its own metric.
+type statdumpMetric struct {
+	key       string
+	name      string
+	help      string
+	valueType prometheus.ValueType
+}
+
+// statdumpMetrics lists the well-known statdump keys exported under their own
+// names, as cubrid_statdump_<name>. Other keys are exported as StatdumpInfo.
+var statdumpMetrics = []statdumpMetric{
+	{"Num_data_page_fetches", "data_page_fetches_total", "Total number of data page fetches.", prometheus.CounterValue},
+	{"Num_data_page_dirties", "data_page_dirties_total", "Total number of data pages made dirty.", prometheus.CounterValue},
+	{"Num_data_page_ioreads", "data_page_ioreads_total", "Total number of data pages read from disk.", prometheus.CounterValue},
+	{"Num_data_page_iowrites", "data_page_iowrites_total", "Total number of data pages written to disk.", prometheus.CounterValue},
+	{"Num_data_page_victims", "data_page_victims_total", "Total number of data pages evicted from the buffer.", prometheus.CounterValue},
+	{"Num_data_page_fixed", "data_page_fixed", "Number of data pages currently fixed in the buffer.", prometheus.GaugeValue},
+	{"Num_data_page_dirty", "data_page_dirty", "Number of dirty data pages in the buffer.", prometheus.GaugeValue},
+	{"Data_page_buffer_hit_ratio", "data_page_buffer_hit_ratio", "Data page buffer hit ratio in percent.", prometheus.GaugeValue},
+
+	{"Num_log_page_fetches", "log_page_fetches_total", "Total number of log page fetches.", prometheus.CounterValue},
+	{"Num_log_page_ioreads", "log_page_ioreads_total", "Total number of log pages read from disk.", prometheus.CounterValue},
+	{"Num_log_page_iowrites", "log_page_iowrites_total", "Total number of log pages written to disk.", prometheus.CounterValue},
+	{"Num_log_append_records", "log_append_records_total", "Total number of log records appended.", prometheus.CounterValue},
+	{"Num_log_checkpoints", "log_checkpoints_total", "Total number of checkpoints.", prometheus.CounterValue},
+	{"Log_page_buffer_hit_ratio", "log_page_buffer_hit_ratio", "Log page buffer hit ratio in percent.", prometheus.GaugeValue},
+
+	{"Num_query_selects", "query_selects_total", "Total number of SELECT queries executed.", prometheus.CounterValue},
+	{"Num_query_inserts", "query_inserts_total", "Total number of INSERT queries executed.", prometheus.CounterValue},
+	{"Num_query_updates", "query_updates_total", "Total number of UPDATE queries executed.", prometheus.CounterValue},
+	{"Num_query_deletes", "query_deletes_total", "Total number of DELETE queries executed.", prometheus.CounterValue},
+	{"Num_query_sscans", "query_sscans_total", "Total number of sequential scans.", prometheus.CounterValue},
+	{"Num_query_iscans", "query_iscans_total", "Total number of index scans.", prometheus.CounterValue},
+	{"Num_query_lscans", "query_lscans_total", "Total number of list scans.", prometheus.CounterValue},
+	{"Num_query_nljoins", "query_nljoins_total", "Total number of nested loop joins.", prometheus.CounterValue},
+	{"Num_query_mjoins", "query_mjoins_total", "Total number of merge joins.", prometheus.CounterValue},
+	{"Num_query_objfetches", "query_objfetches_total", "Total number of object fetches.", prometheus.CounterValue},
+
+	{"Num_heap_home_inserts", "heap_home_inserts_total", "Total number of records inserted into their home page.", prometheus.CounterValue},
+	{"Num_heap_big_inserts", "heap_big_inserts_total", "Total number of big records inserted.", prometheus.CounterValue},
+	{"Num_heap_home_deletes", "heap_home_deletes_total", "Total number of records deleted from their home page.", prometheus.CounterValue},
+	{"Num_heap_home_updates", "heap_home_updates_total", "Total number of records updated in their home page.", prometheus.CounterValue},
+
+	{"Num_btree_inserts", "btree_inserts_total", "Total number of B-tree key insertions.", prometheus.CounterValue},
+	{"Num_btree_deletes", "btree_deletes_total", "Total number of B-tree key deletions.", prometheus.CounterValue},
+	{"Num_btree_updates", "btree_updates_total", "Total number of B-tree key updates.", prometheus.CounterValue},
+	{"Num_btree_covered", "btree_covered_total", "Total number of covering index scans.", prometheus.CounterValue},
+	{"Num_btree_noncovered", "btree_noncovered_total", "Total number of non-covering index scans.", prometheus.CounterValue},
+	{"Num_btree_splits", "btree_splits_total", "Total number of B-tree page splits.", prometheus.CounterValue},
+	{"Num_btree_merges", "btree_merges_total", "Total number of B-tree page merges.", prometheus.CounterValue},
+}
+
+// statdumpDescs holds the descriptors of statdumpMetrics by key.
+var statdumpDescs = func() map[string]*prometheus.Desc {
+	descs := make(map[string]*prometheus.Desc, len(statdumpMetrics))
+	for _, m := range statdumpMetrics {
+		descs[m.key] = newDatabaseDesc("statdump", m.name, m.help)
+	}
+	return descs
+}()
+
+// statdumpValueTypes holds the value types of statdumpMetrics by key.
+var statdumpValueTypes = func() map[string]prometheus.ValueType {
+	types := make(map[string]prometheus.ValueType, len(statdumpMetrics))
+	for _, m := range statdumpMetrics {
+		types[m.key] = m.valueType
+	}
+	return types
+}()
+
+// statdumpKeyPlan is the processing of a statdump key, compiled once from the
+// mapping tables so the values of the key are processed without consulting
+// them again.
+type statdumpKeyPlan struct {
+	// desc is the dedicated descriptor of the key, nil for the others.
+	desc      *prometheus.Desc
+	valueType prometheus.ValueType
+	// consumed reports whether a dedicated metric or a derived counter uses the key.
+	consumed bool
+	// info reports whether StatdumpInfo exports the key, which are the keys
+	// without a dedicated descriptor except the commit counter.
+	info bool
+	// subsystem is the activity subsystem of a counter, empty for gauges and
+	// keys outside the subsystems.
+	subsystem string
+}
+
+// statdumpPlans caches the compiled plans by key. Keys beyond
+// maxCoverageItems are compiled on every use rather than cached, bounding
+// the cache against servers reporting unexpected keys.
+var statdumpPlans = struct {
+	sync.RWMutex
+	plans map[string]*statdumpKeyPlan
+}{plans: map[string]*statdumpKeyPlan{}}
+
+// statdumpPlan returns the compiled plan of the key.
+func statdumpPlan(key string) *statdumpKeyPlan {
+	statdumpPlans.RLock()
+	plan, ok := statdumpPlans.plans[key]
+	statdumpPlans.RUnlock()
+	if ok {
+		return plan
+	}
+
+	plan = compileStatdumpPlan(key)
+	statdumpPlans.Lock()
+	if len(statdumpPlans.plans) < maxCoverageItems {
+		statdumpPlans.plans[key] = plan
+	}
+	statdumpPlans.Unlock()
+	return plan
+}
+
+// compileStatdumpPlan compiles the plan of the key from the mapping tables.
+func compileStatdumpPlan(key string) *statdumpKeyPlan {
+	plan := &statdumpKeyPlan{desc: statdumpDescs[key], valueType: prometheus.GaugeValue, consumed: statdumpConsumed(key)}
+	if plan.desc != nil {
+		plan.valueType = statdumpValueTypes[key]
+	}
+	plan.info = plan.desc == nil && key != statdumpCommitsKey
+	if plan.desc == nil || plan.valueType == prometheus.CounterValue {
+		plan.subsystem = subsystemOfStatdumpKey(key)
+	}
+	return plan
+}
+
+// Extended statistics are only populated when the server parameter
+// extendedStatsParameter is enabled.
+const extendedStatsParameter = "extended_statistic_activation"
+
+var (
+	// statdumpBaseKey is always present in statdump output.
+	statdumpBaseKey = "Num_data_page_fetches"
+	// statdumpExtendedKeys are only present with extended statistics enabled.
+	statdumpExtendedKeys = []string{"Num_data_page_fix_ext", "Num_data_page_promote_ext", "Num_data_page_unfix_ext", "Num_mvcc_snapshot_ext"}
+
+	ExtendedStatsAvailable = newDatabaseDesc(exporter, "extended_stats_available",
+		"Whether the server reports extended statdump statistics (1 for available).")
 )
 
+// extendedStatsCollectors are the curated collectors reading extended
+// statistics. They are skipped with reason="stats_level" while the last
+// statdump scrape of the target found the statistics disabled, and resume
+// once it finds them enabled.
+var extendedStatsCollectors = map[string]bool{}
+
+// extendedStatsState remembers the last detected availability per target and
+// database so the actionable message is only logged on a change.
+var extendedStatsState = struct {
+	sync.Mutex
+	available map[string]bool
+}{available: map[string]bool{}}
+
+// checkExtendedStats reports whether extended statistics are available, or
+// false for ok when the output doesn't contain the base statistics either.
+func checkExtendedStats(ctx context.Context, database string, values map[string]float64) (available, ok bool) {
+	if _, ok := values[statdumpBaseKey]; !ok {
+		return false, false
+	}
+	for _, key := range statdumpExtendedKeys {
+		if _, ok := values[key]; ok {
+			available = true
+			break
+		}
+	}
+
+	key := targetScoped(ctx, database)
+	extendedStatsState.Lock()
+	defer extendedStatsState.Unlock()
+	if previous, known := extendedStatsState.available[key]; !known || previous != available {
+		if available {
+			log.Infof("Extended statdump statistics are available for %s", database)
+		} else {
+			log.Warnf("Extended statdump statistics are not collected for %s; set %s=yes in cubrid.conf to enable them", database, extendedStatsParameter)
+		}
+	}
+	extendedStatsState.available[key] = available
+	return available, true
+}
+
+// extendedStatsMissing reports whether the last statdump scrape of target
+// found the extended statistics disabled on every database it checked.
+func extendedStatsMissing(target string) bool {
+	extendedStatsState.Lock()
+	defer extendedStatsState.Unlock()
+	missing := false
+	for key, available := range extendedStatsState.available {
+		if !strings.HasPrefix(key, target+"/") {
+			continue
+		}
+		if available {
+			return false
+		}
+		missing = true
+	}
+	return missing
+}
+
+// statdumpCommitsKey counts committed transactions. The server reports no
+// time of the last commit, so recency is left to rate() on the counter.
+const statdumpCommitsKey = "Num_tran_commits"
+
+// statdumpRowCounters derives per-row DML counters from the heap statistics,
+// summing the keys of every record placement (home, relocated, big).
+var statdumpRowCounters = []struct {
+	desc *prometheus.Desc
+	keys []string
+}{
+	{RowsInserted, []string{"Num_heap_home_inserts", "Num_heap_big_inserts", "Num_heap_assign_inserts"}},
+	{RowsUpdated, []string{"Num_heap_home_updates", "Num_heap_home_to_rel_updates", "Num_heap_home_to_big_updates",
+		"Num_heap_rel_updates", "Num_heap_rel_to_home_updates", "Num_heap_rel_to_rel_updates", "Num_heap_rel_to_big_updates",
+		"Num_heap_big_updates"}},
+	{RowsDeleted, []string{"Num_heap_home_deletes", "Num_heap_home_mvcc_deletes", "Num_heap_rel_deletes",
+		"Num_heap_rel_mvcc_deletes", "Num_heap_big_deletes", "Num_heap_big_mvcc_deletes"}},
+}
+
 // ScrapeStatdump
 type ScrapeStatdump struct{}
 
@@ -58,31 +284,103 @@ func (ScrapeStatdump) Version() float64 {
 
 // Scrape collects data from database connection and sends it over channel as prometheus metric.
 func (ScrapeStatdump) Scrape(ctx context.Context, db *sql.DB, ch chan<- prometheus.Metric) error {
+	return scrapeDatabases(ctx, db, func(database string) (int, error) {
+		return scrapeStatdumpDatabase(ctx, db, database, ch)
+	})
+}
 
-	statdumpRows, err := db.QueryContext(ctx, statdumpQuery)
+func scrapeStatdumpDatabase(ctx context.Context, db *sql.DB, database string, ch chan<- prometheus.Metric) (int, error) {
+	statdumpRows, err := db.QueryContext(ctx, fmt.Sprintf(statdumpQuery, database))
 	if err != nil {
-		return err
+		return 0, err
 	}
 
+	defer statdumpRows.Close()
+
 	var key string
 	var value string
+	values := map[string]float64{}
+	rows := 0
 
 	for statdumpRows.Next() {
+		rows++
 
 		err := statdumpRows.Scan(&key, &value)
 		if err != nil {
-			return err
+			return rows, err
 		}
+		plan := statdumpPlan(key)
+		Coverage.observe(coverageStatdump, key, plan.consumed)
 
-		floatValue, err := strconv.ParseFloat(value, 64)
-		if err != nil {
-			return err
+		floatValue, ok := parseStatdumpValue(value)
+		if !ok {
+			loggerFrom(ctx).Debugf("Skipping non-numeric statdump value %s=%q of %s", key, value, database)
+			reportAnomaly(ctx, anomalyUnparsedValue, fmt.Sprintf("%s of %s: %q", key, database, value))
+			continue
 		}
 
-		ch <- prometheus.MustNewConstMetric(StatdumpInfo, prometheus.GaugeValue, floatValue, key)
+		if _, ok := values[key]; ok {
+			reportAnomaly(ctx, anomalyDuplicateKey, key)
+			continue
+		}
+		values[key] = floatValue
+		if plan.desc != nil {
+			ch <- prometheus.MustNewConstMetric(plan.desc, plan.valueType, floatValue, database)
+		} else if plan.info {
+			ch <- prometheus.MustNewConstMetric(StatdumpInfo, prometheus.GaugeValue, floatValue, database, key)
+		}
+	}
+	if err := statdumpRows.Err(); err != nil {
+		return rows, err
 	}
 
-	return nil
+	for _, counter := range statdumpRowCounters {
+		sum, found := 0.0, false
+		for _, key := range counter.keys {
+			if v, ok := values[key]; ok {
+				sum += v
+				found = true
+			}
+		}
+		if found {
+			ch <- prometheus.MustNewConstMetric(counter.desc, prometheus.CounterValue, sum, database)
+		}
+	}
+
+	if v, ok := values[statdumpCommitsKey]; ok {
+		ch <- prometheus.MustNewConstMetric(CommitsTotal, prometheus.CounterValue, v, database)
+	}
+
+	scrapeActivity(ctx, database, values, ch)
+
+	if available, ok := checkExtendedStats(ctx, database, values); ok {
+		v := 0.0
+		if available {
+			v = 1
+		}
+		ch <- prometheus.MustNewConstMetric(ExtendedStatsAvailable, prometheus.GaugeValue, v, database)
+	}
+
+	return rows, nil
+}
+
+// statdumpUnitRE matches a value with thousands separators and an optional
+// trailing unit, e.g. "1,024 pages" or "98.5%".
+var statdumpUnitRE = regexp.MustCompile(`^(-?[0-9][0-9,]*(?:\.[0-9]+)?)\s*[A-Za-z%]*$`)
+
+// parseStatdumpValue parses a statdump value, normalizing thousands
+// separators and trailing units. Timestamps and grouped output are not numeric.
+func parseStatdumpValue(value string) (float64, bool) {
+	value = strings.TrimSpace(value)
+	if v, err := strconv.ParseFloat(value, 64); err == nil {
+		return v, true
+	}
+	m := statdumpUnitRE.FindStringSubmatch(value)
+	if m == nil {
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(strings.Replace(m[1], ",", "", -1), 64)
+	return v, err == nil
 }
 
 // check interface
@@ -0,0 +1,265 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var statdumpTestColumns = []string{"key", "value"}
+
+func TestScrapeStatdump(t *testing.T) {
+	db, mock := newMock(t)
+	defer db.Close()
+
+	expectDatabase(mock, "statdumptest")
+	mock.ExpectQuery("show statdump statdumptest").WillReturnRows(sqlmock.NewRows(statdumpTestColumns).
+		AddRow("Num_data_page_fetches", "1,024").
+		AddRow("Data_page_buffer_hit_ratio", "98.5%").
+		AddRow("Num_heap_home_inserts", "3").
+		AddRow("Num_heap_big_inserts", "2").
+		AddRow("Num_tran_commits", "7").
+		AddRow("Num_unheard_of", "11").
+		AddRow("Time_data_page_lock_acquire_time", "2020-01-01 00:00:00"))
+
+	expected := `
+# HELP cubrid_statdump_data_page_fetches_total Total number of data page fetches.
+# TYPE cubrid_statdump_data_page_fetches_total counter
+cubrid_statdump_data_page_fetches_total{database="statdumptest"} 1024
+# HELP cubrid_statdump_data_page_buffer_hit_ratio Data page buffer hit ratio in percent.
+# TYPE cubrid_statdump_data_page_buffer_hit_ratio gauge
+cubrid_statdump_data_page_buffer_hit_ratio{database="statdumptest"} 98.5
+# HELP cubrid_statdump_info CUBRID statdump values without a dedicated metric.
+# TYPE cubrid_statdump_info gauge
+cubrid_statdump_info{database="statdumptest",key="Num_unheard_of"} 11
+# HELP cubrid_rows_inserted_total Total number of heap records inserted since server start.
+# TYPE cubrid_rows_inserted_total counter
+cubrid_rows_inserted_total{database="statdumptest"} 5
+# HELP cubrid_commits_total Total number of committed transactions since server start.
+# TYPE cubrid_commits_total counter
+cubrid_commits_total{database="statdumptest"} 7
+# HELP cubrid_exporter_extended_stats_available Whether the server reports extended statdump statistics (1 for available).
+# TYPE cubrid_exporter_extended_stats_available gauge
+cubrid_exporter_extended_stats_available{database="statdumptest"} 0
+`
+	if err := testutil.CollectAndCompare(scrapeCollector{t, ScrapeStatdump{}, db}, strings.NewReader(expected),
+		"cubrid_statdump_data_page_fetches_total", "cubrid_statdump_data_page_buffer_hit_ratio", "cubrid_statdump_info",
+		"cubrid_rows_inserted_total", "cubrid_commits_total", "cubrid_exporter_extended_stats_available"); err != nil {
+		t.Error(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err)
+	}
+}
+
+// scrapeExtendedStats scrapes the statdump of demodb on target, with the
+// extended statistics if extended.
+func scrapeExtendedStats(t *testing.T, target string, extended bool) float64 {
+	db, mock := newMock(t)
+	defer db.Close()
+	expectDatabase(mock, "demodb")
+	rows := sqlmock.NewRows(statdumpTestColumns).AddRow(statdumpBaseKey, "10")
+	if extended {
+		rows.AddRow(statdumpExtendedKeys[0], "5")
+	}
+	mock.ExpectQuery("show statdump demodb").WillReturnRows(rows)
+
+	ch := make(chan prometheus.Metric, 100)
+	if err := (ScrapeStatdump{}).Scrape(withTarget(context.Background(), target), db, ch); err != nil {
+		t.Fatal(err)
+	}
+	close(ch)
+	for metric := range ch {
+		if metric.Desc() == ExtendedStatsAvailable {
+			var m dto.Metric
+			metric.Write(&m)
+			return m.GetGauge().GetValue()
+		}
+	}
+	t.Fatal("no cubrid_exporter_extended_stats_available sample")
+	return 0
+}
+
+// skippedCollectors returns the reasons of the collectors the exporter
+// skipped by collector.
+func skippedCollectors(e *Exporter) map[string]string {
+	ch := make(chan prometheus.Metric)
+	done := make(chan map[string]string)
+	go func() {
+		skipped := map[string]string{}
+		for metric := range ch {
+			if metric.Desc() != collectorSkippedDesc {
+				continue
+			}
+			var m dto.Metric
+			metric.Write(&m)
+			labels := map[string]string{}
+			for _, l := range m.Label {
+				labels[l.GetName()] = l.GetValue()
+			}
+			skipped[labels["collector"]] = labels["reason"]
+		}
+		done <- skipped
+	}()
+	e.Collect(ch)
+	close(ch)
+	return <-done
+}
+
+// TestExtendedStatsSkip checks that the collectors of extended statistics
+// are skipped with reason="stats_level" while the server does not collect
+// them, and resume once it does.
+func TestExtendedStatsSkip(t *testing.T) {
+	extendedStatsCollectors["fake_ext"] = true
+	t.Cleanup(func() { delete(extendedStatsCollectors, "fake_ext") })
+	target := dsnTarget(SimulatedDSN)
+	t.Cleanup(func() {
+		extendedStatsState.Lock()
+		delete(extendedStatsState.available, target+"/demodb")
+		extendedStatsState.Unlock()
+	})
+	extScraper, baseScraper := &countingScraper{name: "fake_ext"}, &countingScraper{name: "fake_base"}
+	scrape := func() map[string]string {
+		return skippedCollectors(New(context.Background(), SimulatedDSN, NewMetrics(), []Scraper{extScraper, baseScraper}, nil))
+	}
+
+	// Before the first statdump scrape the level is unknown.
+	if skipped := scrape(); len(skipped) != 0 || extScraper.runs != 1 {
+		t.Errorf("unknown stats level: skipped %v, runs %d; want none skipped, 1 run", skipped, extScraper.runs)
+	}
+
+	if v := scrapeExtendedStats(t, target, false); v != 0 {
+		t.Errorf("extended_stats_available without extended statistics = %v, want 0", v)
+	}
+	if skipped := scrape(); skipped["collect.fake_ext"] != skipReasonStatsLevel || len(skipped) != 1 {
+		t.Errorf("skipped collectors = %v, want only collect.fake_ext for %s", skipped, skipReasonStatsLevel)
+	}
+	if extScraper.runs != 1 || baseScraper.runs != 2 {
+		t.Errorf("runs = %d, %d, want the extended collector skipped and the base one run", extScraper.runs, baseScraper.runs)
+	}
+	if extendedStatsMissing("other:33000:demodb") {
+		t.Error("the stats level of another target skips its collectors")
+	}
+
+	if v := scrapeExtendedStats(t, target, true); v != 1 {
+		t.Errorf("extended_stats_available with extended statistics = %v, want 1", v)
+	}
+	if skipped := scrape(); len(skipped) != 0 || extScraper.runs != 2 {
+		t.Errorf("enabled stats level: skipped %v, runs %d; want none skipped, 2 runs", skipped, extScraper.runs)
+	}
+}
+
+// BenchmarkStatdumpPlan compares the cached plans of the keys of the large
+// statdump fixture with compiling them on every use.
+func BenchmarkStatdumpPlan(b *testing.B) {
+	fixture := benchFixture(b, statdump)
+	for _, bc := range []struct {
+		name string
+		plan func(string) *statdumpKeyPlan
+	}{
+		{"cached", statdumpPlan},
+		{"compiled", compileStatdumpPlan},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				for _, row := range fixture.Rows {
+					bc.plan(*row[0])
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkScrapeStatdump scrapes the large statdump fixture.
+func BenchmarkScrapeStatdump(b *testing.B) {
+	fixture := benchFixture(b, statdump)
+	db, mock := newMock(b)
+	defer db.Close()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		expectDatabase(mock, "demodb")
+		mock.ExpectQuery(fixture.Query).WillReturnRows(fixtureRows(fixture))
+		b.StartTimer()
+		if err := drainScrape(ScrapeStatdump{}, db); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// TestScrapeStatdumpCommits replays both shapes a commit source can take.
+// The counter is exported as cubrid_commits_total, also while it stays flat
+// on an idle database. A time of the last commit is not mixed with the
+// exporter's clock, so no age or commit metric is derived from it.
+func TestScrapeStatdumpCommits(t *testing.T) {
+	_, fixtures, err := LoadFixtures(filepath.Join("testdata", "fixtures", "commits"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fixtures) != 2 {
+		t.Fatalf("commit fixtures = %d, want 2", len(fixtures))
+	}
+	counter, timestamp := fixtures[0], fixtures[1]
+
+	for _, tc := range []struct {
+		name     string
+		fixture  Fixture
+		expected string
+	}{
+		{"counter", counter, `
+# HELP cubrid_commits_total Total number of committed transactions since server start.
+# TYPE cubrid_commits_total counter
+cubrid_commits_total{database="demodb"} 7
+# HELP cubrid_statdump_info CUBRID statdump values without a dedicated metric.
+# TYPE cubrid_statdump_info gauge
+cubrid_statdump_info{database="demodb",key="Num_tran_rollbacks"} 1
+`},
+		{"idle counter", counter, `
+# HELP cubrid_commits_total Total number of committed transactions since server start.
+# TYPE cubrid_commits_total counter
+cubrid_commits_total{database="demodb"} 7
+# HELP cubrid_statdump_info CUBRID statdump values without a dedicated metric.
+# TYPE cubrid_statdump_info gauge
+cubrid_statdump_info{database="demodb",key="Num_tran_rollbacks"} 1
+`},
+		{"timestamp", timestamp, `
+# HELP cubrid_statdump_info CUBRID statdump values without a dedicated metric.
+# TYPE cubrid_statdump_info gauge
+cubrid_statdump_info{database="demodb",key="Num_tran_rollbacks"} 1
+`},
+	} {
+		db, mock := newMock(t)
+		expectDatabase(mock, "demodb")
+		mock.ExpectQuery(tc.fixture.Query).WillReturnRows(fixtureRows(tc.fixture))
+		if err := testutil.CollectAndCompare(scrapeCollector{t, ScrapeStatdump{}, db}, strings.NewReader(tc.expected),
+			"cubrid_commits_total", "cubrid_last_commit_age_seconds", "cubrid_statdump_info"); err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("%s: unfulfilled expectations: %s", tc.name, err)
+		}
+		db.Close()
+	}
+}
@@ -0,0 +1,362 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Collector state persisted across exporter restarts.
+
+package collector
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	stateFile = kingpin.Flag(
+		"exporter.state-file",
+		"File persisting collector state across restarts, such as the last port of every broker. Empty keeps it in memory only.",
+	).Default("").String()
+	stateSectionMaxBytes = kingpin.Flag(
+		"exporter.state-file.section-max-bytes",
+		"Maximum size of the state of one feature in the state file. Features evict their oldest entries to fit.",
+	).Default("1048576").Int()
+)
+
+// StateSaver is a feature persisting its state in a section of the state file.
+type StateSaver interface {
+	// Section identifies the section of the feature in the state file.
+	Section() string
+	// Version is the format version of the section. Sections written with
+	// another version are dropped on load.
+	Version() uint16
+	// MarshalState returns the state in at most maxBytes, evicting entries by
+	// the feature's own policy to fit, and the number of entries evicted.
+	MarshalState(maxBytes int) ([]byte, int, error)
+	// UnmarshalState replaces the state with data written by MarshalState.
+	UnmarshalState(data []byte) error
+}
+
+// stateSavers are the features persisting state, in the order of their sections.
+var stateSavers = []StateSaver{brokerPortState{}}
+
+// RegisterStateSaver adds the state of a feature created at startup, such as
+// the high-water marks, to the state file. It must be called before LoadState.
+func RegisterStateSaver(saver StateSaver) {
+	stateSavers = append(stateSavers, saver)
+}
+
+// The state file starts with stateMagic and the format version, followed by
+// the sections. Each section is the length-prefixed identifier, the section
+// version, the length of the data, the CRC-32 of identifier, version and
+// data, and the data itself. A corrupt section is skipped by its length; a
+// truncated one ends the file.
+const (
+	stateMagic         = "CUBRIDST"
+	stateFormatVersion = 1
+)
+
+// Reasons a section of the state file is dropped on load.
+const (
+	stateDropChecksum  = "checksum"
+	stateDropTruncated = "truncated"
+	stateDropVersion   = "version"
+	stateDropDecode    = "decode"
+	stateDropUnknown   = "unknown_section"
+)
+
+var (
+	stateSectionsDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: exporter,
+		Name:      "state_sections_dropped_total",
+		Help:      "Number of state file sections dropped on load, by reason.",
+	}, []string{"section", "reason"})
+	stateEntriesEvicted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: exporter,
+		Name:      "state_entries_evicted_total",
+		Help:      "Number of entries a feature evicted to keep its state file section within --exporter.state-file.section-max-bytes.",
+	}, []string{"section"})
+)
+
+// StateFileMetrics exports the integrity and size budget of the state file.
+var StateFileMetrics prometheus.Collector = stateFileMetrics{}
+
+type stateFileMetrics struct{}
+
+// Describe implements prometheus.Collector.
+func (stateFileMetrics) Describe(ch chan<- *prometheus.Desc) {
+	stateSectionsDropped.Describe(ch)
+	stateEntriesEvicted.Describe(ch)
+	stateUndecryptable.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (stateFileMetrics) Collect(ch chan<- prometheus.Metric) {
+	stateSectionsDropped.Collect(ch)
+	stateEntriesEvicted.Collect(ch)
+	stateUndecryptable.Collect(ch)
+}
+
+// stateSection is a section as stored in the state file.
+type stateSection struct {
+	id      string
+	version uint16
+	data    []byte
+}
+
+// stateFileMu serializes writes of the state file.
+var stateFileMu sync.Mutex
+
+// stateDirty is set by features whose state changed in a way worth saving
+// before the exporter shuts down. The state is then saved once at the end of
+// the scrape instead of on every change.
+var stateDirty int32
+
+// markStateDirty requests the state to be saved at the end of the scrape.
+func markStateDirty() {
+	atomic.StoreInt32(&stateDirty, 1)
+}
+
+// saveStateIfDirty saves the state if it was marked dirty since the last
+// save. A failed save is retried after the next scrape.
+func saveStateIfDirty() error {
+	if !atomic.CompareAndSwapInt32(&stateDirty, 1, 0) {
+		return nil
+	}
+	err := SaveState()
+	if err != nil {
+		markStateDirty()
+	}
+	return err
+}
+
+// LoadState restores the state persisted in --exporter.state-file. A missing
+// file is not an error. Intact sections are restored even if others are
+// corrupt; the dropped ones are counted in
+// cubrid_exporter_state_sections_dropped_total. A file none of the keys of
+// --state.encryption-key-file decrypts is ignored like a missing one.
+func LoadState() error {
+	if *stateFile == "" {
+		return nil
+	}
+	// A temporary file is only left behind by a crash while writing, in which
+	// case the previous state file is still intact.
+	if err := os.Remove(*stateFile + ".tmp"); err == nil {
+		log.Warnf("Removed the incomplete state file %s.tmp of an interrupted write", *stateFile)
+	}
+	keys, err := stateKeys()
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(*stateFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	data, err = decryptState(data, keys)
+	if err == errStateUndecryptable {
+		// Like a missing file, the exporter starts without persisted state.
+		stateUndecryptable.Inc()
+		log.Warnf("Ignoring the state file %s: %s", *stateFile, err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	sections, err := decodeStateFile(data)
+	if err != nil {
+		return err
+	}
+
+	savers := make(map[string]StateSaver, len(stateSavers))
+	for _, saver := range stateSavers {
+		savers[saver.Section()] = saver
+	}
+	for _, section := range sections {
+		saver, ok := savers[section.id]
+		switch {
+		case !ok:
+			dropStateSection(section.id, stateDropUnknown, nil)
+		case section.version != saver.Version():
+			dropStateSection(section.id, stateDropVersion, fmt.Errorf("version %d, want %d", section.version, saver.Version()))
+		default:
+			if err := saver.UnmarshalState(section.data); err != nil {
+				dropStateSection(section.id, stateDropDecode, err)
+			}
+		}
+	}
+	return nil
+}
+
+func dropStateSection(section, reason string, err error) {
+	stateSectionsDropped.WithLabelValues(section, reason).Inc()
+	log.Warnf("Dropped section %s of the state file (%s): %v", section, reason, err)
+}
+
+// decodeStateFile returns the intact sections of the state file data.
+func decodeStateFile(data []byte) ([]stateSection, error) {
+	if len(data) < len(stateMagic)+2 || string(data[:len(stateMagic)]) != stateMagic {
+		return nil, errors.New("not a state file")
+	}
+	if version := binary.BigEndian.Uint16(data[len(stateMagic):]); version != stateFormatVersion {
+		return nil, fmt.Errorf("unsupported state file format version %d", version)
+	}
+	r := bytes.NewReader(data[len(stateMagic)+2:])
+
+	var sections []stateSection
+	for r.Len() > 0 {
+		var idLen uint16
+		if err := binary.Read(r, binary.BigEndian, &idLen); err != nil {
+			dropStateSection("", stateDropTruncated, err)
+			break
+		}
+		id := make([]byte, idLen)
+		var header struct {
+			Version uint16
+			Length  uint32
+			CRC     uint32
+		}
+		if _, err := io.ReadFull(r, id); err != nil {
+			dropStateSection("", stateDropTruncated, err)
+			break
+		}
+		if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+			dropStateSection(string(id), stateDropTruncated, err)
+			break
+		}
+		if int64(header.Length) > int64(r.Len()) {
+			dropStateSection(string(id), stateDropTruncated, fmt.Errorf("%d bytes of %d left", r.Len(), header.Length))
+			break
+		}
+		section := make([]byte, header.Length)
+		io.ReadFull(r, section)
+		if stateChecksum(id, header.Version, section) != header.CRC {
+			dropStateSection(string(id), stateDropChecksum, nil)
+			continue
+		}
+		sections = append(sections, stateSection{id: string(id), version: header.Version, data: section})
+	}
+	return sections, nil
+}
+
+// stateChecksum is the CRC-32 of a section's identifier, version and data.
+func stateChecksum(id []byte, version uint16, data []byte) uint32 {
+	h := crc32.NewIEEE()
+	h.Write(id)
+	binary.Write(h, binary.BigEndian, version)
+	h.Write(data)
+	return h.Sum32()
+}
+
+// SaveState writes the current state to --exporter.state-file. The file is
+// replaced atomically, so a crash leaves either the old or the new state.
+// It is encrypted with the first key of --state.encryption-key-file.
+func SaveState() error {
+	if *stateFile == "" {
+		return nil
+	}
+	var buf bytes.Buffer
+	buf.WriteString(stateMagic)
+	binary.Write(&buf, binary.BigEndian, uint16(stateFormatVersion))
+	for _, saver := range stateSavers {
+		data, evicted, err := saver.MarshalState(*stateSectionMaxBytes)
+		if err != nil {
+			return fmt.Errorf("section %s: %s", saver.Section(), err)
+		}
+		if evicted > 0 {
+			stateEntriesEvicted.WithLabelValues(saver.Section()).Add(float64(evicted))
+		}
+		id := []byte(saver.Section())
+		binary.Write(&buf, binary.BigEndian, uint16(len(id)))
+		buf.Write(id)
+		binary.Write(&buf, binary.BigEndian, saver.Version())
+		binary.Write(&buf, binary.BigEndian, uint32(len(data)))
+		binary.Write(&buf, binary.BigEndian, stateChecksum(id, saver.Version(), data))
+		buf.Write(data)
+	}
+
+	keys, err := stateKeys()
+	if err != nil {
+		return err
+	}
+	data, err := encryptState(buf.Bytes(), keys)
+	if err != nil {
+		return err
+	}
+
+	stateFileMu.Lock()
+	defer stateFileMu.Unlock()
+	return writeFileAtomic(*stateFile, data)
+}
+
+// writeFileAtomic replaces path with data through a synced temporary file
+// named path.tmp, so a crash leaves either the old or the new content.
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// marshalWithinBudget encodes entries as JSON, evicting entries in the order
+// of evict until the encoding fits in maxBytes. It returns the encoding and
+// the number of entries evicted.
+func marshalWithinBudget(entries map[string]json.RawMessage, evict []string, maxBytes int) ([]byte, int, error) {
+	evicted := 0
+	for {
+		data, err := json.Marshal(entries)
+		if err != nil || len(data) <= maxBytes || len(entries) == 0 {
+			return data, evicted, err
+		}
+		if evicted == len(evict) {
+			return nil, evicted, fmt.Errorf("%d entries exceed %d bytes", len(entries), maxBytes)
+		}
+		// Evict roughly the share of entries exceeding the budget at once.
+		n := len(entries) * (len(data) - maxBytes) / len(data)
+		if n < 1 {
+			n = 1
+		}
+		for ; n > 0 && evicted < len(evict); n-- {
+			delete(entries, evict[evicted])
+			evicted++
+		}
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Encryption of the state file at rest.
+
+package collector
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+var stateKeyFiles = kingpin.Flag(
+	"state.encryption-key-file",
+	"File with a 32-byte AES-256 key, raw or hex-encoded, encrypting the state file at rest. Repeat to rotate keys: the first key encrypts, all are tried to decrypt.",
+).Strings()
+
+// An encrypted state file starts with stateEncryptedMagic and the encryption
+// format version, followed by the GCM nonce and the sealed plain state file.
+// Magic and version are authenticated as additional data.
+const (
+	stateEncryptedMagic   = "CUBRIDSE"
+	stateEncryptedVersion = 1
+	stateKeySize          = 32
+)
+
+// errStateUndecryptable is returned for an encrypted state file none of the
+// keys decrypts.
+var errStateUndecryptable = errors.New("no key decrypts the state file")
+
+var stateUndecryptable = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: namespace,
+	Subsystem: exporter,
+	Name:      "state_file_undecryptable_total",
+	Help:      "Number of times the state file could not be decrypted with any key of --state.encryption-key-file and was treated as absent.",
+})
+
+// CheckStateKeys reads the keys of --state.encryption-key-file, so that a
+// missing or invalid key fails at startup.
+func CheckStateKeys() error {
+	_, err := stateKeys()
+	return err
+}
+
+// stateKeys returns the AEADs of the keys of --state.encryption-key-file,
+// the one to encrypt with first. The files are read on every use, so keys
+// are rotated without a restart.
+func stateKeys() ([]cipher.AEAD, error) {
+	var aeads []cipher.AEAD
+	for _, file := range *stateKeyFiles {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		key, err := parseStateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("key file %s: %s", file, err)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		aeads = append(aeads, aead)
+	}
+	return aeads, nil
+}
+
+// parseStateKey accepts a raw or a hex-encoded key.
+func parseStateKey(data []byte) ([]byte, error) {
+	if len(data) == stateKeySize {
+		return data, nil
+	}
+	if key, err := hex.DecodeString(string(bytes.TrimSpace(data))); err == nil && len(key) == stateKeySize {
+		return key, nil
+	}
+	return nil, fmt.Errorf("want %d raw or %d hex-encoded bytes", stateKeySize, 2*stateKeySize)
+}
+
+func stateEncryptionHeader() []byte {
+	header := make([]byte, len(stateEncryptedMagic)+2)
+	copy(header, stateEncryptedMagic)
+	binary.BigEndian.PutUint16(header[len(stateEncryptedMagic):], stateEncryptedVersion)
+	return header
+}
+
+// encryptState seals data with the first of keys. Without keys data is
+// returned unchanged.
+func encryptState(data []byte, keys []cipher.AEAD) ([]byte, error) {
+	if len(keys) == 0 {
+		return data, nil
+	}
+	header := stateEncryptionHeader()
+	nonce := make([]byte, keys[0].NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	sealed := append(header, nonce...)
+	return keys[0].Seal(sealed, nonce, data, header), nil
+}
+
+// decryptState opens an encrypted state file with the first key that
+// decrypts it. A plain state file is returned unchanged, so enabling
+// encryption keeps the state; it is encrypted on the next write.
+func decryptState(data []byte, keys []cipher.AEAD) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte(stateEncryptedMagic)) {
+		return data, nil
+	}
+	header := stateEncryptionHeader()
+	if len(data) < len(header) || !bytes.Equal(data[:len(header)], header) {
+		return nil, fmt.Errorf("unsupported state file encryption version")
+	}
+	for _, key := range keys {
+		if len(data) < len(header)+key.NonceSize() {
+			break
+		}
+		nonce := data[len(header) : len(header)+key.NonceSize()]
+		if plain, err := key.Open(nil, nonce, data[len(header)+key.NonceSize():], header); err == nil {
+			return plain, nil
+		}
+	}
+	return nil, errStateUndecryptable
+}
@@ -0,0 +1,202 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// withStateKeys writes keys to key files and configures them in order.
+func withStateKeys(t *testing.T, keys ...[]byte) {
+	dir, err := ioutil.TempDir("", "state-keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	saved := *stateKeyFiles
+	t.Cleanup(func() { *stateKeyFiles = saved })
+
+	*stateKeyFiles = nil
+	for i, key := range keys {
+		file := filepath.Join(dir, string(rune('a'+i)))
+		if err := ioutil.WriteFile(file, key, 0600); err != nil {
+			t.Fatal(err)
+		}
+		*stateKeyFiles = append(*stateKeyFiles, file)
+	}
+}
+
+func testStateKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, stateKeySize)
+}
+
+func TestStateEncryptionRoundTrip(t *testing.T) {
+	withStateFile(t)
+	// The hex-encoded form with a trailing newline, as written by a shell.
+	withStateKeys(t, []byte(hex.EncodeToString(testStateKey(1))+"\n"))
+	section := &memoryState{"secret", []byte("db1@host1")}
+	withStateSavers(t, section)
+	if err := SaveState(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(*stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, []byte(stateEncryptedMagic)) || bytes.Contains(data, []byte("db1@host1")) {
+		t.Fatalf("state file is not encrypted: %q", data)
+	}
+
+	section.data = nil
+	if err := LoadState(); err != nil {
+		t.Fatal(err)
+	}
+	if string(section.data) != "db1@host1" {
+		t.Errorf("loaded section = %q, want \"db1@host1\"", section.data)
+	}
+}
+
+func TestStateEncryptionUndecryptable(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		keys   [][]byte
+		modify func([]byte) []byte
+	}{
+		{"wrong key", [][]byte{testStateKey(2)}, nil},
+		{"no key", nil, nil},
+		{"truncated", [][]byte{testStateKey(1)}, func(data []byte) []byte { return data[:len(data)-1] }},
+		{"truncated nonce", [][]byte{testStateKey(1)}, func(data []byte) []byte { return data[:len(stateEncryptedMagic)+4] }},
+		{"tampered", [][]byte{testStateKey(1)}, func(data []byte) []byte {
+			data[len(data)-1] ^= 1
+			return data
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			withStateFile(t)
+			withStateKeys(t, testStateKey(1))
+			section := &memoryState{"secret", []byte("db1@host1")}
+			withStateSavers(t, section)
+			if err := SaveState(); err != nil {
+				t.Fatal(err)
+			}
+			if tc.modify != nil {
+				data, err := ioutil.ReadFile(*stateFile)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := ioutil.WriteFile(*stateFile, tc.modify(data), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			withStateKeys(t, tc.keys...)
+			section.data = nil
+			before := testutil.ToFloat64(stateUndecryptable)
+			if err := LoadState(); err != nil {
+				t.Fatalf("LoadState() = %s, want the file ignored", err)
+			}
+			if section.data != nil {
+				t.Errorf("loaded section = %q, want nothing", section.data)
+			}
+			if got := testutil.ToFloat64(stateUndecryptable) - before; got != 1 {
+				t.Errorf("undecryptable count increased by %v, want 1", got)
+			}
+		})
+	}
+}
+
+func TestStateEncryptionKeyRotation(t *testing.T) {
+	withStateFile(t)
+	withStateKeys(t, testStateKey(1))
+	section := &memoryState{"secret", []byte("db1@host1")}
+	withStateSavers(t, section)
+	if err := SaveState(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The new key encrypts, the old one still decrypts the existing file.
+	withStateKeys(t, testStateKey(2), testStateKey(1))
+	section.data = nil
+	if err := LoadState(); err != nil {
+		t.Fatal(err)
+	}
+	if string(section.data) != "db1@host1" {
+		t.Fatalf("loaded section with the old key = %q, want \"db1@host1\"", section.data)
+	}
+	if err := SaveState(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Once rewritten, the old key can be retired.
+	withStateKeys(t, testStateKey(2))
+	section.data = nil
+	if err := LoadState(); err != nil {
+		t.Fatal(err)
+	}
+	if string(section.data) != "db1@host1" {
+		t.Errorf("loaded section with the new key = %q, want \"db1@host1\"", section.data)
+	}
+}
+
+func TestStateEncryptionPlainFile(t *testing.T) {
+	withStateFile(t)
+	section := &memoryState{"secret", []byte("db1@host1")}
+	withStateSavers(t, section)
+	if err := SaveState(); err != nil {
+		t.Fatal(err)
+	}
+
+	withStateKeys(t, testStateKey(1))
+	section.data = nil
+	if err := LoadState(); err != nil {
+		t.Fatal(err)
+	}
+	if string(section.data) != "db1@host1" {
+		t.Errorf("loaded plain section = %q, want \"db1@host1\"", section.data)
+	}
+}
+
+func TestCheckStateKeys(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		key   []byte
+		valid bool
+	}{
+		{"raw", testStateKey(1), true},
+		{"hex", []byte(hex.EncodeToString(testStateKey(1))), true},
+		{"short", testStateKey(1)[:16], false},
+		{"invalid hex", bytes.Repeat([]byte("zz"), stateKeySize), false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			withStateKeys(t, tc.key)
+			if err := CheckStateKeys(); (err == nil) != tc.valid {
+				t.Errorf("CheckStateKeys() = %v, want valid %v", err, tc.valid)
+			}
+		})
+	}
+
+	withStateKeys(t)
+	*stateKeyFiles = []string{"/nonexistent/key"}
+	if err := CheckStateKeys(); err == nil {
+		t.Error("CheckStateKeys() with a missing key file = nil, want an error")
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// memoryState is a state section held in memory.
+type memoryState struct {
+	id   string
+	data []byte
+}
+
+func (s *memoryState) Section() string { return s.id }
+func (s *memoryState) Version() uint16 { return 1 }
+
+func (s *memoryState) MarshalState(maxBytes int) ([]byte, int, error) {
+	return s.data, 0, nil
+}
+
+func (s *memoryState) UnmarshalState(data []byte) error {
+	s.data = append([]byte(nil), data...)
+	return nil
+}
+
+// withStateSavers replaces the features persisting state.
+func withStateSavers(t *testing.T, savers ...StateSaver) {
+	saved := stateSavers
+	stateSavers = savers
+	t.Cleanup(func() { stateSavers = saved })
+}
+
+func TestStateRoundTrip(t *testing.T) {
+	withStateFile(t)
+	first, second := &memoryState{"first", []byte("one")}, &memoryState{"second", []byte("two")}
+	withStateSavers(t, first, second)
+	if err := SaveState(); err != nil {
+		t.Fatal(err)
+	}
+
+	first.data, second.data = nil, nil
+	if err := LoadState(); err != nil {
+		t.Fatal(err)
+	}
+	if string(first.data) != "one" || string(second.data) != "two" {
+		t.Errorf("loaded sections = %q, %q, want \"one\", \"two\"", first.data, second.data)
+	}
+}
+
+// TestStateCrashWhileWriting simulates a crash leaving the temporary file
+// of a write behind.
+func TestStateCrashWhileWriting(t *testing.T) {
+	file := withStateFile(t)
+	s := &memoryState{"crash", []byte("intact")}
+	withStateSavers(t, s)
+	if err := SaveState(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(file+".tmp", []byte("CUBRIDST\x00\x01\x00"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s.data = nil
+	if err := LoadState(); err != nil {
+		t.Fatal(err)
+	}
+	if string(s.data) != "intact" {
+		t.Errorf("loaded section = %q, want the state before the crash", s.data)
+	}
+	if _, err := os.Stat(file + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("the temporary file was not removed: %v", err)
+	}
+}
+
+// TestStatePartialRecovery corrupts one section and checks that it is
+// dropped and counted while the other one loads.
+func TestStatePartialRecovery(t *testing.T) {
+	file := withStateFile(t)
+	corrupt, intact := &memoryState{"corrupt", []byte("corrupt-me")}, &memoryState{"intact", []byte("keep-me")}
+	withStateSavers(t, corrupt, intact)
+	if err := SaveState(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = bytes.Replace(data, []byte("corrupt-me"), []byte("CORRUPT-ME"), 1)
+	if err := ioutil.WriteFile(file, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dropped := testutil.ToFloat64(stateSectionsDropped.WithLabelValues("corrupt", stateDropChecksum))
+	corrupt.data, intact.data = nil, nil
+	if err := LoadState(); err != nil {
+		t.Fatal(err)
+	}
+	if corrupt.data != nil {
+		t.Errorf("the corrupt section was loaded: %q", corrupt.data)
+	}
+	if string(intact.data) != "keep-me" {
+		t.Errorf("intact section = %q, want \"keep-me\"", intact.data)
+	}
+	if got := testutil.ToFloat64(stateSectionsDropped.WithLabelValues("corrupt", stateDropChecksum)) - dropped; got != 1 {
+		t.Errorf("sections dropped for their checksum = %v, want 1", got)
+	}
+
+	// A truncated file keeps the sections before the cut.
+	if err := ioutil.WriteFile(file, data[:len(data)-3], 0644); err != nil {
+		t.Fatal(err)
+	}
+	intact.data = nil
+	if err := LoadState(); err != nil {
+		t.Fatal(err)
+	}
+	if intact.data != nil {
+		t.Errorf("the truncated section was loaded: %q", intact.data)
+	}
+}
+
+// TestStateSizeBudget checks that the broker ports not seen for the
+// longest time are evicted to fit the section budget.
+func TestStateSizeBudget(t *testing.T) {
+	withStateFile(t)
+	maxBytes := *stateSectionMaxBytes
+	*stateSectionMaxBytes = 200
+	defer func() { *stateSectionMaxBytes = maxBytes }()
+
+	evicted := testutil.ToFloat64(stateEntriesEvicted.WithLabelValues(brokerPortState{}.Section()))
+	ctx := withTarget(context.Background(), "budget:33000:demodb")
+	for i := 0; i < 10; i++ {
+		observeBrokerPort(ctx, fmt.Sprintf("broker%d", i), float64(30000+i))
+	}
+	if err := saveStateIfDirty(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, _, err := brokerPortState{}.MarshalState(*stateSectionMaxBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) > 200 {
+		t.Errorf("section size = %d bytes, want at most 200", len(data))
+	}
+	if testutil.ToFloat64(stateEntriesEvicted.WithLabelValues(brokerPortState{}.Section()))-evicted == 0 {
+		t.Error("no evictions were counted")
+	}
+	brokerPorts.Lock()
+	_, newest := brokerPorts.ports[targetScoped(ctx, "broker9")]
+	_, oldest := brokerPorts.ports[targetScoped(ctx, "broker0")]
+	brokerPorts.Unlock()
+	if !newest || oldest {
+		t.Errorf("kept the newest broker: %v, the oldest: %v; want only the newest", newest, oldest)
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Partial failure accounting for scrapers issuing several queries.
+
+package collector
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metric descriptors.
+var (
+	subcollectorSuccessDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, exporter, "subcollector_success"),
+		"Whether the sub-collector succeeded (1 for success).",
+		[]string{"collector", "subcollector"}, nil,
+	)
+	subcollectorDurationDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, exporter, "subcollector_duration_seconds"),
+		"Sub-collector time duration.",
+		[]string{"collector", "subcollector"}, nil,
+	)
+	subcollectorRowsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, exporter, "subcollector_rows"),
+		"Number of rows the sub-collector processed.",
+		[]string{"collector", "subcollector"}, nil,
+	)
+)
+
+type subResult struct {
+	name     string
+	duration time.Duration
+	rows     int
+	err      error
+}
+
+// subRecorder collects the sub-results reported during one scraper run.
+type subRecorder struct {
+	mu      sync.Mutex
+	results []subResult
+}
+
+type subRecorderKey struct{}
+
+func withSubRecorder(ctx context.Context) (context.Context, *subRecorder) {
+	rec := &subRecorder{}
+	return context.WithValue(ctx, subRecorderKey{}, rec), rec
+}
+
+// runSubCollector runs one named sub-operation of a compound scraper and
+// records its duration, row count and error.
+func runSubCollector(ctx context.Context, name string, f func() (rows int, err error)) error {
+	start := time.Now()
+	rows, err := f()
+	if rec, ok := ctx.Value(subRecorderKey{}).(*subRecorder); ok {
+		rec.mu.Lock()
+		rec.results = append(rec.results, subResult{name: name, duration: time.Since(start), rows: rows, err: err})
+		rec.mu.Unlock()
+	}
+	return err
+}
+
+// collect sends the recorded sub-results of the collector as metrics.
+func (rec *subRecorder) collect(collector string, ch chan<- prometheus.Metric) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	for _, r := range rec.results {
+		success := 1.0
+		if r.err != nil {
+			success = 0
+		}
+		ch <- prometheus.MustNewConstMetric(subcollectorSuccessDesc, prometheus.GaugeValue, success, collector, r.name)
+		ch <- prometheus.MustNewConstMetric(subcollectorDurationDesc, prometheus.GaugeValue, r.duration.Seconds(), collector, r.name)
+		ch <- prometheus.MustNewConstMetric(subcollectorRowsDesc, prometheus.GaugeValue, float64(r.rows), collector, r.name)
+	}
+}
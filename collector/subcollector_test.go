@@ -0,0 +1,87 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// compoundScraper runs a failing and a succeeding sub-collector, sending the
+// sample of the latter.
+type compoundScraper struct{}
+
+func (compoundScraper) Name() string     { return "fake_compound" }
+func (compoundScraper) Help() string     { return "Fake compound scraper" }
+func (compoundScraper) Version() float64 { return 10.2 }
+
+func (compoundScraper) Scrape(ctx context.Context, db *sql.DB, ch chan<- prometheus.Metric) error {
+	failedErr := runSubCollector(ctx, "failing", func() (int, error) {
+		return 1, errTestRow
+	})
+	siblingErr := runSubCollector(ctx, "sibling", func() (int, error) {
+		ch <- prometheus.MustNewConstMetric(fakeScraperDesc, prometheus.GaugeValue, 1, "sibling")
+		return 2, nil
+	})
+	if failedErr != nil {
+		return failedErr
+	}
+	return siblingErr
+}
+
+func TestSubCollectorPartialFailure(t *testing.T) {
+	metrics := NewMetrics()
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(New(context.Background(), SimulatedDSN, metrics, []Scraper{compoundScraper{}}, nil))
+
+	// The sibling's sample and sub-results are sent despite the failure.
+	expected := `
+# HELP cubrid_fake_value Value of a fake scraper.
+# TYPE cubrid_fake_value gauge
+cubrid_fake_value{scraper="sibling"} 1
+# HELP cubrid_exporter_subcollector_success Whether the sub-collector succeeded (1 for success).
+# TYPE cubrid_exporter_subcollector_success gauge
+cubrid_exporter_subcollector_success{collector="collect.fake_compound",subcollector="failing"} 0
+cubrid_exporter_subcollector_success{collector="collect.fake_compound",subcollector="sibling"} 1
+# HELP cubrid_exporter_subcollector_rows Number of rows the sub-collector processed.
+# TYPE cubrid_exporter_subcollector_rows gauge
+cubrid_exporter_subcollector_rows{collector="collect.fake_compound",subcollector="failing"} 1
+cubrid_exporter_subcollector_rows{collector="collect.fake_compound",subcollector="sibling"} 2
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected), "cubrid_fake_value",
+		"cubrid_exporter_subcollector_success", "cubrid_exporter_subcollector_rows"); err != nil {
+		t.Error(err)
+	}
+	// The scraper still fails as a whole.
+	if got := testutil.ToFloat64(metrics.ScrapeErrors.WithLabelValues("collect.fake_compound")); got != 1 {
+		t.Errorf("scrape errors = %v, want 1", got)
+	}
+}
+
+func TestRunSubCollectorWithoutRecorder(t *testing.T) {
+	// Outside an exporter scrape the sub-collector only runs.
+	ran := false
+	err := runSubCollector(context.Background(), "alone", func() (int, error) {
+		ran = true
+		return 0, errTestRow
+	})
+	if !ran || err != errTestRow {
+		t.Errorf("ran = %v, error = %v", ran, err)
+	}
+}
@@ -0,0 +1,206 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Backing off while the monitored database shows signs of distress.
+
+package collector
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	throttleEnable = kingpin.Flag(
+		"exporter.throttle",
+		"Serve non-essential collectors from cache while the database shows sustained distress.",
+	).Default("false").Bool()
+	throttleQueryLatency = kingpin.Flag(
+		"exporter.throttle.query-latency",
+		"A statement of the exporter running longer than this is a distress signal.",
+	).Default("2s").Duration()
+	throttleActivateAfter = kingpin.Flag(
+		"exporter.throttle.activate-after",
+		"Number of consecutive scrapes with a distress signal that activate the throttle.",
+	).Default("3").Int()
+	throttleReleaseAfter = kingpin.Flag(
+		"exporter.throttle.release-after",
+		"Number of consecutive scrapes without a distress signal that release the throttle.",
+	).Default("10").Int()
+	throttleCacheTTL = kingpin.Flag(
+		"exporter.throttle.cache-ttl",
+		"How long non-essential collectors are served from cache while throttled.",
+	).Default("5m").Duration()
+)
+
+// Distress signals.
+const (
+	signalQueryLatency   = "query_latency"
+	signalConnectFailure = "connect_failure"
+)
+
+var (
+	throttleActiveDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, exporter, "throttle_active"),
+		"Whether non-essential collectors of the target are served from cache because its database shows distress (1 for throttled).",
+		[]string{"target"}, nil,
+	)
+	throttleSignalDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, exporter, "throttle_signal"),
+		"Whether the distress signal was seen in the last scrape of the target (1 for seen).",
+		[]string{"target", "signal"}, nil,
+	)
+	throttleTriggerDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, exporter, "throttle_trigger_info"),
+		"The distress signal that activated the active throttle of the target.",
+		[]string{"target", "signal"}, nil,
+	)
+)
+
+// ScrapeThrottle evaluates the distress signals of a target once per scrape.
+// It activates after --exporter.throttle.activate-after consecutive
+// distressed scrapes and releases only after
+// --exporter.throttle.release-after calm ones, so it does not flap.
+// It implements prometheus.Collector.
+type ScrapeThrottle struct {
+	mu      sync.Mutex
+	targets map[string]*throttleState
+}
+
+// throttleState is the throttle of a single target.
+type throttleState struct {
+	// cache is created on first use, once the flags are parsed.
+	cache *ScrapeCache
+	// slowStatement is set by a slow statement since the last evaluation.
+	slowStatement    bool
+	signals          map[string]bool
+	distressed, calm int
+	active           bool
+	trigger          string
+}
+
+// Throttle is the throttle of all scrapes. It keeps the signals per target,
+// so a struggling probe target neither throttles the other targets nor
+// shares their cached metrics.
+var Throttle = &ScrapeThrottle{targets: map[string]*throttleState{}}
+
+// state returns the throttle of the target. t.mu must be held.
+func (t *ScrapeThrottle) state(target string) *throttleState {
+	state, ok := t.targets[target]
+	if !ok {
+		state = &throttleState{signals: map[string]bool{}}
+		t.targets[target] = state
+	}
+	return state
+}
+
+// observeStatement records the duration of a statement the exporter sent to
+// the target.
+func (t *ScrapeThrottle) observeStatement(target string, d time.Duration) {
+	if !*throttleEnable || d <= *throttleQueryLatency {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.state(target).slowStatement = true
+}
+
+// evaluate updates the throttle of the target at the start of a scrape whose
+// connection attempt failed if connectFailed.
+func (t *ScrapeThrottle) evaluate(target string, connectFailed bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !*throttleEnable {
+		return
+	}
+	s := t.state(target)
+	s.signals = map[string]bool{
+		signalQueryLatency:   s.slowStatement,
+		signalConnectFailure: connectFailed,
+	}
+	s.slowStatement = false
+
+	signal := ""
+	for _, name := range []string{signalConnectFailure, signalQueryLatency} {
+		if s.signals[name] {
+			signal = name
+			break
+		}
+	}
+	if signal == "" {
+		s.distressed = 0
+		s.calm++
+	} else {
+		s.calm = 0
+		s.distressed++
+	}
+
+	switch {
+	case !s.active && s.distressed >= *throttleActivateAfter:
+		s.active, s.trigger = true, signal
+		log.Warnf("Database %s shows distress (%s), serving non-essential collectors from cache", target, signal)
+	case s.active && s.calm >= *throttleReleaseAfter:
+		s.active, s.trigger = false, ""
+		log.Infof("Distress of database %s ended, collecting fresh data again", target)
+	}
+}
+
+// cacheFor returns the cache to serve the collector of the target from while
+// throttled, nil otherwise.
+func (t *ScrapeThrottle) cacheFor(target, collector string) *ScrapeCache {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.targets[target]
+	if !ok || !s.active || essentialCollectors[collector] {
+		return nil
+	}
+	if s.cache == nil {
+		s.cache = NewScrapeCache(*throttleCacheTTL)
+	}
+	return s.cache
+}
+
+// Describe implements prometheus.Collector.
+func (t *ScrapeThrottle) Describe(ch chan<- *prometheus.Desc) {
+	ch <- throttleActiveDesc
+	ch <- throttleSignalDesc
+	ch <- throttleTriggerDesc
+}
+
+// Collect implements prometheus.Collector.
+func (t *ScrapeThrottle) Collect(ch chan<- prometheus.Metric) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !*throttleEnable {
+		return
+	}
+	for target, s := range t.targets {
+		active := 0.0
+		if s.active {
+			active = 1
+			ch <- prometheus.MustNewConstMetric(throttleTriggerDesc, prometheus.GaugeValue, 1, target, s.trigger)
+		}
+		ch <- prometheus.MustNewConstMetric(throttleActiveDesc, prometheus.GaugeValue, active, target)
+		for signal, seen := range s.signals {
+			v := 0.0
+			if seen {
+				v = 1
+			}
+			ch <- prometheus.MustNewConstMetric(throttleSignalDesc, prometheus.GaugeValue, v, target, signal)
+		}
+	}
+}
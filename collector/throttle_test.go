@@ -0,0 +1,129 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// withThrottle enables the throttle, activating after two distressed scrapes
+// and releasing after three calm ones, starting from a clean state.
+func withThrottle(t *testing.T) {
+	enable, latency, activate, release := *throttleEnable, *throttleQueryLatency, *throttleActivateAfter, *throttleReleaseAfter
+	*throttleEnable, *throttleQueryLatency, *throttleActivateAfter, *throttleReleaseAfter = true, time.Second, 2, 3
+	reset := func() {
+		Throttle.mu.Lock()
+		Throttle.targets = map[string]*throttleState{}
+		Throttle.mu.Unlock()
+	}
+	reset()
+	t.Cleanup(func() {
+		*throttleEnable, *throttleQueryLatency, *throttleActivateAfter, *throttleReleaseAfter = enable, latency, activate, release
+		reset()
+	})
+}
+
+func TestThrottleTransitions(t *testing.T) {
+	withThrottle(t)
+	const target = "db1:33000:demodb"
+
+	// A single distressed scrape does not activate the throttle.
+	Throttle.observeStatement(target, 2*time.Second)
+	Throttle.evaluate(target, false)
+	if Throttle.cacheFor(target, "spacedb") != nil {
+		t.Fatal("throttle active after a single distressed scrape")
+	}
+	Throttle.evaluate(target, true)
+	cache := Throttle.cacheFor(target, "spacedb")
+	if cache == nil {
+		t.Fatal("throttle not active after two distressed scrapes")
+	}
+	if Throttle.targets[target].trigger != signalConnectFailure {
+		t.Errorf("trigger = %q, want %q", Throttle.targets[target].trigger, signalConnectFailure)
+	}
+	if Throttle.cacheFor(target, brokerStatus) != nil {
+		t.Error("essential collector served from the throttle's cache")
+	}
+
+	// Hysteresis: a distressed scrape resets the calm ones.
+	Throttle.evaluate(target, false)
+	Throttle.evaluate(target, false)
+	Throttle.evaluate(target, true)
+	Throttle.evaluate(target, false)
+	Throttle.evaluate(target, false)
+	if Throttle.cacheFor(target, "spacedb") != cache {
+		t.Fatal("throttle released before three consecutive calm scrapes")
+	}
+	Throttle.evaluate(target, false)
+	if Throttle.cacheFor(target, "spacedb") != nil {
+		t.Fatal("throttle still active after three calm scrapes")
+	}
+}
+
+func TestThrottleQueryLatency(t *testing.T) {
+	withThrottle(t)
+	const target = "db1:33000:demodb"
+
+	for i := 0; i < 2; i++ {
+		Throttle.observeStatement(target, 500*time.Millisecond)
+		Throttle.evaluate(target, false)
+	}
+	if Throttle.cacheFor(target, "spacedb") != nil {
+		t.Fatal("fast statements activated the throttle")
+	}
+	for i := 0; i < 2; i++ {
+		Throttle.observeStatement(target, 2*time.Second)
+		Throttle.evaluate(target, false)
+	}
+	if Throttle.cacheFor(target, "spacedb") == nil {
+		t.Fatal("slow statements did not activate the throttle")
+	}
+	if Throttle.targets[target].trigger != signalQueryLatency {
+		t.Errorf("trigger = %q, want %q", Throttle.targets[target].trigger, signalQueryLatency)
+	}
+}
+
+// TestThrottleReducesQueries checks that a throttled target serves
+// non-essential scrapers from cache instead of querying the database.
+func TestThrottleReducesQueries(t *testing.T) {
+	withThrottle(t)
+	scraper := &countingScraper{name: "counted"}
+	e := New(context.Background(), SimulatedDSN, NewMetrics(), []Scraper{scraper}, nil)
+
+	collectExporter(e)
+	Throttle.evaluate(e.target, true)
+	Throttle.evaluate(e.target, true)
+	for i := 0; i < 3; i++ {
+		collectExporter(e)
+	}
+	// The first throttled scrape fills the cache.
+	if runs := atomic.LoadInt32(&scraper.runs); runs != 2 {
+		t.Errorf("scraper ran %d times, want 2", runs)
+	}
+}
+
+func TestThrottlePerTarget(t *testing.T) {
+	withThrottle(t)
+	Throttle.evaluate("probed:33000:demodb", true)
+	Throttle.evaluate("probed:33000:demodb", true)
+	if Throttle.cacheFor("probed:33000:demodb", "spacedb") == nil {
+		t.Fatal("throttle of the distressed target not active")
+	}
+	if Throttle.cacheFor("main:33000:demodb", "spacedb") != nil {
+		t.Error("distress of one target throttled another")
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Validation of emitted metrics before they reach the registry.
+
+package collector
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// descNameRE extracts the family name from Desc.String(), the only way
+// client_golang exposes it.
+var descNameRE = regexp.MustCompile(`fqName: "([^"]*)"`)
+
+func descName(desc *prometheus.Desc) string {
+	if match := descNameRE.FindStringSubmatch(desc.String()); match != nil {
+		return match[1]
+	}
+	return desc.String()
+}
+
+// metricValidator checks that the metrics of one scrape have consistent label
+// names per family. The registry fails the whole scrape on the first
+// inconsistent metric, so those are dropped and counted instead.
+type metricValidator struct {
+	mismatches *prometheus.CounterVec
+
+	mu sync.Mutex
+	// labels are the sorted label names per family, as first emitted.
+	labels map[string]string
+}
+
+func newMetricValidator(mismatches *prometheus.CounterVec) *metricValidator {
+	return &metricValidator{mismatches: mismatches, labels: map[string]string{}}
+}
+
+// valid reports whether the metric the collector emitted can be registered.
+func (v *metricValidator) valid(collector string, metric prometheus.Metric) bool {
+	name := descName(metric.Desc())
+	var out dto.Metric
+	if err := metric.Write(&out); err != nil {
+		v.mismatches.WithLabelValues("collect." + collector).Inc()
+		collectorLogger(collector).Errorf("Dropping metric %s: %s", name, err)
+		return false
+	}
+	names := make([]string, len(out.Label))
+	for i, lp := range out.Label {
+		names[i] = lp.GetName()
+	}
+	sort.Strings(names)
+	got := strings.Join(names, ",")
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	expected, ok := v.labels[name]
+	if !ok {
+		v.labels[name] = got
+		return true
+	}
+	if got == expected {
+		return true
+	}
+	v.mismatches.WithLabelValues("collect." + collector).Inc()
+	collectorLogger(collector).Errorf(
+		"Dropping metric %s: %d labels [%s], but earlier metrics of the family have %d labels [%s]",
+		name, len(names), got, strings.Count(expected, ",")+1, expected)
+	return false
+}
+
+// proxy returns a channel forwarding the valid metrics of the collector to
+// ch, and a func to call once done sending.
+func (v *metricValidator) proxy(ch chan<- prometheus.Metric, collector string) (chan<- prometheus.Metric, func()) {
+	forward := make(chan prometheus.Metric)
+	finished := make(chan struct{})
+	go func() {
+		for metric := range forward {
+			if v.valid(collector, metric) {
+				ch <- metric
+			}
+		}
+		close(finished)
+	}()
+	return forward, func() {
+		close(forward)
+		<-finished
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var mismatchedDesc = prometheus.NewDesc("cubrid_fake_value", "Value of a fake scraper.", []string{"scraper", "extra"}, nil)
+
+// mismatchScraper sends a sample of fakeScraperDesc and one of the same
+// family with an extra label.
+type mismatchScraper struct{}
+
+func (mismatchScraper) Name() string     { return "fake_mismatch" }
+func (mismatchScraper) Help() string     { return "Fake scraper with mismatched labels" }
+func (mismatchScraper) Version() float64 { return 10.2 }
+
+func (mismatchScraper) Scrape(ctx context.Context, db *sql.DB, ch chan<- prometheus.Metric) error {
+	ch <- prometheus.MustNewConstMetric(fakeScraperDesc, prometheus.GaugeValue, 1, "fake_mismatch")
+	ch <- prometheus.MustNewConstMetric(mismatchedDesc, prometheus.GaugeValue, 2, "fake_mismatch", "surplus")
+	return nil
+}
+
+// TestExporterDropsMismatchedMetric checks that the metric with mismatched
+// labels is dropped and counted while the scrape succeeds.
+func TestExporterDropsMismatchedMetric(t *testing.T) {
+	metrics := NewMetrics()
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(New(context.Background(), SimulatedDSN, metrics, []Scraper{mismatchScraper{}}, nil))
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("error gathering: %s", err)
+	}
+	var samples int
+	for _, family := range families {
+		if family.GetName() == "cubrid_fake_value" {
+			samples = len(family.Metric)
+		}
+	}
+	if samples != 1 {
+		t.Errorf("samples of cubrid_fake_value = %d, want 1", samples)
+	}
+	if got := testutil.ToFloat64(metrics.DescriptorMismatches.WithLabelValues("collect.fake_mismatch")); got != 1 {
+		t.Errorf("descriptor_mismatches_total = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(metrics.Error); got != 0 {
+		t.Errorf("last_scrape_error = %v, want 0", got)
+	}
+}
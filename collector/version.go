@@ -0,0 +1,85 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Comparison of CUBRID server versions.
+
+package collector
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// versionRE matches the numeric part of versions like "11.2.0.1234-abcdef".
+var versionRE = regexp.MustCompile(`^\d+(\.\d+)*`)
+
+// cubridVersion holds the numeric components of a version, major first.
+// A nil cubridVersion is unknown and supports every scraper.
+type cubridVersion []int
+
+// parseCubridVersion parses the leading numeric components of s.
+func parseCubridVersion(s string) (cubridVersion, bool) {
+	match := versionRE.FindString(strings.TrimSpace(s))
+	if match == "" {
+		return nil, false
+	}
+	var v cubridVersion
+	for _, part := range strings.Split(match, ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, false
+		}
+		v = append(v, n)
+	}
+	return v, true
+}
+
+// scraperVersion converts Scraper.Version(), e.g. 10.2, to a cubridVersion.
+func scraperVersion(f float64) cubridVersion {
+	v, _ := parseCubridVersion(strconv.FormatFloat(f, 'f', -1, 64))
+	return v
+}
+
+// supports reports whether a scraper requiring the version min runs on v.
+// Missing components count as 0, so 11.2.0.1234 supports 11.2.
+func (v cubridVersion) supports(min float64) bool {
+	if v == nil {
+		return true
+	}
+	required := scraperVersion(min)
+	for i := 0; i < len(v) || i < len(required); i++ {
+		var have, want int
+		if i < len(v) {
+			have = v[i]
+		}
+		if i < len(required) {
+			want = required[i]
+		}
+		if have != want {
+			return have > want
+		}
+	}
+	return true
+}
+
+func (v cubridVersion) String() string {
+	if v == nil {
+		return "unknown"
+	}
+	parts := make([]string, len(v))
+	for i, n := range v {
+		parts[i] = strconv.Itoa(n)
+	}
+	return strings.Join(parts, ".")
+}
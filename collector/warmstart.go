@@ -0,0 +1,407 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Serving the metrics of a previous instance or a peer until fresh ones are collected.
+
+package collector
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/log"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	warmStartSnapshotFile = kingpin.Flag(
+		"warmstart.snapshot-file",
+		"File the latest collected metrics are written to periodically and loaded from at startup.",
+	).Default("").String()
+	warmStartPeerURL = kingpin.Flag(
+		"warmstart.peer-url",
+		"URL of the /-/warmstart endpoint of a replica to load the latest collected metrics from at startup, instead of the snapshot file.",
+	).Default("").String()
+	warmStartServe = kingpin.Flag(
+		"warmstart.serve",
+		"Keep the latest collected metrics to serve them to starting replicas at /-/warmstart.",
+	).Default("false").Bool()
+	warmStartInterval = kingpin.Flag(
+		"warmstart.snapshot-interval",
+		"How often the snapshot file is written.",
+	).Default("1m").Duration()
+	warmStartMaxAge = kingpin.Flag(
+		"warmstart.max-age",
+		"Loaded metrics collected longer ago are not served.",
+	).Default("10m").Duration()
+)
+
+// warmStartMaxSize bounds the snapshot fetched from a peer.
+const warmStartMaxSize = 64 << 20
+
+// Results of loaded snapshot entries.
+const (
+	warmStartLoaded  = "loaded"
+	warmStartStale   = "stale"
+	warmStartInvalid = "invalid"
+	warmStartServed  = "served"
+)
+
+// WarmStartSnapshot is the document of the snapshot file and of /-/warmstart.
+type WarmStartSnapshot struct {
+	Entries []WarmStartEntry `json:"entries"`
+}
+
+// WarmStartEntry holds the metrics a collector collected from a target.
+type WarmStartEntry struct {
+	// Target is the host:port:database the metrics were collected from.
+	Target    string    `json:"target"`
+	Collector string    `json:"collector"`
+	Collected time.Time `json:"collected"`
+	// Metrics are in the text exposition format.
+	Metrics string `json:"metrics"`
+}
+
+// warmKey identifies the metrics of a collector on a target. Unlike the
+// database alone, the target tells apart databases of the same name on
+// different hosts.
+type warmKey struct {
+	target    string
+	collector string
+}
+
+type warmEntry struct {
+	metrics   []prometheus.Metric
+	collected time.Time
+	// refreshing is set while a background run collects fresh metrics, fresh
+	// once it did.
+	refreshing, fresh bool
+}
+
+// WarmStartStore keeps the latest collected metrics for the snapshot, and
+// serves the metrics loaded at startup until fresh ones are collected. Each
+// loaded entry is served while a background run collects fresh metrics, which
+// are served once; from then on the collector runs as usual.
+// It implements prometheus.Collector.
+type WarmStartStore struct {
+	mu      sync.Mutex
+	latest  map[warmKey]warmEntry
+	loaded  map[warmKey]*warmEntry
+	entries *prometheus.CounterVec
+}
+
+// WarmStart is the warm-start store of all scrapes.
+var WarmStart = &WarmStartStore{
+	latest: map[warmKey]warmEntry{},
+	loaded: map[warmKey]*warmEntry{},
+	entries: prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: exporter,
+		Name:      "warmstart_entries_total",
+		Help:      "Number of warm-start entries by result: loaded, rejected as stale or invalid, and served.",
+	}, []string{"result"}),
+}
+
+// recording reports whether the latest collected metrics are kept.
+func (w *WarmStartStore) recording() bool {
+	return *warmStartSnapshotFile != "" || *warmStartServe
+}
+
+// remember keeps the metrics collected from the target for the snapshot.
+func (w *WarmStartStore) remember(target, collector string, metrics []prometheus.Metric) {
+	if !w.recording() {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.latest[warmKey{target: target, collector: collector}] = warmEntry{metrics: metrics, collected: time.Now()}
+}
+
+// serve sends the loaded metrics of the scraper, starting a background run
+// to refresh them. It reports whether it sent any.
+func (w *WarmStartStore) serve(e *Exporter, db *sql.DB, scraper Scraper, ch chan<- prometheus.Metric) bool {
+	key := warmKey{target: e.target, collector: scraper.Name()}
+	w.mu.Lock()
+	entry, ok := w.loaded[key]
+	if !ok {
+		w.mu.Unlock()
+		return false
+	}
+	if time.Since(entry.collected) > *warmStartMaxAge {
+		delete(w.loaded, key)
+		w.mu.Unlock()
+		w.entries.WithLabelValues(warmStartStale).Inc()
+		return false
+	}
+	metrics := entry.metrics
+	switch {
+	case entry.fresh:
+		delete(w.loaded, key)
+	case !entry.refreshing:
+		entry.refreshing = true
+		go w.refresh(e, db, scraper, key)
+	}
+	w.mu.Unlock()
+
+	w.entries.WithLabelValues(warmStartServed).Inc()
+	for _, metric := range metrics {
+		ch <- metric
+	}
+	return true
+}
+
+// refresh collects fresh metrics for a loaded entry in the background.
+func (w *WarmStartStore) refresh(e *Exporter, db *sql.DB, scraper Scraper, key warmKey) {
+	ctx, cancel := context.WithTimeout(WithBackgroundQueries(withLogger(withTarget(context.Background(), key.target), scraper.Name())), *warmStartMaxAge)
+	defer cancel()
+	var metrics []prometheus.Metric
+	ch := make(chan prometheus.Metric)
+	done := make(chan struct{})
+	go func() {
+		for metric := range ch {
+			metrics = append(metrics, metric)
+		}
+		close(done)
+	}()
+	err := e.runScraper(ctx, db, scraper, ch)
+	close(ch)
+	<-done
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	entry, ok := w.loaded[key]
+	if !ok {
+		return
+	}
+	entry.refreshing = false
+	if err != nil {
+		loggerFrom(ctx).Warnf("Error refreshing the warm-start metrics of %s: %s", scraper.Name(), err)
+		return
+	}
+	entry.metrics, entry.collected, entry.fresh = metrics, time.Now(), true
+	if w.recording() {
+		w.latest[key] = warmEntry{metrics: metrics, collected: entry.collected}
+	}
+}
+
+// Snapshot returns the latest collected metrics of every collector.
+func (w *WarmStartStore) Snapshot() (WarmStartSnapshot, error) {
+	w.mu.Lock()
+	latest := make(map[warmKey]warmEntry, len(w.latest))
+	for key, entry := range w.latest {
+		latest[key] = entry
+	}
+	w.mu.Unlock()
+
+	snapshot := WarmStartSnapshot{Entries: []WarmStartEntry{}}
+	for key, entry := range latest {
+		registry := prometheus.NewRegistry()
+		if err := registry.Register(constCollector(entry.metrics)); err != nil {
+			return snapshot, err
+		}
+		mfs, err := registry.Gather()
+		if err != nil {
+			return snapshot, fmt.Errorf("collector %s: %s", key.collector, err)
+		}
+		var text bytes.Buffer
+		for _, mf := range mfs {
+			if _, err := expfmt.MetricFamilyToText(&text, mf); err != nil {
+				return snapshot, err
+			}
+		}
+		snapshot.Entries = append(snapshot.Entries, WarmStartEntry{
+			Target:    key.target,
+			Collector: key.collector,
+			Collected: entry.collected,
+			Metrics:   text.String(),
+		})
+	}
+	sort.Slice(snapshot.Entries, func(i, j int) bool {
+		a, b := snapshot.Entries[i], snapshot.Entries[j]
+		return a.Target < b.Target || a.Target == b.Target && a.Collector < b.Collector
+	})
+	return snapshot, nil
+}
+
+// WriteSnapshot writes the snapshot to --warmstart.snapshot-file.
+func (w *WarmStartStore) WriteSnapshot() error {
+	if *warmStartSnapshotFile == "" {
+		return nil
+	}
+	snapshot, err := w.Snapshot()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(*warmStartSnapshotFile, data)
+}
+
+// Run writes the snapshot file every --warmstart.snapshot-interval until ctx
+// is done.
+func (w *WarmStartStore) Run(ctx context.Context) {
+	if *warmStartSnapshotFile == "" {
+		return
+	}
+	ticker := time.NewTicker(*warmStartInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := w.WriteSnapshot(); err != nil {
+				log.Errorln("Error writing warm-start snapshot:", err)
+			}
+		}
+	}
+}
+
+// Load reads the snapshot of --warmstart.peer-url or else
+// --warmstart.snapshot-file. Entries collected longer than
+// --warmstart.max-age ago are rejected. Without either flag, or without a
+// snapshot file yet, nothing is loaded.
+func (w *WarmStartStore) Load(ctx context.Context) error {
+	var snapshot WarmStartSnapshot
+	switch {
+	case *warmStartPeerURL != "":
+		req, err := http.NewRequest(http.MethodGet, *warmStartPeerURL, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("peer returned HTTP status %s", resp.Status)
+		}
+		if err := json.NewDecoder(io.LimitReader(resp.Body, warmStartMaxSize)).Decode(&snapshot); err != nil {
+			return err
+		}
+	case *warmStartSnapshotFile != "":
+		data, err := ioutil.ReadFile(*warmStartSnapshotFile)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, &snapshot); err != nil {
+			return err
+		}
+	default:
+		return nil
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, entry := range snapshot.Entries {
+		if time.Since(entry.Collected) > *warmStartMaxAge {
+			w.entries.WithLabelValues(warmStartStale).Inc()
+			continue
+		}
+		metrics, err := parseWarmStartMetrics(entry.Metrics)
+		if err == nil && entry.Target == "" {
+			// Written before entries were keyed by target.
+			err = errors.New("no target")
+		}
+		if err != nil {
+			log.Warnf("Invalid warm-start metrics of collector %s: %s", entry.Collector, err)
+			w.entries.WithLabelValues(warmStartInvalid).Inc()
+			continue
+		}
+		w.loaded[warmKey{target: entry.Target, collector: entry.Collector}] = &warmEntry{metrics: metrics, collected: entry.Collected}
+		w.entries.WithLabelValues(warmStartLoaded).Inc()
+	}
+	return nil
+}
+
+// parseWarmStartMetrics returns the metrics of the text exposition format.
+func parseWarmStartMetrics(text string) ([]prometheus.Metric, error) {
+	var parser expfmt.TextParser
+	mfs, err := parser.TextToMetricFamilies(strings.NewReader(text))
+	if err != nil {
+		return nil, err
+	}
+	var metrics []prometheus.Metric
+	for _, mf := range mfs {
+		for _, m := range mf.Metric {
+			labels := make([]string, 0, len(m.Label))
+			for _, lp := range m.Label {
+				labels = append(labels, lp.GetName())
+			}
+			desc := prometheus.NewDesc(mf.GetName(), mf.GetHelp(), labels, nil)
+			metrics = append(metrics, restoredMetric{desc: desc, metric: m})
+		}
+	}
+	return metrics, nil
+}
+
+// restoredMetric is a metric parsed from a snapshot.
+type restoredMetric struct {
+	desc   *prometheus.Desc
+	metric *dto.Metric
+}
+
+func (m restoredMetric) Desc() *prometheus.Desc {
+	return m.desc
+}
+
+func (m restoredMetric) Write(out *dto.Metric) error {
+	proto.Merge(out, m.metric)
+	return nil
+}
+
+// constCollector collects a fixed set of metrics. It is unchecked, as their
+// descriptors are not known in advance.
+type constCollector []prometheus.Metric
+
+// Describe implements prometheus.Collector.
+func (c constCollector) Describe(ch chan<- *prometheus.Desc) {}
+
+// Collect implements prometheus.Collector.
+func (c constCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, metric := range c {
+		ch <- metric
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (w *WarmStartStore) Describe(ch chan<- *prometheus.Desc) {
+	w.entries.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (w *WarmStartStore) Collect(ch chan<- prometheus.Metric) {
+	w.entries.Collect(ch)
+}
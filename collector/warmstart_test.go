@@ -0,0 +1,153 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// withSnapshotFile points --warmstart.snapshot-file to a temporary file,
+// starting from an empty store.
+func withSnapshotFile(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "warmstart")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, maxAge := *warmStartSnapshotFile, *warmStartMaxAge
+	*warmStartSnapshotFile, *warmStartMaxAge = filepath.Join(dir, "snapshot.json"), 10*time.Minute
+	reset := func() {
+		WarmStart.mu.Lock()
+		WarmStart.latest = map[warmKey]warmEntry{}
+		WarmStart.loaded = map[warmKey]*warmEntry{}
+		WarmStart.mu.Unlock()
+	}
+	reset()
+	t.Cleanup(func() {
+		waitRefreshed(t)
+		*warmStartSnapshotFile, *warmStartMaxAge = file, maxAge
+		reset()
+		os.RemoveAll(dir)
+	})
+	return *warmStartSnapshotFile
+}
+
+// waitRefreshed waits for the background runs started by serve.
+func waitRefreshed(t *testing.T) {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		refreshing := false
+		WarmStart.mu.Lock()
+		for _, entry := range WarmStart.loaded {
+			refreshing = refreshing || entry.refreshing
+		}
+		WarmStart.mu.Unlock()
+		if !refreshing {
+			return
+		}
+	}
+	t.Error("warm-start entries still refreshing")
+}
+
+// servedValue returns the value of the single metric the warm-start store
+// serves to a scrape of dsn, and whether it served any.
+func servedValue(t *testing.T, dsn string, scraper Scraper) (float64, bool) {
+	e := New(context.Background(), dsn, NewMetrics(), []Scraper{scraper}, nil)
+	ch := make(chan prometheus.Metric, 10)
+	if !WarmStart.serve(e, nil, scraper, ch) {
+		return 0, false
+	}
+	close(ch)
+	var values []float64
+	for metric := range ch {
+		var m dto.Metric
+		if err := metric.Write(&m); err != nil {
+			t.Fatal(err)
+		}
+		values = append(values, m.GetGauge().GetValue())
+	}
+	if len(values) != 1 {
+		t.Fatalf("served %d metrics, want 1", len(values))
+	}
+	return values[0], true
+}
+
+func TestWarmStartRoundTrip(t *testing.T) {
+	withSnapshotFile(t)
+	const (
+		dsnA = "cci:cubrid:hostA:33000:demodb:dba::"
+		dsnB = "cci:cubrid:hostB:33000:demodb:dba::"
+	)
+	scraper := fakeScraper{name: "fake_ok"}
+	WarmStart.remember(dsnTarget(dsnA), scraper.Name(), []prometheus.Metric{
+		prometheus.MustNewConstMetric(fakeScraperDesc, prometheus.GaugeValue, 1, "a"),
+	})
+	WarmStart.remember(dsnTarget(dsnB), scraper.Name(), []prometheus.Metric{
+		prometheus.MustNewConstMetric(fakeScraperDesc, prometheus.GaugeValue, 2, "b"),
+	})
+	if err := WarmStart.WriteSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+
+	WarmStart.mu.Lock()
+	WarmStart.latest = map[warmKey]warmEntry{}
+	WarmStart.mu.Unlock()
+	if err := WarmStart.Load(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	for dsn, want := range map[string]float64{dsnA: 1, dsnB: 2} {
+		got, ok := servedValue(t, dsn, scraper)
+		if !ok {
+			t.Errorf("nothing served to %s", dsnTarget(dsn))
+		} else if got != want {
+			t.Errorf("served %v to %s, want %v", got, dsnTarget(dsn), want)
+		}
+	}
+	if _, ok := servedValue(t, "cci:cubrid:hostC:33000:demodb:dba::", scraper); ok {
+		t.Error("served the metrics of another target with the same database")
+	}
+}
+
+func TestWarmStartLoadRejects(t *testing.T) {
+	file := withSnapshotFile(t)
+	metrics := "# TYPE cubrid_fake_value gauge\ncubrid_fake_value{scraper=\"fake_ok\"} 1\n"
+	snapshot := WarmStartSnapshot{Entries: []WarmStartEntry{
+		{Target: "stale:33000:demodb", Collector: "fake_ok", Collected: time.Now().Add(-time.Hour), Metrics: metrics},
+		{Target: "invalid:33000:demodb", Collector: "fake_ok", Collected: time.Now(), Metrics: "not metrics {"},
+		{Collector: "fake_ok", Collected: time.Now(), Metrics: metrics},
+	}}
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(file, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := WarmStart.Load(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	WarmStart.mu.Lock()
+	defer WarmStart.mu.Unlock()
+	if len(WarmStart.loaded) != 0 {
+		t.Errorf("loaded %d entries, want none", len(WarmStart.loaded))
+	}
+}
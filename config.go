@@ -0,0 +1,102 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/cubrid/cubrid-exporter/collector"
+)
+
+// Config is the content of the --config.file YAML file.
+type Config struct {
+	MetricRelabelConfigs []collector.RelabelRule       `yaml:"metric_relabel_configs"`
+	ChurnLimits          []collector.ChurnLimit        `yaml:"churn_limits"`
+	HighWaterMarks       collector.HWMConfig           `yaml:"high_water_marks"`
+	AuthModules          map[string]authModule         `yaml:"auth_modules"`
+	DerivedMetrics       []collector.DerivedMetric     `yaml:"derived_metrics"`
+	Alerting             collector.AlertingConfig      `yaml:"alerting"`
+	PublicMetrics        collector.PublicMetricsConfig `yaml:"public_metrics"`
+	Profiles             map[string]scrapeProfile      `yaml:"profiles"`
+	// Web holds the listen address and timeouts.
+	Web webConfig `yaml:"web"`
+	// DSN is the CCI DSN of the default target, ranked after --cubrid.dsn
+	// and DATA_SOURCE_NAME.
+	DSN string `yaml:"dsn"`
+	// Collectors holds per-collector settings by collector name, e.g. statdump.
+	Collectors map[string]collector.CollectorConfig `yaml:"collectors"`
+
+	// Hash is the SHA-256 of the file, empty without a config file.
+	Hash string `yaml:"-"`
+}
+
+// webConfig holds the settings of the web flags. Flags set on the command
+// line take precedence over them.
+type webConfig struct {
+	ListenAddress string `yaml:"listen_address"`
+	// TimeoutOffset is subtracted from the scrape timeout, in seconds.
+	TimeoutOffset *float64 `yaml:"timeout_offset"`
+	// The shutdown timeouts are pointers, so that a missing setting keeps
+	// the flag and an explicit zero is rejected.
+	ShutdownTimeout      *time.Duration `yaml:"shutdown_timeout"`
+	ShutdownPhaseTimeout *time.Duration `yaml:"shutdown_phase_timeout"`
+}
+
+// apply sets the web flags not set on the command line to the settings of c.
+func (c webConfig) apply() error {
+	if c.ShutdownTimeout != nil && *c.ShutdownTimeout <= 0 {
+		return fmt.Errorf("shutdown_timeout must be positive, got %s", *c.ShutdownTimeout)
+	}
+	if c.ShutdownPhaseTimeout != nil && *c.ShutdownPhaseTimeout <= 0 {
+		return fmt.Errorf("shutdown_phase_timeout must be positive, got %s", *c.ShutdownPhaseTimeout)
+	}
+	if c.TimeoutOffset != nil && *c.TimeoutOffset < 0 {
+		return fmt.Errorf("negative timeout_offset %g", *c.TimeoutOffset)
+	}
+	if c.ListenAddress != "" && !listenAddressSet {
+		*listenAddress = c.ListenAddress
+	}
+	if c.TimeoutOffset != nil && !timeoutOffsetSet {
+		*timeoutOffset = *c.TimeoutOffset
+	}
+	if c.ShutdownTimeout != nil && !shutdownTimeoutSet {
+		*shutdownTimeout = *c.ShutdownTimeout
+	}
+	if c.ShutdownPhaseTimeout != nil && !shutdownPhaseTimeoutSet {
+		*shutdownPhaseTimeout = *c.ShutdownPhaseTimeout
+	}
+	return nil
+}
+
+// loadConfig reads the config file. An empty path yields an empty config.
+func loadConfig(path string) (*Config, error) {
+	cfg := &Config{}
+	if path == "" {
+		return cfg, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return nil, err
+	}
+	cfg.Hash = fmt.Sprintf("%x", sha256.Sum256(data))
+	return cfg, nil
+}
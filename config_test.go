@@ -0,0 +1,108 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// loadTestConfig loads a config file with content.
+func loadTestConfig(t *testing.T, content string) *Config {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "config.yml")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("error loading the config file: %s", err)
+	}
+	return cfg
+}
+
+func TestWebConfigApply(t *testing.T) {
+	defaultTimeout, defaultPhaseTimeout := *shutdownTimeout, *shutdownPhaseTimeout
+	t.Cleanup(func() {
+		*shutdownTimeout, *shutdownPhaseTimeout = defaultTimeout, defaultPhaseTimeout
+		shutdownTimeoutSet, shutdownPhaseTimeoutSet = false, false
+	})
+
+	for _, tc := range []struct {
+		name                   string
+		content                string
+		flagSet                bool
+		wantTimeout, wantPhase time.Duration
+		wantErr                bool
+	}{
+		{
+			name:        "missing settings keep the flags",
+			content:     "web:\n  listen_address: :9177\n",
+			wantTimeout: defaultTimeout,
+			wantPhase:   defaultPhaseTimeout,
+		},
+		{
+			name:        "settings override the flag defaults",
+			content:     "web:\n  shutdown_timeout: 20s\n  shutdown_phase_timeout: 2s\n",
+			wantTimeout: 20 * time.Second,
+			wantPhase:   2 * time.Second,
+		},
+		{
+			name:        "flags set on the command line win",
+			content:     "web:\n  shutdown_timeout: 20s\n  shutdown_phase_timeout: 2s\n",
+			flagSet:     true,
+			wantTimeout: defaultTimeout,
+			wantPhase:   defaultPhaseTimeout,
+		},
+		{
+			name:    "explicit zero",
+			content: "web:\n  shutdown_timeout: 0s\n",
+			wantErr: true,
+		},
+		{
+			name:    "negative phase timeout",
+			content: "web:\n  shutdown_phase_timeout: -1s\n",
+			wantErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			*shutdownTimeout, *shutdownPhaseTimeout = defaultTimeout, defaultPhaseTimeout
+			shutdownTimeoutSet, shutdownPhaseTimeoutSet = tc.flagSet, tc.flagSet
+
+			err := loadTestConfig(t, tc.content).Web.apply()
+			if tc.wantErr {
+				if err == nil {
+					t.Error("expected an error")
+				}
+				if *shutdownTimeout != defaultTimeout || *shutdownPhaseTimeout != defaultPhaseTimeout {
+					t.Errorf("invalid settings changed the timeouts to %s and %s", *shutdownTimeout, *shutdownPhaseTimeout)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error applying the web settings: %s", err)
+			}
+			if *shutdownTimeout != tc.wantTimeout || *shutdownPhaseTimeout != tc.wantPhase {
+				t.Errorf("timeouts = %s and %s, want %s and %s", *shutdownTimeout, *shutdownPhaseTimeout, tc.wantTimeout, tc.wantPhase)
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/cubrid/cubrid-exporter/collector"
+)
+
+// printCoverage scrapes all enabled scrapers once and writes the coverage
+// report of the observed statdump keys and columns to w.
+func printCoverage(ctx context.Context, dsn string, scrapers []collector.Scraper, w io.Writer) error {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector.New(ctx, dsn, collector.NewMetrics(), scrapers, nil))
+	if _, err := registry.Gather(); err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(collector.Coverage.Sources())
+}
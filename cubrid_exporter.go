@@ -15,12 +15,18 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"net"
 	"net/http"
+	"os"
+	"runtime/debug"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/prometheus/common/log"
 	"github.com/prometheus/common/version"
 	"gopkg.in/alecthomas/kingpin.v2"
@@ -32,128 +38,450 @@ var (
 	listenAddress = kingpin.Flag(
 		"web.listen-address",
 		"Address to listen on for web interface and telemetry.",
-	).Default(":9177").String()
+	).Default(":9177").IsSetByUser(&listenAddressSet).String()
 	metricPath = kingpin.Flag(
 		"web.telemetry-path",
 		"Path under which to expose metrics.",
 	).Default("/metrics").String()
+	configFile = kingpin.Flag(
+		"config.file",
+		"Path to the YAML configuration file.",
+	).Default("").String()
 	timeoutOffset = kingpin.Flag(
 		"timeout-offset",
 		"Offset to subtract from timeout in seconds.",
+	).Default("0.25").IsSetByUser(&timeoutOffsetSet).Float64()
+	leaderElection = kingpin.Flag(
+		"exporter.leader-election",
+		"Let replicas scraping the same database elect a leader; only the leader collects. One of: file, database.",
+	).Default("").Enum("", leaderElectionFile, leaderElectionDatabase)
+	leaseFile = kingpin.Flag(
+		"exporter.leader.lease-file",
+		"Lease file shared by co-located replicas with --exporter.leader-election=file.",
+	).Default("cubrid_exporter.lease").String()
+	leaseTable = kingpin.Flag(
+		"exporter.leader.lease-table",
+		"Table of the monitored database holding the lease with --exporter.leader-election=database.",
+	).Default("cubrid_exporter_lease").String()
+	leaseAllowWrites = kingpin.Flag(
+		"exporter.leader.allow-database-writes",
+		"Allow creating and updating the lease table in the monitored database, which requires write permission.",
+	).Default("false").Bool()
+	leaseDuration = kingpin.Flag(
+		"exporter.leader.lease-duration",
+		"Time after which another replica takes over the lease of a leader that stopped renewing it.",
+	).Default("15s").Duration()
+	leaseClockSkew = kingpin.Flag(
+		"exporter.leader.clock-skew",
+		"Tolerated clock difference between replicas. A leader steps down this long before its lease expires.",
+	).Default("2s").Duration()
+	shutdownTimeout = kingpin.Flag(
+		"web.shutdown-timeout",
+		"Maximum time for the whole shutdown sequence.",
+	).Default("10s").IsSetByUser(&shutdownTimeoutSet).Duration()
+	shutdownPhaseTimeout = kingpin.Flag(
+		"web.shutdown-phase-timeout",
+		"Maximum time to wait for each component to stop on shutdown.",
+	).Default("5s").IsSetByUser(&shutdownPhaseTimeoutSet).Duration()
+	cacheTTL = kingpin.Flag(
+		"exporter.cache-ttl",
+		"How long scraped metrics are cached per database and collector. 0 disables caching. Overrides the cache TTLs of the profile.",
+	).Default("0s").IsSetByUser(&cacheTTLSet).Duration()
+	scrapeProfileName = kingpin.Flag(
+		"exporter.profile",
+		"Scrape profile selecting the collectors and cache TTLs: minimal, standard, intensive or one defined in the config file. --collect.<name> flags override it.",
+	).Default(profileStandard).String()
+	scrapeOffsetMax = kingpin.Flag(
+		"exporter.scrape-offset-hash",
+		"Delay the database collection of each scrape by an offset up to this duration, derived from a hash of the instance ID. 0 disables the offset.",
+	).Default("0s").Duration()
+	scrapeOffsetFraction = kingpin.Flag(
+		"exporter.scrape-offset-max-fraction",
+		"Skip the scrape offset if it would use more than this fraction of the time left until the scrape deadline.",
 	).Default("0.25").Float64()
+	responseHeaders = kingpin.Flag(
+		"web.response-header",
+		"Header to set on the metrics response, as key=value. Can be repeated.",
+	).StringMap()
+	metricsCompat = kingpin.Flag(
+		"metrics.compat",
+		"Additionally export mapped metrics under the names of another exporter, labeled compat=\"<mode>\". Supported: mysqld.",
+	).Default("").Enum("", collector.CompatMysqld)
+	extraNamespace = kingpin.Flag(
+		"metrics.extra-namespace",
+		"Additionally export every cubrid_* family under this namespace, labeled origin=\"compat-namespace\", e.g. during a namespace migration.",
+	).Default("").String()
+	enableAdminRead = kingpin.Flag(
+		"web.enable-admin-api.read",
+		"Enable the read-only administrative endpoints under /-/.",
+	).Default("false").Bool()
+	enableAdminWrite = kingpin.Flag(
+		"web.enable-admin-api.write",
+		"Enable the mutating administrative endpoints under /-/. Requests must present a token from --web.admin-token-file.",
+	).Default("false").Bool()
+	adminTokenFile = kingpin.Flag(
+		"web.admin-token-file",
+		"File with the bearer tokens accepted by mutating administrative endpoints, one [identity:]token per line.",
+	).Default("").String()
+	lockdownOnAuditFailure = kingpin.Flag(
+		"security.lockdown-on-audit-failure",
+		"Reject every request to the mutating administrative endpoints once a security self-audit failed, until restart.",
+	).Default("false").Bool()
+	instanceIDFlag = kingpin.Flag(
+		"instance-id",
+		"Instance ID to use instead of the generated one.",
+	).Default("").String()
+	instanceIDFile = kingpin.Flag(
+		"instance-id.file",
+		"Path to the file where the generated instance ID is persisted.",
+	).Default("cubrid_exporter.instance_id").String()
+	exitOnStartupFailure = kingpin.Flag(
+		"exporter.exit-on-startup-failure",
+		"Exit if the database does not accept connections at startup, once /-/ready reported the failure or after 30s. By default the exporter keeps running and reports cubrid_up 0.",
+	).Default("false").Bool()
+	simulate = kingpin.Flag(
+		"simulate",
+		"Replace the real scrapers with synthetic ones for capacity testing. No database is queried.",
+	).Default("false").Bool()
+	simulateBrokers = kingpin.Flag(
+		"simulate.brokers",
+		"Number of synthetic brokers in simulate mode.",
+	).Default("10").Int()
+	simulateVolumes = kingpin.Flag(
+		"simulate.volumes",
+		"Number of synthetic volumes in simulate mode.",
+	).Default("10").Int()
+	simulateStatdumpKeys = kingpin.Flag(
+		"simulate.statdump-keys",
+		"Number of synthetic statdump keys in simulate mode.",
+	).Default("100").Int()
+	simulateSeed = kingpin.Flag(
+		"simulate.seed",
+		"Seed for the synthetic values in simulate mode.",
+	).Default("1").Int64()
+	simulateDrift = kingpin.Flag(
+		"simulate.drift",
+		"Maximum relative change of synthetic values between scrapes.",
+	).Default("0.05").Float64()
+	cubridDSN = kingpin.Flag(
+		"cubrid.dsn",
+		"Full CCI DSN, e.g. cci:cubrid:host:33000:demodb:dba::. Takes precedence over DATA_SOURCE_NAME and the individual --cubrid.* settings.",
+	).Default("").Envar("CUBRID_DSN").String()
+	dsnFile = kingpin.Flag(
+		"config.dsn-file",
+		"YAML file with the host, port, database, user and password to connect with, keeping credentials off the command line.",
+	).Default("").String()
+	cubridHost = kingpin.Flag(
+		"cubrid.host",
+		"Host of the CUBRID broker.",
+	).Default("").String()
+	cubridPort = kingpin.Flag(
+		"cubrid.port",
+		"Port of the CUBRID broker.",
+	).Default("33000").String()
+	cubridDatabase = kingpin.Flag(
+		"cubrid.database",
+		"Name of the database to connect to.",
+	).Default("").String()
+	cubridUser = kingpin.Flag(
+		"cubrid.user",
+		"User to connect as.",
+	).Default("public").String()
+	cubridPassword = kingpin.Flag(
+		"cubrid.password",
+		"Password of the user.",
+	).Default("").String()
+	pushgatewayURL = kingpin.Flag(
+		"pushgateway.url",
+		"Pushgateway URL. If set, scrape once, push the result and exit instead of serving HTTP.",
+	).Default("").String()
+	pushgatewayJob = kingpin.Flag(
+		"pushgateway.job",
+		"Job name used when pushing to the Pushgateway.",
+	).Default("cubrid_exporter").String()
+	pushgatewayTimeout = kingpin.Flag(
+		"pushgateway.timeout",
+		"Timeout for the one-shot scrape pushed to the Pushgateway or remote-write receiver, or for recording fixtures.",
+	).Default("30s").Duration()
+	remoteWriteURL = kingpin.Flag(
+		"push.remote-write-url",
+		"Prometheus remote-write URL. If set, scrape once, send the result and exit instead of serving HTTP. Requests failing with 5xx or 429 are retried with backoff within --pushgateway.timeout.",
+	).Default("").String()
+	remoteWriteUsername = kingpin.Flag(
+		"push.remote-write.username",
+		"Username for basic authentication against the remote-write receiver.",
+	).Default("").String()
+	remoteWritePasswordFile = kingpin.Flag(
+		"push.remote-write.password-file",
+		"File containing the password for basic authentication against the remote-write receiver.",
+	).Default("").String()
+	remoteWriteBearerTokenFile = kingpin.Flag(
+		"push.remote-write.bearer-token-file",
+		"File containing the bearer token sent to the remote-write receiver.",
+	).Default("").String()
+	coverageReport = kingpin.Flag(
+		"coverage-report",
+		"Scrape once, print which statdump keys and status columns of the server the collectors consume as JSON and exit.",
+	).Default("false").Bool()
+	recordFixtures = kingpin.Flag(
+		"record-fixtures",
+		"Directory to record the raw results of all approved queries into, below a directory named for the server version. If set, record once and exit instead of serving HTTP.",
+	).Default("").String()
+	publicListenAddress = kingpin.Flag(
+		"web.public-listen-address",
+		"Address to serve the metrics allowed by public_metrics of the config file on, without authentication. Empty disables it.",
+	).Default("").String()
+	baselineURL = kingpin.Flag(
+		"baseline.url",
+		"URL of the signed fleet configuration baseline to compare the effective configuration with.",
+	).Default("").String()
+	baselineBearerTokenFile = kingpin.Flag(
+		"baseline.bearer-token-file",
+		"File containing the bearer token sent to the baseline URL.",
+	).Default("").String()
+	baselinePublicKeyFile = kingpin.Flag(
+		"baseline.public-key-file",
+		"File containing the base64 encoded ed25519 public key the baseline is signed with.",
+	).Default("").String()
+	baselineInterval = kingpin.Flag(
+		"baseline.refresh-interval",
+		"How often the baseline is fetched again.",
+	).Default("1h").Duration()
 
-	dsn string
+	instanceID string
+	// cacheTTLSet tells whether --exporter.cache-ttl overrides the profile.
+	cacheTTLSet bool
+	// The flags set on the command line take precedence over the web
+	// settings of the config file.
+	listenAddressSet, timeoutOffsetSet, shutdownTimeoutSet, shutdownPhaseTimeoutSet bool
 )
 
 // scrapers lists all possible collection methods and if they should be enabled by default.
 var scrapers = map[collector.Scraper]bool{
-	collector.ScrapeBrokerStatus{}:  true,
-	collector.ScrapeStatdump{}:      true,
-	collector.ScrapeSpaceDBStatus{}: true,
+	collector.ScrapeBrokerStatus{}:      true,
+	collector.ScrapeStatdump{}:          true,
+	collector.ScrapeSpaceDBStatus{}:     true,
+	collector.ScrapeBrokerServerPing{}:  false,
+	collector.ScrapeReplicationApply{}:  false,
+	collector.ScrapeHAStatus{}:          false,
+	collector.ScrapeSessionsByProgram{}: false,
+	collector.ScrapeErrorLog{}:          false,
+	collector.ScrapeLobStorage{}:        false,
+	collector.ScrapeInventory{}:         true,
 }
 
+// buildFeatureRemoteWrite is the optional remote-write client, excluded by
+// the build tag noremotewrite.
+const buildFeatureRemoteWrite = "remotewrite"
+
 func init() {
 	prometheus.MustRegister(version.NewCollector("cubrid_exporter"))
+	prometheus.MustRegister(collector.BuildFeatureCollector)
 }
 
-func newHandler(metrics collector.Metrics, scrapers []collector.Scraper) http.HandlerFunc {
+// pipeline post-processes gathered metrics before any output path.
+type pipeline func(prometheus.Gatherer) prometheus.Gatherer
+
+func newHandler(dsn string, metrics collector.Metrics, profiles *profileSwitch, process pipeline, leader *leaderElector) http.HandlerFunc {
+	meta := promhttp.HandlerFor(process(prometheus.DefaultGatherer), promhttp.HandlerOpts{})
+	coalesce := newCoalescer()
 	return func(w http.ResponseWriter, r *http.Request) {
-		filteredScrapers := scrapers
-		params := r.URL.Query()["collect[]"]
-		// Use request context for cancellation when connection gets closed.
-		ctx := r.Context()
-		// If a timeout is configured via the Prometheus header, add it to the context.
-		if v := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"); v != "" {
-			timeoutSeconds, err := strconv.ParseFloat(v, 64)
-			if err != nil {
-				log.Errorf("Failed to parse timeout from Prometheus header: %s", err)
-			} else {
-				if *timeoutOffset >= timeoutSeconds {
-					// Ignore timeout offset if it doesn't leave time to scrape.
-					log.Errorf(
-						"Timeout offset (--timeout-offset=%.2f) should be lower than prometheus scrape time (X-Prometheus-Scrape-Timeout-Seconds=%.2f).",
-						*timeoutOffset,
-						timeoutSeconds,
-					)
-				} else {
-					// Subtract timeout offset from timeout.
-					timeoutSeconds -= *timeoutOffset
-				}
-				// Create new timeout context with request context as parent.
-				var cancel context.CancelFunc
-				ctx, cancel = context.WithTimeout(ctx, time.Duration(timeoutSeconds*float64(time.Second)))
-				defer cancel()
-				// Overwrite request with timeout context.
-				r = r.WithContext(ctx)
+		// Without a default target CUBRID is only scraped through /probe.
+		// Replicas not holding the leader lease do not collect either.
+		if dsn == "" || !leader.isLeader() {
+			for name, value := range *responseHeaders {
+				w.Header().Set(name, value)
 			}
+			meta.ServeHTTP(w, r)
+			return
 		}
-		log.Debugln("collect query:", params)
+		settings := profiles.current()
+		serveScrape(w, r, dsn, metrics, settings.scrapers, settings.cache, process, coalesce, prometheus.DefaultGatherer)
+	}
+}
 
-		// Check if we have some "collect[]" query parameters.
-		if len(params) > 0 {
-			filters := make(map[string]bool)
-			for _, param := range params {
-				filters[param] = true
+// serveScrape scrapes dsn with the scrapers selected by the collect[] parameters
+// and serves the result together with base, if not nil. An empty dsn reports
+// the target as down.
+func serveScrape(w http.ResponseWriter, r *http.Request, dsn string, metrics collector.Metrics, scrapers []collector.Scraper,
+	cache *collector.ScrapeCache, process pipeline, coalesce *coalescer, base prometheus.Gatherer) {
+	filteredScrapers := scrapers
+	params := r.URL.Query()["collect[]"]
+	// Use request context for cancellation when connection gets closed.
+	ctx := r.Context()
+	// If a timeout is configured via the Prometheus header, add it to the context.
+	if v := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"); v != "" {
+		timeoutSeconds, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			log.Errorf("Failed to parse timeout from Prometheus header: %s", err)
+		} else {
+			if *timeoutOffset >= timeoutSeconds {
+				// Ignore timeout offset if it doesn't leave time to scrape.
+				log.Errorf(
+					"Timeout offset (--timeout-offset=%.2f) should be lower than prometheus scrape time (X-Prometheus-Scrape-Timeout-Seconds=%.2f).",
+					*timeoutOffset,
+					timeoutSeconds,
+				)
+			} else {
+				// Subtract timeout offset from timeout.
+				timeoutSeconds -= *timeoutOffset
 			}
+			// Create new timeout context with request context as parent.
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, time.Duration(timeoutSeconds*float64(time.Second)))
+			defer cancel()
+			// Overwrite request with timeout context.
+			r = r.WithContext(ctx)
+		}
+	}
+	log.Debugln("collect query:", params)
+
+	// Check if we have some "collect[]" query parameters.
+	if len(params) > 0 {
+		filters := make(map[string]bool)
+		for _, param := range params {
+			filters[param] = true
+		}
 
-			filteredScrapers = nil
-			for _, scraper := range scrapers {
-				if filters[scraper.Name()] {
-					filteredScrapers = append(filteredScrapers, scraper)
-				}
+		filteredScrapers = nil
+		for _, scraper := range scrapers {
+			if filters[scraper.Name()] {
+				filteredScrapers = append(filteredScrapers, scraper)
 			}
 		}
+	}
+
+	waitScrapeOffset(ctx, scrapeOffset(instanceID, *scrapeOffsetMax), *scrapeOffsetFraction)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector.New(ctx, dsn, metrics, filteredScrapers, cache))
+
+	gatherers := prometheus.Gatherers{registry}
+	if base != nil {
+		gatherers = append(prometheus.Gatherers{base}, gatherers...)
+	}
+	for name, value := range *responseHeaders {
+		w.Header().Set(name, value)
+	}
+
+	// Concurrent identical requests share one collection.
+	key := coalesceKey(dsn, filteredScrapers)
+	gatherer := prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		return coalesce.gather(ctx, key, process(gatherers), metrics)
+	})
 
-		registry := prometheus.NewRegistry()
-		registry.MustRegister(collector.New(ctx, dsn, metrics, filteredScrapers))
+	// Delegate http serving to Prometheus client library, which will call collector.Collect.
+	h := promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{})
+	h.ServeHTTP(w, r)
+}
+
+// newPublicHandler serves the metrics of public on metricPath and nothing
+// else, so no admin or probe route is reachable without authentication.
+func newPublicHandler(metricPath string, public prometheus.Gatherer) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle(metricPath, promhttp.HandlerFor(public, promhttp.HandlerOpts{}))
+	return mux
+}
 
-		gatherers := prometheus.Gatherers{
-			prometheus.DefaultGatherer,
-			registry,
+// validHeaderName reports whether name is a valid HTTP header field name (RFC 7230 token).
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.ContainsRune("!#$%&'*+-.^_`|~", c):
+		default:
+			return false
 		}
-		// Delegate http serving to Prometheus client library, which will call collector.Collect.
-		h := promhttp.HandlerFor(gatherers, promhttp.HandlerOpts{})
-		h.ServeHTTP(w, r)
 	}
+	return true
 }
 
-func createDSN() {
-	// code
-	ip := ""
-	port := ""
-	databaseName := ""
-	username := ""
-	password := ""
+// driverVersion returns the version of the CUBRID Go driver the binary was built with.
+func driverVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range info.Deps {
+			if dep.Path == "github.com/cubrid/cubrid-go" {
+				return dep.Version
+			}
+		}
+	}
+	return "unknown"
+}
 
-	dsn = "cci:cubrid:" + ip + ":" + port + ":" + databaseName + ":" + username + ":" + password + ":"
+// minServerVersion returns the lowest CUBRID version any scraper supports.
+func minServerVersion() float64 {
+	min := 0.0
+	for scraper := range scrapers {
+		if min == 0 || scraper.Version() < min {
+			min = scraper.Version()
+		}
+	}
+	return min
 }
 
 func main() {
 
-	createDSN()
-
 	// Generate ON/OFF flags for all scrapers.
 	scraperFlags := map[collector.Scraper]*bool{}
+	scraperFlagsSet := map[collector.Scraper]*bool{}
 	for scraper, enabledByDefault := range scrapers {
 		defaultOn := "false"
 		if enabledByDefault {
 			defaultOn = "true"
 		}
 
+		set := new(bool)
 		f := kingpin.Flag(
 			"collect."+scraper.Name(),
 			scraper.Help(),
-		).Default(defaultOn).Bool()
+		).Default(defaultOn).IsSetByUser(set).Bool()
 
 		scraperFlags[scraper] = f
+		scraperFlagsSet[scraper] = set
 	}
 
 	// Parse flags.
 	log.AddFlags(kingpin.CommandLine)
-	kingpin.Version(version.Print("cubrid_exporter"))
+	kingpin.Version(fmt.Sprintf("%s\n  cubrid driver:     %s\n  min server:        %.1f\n  features:          %s",
+		version.Print("cubrid_exporter"), driverVersion(), minServerVersion(), strings.Join(collector.BuildFeatures(), ", ")))
 	kingpin.HelpFlag.Short('h')
 	kingpin.Parse()
 
+	features := featureConfig{
+		FeatureSettings:            collector.Features(),
+		Profile:                    *scrapeProfileName,
+		PushgatewayURL:             *pushgatewayURL,
+		RemoteWriteURL:             *remoteWriteURL,
+		RemoteWriteUsername:        *remoteWriteUsername,
+		RemoteWritePasswordFile:    *remoteWritePasswordFile,
+		RemoteWriteBearerTokenFile: *remoteWriteBearerTokenFile,
+		RecordFixtures:             *recordFixtures,
+		Simulate:                   *simulate,
+		LeaderElection:             *leaderElection,
+		LeaseAllowWrites:           *leaseAllowWrites,
+		LeaseDuration:              *leaseDuration,
+		LeaseClockSkew:             *leaseClockSkew,
+		BaselineURL:                *baselineURL,
+		BaselinePublicKeyFile:      *baselinePublicKeyFile,
+		BaselineBearerTokenFile:    *baselineBearerTokenFile,
+	}
+	featureErrs := checkFeatures(features)
+	for _, err := range featureErrs {
+		log.Errorln("Incompatible features:", err)
+	}
+	if len(featureErrs) > 0 {
+		log.Fatalf("%d feature compatibility rule(s) violated", len(featureErrs))
+	}
+
+	for name := range *responseHeaders {
+		if !validHeaderName(name) {
+			log.Fatalf("Invalid header name in --web.response-header: %q", name)
+		}
+	}
+
 	// landingPage contains the HTML served at '/'.
 	// TODO: Make this nicer and more informative.
 	var landingPage = []byte(`<html>
@@ -161,6 +489,8 @@ func main() {
 <body>
 <h1>CUBRID exporter</h1>
 <p><a href='` + *metricPath + `'>Metrics</a></p>
+<p><a href='/-/healthy'>Health</a></p>
+<p><a href='/-/ready'>Readiness</a></p>
 </body>
 </html>
 `)
@@ -168,21 +498,335 @@ func main() {
 	log.Infoln("Starting cubrid_exporter", version.Info())
 	log.Infoln("Build context", version.BuildContext())
 
-	// Register only scrapers enabled by flag.
-	log.Infof("Enabled scrapers:")
-	enabledScrapers := []collector.Scraper{}
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		log.Fatalf("Error loading config file %s: %s", *configFile, err)
+	}
+	if err := cfg.Web.apply(); err != nil {
+		log.Fatalf("Invalid web settings in config file %s: %s", *configFile, err)
+	}
+
+	// Only set up the connection once flags are parsed, so --version and --help exit without it.
+	// With auth modules for /probe, an exporter without a default target is valid.
+	dsn := collector.SimulatedDSN
+	if !*simulate {
+		if dsn, err = createDSN(cfg.DSN); err != nil {
+			if len(cfg.AuthModules) == 0 || *cubridDSN != "" || os.Getenv("DATA_SOURCE_NAME") != "" || cfg.DSN != "" || *pushgatewayURL != "" || *remoteWriteURL != "" || *recordFixtures != "" || *coverageReport {
+				log.Fatalln("Invalid database connection settings:", err)
+			}
+			log.Infoln("No default target configured, scrape CUBRID through /probe:", err)
+		}
+	}
+
+	for name := range cfg.Collectors {
+		known := false
+		for scraper := range scrapers {
+			known = known || scraper.Name() == name
+		}
+		if !known {
+			log.Fatalf("Unknown collector %q in collectors", name)
+		}
+	}
+	if err := collector.SetCollectorConfigs(cfg.Collectors); err != nil {
+		log.Fatalf("Invalid collectors: %s", err)
+	}
+	relabeler, err := collector.NewRelabeler(cfg.MetricRelabelConfigs)
+	if err != nil {
+		log.Fatalf("Invalid metric_relabel_configs: %s", err)
+	}
+	prometheus.MustRegister(relabeler)
+	churnGuard, err := collector.NewChurnGuard(cfg.ChurnLimits)
+	if err != nil {
+		log.Fatalf("Invalid churn_limits: %s", err)
+	}
+	prometheus.MustRegister(churnGuard)
+	hwm, err := collector.NewHighWaterMarks(cfg.HighWaterMarks)
+	if err != nil {
+		log.Fatalf("Invalid high_water_marks: %s", err)
+	}
+	prometheus.MustRegister(hwm)
+	collector.RegisterStateSaver(hwm)
+	derived, err := collector.NewDerivedMetrics(cfg.DerivedMetrics)
+	if err != nil {
+		log.Fatalf("Invalid derived_metrics: %s", err)
+	}
+	prometheus.MustRegister(derived)
+	alerting, err := collector.NewAlerting(cfg.Alerting)
+	if err != nil {
+		log.Fatalf("Invalid alerting: %s", err)
+	}
+	if alerting != nil {
+		prometheus.MustRegister(alerting)
+	}
+	public, err := collector.NewPublicView(cfg.PublicMetrics)
+	if err != nil {
+		log.Fatalf("Invalid public_metrics: %s", err)
+	}
+	if *publicListenAddress != "" && public == nil {
+		log.Fatalln("--web.public-listen-address requires public_metrics.families in the config file")
+	}
+	if err := collector.CheckStateKeys(); err != nil {
+		log.Fatalf("Invalid --state.encryption-key-file: %s", err)
+	}
+	if err := collector.LoadState(); err != nil {
+		log.Warnf("Error loading state file, starting without persisted state: %s", err)
+	}
+	prometheus.MustRegister(collector.StateFileMetrics)
+	// Load the warm-start snapshot before serving, so the first scrapes use it.
+	loadCtx, cancelLoad := context.WithTimeout(context.Background(), 10*time.Second)
+	if err := collector.WarmStart.Load(loadCtx); err != nil {
+		log.Warnf("Error loading warm-start snapshot, collecting from scratch: %s", err)
+	}
+	cancelLoad()
+	prometheus.MustRegister(collector.WarmStart)
+	if err := collector.ApproveCanaryQuery(); err != nil {
+		log.Fatalf("Invalid --probe.query: %s", err)
+	}
+	prometheus.MustRegister(collector.DNSCache)
+	prometheus.MustRegister(collector.ConnectStages)
+	prometheus.MustRegister(collector.Throttle)
+	prometheus.MustRegister(collector.Audit)
+	prometheus.MustRegister(collector.SelfAudit)
+	prometheus.MustRegister(collector.QueryBudget)
+	prometheus.MustRegister(collector.Coverage)
+	prometheus.MustRegister(collector.Chaos)
+	if collector.ChaosEnabled() {
+		log.Warnln("Fault injection enabled, metrics may be falsified through the admin API")
+	}
+	compat, err := collector.NewCompat(*metricsCompat)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	extraNS, err := collector.NewExtraNamespace(*extraNamespace)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	process := func(g prometheus.Gatherer) prometheus.Gatherer {
+		return alerting.Wrap(public.Wrap(extraNS.Wrap(hwm.Wrap(churnGuard.Wrap(relabeler.Wrap(compat.Wrap(derived.Wrap(collector.Chaos.Wrap(g)))))))))
+	}
+
+	instanceID, err = collector.ResolveInstanceID(*instanceIDFlag, *instanceIDFile)
+	if err != nil {
+		log.Warnf("Using ephemeral instance ID %s: %s", instanceID, err)
+	}
+	log.Infoln("Instance ID", instanceID)
+	prometheus.MustRegister(collector.InstanceCollector{ID: instanceID, Ephemeral: err != nil})
+
+	startup := newStartupTracker()
+	prometheus.MustRegister(startup)
+	// Database-dependent startup work, run in the background once the listener is up.
+	var startupTasks []startupTask
+	if dsn != "" && !*simulate {
+		startupTasks = append(startupTasks, startupTask{name: "database ping", run: pingDatabase(dsn), fatal: *exitOnStartupFailure})
+	}
+
+	// Collectors enabled or disabled in the config file override the profile,
+	// and flags override both.
+	explicitScrapers := map[string]bool{}
+	for name, c := range cfg.Collectors {
+		if c.Enabled != nil {
+			explicitScrapers[name] = *c.Enabled
+		}
+	}
 	for scraper, enabled := range scraperFlags {
-		if *enabled {
-			log.Infof(" --collect.%s", scraper.Name())
-			enabledScrapers = append(enabledScrapers, scraper)
+		if *scraperFlagsSet[scraper] {
+			explicitScrapers[scraper.Name()] = *enabled
+		}
+	}
+	var explicitCacheTTL *time.Duration
+	if cacheTTLSet {
+		explicitCacheTTL = cacheTTL
+	}
+	var simulated []collector.Scraper
+	if *simulate {
+		log.Warnln("Simulate mode enabled, serving synthetic data instead of scraping CUBRID")
+		simulated = collector.NewSimulatedScrapers(collector.Simulation{
+			Brokers:      *simulateBrokers,
+			Volumes:      *simulateVolumes,
+			StatdumpKeys: *simulateStatdumpKeys,
+			Seed:         *simulateSeed,
+			Drift:        *simulateDrift,
+		})
+	}
+	profiles, err := newProfileSwitch(*scrapeProfileName, cfg.Profiles, explicitScrapers, explicitCacheTTL, simulated,
+		func(profile string) []error {
+			candidate := features
+			candidate.Profile = profile
+			return checkFeatures(candidate)
+		})
+	if err != nil {
+		log.Fatalf("Invalid profiles: %s", err)
+	}
+	prometheus.MustRegister(profiles)
+	enabledScrapers := profiles.current().scrapers
+	log.Infof("Enabled scrapers (profile %s):", *scrapeProfileName)
+	for _, scraper := range enabledScrapers {
+		log.Infof(" --collect.%s", scraper.Name())
+	}
+	if len(enabledScrapers) == 0 {
+		log.Warnln("No collectors are enabled, scrapes will not produce any CUBRID metrics")
+	}
+	if *coverageReport {
+		ctx, cancel := context.WithTimeout(context.Background(), *pushgatewayTimeout)
+		defer cancel()
+		if err := printCoverage(ctx, dsn, enabledScrapers, os.Stdout); err != nil {
+			log.Fatalln("Error reporting coverage:", err)
+		}
+		return
+	}
+	if *recordFixtures != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), *pushgatewayTimeout)
+		defer cancel()
+		dir, err := collector.RecordFixtures(ctx, dsn, *recordFixtures, version.Version, enabledScrapers)
+		if err != nil {
+			log.Fatalln("Error recording fixtures:", err)
 		}
+		log.Infoln("Recorded fixtures to", dir)
+		return
 	}
-	handlerFunc := newHandler(collector.NewMetrics(), enabledScrapers)
+	if *pushgatewayURL != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), *pushgatewayTimeout)
+		defer cancel()
+		if err := pushOnce(ctx, dsn, *pushgatewayURL, *pushgatewayJob, enabledScrapers, process); err != nil {
+			log.Fatalln("Error pushing to Pushgateway:", err)
+		}
+		log.Infoln("Pushed metrics to", *pushgatewayURL)
+		return
+	}
+	if *remoteWriteURL != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), *pushgatewayTimeout)
+		defer cancel()
+		auth := remoteWriteAuth{
+			username:        *remoteWriteUsername,
+			passwordFile:    *remoteWritePasswordFile,
+			bearerTokenFile: *remoteWriteBearerTokenFile,
+		}
+		if err := remoteWriteOnce(ctx, dsn, *remoteWriteURL, auth, enabledScrapers, process); err != nil {
+			log.Fatalln("Error sending remote write request:", err)
+		}
+		log.Infoln("Sent metrics to", *remoteWriteURL)
+		return
+	}
+
+	metrics := collector.NewMetrics()
+	var leader *leaderElector
+	if *leaderElection != "" {
+		var store leaseStore = fileLeaseStore{path: *leaseFile, settle: fileLeaseSettle}
+		if *leaderElection == leaderElectionDatabase {
+			if store, err = newDBLeaseStore(dsn, *leaseTable); err != nil {
+				log.Fatalln("Invalid lease table:", err)
+			}
+		}
+		leader = newLeaderElector(store, instanceID, *leaseDuration, *leaseClockSkew)
+		prometheus.MustRegister(leader)
+	}
+	var drift *baselineChecker
+	if *baselineURL != "" {
+		if drift, err = newBaselineChecker(*baselineURL, *baselineBearerTokenFile, *baselinePublicKeyFile, cfg.Hash); err != nil {
+			log.Fatalln("Invalid baseline public key:", err)
+		}
+		prometheus.MustRegister(drift)
+	}
+	handlerFunc := newHandler(dsn, metrics, profiles, process, leader)
 	http.Handle(*metricPath, promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, handlerFunc))
+	http.Handle("/health-metrics", newHealthMetricsHandler(metrics, cfg.Hash))
+	if !*simulate {
+		// Probes skip the stateful high-water marks and churn limits, which would mix targets.
+		probeProcess := func(g prometheus.Gatherer) prometheus.Gatherer {
+			return extraNS.Wrap(relabeler.Wrap(compat.Wrap(derived.Wrap(collector.Chaos.Wrap(g)))))
+		}
+		http.Handle("/probe", newProbeHandler(cfg.AuthModules, profiles, probeProcess))
+	}
+	http.HandleFunc("/-/healthy", healthyHandler)
+	http.HandleFunc("/-/ready", startup.readyHandler)
+	adminTokens, err := loadAdminTokens(*adminTokenFile)
+	if err != nil {
+		log.Fatalf("Error loading admin token file %s: %s", *adminTokenFile, err)
+	}
+	if *enableAdminWrite && len(adminTokens) == 0 {
+		log.Warnln("Admin write API enabled without --web.admin-token-file, all write requests will be rejected")
+	}
+	admin := &adminAPI{read: *enableAdminRead, write: *enableAdminWrite, tokens: adminTokens}
+	if *lockdownOnAuditFailure {
+		collector.SelfAudit.OnFailure(admin.lockDown)
+	}
+	admin.handleRead("/-/hwm", hwmHandler(hwm))
+	admin.handleRead("/-/queries", queriesHandler)
+	admin.handleRead("/-/coverage", coverageHandler)
+	admin.handleRead("/-/recommendations", recommendationsHandler)
+	admin.handleRead("/-/warmstart", warmStartHandler)
+	admin.handleReadWrite("/-/chaos", chaosHandler, http.MethodPost, chaosInjectHandler)
+	admin.handleWrite("/-/chaos/clear", chaosClearHandler)
+	admin.handleWrite("/-/hwm/reset", hwmResetHandler(hwm))
+	admin.handleWrite("/-/collectors/reenable", collectorReenableHandler)
+	admin.handleReadWrite(logLevelPath, logLevelReadHandler, http.MethodPut, logLevelWriteHandler(scrapers))
+	admin.handleReadWrite("/-/errors", errorsHandler, http.MethodDelete, errorsClearHandler)
+	admin.handleReadWrite("/-/profile", profileReadHandler(profiles), http.MethodPut, profileWriteHandler(profiles))
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write(landingPage)
 	})
 
+	// Bind before any database work so liveness probes succeed while the database is slow or down.
+	listener, err := net.Listen("tcp", *listenAddress)
+	if err != nil {
+		log.Fatal(err)
+	}
+	startup.complete(phaseListening)
 	log.Infoln("Listening on", *listenAddress)
-	log.Fatal(http.ListenAndServe(*listenAddress, nil))
+
+	// Shutdown order: stop advertising readiness, then stop accepting HTTP
+	// requests and drain in-flight scrapes, then cancel and wait for the
+	// background workers in the order they are started below.
+	server := &http.Server{}
+	publicServer := &http.Server{}
+	shutdown := newLifecycle(*shutdownPhaseTimeout)
+	shutdown.Register("readiness", func(ctx context.Context) error {
+		startup.unready()
+		return nil
+	})
+	shutdown.Register("http server", server.Shutdown)
+	shutdown.Register("public http server", publicServer.Shutdown)
+
+	shutdown.Go("startup tasks", func(ctx context.Context) { startup.run(ctx, startupTasks) }, nil)
+	if leader != nil {
+		// Stop the renewals first; release waits for one in flight.
+		shutdown.Go("leader lease", leader.run, leader.release)
+	}
+	if drift != nil {
+		shutdown.Go("baseline drift check", func(ctx context.Context) { drift.run(ctx, *baselineInterval) }, nil)
+	}
+	shutdown.Go("alerting", alerting.Run, nil)
+	shutdown.Go("security self-audit", collector.SelfAudit.Run, nil)
+	shutdown.Go("warm-start snapshot", collector.WarmStart.Run, func(ctx context.Context) error {
+		return collector.WarmStart.WriteSnapshot()
+	})
+
+	go func() {
+		if err := server.Serve(listener); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+	// The public listener only serves the allowed subset of the last /metrics
+	// scrape; no other route is registered on it.
+	if *publicListenAddress != "" {
+		publicListener, err := net.Listen("tcp", *publicListenAddress)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Infoln("Serving public metrics on", *publicListenAddress)
+		publicServer.Handler = newPublicHandler(*metricPath, public)
+		go func() {
+			if err := publicServer.Serve(publicListener); err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	shutdown.Register("state file", func(ctx context.Context) error {
+		return collector.SaveState()
+	})
+	shutdown.Register("database connections", func(ctx context.Context) error {
+		return collector.ClosePools()
+	})
+	waitForShutdown(shutdown, *shutdownTimeout)
 }
@@ -0,0 +1,147 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/cubrid/cubrid-exporter/collector"
+)
+
+// pingTimeout bounds the connection check at startup.
+const pingTimeout = 10 * time.Second
+
+// dsnConfig holds the parts of a CCI connection URL. It is the content of
+// the --config.dsn-file YAML file.
+type dsnConfig struct {
+	Host     string `yaml:"host"`
+	Port     string `yaml:"port"`
+	Database string `yaml:"database"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+}
+
+// build returns the cci:cubrid:host:port:db:user:password: DSN. The password
+// is the last positional field, so it may contain ':'; the other fields may not.
+func (c dsnConfig) build() (string, error) {
+	if c.Host == "" || c.Database == "" {
+		return "", fmt.Errorf("host and database are required")
+	}
+	for name, value := range map[string]string{"host": c.Host, "port": c.Port, "database": c.Database, "user": c.User} {
+		if strings.Contains(value, ":") {
+			return "", fmt.Errorf("%s must not contain ':'", name)
+		}
+	}
+	return "cci:cubrid:" + c.Host + ":" + c.Port + ":" + c.Database + ":" + c.User + ":" + c.Password + ":", nil
+}
+
+// loadDSNFile reads a dsnConfig from path. Fields missing from the file keep
+// the values of c, so the individual flags act as defaults.
+func (c dsnConfig) loadDSNFile(path string) (dsnConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return c, err
+	}
+	if err := yaml.UnmarshalStrict(data, &c); err != nil {
+		return c, err
+	}
+	return c, nil
+}
+
+// validateDSN checks that dsn is a CCI connection URL of the form
+// cci:cubrid:host:port:database:user:password:[?properties] with a host, a
+// database and a numeric port if one is given. Errors never contain the password.
+func validateDSN(dsn string) error {
+	fields := strings.SplitN(dsn, ":", 7)
+	if len(fields) < 7 || fields[0] != "cci" || fields[1] != "cubrid" {
+		return fmt.Errorf("not of the form cci:cubrid:host:port:database:user:password:")
+	}
+	if fields[2] == "" || fields[4] == "" {
+		return fmt.Errorf("host and database are required")
+	}
+	if port := fields[3]; port != "" {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return fmt.Errorf("invalid port %q", port)
+		}
+	}
+	return nil
+}
+
+// createDSN returns the DSN to connect with. In order of precedence it is
+// taken from --cubrid.dsn or CUBRID_DSN, from DATA_SOURCE_NAME, or from the
+// dsn of --config.file given as configDSN, after validating its format,
+// or assembled from --config.dsn-file and the individual --cubrid.* flags.
+// Errors never contain the password.
+func createDSN(configDSN string) (string, error) {
+	if *cubridDSN != "" {
+		if err := validateDSN(*cubridDSN); err != nil {
+			return "", fmt.Errorf("invalid --cubrid.dsn: %s", err)
+		}
+		return *cubridDSN, nil
+	}
+	if dsn := os.Getenv("DATA_SOURCE_NAME"); dsn != "" {
+		if err := validateDSN(dsn); err != nil {
+			return "", fmt.Errorf("invalid DATA_SOURCE_NAME: %s", err)
+		}
+		return dsn, nil
+	}
+	if configDSN != "" {
+		if err := validateDSN(configDSN); err != nil {
+			return "", fmt.Errorf("invalid dsn in --config.file: %s", err)
+		}
+		return configDSN, nil
+	}
+
+	cfg := dsnConfig{
+		Host:     *cubridHost,
+		Port:     *cubridPort,
+		Database: *cubridDatabase,
+		User:     *cubridUser,
+		Password: *cubridPassword,
+	}
+	if *dsnFile != "" {
+		var err error
+		if cfg, err = cfg.loadDSNFile(*dsnFile); err != nil {
+			return "", fmt.Errorf("error reading %s: %s", *dsnFile, err)
+		}
+	}
+	dsn, err := cfg.build()
+	if err != nil {
+		return "", fmt.Errorf("%s; set --cubrid.host and --cubrid.database, use --config.dsn-file, or a full DSN with --cubrid.dsn or DATA_SOURCE_NAME", err)
+	}
+	return dsn, nil
+}
+
+// pingDatabase returns a startup task checking that the database accepts connections.
+func pingDatabase(dsn string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		db, err := collector.OpenDB(dsn)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+		defer cancel()
+		return db.PingContext(ctx)
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+func TestValidateDSN(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		dsn     string
+		wantErr bool
+	}{
+		{name: "full", dsn: "cci:cubrid:db.example.com:33000:demodb:dba:secret:"},
+		{name: "properties", dsn: "cci:cubrid:db.example.com:33000:demodb:dba::?althosts=db2.example.com:33000"},
+		{name: "default port", dsn: "cci:cubrid:db.example.com::demodb:::"},
+		// The password is the last positional field and keeps any further ':'.
+		{name: "extra fields in the password", dsn: "cci:cubrid:db.example.com:33000:demodb:dba:se:cr:et:"},
+		{name: "missing host", dsn: "cci:cubrid::33000:demodb:dba:secret:", wantErr: true},
+		{name: "missing database", dsn: "cci:cubrid:db.example.com:33000::dba:secret:", wantErr: true},
+		{name: "non-numeric port", dsn: "cci:cubrid:db.example.com:cubrid:demodb:dba:secret:", wantErr: true},
+		{name: "port out of range", dsn: "cci:cubrid:db.example.com:70000:demodb:dba:secret:", wantErr: true},
+		{name: "too few fields", dsn: "cci:cubrid:db.example.com:33000:demodb:dba", wantErr: true},
+		{name: "wrong scheme", dsn: "cubrid:cci:db.example.com:33000:demodb:dba:secret:", wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateDSN(tc.dsn)
+			if tc.wantErr != (err != nil) {
+				t.Fatalf("validateDSN(%q) = %v, want error %v", tc.dsn, err, tc.wantErr)
+			}
+			if err != nil && strings.Contains(err.Error(), "secret") {
+				t.Errorf("error %q contains the password", err)
+			}
+		})
+	}
+}
+
+// withDSNSources parses args and sets the DSN environment variables, unset
+// if empty. The flags and environment are reset after the test.
+func withDSNSources(t *testing.T, args []string, cubridDSNEnv, dataSourceName string) {
+	for name, value := range map[string]string{"CUBRID_DSN": cubridDSNEnv, "DATA_SOURCE_NAME": dataSourceName} {
+		name := name
+		saved, ok := os.LookupEnv(name)
+		t.Cleanup(func() {
+			if ok {
+				os.Setenv(name, saved)
+			} else {
+				os.Unsetenv(name)
+			}
+		})
+		if value == "" {
+			os.Unsetenv(name)
+		} else {
+			os.Setenv(name, value)
+		}
+	}
+	t.Cleanup(func() {
+		if _, err := kingpin.CommandLine.Parse([]string{}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if _, err := kingpin.CommandLine.Parse(args); err != nil {
+		t.Fatalf("error parsing %v: %s", args, err)
+	}
+}
+
+// TestCreateDSNPrecedence checks that each DSN source wins over the ones
+// ranked after it, and that an invalid source does not fall back to them.
+func TestCreateDSNPrecedence(t *testing.T) {
+	const (
+		flagDSN   = "cci:cubrid:flag:33000:demodb:dba::"
+		envDSN    = "cci:cubrid:env:33000:demodb:dba::"
+		legacyDSN = "cci:cubrid:legacy:33000:demodb:dba::"
+		configDSN = "cci:cubrid:config:33000:demodb:dba::"
+	)
+	fieldFlags := []string{"--cubrid.host=fields", "--cubrid.port=33000", "--cubrid.database=demodb", "--cubrid.user=dba"}
+
+	for _, tc := range []struct {
+		name           string
+		args           []string
+		cubridDSNEnv   string
+		dataSourceName string
+		configDSN      string
+		want           string
+		wantErr        bool
+	}{
+		{
+			name:           "--cubrid.dsn",
+			args:           append([]string{"--cubrid.dsn=" + flagDSN}, fieldFlags...),
+			cubridDSNEnv:   envDSN,
+			dataSourceName: legacyDSN,
+			configDSN:      configDSN,
+			want:           flagDSN,
+		},
+		{
+			name:           "CUBRID_DSN",
+			args:           fieldFlags,
+			cubridDSNEnv:   envDSN,
+			dataSourceName: legacyDSN,
+			configDSN:      configDSN,
+			want:           envDSN,
+		},
+		{
+			name:           "DATA_SOURCE_NAME",
+			args:           fieldFlags,
+			dataSourceName: legacyDSN,
+			configDSN:      configDSN,
+			want:           legacyDSN,
+		},
+		{
+			name:      "config file",
+			args:      fieldFlags,
+			configDSN: configDSN,
+			want:      configDSN,
+		},
+		{
+			name: "individual flags",
+			args: fieldFlags,
+			want: "cci:cubrid:fields:33000:demodb:dba::",
+		},
+		{
+			name:           "invalid DATA_SOURCE_NAME",
+			args:           fieldFlags,
+			dataSourceName: "cci:cubrid::33000:demodb:dba::",
+			configDSN:      configDSN,
+			wantErr:        true,
+		},
+		{
+			name:    "no source",
+			wantErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			withDSNSources(t, tc.args, tc.cubridDSNEnv, tc.dataSourceName)
+			dsn, err := createDSN(tc.configDSN)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("createDSN = %q, want an error", dsn)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error creating the DSN: %s", err)
+			}
+			if dsn != tc.want {
+				t.Errorf("createDSN = %q, want %q", dsn, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cubrid/cubrid-exporter/collector"
+)
+
+// featureConfig holds the settings the feature compatibility rules inspect.
+type featureConfig struct {
+	collector.FeatureSettings
+
+	PushgatewayURL             string
+	RemoteWriteURL             string
+	RemoteWriteUsername        string
+	RemoteWritePasswordFile    string
+	RemoteWriteBearerTokenFile string
+	RecordFixtures             string
+	Simulate                   bool
+	LeaderElection             string
+	LeaseAllowWrites           bool
+	LeaseDuration              time.Duration
+	LeaseClockSkew             time.Duration
+	BaselineURL                string
+	BaselinePublicKeyFile      string
+	BaselineBearerTokenFile    string
+	Profile                    string
+}
+
+// featureRule is a constraint between features. violated reports whether
+// cfg breaks it; message tells the user how to resolve it.
+type featureRule struct {
+	flags    []string
+	violated func(cfg featureConfig) bool
+	message  string
+}
+
+// featureRules is the feature compatibility matrix. Features interacting
+// with others register their rules here.
+var featureRules = []featureRule{
+	{
+		flags: []string{"strict", "exporter.auto-disable-after"},
+		violated: func(cfg featureConfig) bool {
+			return cfg.Strict && cfg.AutoDisableAfter > 0
+		},
+		message: "strict mode fails collectors on data anomalies, which would auto-disable them; use only one of the two",
+	},
+	{
+		flags: []string{"exporter.auto-disable-cooldown", "exporter.auto-disable-max-cooldown"},
+		violated: func(cfg featureConfig) bool {
+			return cfg.AutoDisableAfter > 0 && cfg.AutoDisableMaxCooldown < cfg.AutoDisableCooldown
+		},
+		message: "the maximum cool-down must not be shorter than the initial cool-down",
+	},
+	{
+		flags: []string{"cubrid.databases", "cubrid.databases.auto-discover"},
+		violated: func(cfg featureConfig) bool {
+			return cfg.AutoDiscoverDatabases && len(cfg.Databases) > 0
+		},
+		message: "auto-discovery replaces the configured databases; remove --cubrid.databases or disable auto-discovery",
+	},
+	{
+		flags: []string{"pushgateway.url", "push.remote-write-url"},
+		violated: func(cfg featureConfig) bool {
+			return cfg.PushgatewayURL != "" && cfg.RemoteWriteURL != ""
+		},
+		message: "metrics are pushed to one destination per run; set only one of the two",
+	},
+	{
+		flags: []string{"push.remote-write-url", "push.remote-write.username", "push.remote-write.password-file", "push.remote-write.bearer-token-file"},
+		violated: func(cfg featureConfig) bool {
+			return cfg.RemoteWriteURL == "" &&
+				(cfg.RemoteWriteUsername != "" || cfg.RemoteWritePasswordFile != "" || cfg.RemoteWriteBearerTokenFile != "")
+		},
+		message: "remote-write credentials are ignored without a remote-write URL",
+	},
+	{
+		flags: []string{"push.remote-write.username", "push.remote-write.password-file"},
+		violated: func(cfg featureConfig) bool {
+			return cfg.RemoteWritePasswordFile != "" && cfg.RemoteWriteUsername == ""
+		},
+		message: "the password file is only used together with a username",
+	},
+	{
+		flags: []string{"record-fixtures", "simulate", "pushgateway.url", "push.remote-write-url"},
+		violated: func(cfg featureConfig) bool {
+			return cfg.RecordFixtures != "" && (cfg.Simulate || cfg.PushgatewayURL != "" || cfg.RemoteWriteURL != "")
+		},
+		message: "fixtures are recorded from a real server in a run of their own; do not combine them with simulate mode or pushing",
+	},
+	{
+		flags: []string{"exporter.leader-election", "exporter.leader.allow-database-writes"},
+		violated: func(cfg featureConfig) bool {
+			return cfg.LeaderElection == leaderElectionDatabase && !cfg.LeaseAllowWrites
+		},
+		message: "the database lease writes to the monitored database; opt in with --exporter.leader.allow-database-writes or use a lease file",
+	},
+	{
+		flags: []string{"security.verify-read-only", "exporter.leader-election"},
+		violated: func(cfg featureConfig) bool {
+			return cfg.VerifyReadOnly && cfg.LeaderElection == leaderElectionDatabase
+		},
+		message: "the database lease writes to the monitored database, which fails every read-only self-audit; use a lease file",
+	},
+	{
+		flags: []string{"exporter.leader.lease-duration", "exporter.leader.clock-skew"},
+		violated: func(cfg featureConfig) bool {
+			return cfg.LeaderElection != "" && cfg.LeaseClockSkew*2 >= cfg.LeaseDuration
+		},
+		message: "the lease duration must exceed twice the clock skew, or no replica ever leads",
+	},
+	{
+		flags: []string{"exporter.leader-election", "simulate"},
+		violated: func(cfg featureConfig) bool {
+			return cfg.LeaderElection != "" && cfg.Simulate
+		},
+		message: "simulate mode has no database to coordinate on; disable leader election",
+	},
+	{
+		flags: []string{"baseline.url", "baseline.public-key-file"},
+		violated: func(cfg featureConfig) bool {
+			return cfg.BaselineURL != "" && cfg.BaselinePublicKeyFile == ""
+		},
+		message: "the baseline must be signed; set the public key of the config service",
+	},
+	{
+		flags: []string{"baseline.url", "baseline.bearer-token-file"},
+		violated: func(cfg featureConfig) bool {
+			return cfg.BaselineURL == "" && cfg.BaselineBearerTokenFile != ""
+		},
+		message: "the baseline bearer token is ignored without a baseline URL",
+	},
+	{
+		flags: []string{"exporter.profile", "simulate"},
+		violated: func(cfg featureConfig) bool {
+			return cfg.Simulate && cfg.Profile != profileStandard
+		},
+		message: "simulate mode replaces the collectors of every profile; use the standard profile",
+	},
+}
+
+// checkFeatures evaluates all feature compatibility rules and returns every violation.
+func checkFeatures(cfg featureConfig) []error {
+	var errs []error
+	for _, rule := range featureRules {
+		if rule.violated(cfg) {
+			errs = append(errs, fmt.Errorf("--%s: %s", strings.Join(rule.flags, ", --"), rule.message))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,144 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cubrid/cubrid-exporter/collector"
+)
+
+func TestCheckFeatures(t *testing.T) {
+	lease := func(cfg featureConfig) featureConfig {
+		cfg.LeaderElection = leaderElectionFile
+		cfg.LeaseDuration = 15 * time.Second
+		cfg.LeaseClockSkew = 2 * time.Second
+		return cfg
+	}
+	tested := map[string]bool{}
+	for _, tc := range []struct {
+		// flags identifies the rule by the flags it involves.
+		flags      string
+		violating  featureConfig
+		satisfying featureConfig
+	}{
+		{
+			flags:      "strict, exporter.auto-disable-after",
+			violating:  featureConfig{FeatureSettings: collector.FeatureSettings{Strict: true, AutoDisableAfter: 3, AutoDisableCooldown: time.Minute, AutoDisableMaxCooldown: time.Hour}},
+			satisfying: featureConfig{FeatureSettings: collector.FeatureSettings{Strict: true}},
+		},
+		{
+			flags:      "exporter.auto-disable-cooldown, exporter.auto-disable-max-cooldown",
+			violating:  featureConfig{FeatureSettings: collector.FeatureSettings{AutoDisableAfter: 3, AutoDisableCooldown: time.Hour, AutoDisableMaxCooldown: time.Minute}},
+			satisfying: featureConfig{FeatureSettings: collector.FeatureSettings{AutoDisableAfter: 3, AutoDisableCooldown: time.Minute, AutoDisableMaxCooldown: time.Hour}},
+		},
+		{
+			flags:      "cubrid.databases, cubrid.databases.auto-discover",
+			violating:  featureConfig{FeatureSettings: collector.FeatureSettings{Databases: []string{"demodb"}, AutoDiscoverDatabases: true}},
+			satisfying: featureConfig{FeatureSettings: collector.FeatureSettings{Databases: []string{"demodb"}}},
+		},
+		{
+			flags:      "pushgateway.url, push.remote-write-url",
+			violating:  featureConfig{PushgatewayURL: "http://pushgateway:9091", RemoteWriteURL: "http://prometheus:9090/api/v1/write"},
+			satisfying: featureConfig{PushgatewayURL: "http://pushgateway:9091"},
+		},
+		{
+			flags:      "push.remote-write-url, push.remote-write.username, push.remote-write.password-file, push.remote-write.bearer-token-file",
+			violating:  featureConfig{RemoteWriteBearerTokenFile: "/etc/token"},
+			satisfying: featureConfig{RemoteWriteURL: "http://prometheus:9090/api/v1/write", RemoteWriteBearerTokenFile: "/etc/token"},
+		},
+		{
+			flags:      "push.remote-write.username, push.remote-write.password-file",
+			violating:  featureConfig{RemoteWriteURL: "http://prometheus:9090/api/v1/write", RemoteWritePasswordFile: "/etc/password"},
+			satisfying: featureConfig{RemoteWriteURL: "http://prometheus:9090/api/v1/write", RemoteWriteUsername: "push", RemoteWritePasswordFile: "/etc/password"},
+		},
+		{
+			flags:      "record-fixtures, simulate, pushgateway.url, push.remote-write-url",
+			violating:  featureConfig{RecordFixtures: "fixtures", Simulate: true, Profile: profileStandard},
+			satisfying: featureConfig{RecordFixtures: "fixtures"},
+		},
+		{
+			flags:      "exporter.leader-election, exporter.leader.allow-database-writes",
+			violating:  featureConfig{LeaderElection: leaderElectionDatabase, LeaseDuration: 15 * time.Second},
+			satisfying: featureConfig{LeaderElection: leaderElectionDatabase, LeaseAllowWrites: true, LeaseDuration: 15 * time.Second},
+		},
+		{
+			flags: "security.verify-read-only, exporter.leader-election",
+			violating: featureConfig{
+				FeatureSettings: collector.FeatureSettings{VerifyReadOnly: true},
+				LeaderElection:  leaderElectionDatabase, LeaseAllowWrites: true, LeaseDuration: 15 * time.Second,
+			},
+			satisfying: lease(featureConfig{FeatureSettings: collector.FeatureSettings{VerifyReadOnly: true}}),
+		},
+		{
+			flags:      "exporter.leader.lease-duration, exporter.leader.clock-skew",
+			violating:  featureConfig{LeaderElection: leaderElectionFile, LeaseDuration: 4 * time.Second, LeaseClockSkew: 2 * time.Second},
+			satisfying: lease(featureConfig{}),
+		},
+		{
+			flags:      "exporter.leader-election, simulate",
+			violating:  lease(featureConfig{Simulate: true, Profile: profileStandard}),
+			satisfying: featureConfig{Simulate: true, Profile: profileStandard},
+		},
+		{
+			flags:      "baseline.url, baseline.public-key-file",
+			violating:  featureConfig{BaselineURL: "https://config/baseline"},
+			satisfying: featureConfig{BaselineURL: "https://config/baseline", BaselinePublicKeyFile: "/etc/baseline.pub"},
+		},
+		{
+			flags:      "baseline.url, baseline.bearer-token-file",
+			violating:  featureConfig{BaselineBearerTokenFile: "/etc/token"},
+			satisfying: featureConfig{BaselineURL: "https://config/baseline", BaselinePublicKeyFile: "/etc/baseline.pub", BaselineBearerTokenFile: "/etc/token"},
+		},
+		{
+			flags:      "exporter.profile, simulate",
+			violating:  featureConfig{Simulate: true, Profile: "intensive"},
+			satisfying: featureConfig{Profile: "intensive"},
+		},
+	} {
+		tested[tc.flags] = true
+		prefix := "--" + strings.Replace(tc.flags, ", ", ", --", -1) + ":"
+		var found bool
+		for _, err := range checkFeatures(tc.violating) {
+			found = found || strings.HasPrefix(err.Error(), prefix)
+		}
+		if !found {
+			t.Errorf("rule %s: violating configuration %+v not reported", tc.flags, tc.violating)
+		}
+		if errs := checkFeatures(tc.satisfying); len(errs) != 0 {
+			t.Errorf("rule %s: satisfying configuration %+v reported %v", tc.flags, tc.satisfying, errs)
+		}
+	}
+	for _, rule := range featureRules {
+		if flags := strings.Join(rule.flags, ", "); !tested[flags] {
+			t.Errorf("rule %s has no test", flags)
+		}
+	}
+}
+
+// TestCheckFeaturesAllViolations checks that every violation is reported, not
+// only the first.
+func TestCheckFeaturesAllViolations(t *testing.T) {
+	cfg := featureConfig{
+		FeatureSettings: collector.FeatureSettings{Strict: true, AutoDisableAfter: 3, AutoDisableCooldown: time.Minute, AutoDisableMaxCooldown: time.Hour},
+		PushgatewayURL:  "http://pushgateway:9091",
+		RemoteWriteURL:  "http://prometheus:9090/api/v1/write",
+		BaselineURL:     "https://config/baseline",
+	}
+	if errs := checkFeatures(cfg); len(errs) != 3 {
+		t.Errorf("got %d violations, want 3: %v", len(errs), errs)
+	}
+}
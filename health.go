@@ -0,0 +1,68 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/cubrid/cubrid-exporter/collector"
+)
+
+var (
+	autoDisabledCollectorsDesc = prometheus.NewDesc(
+		"cubrid_exporter_auto_disabled_collectors",
+		"Number of collectors currently disabled after consecutive failures.",
+		nil, nil,
+	)
+	configInfoDesc = prometheus.NewDesc(
+		"cubrid_exporter_config_info",
+		"SHA-256 of the loaded --config.file, empty without one.",
+		[]string{"hash"}, nil,
+	)
+)
+
+// healthCollector serves the state of the main scrape path as kept by the
+// last /metrics scrape. It never queries the database.
+type healthCollector struct {
+	metrics    collector.Metrics
+	configHash string
+}
+
+func (c healthCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.metrics.CubridUp.Desc()
+	ch <- c.metrics.UsefulScrape.Desc()
+	ch <- c.metrics.Error.Desc()
+	ch <- c.metrics.LastSuccessfulScrape.Desc()
+	ch <- autoDisabledCollectorsDesc
+	ch <- configInfoDesc
+}
+
+func (c healthCollector) Collect(ch chan<- prometheus.Metric) {
+	ch <- c.metrics.CubridUp
+	ch <- c.metrics.UsefulScrape
+	ch <- c.metrics.Error
+	ch <- c.metrics.LastSuccessfulScrape
+	ch <- prometheus.MustNewConstMetric(autoDisabledCollectorsDesc, prometheus.GaugeValue, float64(collector.AutoDisabledCollectors()))
+	ch <- prometheus.MustNewConstMetric(configInfoDesc, prometheus.GaugeValue, 1, c.configHash)
+}
+
+// newHealthMetricsHandler serves the compact health summary for meta-monitoring.
+func newHealthMetricsHandler(metrics collector.Metrics, configHash string) http.Handler {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(healthCollector{metrics: metrics, configHash: configHash})
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,91 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+
+	"github.com/cubrid/cubrid-exporter/collector"
+)
+
+// healthMetrics requests /health-metrics of handler and returns the metrics
+// of the response.
+func healthMetrics(t *testing.T, handler http.Handler) map[string]*dto.MetricFamily {
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health-metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	var parser expfmt.TextParser
+	mfs, err := parser.TextToMetricFamilies(rec.Body)
+	if err != nil {
+		t.Fatalf("error parsing the health response: %s", err)
+	}
+	return mfs
+}
+
+func TestHealthMetrics(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		dsn   string
+		up    float64
+		error float64
+	}{
+		{name: "reachable", dsn: collector.SimulatedDSN, up: 1},
+		{name: "unreachable", dsn: "monitor:secret@tcp(127.0.0.1:1)/demodb", error: 1},
+	} {
+		metrics := collector.NewMetrics()
+		handler := newHealthMetricsHandler(metrics, "3a7bd3e2")
+		if mfs := healthMetrics(t, handler); sampleValue(t, mfs, "cubrid_up") != 0 {
+			t.Errorf("%s: cubrid_up before the first scrape = 1, want 0", tc.name)
+		}
+
+		// The health summary reports the last /metrics scrape.
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(collector.New(context.Background(), tc.dsn, metrics, nil, nil))
+		if _, err := registry.Gather(); err != nil {
+			t.Fatalf("%s: %s", tc.name, err)
+		}
+		mfs := healthMetrics(t, handler)
+		if len(mfs) != 6 {
+			t.Errorf("%s: %d families, want 6", tc.name, len(mfs))
+		}
+		if got := sampleValue(t, mfs, "cubrid_up"); got != tc.up {
+			t.Errorf("%s: cubrid_up = %v, want %v", tc.name, got, tc.up)
+		}
+		if got := sampleValue(t, mfs, "cubrid_exporter_last_scrape_error"); got != tc.error {
+			t.Errorf("%s: cubrid_exporter_last_scrape_error = %v, want %v", tc.name, got, tc.error)
+		}
+		if got := sampleValue(t, mfs, "cubrid_exporter_last_successful_scrape_timestamp_seconds"); (got > 0) != (tc.error == 0) {
+			t.Errorf("%s: cubrid_exporter_last_successful_scrape_timestamp_seconds = %v", tc.name, got)
+		}
+		if got := sampleValue(t, mfs, "cubrid_exporter_useful_scrape"); got != 0 {
+			t.Errorf("%s: cubrid_exporter_useful_scrape without collectors = %v, want 0", tc.name, got)
+		}
+		if got := sampleValue(t, mfs, "cubrid_exporter_auto_disabled_collectors"); got != 0 {
+			t.Errorf("%s: cubrid_exporter_auto_disabled_collectors = %v, want 0", tc.name, got)
+		}
+		info := mfs["cubrid_exporter_config_info"]
+		if info == nil || len(info.Metric) != 1 || info.Metric[0].Label[0].GetValue() != "3a7bd3e2" {
+			t.Errorf("%s: cubrid_exporter_config_info = %v, want hash 3a7bd3e2", tc.name, info)
+		}
+	}
+}
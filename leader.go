@@ -0,0 +1,328 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+
+	"github.com/cubrid/cubrid-exporter/collector"
+)
+
+// Leader election modes.
+const (
+	leaderElectionFile     = "file"
+	leaderElectionDatabase = "database"
+)
+
+// leaseName is the row of the lease table used by the exporter.
+const leaseName = "cubrid_exporter"
+
+// fileLeaseSettle is how long a replica waits after writing the lease file
+// before reading it back. Replicas racing for an expired lease both write it;
+// only the one whose write survived the settle time becomes leader.
+const fileLeaseSettle = 500 * time.Millisecond
+
+// Statements of the database lease. %s is the lease table.
+const (
+	leaseCreateQuery  = "CREATE TABLE IF NOT EXISTS %s (name VARCHAR(64) PRIMARY KEY, holder VARCHAR(255), expires BIGINT)"
+	leaseUpdateQuery  = "UPDATE %s SET holder = ?, expires = ? WHERE name = ? AND (holder = ? OR expires < ?)"
+	leaseCountQuery   = "SELECT COUNT(*) FROM %s WHERE name = ?"
+	leaseInsertQuery  = "INSERT INTO %s (name, holder, expires) VALUES (?, ?, ?)"
+	leaseReleaseQuery = "UPDATE %s SET expires = 0 WHERE name = ? AND holder = ?"
+)
+
+// leaseWrites are the lease statements modifying the database by the name
+// they are reported under.
+var leaseWrites = map[string]string{
+	"lease_create":  leaseCreateQuery,
+	"lease_update":  leaseUpdateQuery,
+	"lease_insert":  leaseInsertQuery,
+	"lease_release": leaseReleaseQuery,
+}
+
+// leaseTableRE matches the table names accepted for the lease table.
+var leaseTableRE = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+var isLeaderDesc = prometheus.NewDesc(
+	"cubrid_exporter_is_leader",
+	"Whether this replica holds the leader lease and collects metrics (1 for leader).",
+	nil, nil,
+)
+
+// leaseStore persists the lease shared by the replicas.
+type leaseStore interface {
+	// acquire takes the lease for holder until expires if it is free, held
+	// by holder, or expired before stale. It reports whether holder holds it.
+	acquire(ctx context.Context, holder string, expires, stale time.Time) (bool, error)
+	// release gives up the lease if holder holds it.
+	release(ctx context.Context, holder string) error
+}
+
+// leaderElector keeps renewing the lease. It implements prometheus.Collector.
+type leaderElector struct {
+	store  leaseStore
+	holder string
+	ttl    time.Duration
+	// skew is the tolerated clock difference between replicas.
+	skew time.Duration
+	now  func() time.Time
+
+	// lease serializes the renewals with the release, so a renewal in flight
+	// cannot take the lease again once it was released.
+	lease    sync.Mutex
+	released bool
+
+	mu sync.Mutex
+	// validUntil is when this replica stops considering itself leader
+	// unless it renewed the lease; zero while it is not leader.
+	validUntil time.Time
+}
+
+func newLeaderElector(store leaseStore, holder string, ttl, skew time.Duration) *leaderElector {
+	return &leaderElector{store: store, holder: holder, ttl: ttl, skew: skew, now: time.Now}
+}
+
+// isLeader reports whether this replica holds the lease. A nil elector
+// always leads. Leadership ends skew before the lease expires, so another
+// replica with a clock ahead by up to skew never overlaps with it.
+func (l *leaderElector) isLeader() bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.now().Before(l.validUntil)
+}
+
+// renew tries to take or keep the lease once. Errors end the leadership, as
+// no leader is preferable to two. After release it does nothing.
+func (l *leaderElector) renew(ctx context.Context) {
+	l.lease.Lock()
+	defer l.lease.Unlock()
+	if l.released {
+		return
+	}
+	ctx = collector.WithBackgroundQueries(ctx)
+	start := l.now()
+	acquired, err := l.store.acquire(ctx, l.holder, start.Add(l.ttl), start.Add(-l.skew))
+	if err != nil {
+		log.Warnln("Error renewing leader lease:", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	wasLeader := l.now().Before(l.validUntil)
+	if err != nil || !acquired {
+		l.validUntil = time.Time{}
+	} else {
+		l.validUntil = start.Add(l.ttl - l.skew)
+	}
+	if leader := l.now().Before(l.validUntil); leader != wasLeader {
+		log.Infof("Leadership changed, leader: %t", leader)
+	}
+}
+
+// run renews the lease three times per lease duration until ctx is done.
+func (l *leaderElector) run(ctx context.Context) {
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+	for {
+		l.renew(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// release gives up the lease, so another replica takes over without waiting
+// for it to expire. It waits for a renewal in flight; the context of run
+// should be canceled first, so that none is started or takes long.
+func (l *leaderElector) release(ctx context.Context) error {
+	l.lease.Lock()
+	defer l.lease.Unlock()
+	l.released = true
+	l.mu.Lock()
+	l.validUntil = time.Time{}
+	l.mu.Unlock()
+	return l.store.release(ctx, l.holder)
+}
+
+// Describe implements prometheus.Collector.
+func (l *leaderElector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- isLeaderDesc
+}
+
+// Collect implements prometheus.Collector.
+func (l *leaderElector) Collect(ch chan<- prometheus.Metric) {
+	v := 0.0
+	if l.isLeader() {
+		v = 1
+	}
+	ch <- prometheus.MustNewConstMetric(isLeaderDesc, prometheus.GaugeValue, v)
+}
+
+// fileLeaseStore keeps the lease in a file shared by co-located replicas.
+// The file holds the expiry in Unix nanoseconds followed by the holder.
+type fileLeaseStore struct {
+	path   string
+	settle time.Duration
+}
+
+func (s fileLeaseStore) read() (string, time.Time, error) {
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return "", time.Time{}, nil
+	}
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	fields := strings.SplitN(strings.TrimSpace(string(data)), " ", 2)
+	if len(fields) != 2 {
+		return "", time.Time{}, fmt.Errorf("invalid lease file %s", s.path)
+	}
+	expires, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("invalid lease file %s: %s", s.path, err)
+	}
+	return fields[1], time.Unix(0, expires), nil
+}
+
+// write replaces the lease file atomically.
+func (s fileLeaseStore) write(holder string, expires time.Time) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(tmp, "%d %s\n", expires.UnixNano(), holder)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+func (s fileLeaseStore) acquire(ctx context.Context, holder string, expires, stale time.Time) (bool, error) {
+	current, currentExpires, err := s.read()
+	if err != nil {
+		return false, err
+	}
+	if current != "" && current != holder && !currentExpires.Before(stale) {
+		return false, nil
+	}
+	if err := s.write(holder, expires); err != nil {
+		return false, err
+	}
+	if current == holder {
+		// Renewing a held lease does not race with other replicas.
+		return true, nil
+	}
+
+	select {
+	case <-ctx.Done():
+		return false, ctx.Err()
+	case <-time.After(s.settle):
+	}
+	current, _, err = s.read()
+	return err == nil && current == holder, err
+}
+
+func (s fileLeaseStore) release(ctx context.Context, holder string) error {
+	current, _, err := s.read()
+	if err != nil || current != holder {
+		return err
+	}
+	// The zero time.Time has no Unix nanoseconds representation; the Unix
+	// epoch is expired for every replica.
+	return s.write(holder, time.Unix(0, 0))
+}
+
+// dbLeaseStore keeps the lease in a table of the monitored database. The
+// conditional UPDATE makes taking the lease atomic.
+type dbLeaseStore struct {
+	db    *sql.DB
+	table string
+	// created is set once the lease table exists.
+	created bool
+}
+
+func newDBLeaseStore(dsn, table string) (*dbLeaseStore, error) {
+	if !leaseTableRE.MatchString(table) {
+		return nil, fmt.Errorf("invalid lease table name %q", table)
+	}
+	// OpenDB does not connect; the table is created on the first renewal.
+	db, err := collector.OpenDB(dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	for name, query := range leaseWrites {
+		collector.Audit.ApproveWrite("leader", name, query)
+	}
+	collector.Audit.ApproveQuery("leader", "lease_count", leaseCountQuery)
+	return &dbLeaseStore{db: db, table: table}, nil
+}
+
+func (s *dbLeaseStore) acquire(ctx context.Context, holder string, expires, stale time.Time) (bool, error) {
+	if !s.created {
+		_, err := s.db.ExecContext(ctx, fmt.Sprintf(leaseCreateQuery, s.table))
+		if err != nil {
+			return false, err
+		}
+		s.created = true
+	}
+	result, err := s.db.ExecContext(ctx, fmt.Sprintf(leaseUpdateQuery, s.table),
+		holder, expires.UnixNano(), leaseName, holder, stale.UnixNano())
+	if err != nil {
+		return false, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n > 0 {
+		return err == nil, err
+	}
+
+	var rows int
+	if err := s.db.QueryRowContext(ctx, fmt.Sprintf(leaseCountQuery, s.table), leaseName).Scan(&rows); err != nil {
+		return false, err
+	}
+	if rows > 0 {
+		return false, nil
+	}
+	// A replica inserting concurrently fails on the primary key and stays follower.
+	_, err = s.db.ExecContext(ctx, fmt.Sprintf(leaseInsertQuery, s.table),
+		leaseName, holder, expires.UnixNano())
+	return err == nil, err
+}
+
+func (s *dbLeaseStore) release(ctx context.Context, holder string) error {
+	defer s.db.Close()
+	_, err := s.db.ExecContext(ctx, fmt.Sprintf(leaseReleaseQuery, s.table), leaseName, holder)
+	return err
+}
@@ -0,0 +1,177 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// tempLeaseFile returns a lease file in a temporary directory.
+func tempLeaseFile(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "lease")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return filepath.Join(dir, "lease")
+}
+
+func TestFileLeaseStore(t *testing.T) {
+	store := fileLeaseStore{path: tempLeaseFile(t)}
+	ctx := context.Background()
+	now := time.Now()
+
+	if ok, err := store.acquire(ctx, "a", now.Add(time.Minute), now); err != nil || !ok {
+		t.Fatalf("acquire of a free lease = %t, %v", ok, err)
+	}
+	if ok, err := store.acquire(ctx, "b", now.Add(time.Minute), now); err != nil || ok {
+		t.Fatalf("acquire of a held lease = %t, %v", ok, err)
+	}
+	if ok, err := store.acquire(ctx, "a", now.Add(2*time.Minute), now); err != nil || !ok {
+		t.Fatalf("renewal by the holder = %t, %v", ok, err)
+	}
+
+	// Releasing by another replica leaves the lease alone.
+	if err := store.release(ctx, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if holder, _, _ := store.read(); holder != "a" {
+		t.Fatalf("holder after a foreign release = %q, want a", holder)
+	}
+
+	if err := store.release(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	_, expires, err := store.read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !expires.Equal(time.Unix(0, 0)) {
+		t.Errorf("expiry after release = %s, want the Unix epoch", expires)
+	}
+	if ok, err := store.acquire(ctx, "b", now.Add(time.Minute), now); err != nil || !ok {
+		t.Fatalf("acquire of a released lease = %t, %v", ok, err)
+	}
+}
+
+// stubLeaseStore grants the lease while granted is set. While block is set,
+// acquire waits for it to be closed.
+type stubLeaseStore struct {
+	mu       sync.Mutex
+	granted  bool
+	block    chan struct{}
+	acquired chan struct{}
+	events   []string
+}
+
+func (s *stubLeaseStore) acquire(ctx context.Context, holder string, expires, stale time.Time) (bool, error) {
+	s.mu.Lock()
+	block, acquired := s.block, s.acquired
+	s.mu.Unlock()
+	if acquired != nil {
+		close(acquired)
+	}
+	if block != nil {
+		<-block
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, "acquire")
+	return s.granted, nil
+}
+
+func (s *stubLeaseStore) release(ctx context.Context, holder string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, "release")
+	return nil
+}
+
+func TestLeaderElectorLeadership(t *testing.T) {
+	store := &stubLeaseStore{granted: true}
+	l := newLeaderElector(store, "a", time.Minute, 10*time.Second)
+	now := time.Now()
+	l.now = func() time.Time { return now }
+
+	if l.isLeader() {
+		t.Fatal("leader before the first renewal")
+	}
+	l.renew(context.Background())
+	if !l.isLeader() {
+		t.Fatal("not leader after taking the lease")
+	}
+	// Leadership ends the clock skew before the lease expires.
+	now = now.Add(50 * time.Second)
+	if l.isLeader() {
+		t.Fatal("still leader within the clock skew of the expiry")
+	}
+
+	store.granted = false
+	l.renew(context.Background())
+	if l.isLeader() {
+		t.Fatal("leader after losing the lease")
+	}
+
+	var nilElector *leaderElector
+	if !nilElector.isLeader() {
+		t.Error("a nil elector does not lead")
+	}
+}
+
+// TestLeaderElectorReleaseWaitsForRenewal checks that a release waits for a
+// renewal in flight, and that no renewal takes the lease afterwards.
+func TestLeaderElectorReleaseWaitsForRenewal(t *testing.T) {
+	block, acquired := make(chan struct{}), make(chan struct{})
+	store := &stubLeaseStore{granted: true, block: block, acquired: acquired}
+	l := newLeaderElector(store, "a", time.Minute, time.Second)
+
+	renewed := make(chan struct{})
+	go func() {
+		l.renew(context.Background())
+		close(renewed)
+	}()
+	<-acquired
+
+	released := make(chan error)
+	go func() { released <- l.release(context.Background()) }()
+	select {
+	case <-released:
+		t.Fatal("release did not wait for the renewal in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(block)
+	<-renewed
+	if err := <-released; err != nil {
+		t.Fatal(err)
+	}
+
+	store.mu.Lock()
+	store.block, store.acquired = nil, nil
+	store.mu.Unlock()
+	l.renew(context.Background())
+	if l.isLeader() {
+		t.Error("leader after releasing the lease")
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if want := []string{"acquire", "release"}; len(store.events) != len(want) || store.events[0] != want[0] || store.events[1] != want[1] {
+		t.Errorf("store calls = %v, want %v", store.events, want)
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"hash/fnv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+var scrapeOffsetGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "cubrid_exporter_scrape_offset_seconds",
+	Help: "Delay applied before the database collection of the last scrape.",
+})
+
+func init() {
+	prometheus.MustRegister(scrapeOffsetGauge)
+}
+
+// scrapeOffset returns the deterministic delay of the instance, spreading the
+// database load of many exporters scraped at the same phase over max.
+func scrapeOffset(instanceID string, max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	h := fnv.New64a()
+	h.Write([]byte(instanceID))
+	return time.Duration(h.Sum64() % uint64(max))
+}
+
+// waitScrapeOffset delays the collection by offset, unless that would take
+// more than fraction of the time left until the deadline of ctx.
+func waitScrapeOffset(ctx context.Context, offset time.Duration, fraction float64) {
+	if offset <= 0 {
+		return
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if remaining := time.Until(deadline); float64(offset) > float64(remaining)*fraction {
+			log.Debugf("Skipping scrape offset of %s, only %s left until the deadline", offset, remaining)
+			scrapeOffsetGauge.Set(0)
+			return
+		}
+	}
+	timer := time.NewTimer(offset)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		scrapeOffsetGauge.Set(offset.Seconds())
+	case <-ctx.Done():
+		scrapeOffsetGauge.Set(0)
+	}
+}
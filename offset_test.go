@@ -0,0 +1,86 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestScrapeOffset(t *testing.T) {
+	max := 30 * time.Second
+	offsets := map[time.Duration]bool{}
+	for i := 0; i < 20; i++ {
+		id := fmt.Sprintf("instance-%d", i)
+		offset := scrapeOffset(id, max)
+		if offset < 0 || offset >= max {
+			t.Errorf("offset of %s = %s, want within [0, %s)", id, offset, max)
+		}
+		if again := scrapeOffset(id, max); again != offset {
+			t.Errorf("offset of %s = %s, then %s, want it stable", id, offset, again)
+		}
+		offsets[offset] = true
+	}
+	if len(offsets) < 15 {
+		t.Errorf("%d distinct offsets of 20 instances, want them spread", len(offsets))
+	}
+	for _, max := range []time.Duration{0, -time.Second} {
+		if offset := scrapeOffset("instance-1", max); offset != 0 {
+			t.Errorf("offset with max %s = %s, want 0", max, offset)
+		}
+	}
+}
+
+func TestWaitScrapeOffset(t *testing.T) {
+	t.Cleanup(func() { scrapeOffsetGauge.Set(0) })
+	offset := 20 * time.Millisecond
+
+	start := time.Now()
+	waitScrapeOffset(context.Background(), offset, 0.5)
+	if elapsed := time.Since(start); elapsed < offset {
+		t.Errorf("waited %s, want at least %s", elapsed, offset)
+	}
+	if got := testutil.ToFloat64(scrapeOffsetGauge); got != offset.Seconds() {
+		t.Errorf("scrape_offset_seconds = %v, want %v", got, offset.Seconds())
+	}
+
+	// The offset is skipped when it would take too much of the time left.
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	waitScrapeOffset(ctx, offset, 0.5)
+	if elapsed := time.Since(start); elapsed >= offset {
+		t.Errorf("waited %s close to the deadline, want no wait", elapsed)
+	}
+	if got := testutil.ToFloat64(scrapeOffsetGauge); got != 0 {
+		t.Errorf("scrape_offset_seconds of a skipped offset = %v, want 0", got)
+	}
+
+	// A canceled scrape stops waiting.
+	scrapeOffsetGauge.Set(1)
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(5*time.Millisecond, cancel)
+	start = time.Now()
+	waitScrapeOffset(ctx, time.Minute, 0.5)
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Errorf("waited %s after the cancellation", elapsed)
+	}
+	if got := testutil.ToFloat64(scrapeOffsetGauge); got != 0 {
+		t.Errorf("scrape_offset_seconds of a canceled wait = %v, want 0", got)
+	}
+}
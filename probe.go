@@ -0,0 +1,78 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/prometheus/common/log"
+
+	"github.com/cubrid/cubrid-exporter/collector"
+)
+
+// authModule holds the credentials /probe uses for a target, so they never
+// appear in the probe URL.
+type authModule struct {
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+}
+
+// probeDSN builds the DSN of the target=host[:port], database and
+// auth_module parameters. Without auth_module the default --cubrid.user
+// connects without a password.
+func probeDSN(params url.Values, modules map[string]authModule) (string, error) {
+	cfg := dsnConfig{
+		Host:     params.Get("target"),
+		Port:     *cubridPort,
+		Database: params.Get("database"),
+		User:     *cubridUser,
+	}
+	if host, port, err := net.SplitHostPort(cfg.Host); err == nil {
+		cfg.Host, cfg.Port = host, port
+	}
+	if name := params.Get("auth_module"); name != "" {
+		module, ok := modules[name]
+		if !ok {
+			return "", fmt.Errorf("unknown auth module %q", name)
+		}
+		cfg.User, cfg.Password = module.User, module.Password
+	}
+	return cfg.build()
+}
+
+// newProbeHandler scrapes the target of each request. Every probe gets its
+// own metrics and no scrape cache. The state kept between scrapes, such as
+// the throttle, warm-start entries, auto-disabled collectors and broker
+// ports, is keyed by host, port and database, so targets do not share it.
+// They use the collectors of the active profile.
+// An invalid target is reported as cubrid_up 0.
+func newProbeHandler(modules map[string]authModule, profiles *profileSwitch, process pipeline) http.HandlerFunc {
+	coalesce := newCoalescer()
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()
+		target := params.Get("target")
+		if target == "" {
+			http.Error(w, "Missing target parameter.", http.StatusBadRequest)
+			return
+		}
+		dsn, err := probeDSN(params, modules)
+		if err != nil {
+			log.Warnf("Invalid probe of %s: %s", target, err)
+		}
+		serveScrape(w, r, dsn, collector.NewMetrics(), profiles.current().scrapers, nil, process, coalesce, nil)
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+func TestMain(m *testing.M) {
+	// Apply the flag defaults, as the handlers read them.
+	if _, err := kingpin.CommandLine.Parse([]string{}); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+// probe requests /probe with the query from handler and returns the metrics
+// of the response.
+func probe(t *testing.T, handler http.HandlerFunc, query string) (int, map[string]*dto.MetricFamily) {
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/probe?"+query, nil))
+	if rec.Code != http.StatusOK {
+		return rec.Code, nil
+	}
+	var parser expfmt.TextParser
+	mfs, err := parser.TextToMetricFamilies(rec.Body)
+	if err != nil {
+		t.Fatalf("error parsing the probe response: %s", err)
+	}
+	return rec.Code, mfs
+}
+
+// sampleValue returns the value of the unlabeled gauge or counter name of mfs.
+func sampleValue(t *testing.T, mfs map[string]*dto.MetricFamily, name string) float64 {
+	mf, ok := mfs[name]
+	if !ok || len(mf.Metric) != 1 {
+		t.Fatalf("no single %s in the probe response", name)
+	}
+	if m := mf.Metric[0]; m.Gauge != nil {
+		return m.Gauge.GetValue()
+	}
+	return mf.Metric[0].Counter.GetValue()
+}
+
+func newTestProbeHandler() http.HandlerFunc {
+	modules := map[string]authModule{"monitor": {User: "monitor", Password: "secret"}}
+	identity := func(g prometheus.Gatherer) prometheus.Gatherer { return g }
+	return newProbeHandler(modules, &profileSwitch{}, identity)
+}
+
+func TestProbeMissingTarget(t *testing.T) {
+	if code, _ := probe(t, newTestProbeHandler(), "database=demodb"); code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", code, http.StatusBadRequest)
+	}
+}
+
+// TestProbeTargets checks that each probe reports its own target with its
+// own metrics: the probes of two unreachable targets are scraped once each.
+func TestProbeTargets(t *testing.T) {
+	handler := newTestProbeHandler()
+	for _, query := range []string{
+		"target=127.0.0.1:1&database=demodb",
+		"target=127.0.0.1:2&database=demodb&auth_module=monitor",
+		"target=127.0.0.1:1&database=demodb&auth_module=unknown",
+	} {
+		code, mfs := probe(t, handler, query)
+		if code != http.StatusOK {
+			t.Errorf("%s: status = %d, want %d", query, code, http.StatusOK)
+			continue
+		}
+		if got := sampleValue(t, mfs, "cubrid_up"); got != 0 {
+			t.Errorf("%s: cubrid_up = %v, want 0", query, got)
+		}
+		if got := sampleValue(t, mfs, "cubrid_exporter_scrapes_total"); got != 1 {
+			t.Errorf("%s: cubrid_exporter_scrapes_total = %v, want 1", query, got)
+		}
+	}
+}
+
+func TestProbeDSN(t *testing.T) {
+	modules := map[string]authModule{"monitor": {User: "monitor", Password: "secret"}}
+	for _, tc := range []struct {
+		query string
+		dsn   string
+		err   bool
+	}{
+		{"target=db1&database=demodb", "cci:cubrid:db1:" + *cubridPort + ":demodb:" + *cubridUser + "::", false},
+		{"target=db1:30000&database=demodb&auth_module=monitor", "cci:cubrid:db1:30000:demodb:monitor:secret:", false},
+		{"target=db1&database=demodb&auth_module=unknown", "", true},
+		{"target=db1", "", true},
+	} {
+		params, err := url.ParseQuery(tc.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dsn, err := probeDSN(params, modules)
+		if (err != nil) != tc.err {
+			t.Errorf("%s: error = %v, want error %t", tc.query, err, tc.err)
+		}
+		if dsn != tc.dsn {
+			t.Errorf("%s: dsn = %q, want %q", tc.query, dsn, tc.dsn)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+
+	"github.com/cubrid/cubrid-exporter/collector"
+)
+
+// Built-in scrape profiles.
+const (
+	profileMinimal   = "minimal"
+	profileStandard  = "standard"
+	profileIntensive = "intensive"
+)
+
+// scrapeProfile bundles the scrape settings selected together by
+// --exporter.profile.
+type scrapeProfile struct {
+	// Collectors are the names of the enabled collectors.
+	Collectors []string `yaml:"collectors"`
+	// CacheTTL is how long collected metrics are cached, 0 for no caching.
+	CacheTTL time.Duration `yaml:"cache_ttl"`
+	// CollectorCacheTTLs replace CacheTTL for the collectors they name.
+	CollectorCacheTTLs map[string]time.Duration `yaml:"collector_cache_ttls"`
+}
+
+// builtinProfiles returns the profiles available without a config file. The
+// standard profile enables the collectors enabled by default and does not
+// cache. The intensive one leaves out the collectors reading local files,
+// which need their paths configured.
+func builtinProfiles() map[string]scrapeProfile {
+	standard := scrapeProfile{}
+	for scraper, enabledByDefault := range scrapers {
+		if enabledByDefault {
+			standard.Collectors = append(standard.Collectors, scraper.Name())
+		}
+	}
+	return map[string]scrapeProfile{
+		profileMinimal: {
+			Collectors: []string{"broker_status", "inventory"},
+			CacheTTL:   time.Minute,
+		},
+		profileStandard: standard,
+		profileIntensive: {
+			Collectors: []string{
+				"broker_status", "statdump", "spacedb", "broker_server_ping", "replication_apply",
+				"ha_status", "sessions_by_program", "inventory",
+			},
+		},
+	}
+}
+
+var profileInfoDesc = prometheus.NewDesc(
+	"cubrid_exporter_profile_info",
+	"The active scrape profile.",
+	[]string{"profile"}, nil,
+)
+
+// scrapeSettings are the settings a profile resolves to.
+type scrapeSettings struct {
+	profile  string
+	scrapers []collector.Scraper
+	cache    *collector.ScrapeCache
+}
+
+// profileSwitch resolves the active profile and switches it at runtime.
+// Flags set on the command line take precedence over any profile: a
+// --collect.<name> flag enables or disables its collector, and
+// --exporter.cache-ttl replaces the cache TTLs of the profile.
+// It implements prometheus.Collector.
+type profileSwitch struct {
+	profiles map[string]scrapeProfile
+	// explicit holds the collectors enabled or disabled on the command line.
+	explicit map[string]bool
+	// cacheTTL is nil unless --exporter.cache-ttl was set.
+	cacheTTL *time.Duration
+	// simulated replace the collectors of every profile in simulate mode.
+	simulated []collector.Scraper
+	// check validates a profile against the feature compatibility rules.
+	check func(profile string) []error
+
+	mu     sync.Mutex
+	active scrapeSettings
+}
+
+// newProfileSwitch validates the user-defined profiles, which must not
+// shadow built-in ones, and activates profile.
+func newProfileSwitch(profile string, userProfiles map[string]scrapeProfile, explicit map[string]bool, cacheTTL *time.Duration,
+	simulated []collector.Scraper, check func(profile string) []error) (*profileSwitch, error) {
+	p := &profileSwitch{
+		profiles:  builtinProfiles(),
+		explicit:  explicit,
+		cacheTTL:  cacheTTL,
+		simulated: simulated,
+		check:     check,
+	}
+	for name, profile := range userProfiles {
+		if _, ok := p.profiles[name]; ok {
+			return nil, fmt.Errorf("profile %s is built in", name)
+		}
+		if err := validateProfile(profile); err != nil {
+			return nil, fmt.Errorf("profile %s: %s", name, err)
+		}
+		p.profiles[name] = profile
+	}
+	if err := p.activate(profile); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func validateProfile(profile scrapeProfile) error {
+	for _, name := range profile.Collectors {
+		if scraperByName(name) == nil {
+			return fmt.Errorf("unknown collector %q", name)
+		}
+	}
+	if profile.CacheTTL < 0 {
+		return fmt.Errorf("negative cache_ttl %s", profile.CacheTTL)
+	}
+	for name, ttl := range profile.CollectorCacheTTLs {
+		if scraperByName(name) == nil {
+			return fmt.Errorf("unknown collector %q in collector_cache_ttls", name)
+		}
+		if ttl < 0 {
+			return fmt.Errorf("negative cache TTL %s for collector %s", ttl, name)
+		}
+	}
+	return nil
+}
+
+// scraperByName returns the scraper named name, nil if there is none.
+func scraperByName(name string) collector.Scraper {
+	for scraper := range scrapers {
+		if scraper.Name() == name {
+			return scraper
+		}
+	}
+	return nil
+}
+
+// resolve returns the settings of the profile named name, with the flags
+// set on the command line applied on top.
+func (p *profileSwitch) resolve(name string) (scrapeSettings, error) {
+	profile, ok := p.profiles[name]
+	if !ok {
+		return scrapeSettings{}, fmt.Errorf("unknown profile %q", name)
+	}
+	if errs := p.check(name); len(errs) > 0 {
+		return scrapeSettings{}, fmt.Errorf("profile %s violates %d feature compatibility rule(s), first: %s", name, len(errs), errs[0])
+	}
+
+	enabled := map[string]bool{}
+	for _, c := range profile.Collectors {
+		enabled[c] = true
+	}
+	for c, on := range p.explicit {
+		enabled[c] = on
+	}
+	settings := scrapeSettings{profile: name, scrapers: p.simulated}
+	if p.simulated == nil {
+		settings.scrapers = []collector.Scraper{}
+		for scraper := range scrapers {
+			if enabled[scraper.Name()] {
+				settings.scrapers = append(settings.scrapers, scraper)
+			}
+		}
+		sort.Slice(settings.scrapers, func(i, j int) bool { return settings.scrapers[i].Name() < settings.scrapers[j].Name() })
+	}
+	if p.cacheTTL != nil {
+		settings.cache = collector.NewScrapeCache(*p.cacheTTL)
+	} else {
+		settings.cache = collector.NewCollectorScrapeCache(profile.CacheTTL, profile.CollectorCacheTTLs)
+	}
+	return settings, nil
+}
+
+// activate switches to the profile named name. The next scrape uses its
+// settings; scrapes in flight finish with the previous ones.
+func (p *profileSwitch) activate(name string) error {
+	settings, err := p.resolve(name)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.active = settings
+	return nil
+}
+
+// current returns the settings of the active profile.
+func (p *profileSwitch) current() scrapeSettings {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.active
+}
+
+// Describe implements prometheus.Collector.
+func (p *profileSwitch) Describe(ch chan<- *prometheus.Desc) {
+	ch <- profileInfoDesc
+}
+
+// Collect implements prometheus.Collector.
+func (p *profileSwitch) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(profileInfoDesc, prometheus.GaugeValue, 1, p.current().profile)
+}
+
+// profileReadHandler serves the active profile and its collectors.
+func profileReadHandler(p *profileSwitch) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		settings := p.current()
+		fmt.Fprintf(w, "profile %s\n", settings.profile)
+		for _, scraper := range settings.scrapers {
+			fmt.Fprintf(w, "collector %s\n", scraper.Name())
+		}
+	}
+}
+
+// profileWriteHandler activates the profile given by the name parameter.
+func profileWriteHandler(p *profileSwitch) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("name")
+		if name == "" {
+			http.Error(w, "Missing name parameter.", http.StatusBadRequest)
+			return
+		}
+		if err := p.activate(name); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid profile: %s.", err), http.StatusBadRequest)
+			return
+		}
+		log.Infof("audit: scrape profile set to %s", name)
+		fmt.Fprintf(w, "Scrape profile %s active.\n", name)
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// newTestProfileSwitch activates profile without compatibility rules.
+func newTestProfileSwitch(t *testing.T, profile string, userProfiles map[string]scrapeProfile, explicit map[string]bool, cacheTTL *time.Duration) *profileSwitch {
+	p, err := newProfileSwitch(profile, userProfiles, explicit, cacheTTL, nil, func(string) []error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// collectorNames returns the names of the collectors of settings.
+func collectorNames(settings scrapeSettings) []string {
+	names := []string{}
+	for _, scraper := range settings.scrapers {
+		names = append(names, scraper.Name())
+	}
+	return names
+}
+
+// caches reports whether settings cache the metrics of the collector.
+func caches(settings scrapeSettings, collector string) bool {
+	settings.cache.Set("demodb", collector, nil)
+	_, ok := settings.cache.Get("demodb", collector)
+	return ok
+}
+
+func TestBuiltinProfiles(t *testing.T) {
+	minimal := newTestProfileSwitch(t, profileMinimal, nil, nil, nil).current()
+	if names := collectorNames(minimal); !reflect.DeepEqual(names, []string{"broker_status", "inventory"}) {
+		t.Errorf("minimal collectors = %v", names)
+	}
+	if !caches(minimal, "broker_status") {
+		t.Error("the minimal profile does not cache")
+	}
+
+	standard := newTestProfileSwitch(t, profileStandard, nil, nil, nil).current()
+	var want []string
+	for scraper, enabledByDefault := range scrapers {
+		if enabledByDefault {
+			want = append(want, scraper.Name())
+		}
+	}
+	sort.Strings(want)
+	if names := collectorNames(standard); !reflect.DeepEqual(names, want) {
+		t.Errorf("standard collectors = %v, want the default ones %v", names, want)
+	}
+	if standard.cache != nil {
+		t.Error("the standard profile caches")
+	}
+}
+
+func TestUserProfile(t *testing.T) {
+	user := map[string]scrapeProfile{"capacity": {
+		Collectors:         []string{"spacedb", "statdump"},
+		CollectorCacheTTLs: map[string]time.Duration{"spacedb": time.Hour},
+	}}
+	settings := newTestProfileSwitch(t, "capacity", user, nil, nil).current()
+	if names := collectorNames(settings); !reflect.DeepEqual(names, []string{"spacedb", "statdump"}) {
+		t.Errorf("collectors = %v", names)
+	}
+	if !caches(settings, "spacedb") || caches(settings, "statdump") {
+		t.Error("only spacedb should be cached")
+	}
+
+	for name, invalid := range map[string]map[string]scrapeProfile{
+		"shadowing":         {profileMinimal: {}},
+		"unknown collector": {"capacity": {Collectors: []string{"unheard_of"}}},
+		"negative TTL":      {"capacity": {CacheTTL: -time.Second}},
+	} {
+		if _, err := newProfileSwitch(profileMinimal, invalid, nil, nil, nil, func(string) []error { return nil }); err == nil {
+			t.Errorf("%s: profile was accepted", name)
+		}
+	}
+}
+
+// TestProfileOverrides checks that flags set on the command line take
+// precedence over the profile.
+func TestProfileOverrides(t *testing.T) {
+	noCache := time.Duration(0)
+	explicit := map[string]bool{"broker_status": false, "statdump": true}
+	settings := newTestProfileSwitch(t, profileMinimal, nil, explicit, &noCache).current()
+	if names := collectorNames(settings); !reflect.DeepEqual(names, []string{"inventory", "statdump"}) {
+		t.Errorf("collectors = %v, want inventory and statdump", names)
+	}
+	if settings.cache != nil {
+		t.Error("--exporter.cache-ttl=0 did not disable the cache of the profile")
+	}
+}
+
+func TestProfileSwitch(t *testing.T) {
+	p := newTestProfileSwitch(t, profileStandard, nil, nil, nil)
+	write := profileWriteHandler(p)
+
+	rec := httptest.NewRecorder()
+	write(rec, httptest.NewRequest(http.MethodPost, "/-/profile?name=minimal", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	// The next scrape uses the settings of the new profile.
+	if settings := p.current(); settings.profile != profileMinimal || len(settings.scrapers) != 2 {
+		t.Errorf("active profile = %s with %v", settings.profile, collectorNames(settings))
+	}
+
+	rec = httptest.NewRecorder()
+	write(rec, httptest.NewRequest(http.MethodPost, "/-/profile?name=unheard_of", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status for an unknown profile = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if profile := p.current().profile; profile != profileMinimal {
+		t.Errorf("active profile after a failed switch = %s, want %s", profile, profileMinimal)
+	}
+}
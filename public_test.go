@@ -0,0 +1,75 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/cubrid/cubrid-exporter/collector"
+)
+
+// get returns the status and body of a GET of path from h.
+func get(h http.Handler, path string) (int, string) {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	return rec.Code, rec.Body.String()
+}
+
+func TestPublicListener(t *testing.T) {
+	up := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "cubrid_up", Help: "Up."}, []string{"database"})
+	up.WithLabelValues("appdb").Set(1)
+	up.WithLabelValues("otherdb").Set(1)
+	secret := prometheus.NewGauge(prometheus.GaugeOpts{Name: "cubrid_exporter_internal", Help: "Internal."})
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(up, secret)
+
+	public, err := collector.NewPublicView(collector.PublicMetricsConfig{
+		Families:    []string{"cubrid_up"},
+		LabelValues: map[string][]string{"database": {"appdb"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	private := promhttp.HandlerFor(public.Wrap(registry), promhttp.HandlerOpts{})
+	publicHandler := newPublicHandler("/metrics", public)
+
+	// Nothing is published before the first private scrape.
+	if code, body := get(publicHandler, "/metrics"); code != http.StatusOK || body != "" {
+		t.Errorf("public metrics before a scrape = %d %q, want none", code, body)
+	}
+
+	_, privateBody := get(private, "/metrics")
+	for _, sample := range []string{`cubrid_up{database="appdb"} 1`, `cubrid_up{database="otherdb"} 1`, "cubrid_exporter_internal 0"} {
+		if !strings.Contains(privateBody, sample) {
+			t.Errorf("private metrics lack %s", sample)
+		}
+	}
+
+	code, body := get(publicHandler, "/metrics")
+	want := "# HELP cubrid_up Up.\n# TYPE cubrid_up gauge\ncubrid_up{database=\"appdb\"} 1\n"
+	if code != http.StatusOK || body != want {
+		t.Errorf("public metrics = %d %q, want %q", code, body, want)
+	}
+	for _, path := range []string{"/-/hwm", "/-/queries", "/-/reload", "/probe", "/"} {
+		if code, _ := get(publicHandler, path); code != http.StatusNotFound {
+			t.Errorf("public listener serves %s with status %d, want %d", path, code, http.StatusNotFound)
+		}
+	}
+}
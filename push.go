@@ -0,0 +1,65 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+
+	"github.com/cubrid/cubrid-exporter/collector"
+)
+
+// pushOnce gathers all enabled scrapers once and pushes the result to the Pushgateway.
+func pushOnce(ctx context.Context, dsn, url, job string, scrapers []collector.Scraper, process pipeline) error {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector.New(ctx, dsn, collector.NewMetrics(), scrapers, nil))
+
+	return push.New(url, job).
+		Gatherer(withPushChecksum(process(registry))).
+		Grouping("instance_id", instanceID).
+		Push()
+}
+
+// remoteWriteAuth holds the optional credentials sent with HTTP requests, e.g. to the remote-write receiver.
+type remoteWriteAuth struct {
+	username        string
+	passwordFile    string
+	bearerTokenFile string
+}
+
+func (a remoteWriteAuth) apply(req *http.Request) error {
+	if a.bearerTokenFile != "" {
+		token, err := ioutil.ReadFile(a.bearerTokenFile)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	if a.username != "" {
+		var password []byte
+		if a.passwordFile != "" {
+			var err error
+			if password, err = ioutil.ReadFile(a.passwordFile); err != nil {
+				return err
+			}
+		}
+		req.SetBasicAuth(a.username, strings.TrimSpace(string(password)))
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/log"
+)
+
+// Families added to every push batch, so the receiving side can verify it.
+const (
+	pushChecksumFamily = "cubrid_exporter_push_batch_checksum"
+	pushFamiliesFamily = "cubrid_exporter_push_batch_families"
+	pushSamplesFamily  = "cubrid_exporter_push_batch_samples"
+)
+
+// pushChecksumMask keeps the checksum exactly representable as a float64.
+const pushChecksumMask = 1<<53 - 1
+
+// pushChecksum returns the checksum of a push batch and its family and
+// sample counts. The checksum is the 64-bit FNV-1a hash of one line
+// "<family name> <number of samples>\n" per family, sorted by name, masked
+// to the low 53 bits. A sample is a dto.Metric, so a histogram counts once.
+// An empty batch hashes nothing and yields the FNV offset basis, masked.
+func pushChecksum(mfs []*dto.MetricFamily) (uint64, int, int) {
+	lines := make([]string, 0, len(mfs))
+	samples := 0
+	for _, mf := range mfs {
+		lines = append(lines, fmt.Sprintf("%s %d\n", mf.GetName(), len(mf.Metric)))
+		samples += len(mf.Metric)
+	}
+	sort.Strings(lines)
+
+	h := fnv.New64a()
+	for _, line := range lines {
+		h.Write([]byte(line))
+	}
+	return h.Sum64() & pushChecksumMask, len(mfs), samples
+}
+
+// withPushChecksum returns a Gatherer adding the checksum and counts of the
+// batch g gathers as gauges, and logging them for comparison with what the
+// receiving side ingested.
+func withPushChecksum(g prometheus.Gatherer) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		mfs, err := g.Gather()
+		if err != nil {
+			return mfs, err
+		}
+		checksum, families, samples := pushChecksum(mfs)
+		log.Infof("Push batch checksum %d over %d families and %d samples", checksum, families, samples)
+
+		gauge := func(name, help string, v float64) *dto.MetricFamily {
+			return &dto.MetricFamily{
+				Name:   proto.String(name),
+				Help:   proto.String(help),
+				Type:   dto.MetricType_GAUGE.Enum(),
+				Metric: []*dto.Metric{{Gauge: &dto.Gauge{Value: proto.Float64(v)}}},
+			}
+		}
+		mfs = append(mfs,
+			gauge(pushChecksumFamily, "Checksum over the names and sample counts of the other families of the push batch.", float64(checksum)),
+			gauge(pushFamiliesFamily, "Number of other families in the push batch.", float64(families)),
+			gauge(pushSamplesFamily, "Number of samples of the other families in the push batch.", float64(samples)),
+		)
+		sort.Slice(mfs, func(i, j int) bool { return mfs[i].GetName() < mfs[j].GetName() })
+		return mfs, nil
+	})
+}
@@ -0,0 +1,93 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// checksumFamily returns a gauge family with samples metrics.
+func checksumFamily(name string, samples int) *dto.MetricFamily {
+	mf := &dto.MetricFamily{Name: proto.String(name), Type: dto.MetricType_GAUGE.Enum()}
+	for i := 0; i < samples; i++ {
+		mf.Metric = append(mf.Metric, &dto.Metric{Gauge: &dto.Gauge{Value: proto.Float64(float64(i))}})
+	}
+	return mf
+}
+
+func TestPushChecksum(t *testing.T) {
+	for _, tc := range []struct {
+		name              string
+		batch             []*dto.MetricFamily
+		checksum          uint64
+		families, samples int
+	}{
+		// The FNV-1a offset basis, masked to 53 bits.
+		{"empty", nil, 5239054864098085, 0, 0},
+		{"sorted", []*dto.MetricFamily{checksumFamily("cubrid_up", 1), checksumFamily("cubrid_version_info", 2)},
+			8583042553214922, 2, 3},
+		{"unsorted", []*dto.MetricFamily{checksumFamily("cubrid_version_info", 2), checksumFamily("cubrid_up", 1)},
+			8583042553214922, 2, 3},
+	} {
+		checksum, families, samples := pushChecksum(tc.batch)
+		if checksum != tc.checksum || families != tc.families || samples != tc.samples {
+			t.Errorf("%s: pushChecksum = %d, %d, %d, want %d, %d, %d",
+				tc.name, checksum, families, samples, tc.checksum, tc.families, tc.samples)
+		}
+	}
+
+	full, _, _ := pushChecksum([]*dto.MetricFamily{checksumFamily("cubrid_up", 1), checksumFamily("cubrid_version_info", 2)})
+	for name, truncated := range map[string][]*dto.MetricFamily{
+		"missing family": {checksumFamily("cubrid_up", 1)},
+		"missing sample": {checksumFamily("cubrid_up", 1), checksumFamily("cubrid_version_info", 1)},
+	} {
+		if checksum, _, _ := pushChecksum(truncated); checksum == full {
+			t.Errorf("%s: the checksum of the truncated batch equals the full one", name)
+		}
+	}
+}
+
+func TestWithPushChecksum(t *testing.T) {
+	batch := []*dto.MetricFamily{checksumFamily("cubrid_up", 1), checksumFamily("cubrid_version_info", 2)}
+	mfs, err := withPushChecksum(prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		return batch, nil
+	})).Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := map[string]float64{}
+	for _, mf := range mfs {
+		if len(mf.Metric) == 1 && mf.Metric[0].Gauge != nil {
+			values[mf.GetName()] = mf.Metric[0].Gauge.GetValue()
+		}
+	}
+	want := map[string]float64{
+		"cubrid_up":        0,
+		pushChecksumFamily: 8583042553214922,
+		pushFamiliesFamily: 2,
+		pushSamplesFamily:  3,
+	}
+	for name, v := range want {
+		if got, ok := values[name]; !ok || got != v {
+			t.Errorf("%s = %v, want %v", name, got, v)
+		}
+	}
+	if len(mfs) != 5 {
+		t.Errorf("families = %d, want 5", len(mfs))
+	}
+}
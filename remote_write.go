@@ -0,0 +1,273 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !noremotewrite
+// +build !noremotewrite
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/log"
+	"github.com/prometheus/common/version"
+
+	"github.com/cubrid/cubrid-exporter/collector"
+)
+
+func init() {
+	collector.RegisterBuildFeature(buildFeatureRemoteWrite, true)
+}
+
+const (
+	// remoteWriteRetries bounds the attempts to send a remote-write request.
+	remoteWriteRetries = 5
+)
+
+// remoteWriteRetryBackoff is the delay before the first retry; it doubles
+// with every retry. It is shortened to test the retries.
+var remoteWriteRetryBackoff = time.Second
+
+// Metric types of the remote-write MetricMetadata message.
+const (
+	metadataUnknown   = 0
+	metadataCounter   = 1
+	metadataGauge     = 2
+	metadataHistogram = 3
+	metadataSummary   = 5
+)
+
+type rwLabel struct {
+	name, value string
+}
+
+type rwSeries struct {
+	labels    []rwLabel
+	value     float64
+	timestamp int64
+}
+
+// remoteWriteOnce gathers all enabled scrapers once and sends the result as a
+// Prometheus remote-write request.
+func remoteWriteOnce(ctx context.Context, dsn, url string, auth remoteWriteAuth, scrapers []collector.Scraper, process pipeline) error {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector.New(ctx, dsn, collector.NewMetrics(), scrapers, nil))
+
+	mfs, err := withPushChecksum(process(registry)).Gather()
+	if err != nil {
+		return err
+	}
+	// Identify the exporter the same way the Pushgateway grouping key does.
+	extra := []rwLabel{{"instance_id", instanceID}}
+	body := snappy.Encode(nil, encodeWriteRequest(mfs, extra, time.Now()))
+
+	backoff := remoteWriteRetryBackoff
+	for attempt := 1; ; attempt++ {
+		retry, err := postRemoteWrite(ctx, url, auth, body)
+		if err == nil || !retry {
+			return err
+		}
+		if attempt == remoteWriteRetries {
+			return fmt.Errorf("giving up after %d attempts: %s", attempt, err)
+		}
+		log.Warnf("Error sending remote write request, retrying in %s: %s", backoff, err)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s, last error: %s", ctx.Err(), err)
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// postRemoteWrite sends a remote-write request and reports whether a failed
+// request may be retried: receivers answer 5xx and 429 for transient errors
+// and other 4xx for requests that will never be accepted.
+func postRemoteWrite(ctx context.Context, url string, auth remoteWriteAuth, body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "cubrid_exporter/"+version.Version)
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if err := auth.apply(req); err != nil {
+		return false, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		retry := resp.StatusCode/100 == 5 || resp.StatusCode == http.StatusTooManyRequests
+		return retry, fmt.Errorf("remote write returned HTTP status %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return false, nil
+}
+
+// toSeries converts a metric family into remote-write series. Histograms and
+// summaries are expanded into their _bucket/quantile, _sum and _count series
+// the way Prometheus stores them. Samples without a timestamp get now.
+func toSeries(mf *dto.MetricFamily, extra []rwLabel, now time.Time) []rwSeries {
+	var series []rwSeries
+	name := mf.GetName()
+	for _, m := range mf.Metric {
+		ts := now.UnixNano() / int64(time.Millisecond)
+		if m.TimestampMs != nil {
+			ts = m.GetTimestampMs()
+		}
+		add := func(suffix string, value float64, special ...rwLabel) {
+			labels := []rwLabel{{"__name__", name + suffix}}
+			for _, lp := range m.Label {
+				labels = append(labels, rwLabel{lp.GetName(), lp.GetValue()})
+			}
+			labels = append(labels, special...)
+			labels = append(labels, extra...)
+			sort.Slice(labels, func(i, j int) bool { return labels[i].name < labels[j].name })
+			series = append(series, rwSeries{labels: labels, value: value, timestamp: ts})
+		}
+
+		switch mf.GetType() {
+		case dto.MetricType_COUNTER:
+			add("", m.GetCounter().GetValue())
+		case dto.MetricType_GAUGE:
+			add("", m.GetGauge().GetValue())
+		case dto.MetricType_UNTYPED:
+			add("", m.GetUntyped().GetValue())
+		case dto.MetricType_SUMMARY:
+			s := m.GetSummary()
+			for _, q := range s.Quantile {
+				add("", q.GetValue(), rwLabel{"quantile", formatFloat(q.GetQuantile())})
+			}
+			add("_sum", s.GetSampleSum())
+			add("_count", float64(s.GetSampleCount()))
+		case dto.MetricType_HISTOGRAM:
+			h := m.GetHistogram()
+			infSeen := false
+			for _, b := range h.Bucket {
+				add("_bucket", float64(b.GetCumulativeCount()), rwLabel{"le", formatFloat(b.GetUpperBound())})
+				infSeen = infSeen || math.IsInf(b.GetUpperBound(), +1)
+			}
+			if !infSeen {
+				add("_bucket", float64(h.GetSampleCount()), rwLabel{"le", "+Inf"})
+			}
+			add("_sum", h.GetSampleSum())
+			add("_count", float64(h.GetSampleCount()))
+		}
+	}
+	return series
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, +1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func metadataType(t dto.MetricType) uint64 {
+	switch t {
+	case dto.MetricType_COUNTER:
+		return metadataCounter
+	case dto.MetricType_GAUGE:
+		return metadataGauge
+	case dto.MetricType_HISTOGRAM:
+		return metadataHistogram
+	case dto.MetricType_SUMMARY:
+		return metadataSummary
+	}
+	return metadataUnknown
+}
+
+// encodeWriteRequest encodes the families as a remote-write WriteRequest
+// protobuf message:
+//
+//	WriteRequest   { repeated TimeSeries timeseries = 1; repeated MetricMetadata metadata = 3; }
+//	TimeSeries     { repeated Label labels = 1; repeated Sample samples = 2; }
+//	Label          { string name = 1; string value = 2; }
+//	Sample         { double value = 1; int64 timestamp = 2; }
+//	MetricMetadata { MetricType type = 1; string metric_family_name = 2; string help = 4; }
+func encodeWriteRequest(mfs []*dto.MetricFamily, extra []rwLabel, now time.Time) []byte {
+	var buf []byte
+	for _, mf := range mfs {
+		for _, s := range toSeries(mf, extra, now) {
+			var ts []byte
+			for _, l := range s.labels {
+				var label []byte
+				label = appendString(label, 1, l.name)
+				label = appendString(label, 2, l.value)
+				ts = appendBytes(ts, 1, label)
+			}
+			var sample []byte
+			sample = appendTag(sample, 1, 1)
+			sample = appendFixed64(sample, math.Float64bits(s.value))
+			sample = appendTag(sample, 2, 0)
+			sample = appendVarint(sample, uint64(s.timestamp))
+			ts = appendBytes(ts, 2, sample)
+			buf = appendBytes(buf, 1, ts)
+		}
+	}
+	for _, mf := range mfs {
+		var md []byte
+		md = appendTag(md, 1, 0)
+		md = appendVarint(md, metadataType(mf.GetType()))
+		md = appendString(md, 2, mf.GetName())
+		md = appendString(md, 4, mf.GetHelp())
+		buf = appendBytes(buf, 3, md)
+	}
+	return buf
+}
+
+func appendTag(b []byte, field, wireType uint64) []byte {
+	return appendVarint(b, field<<3|wireType)
+}
+
+func appendVarint(b []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	return append(b, tmp[:n]...)
+}
+
+func appendFixed64(b []byte, v uint64) []byte {
+	var tmp [8]byte
+	binary.LittleEndian.PutUint64(tmp[:], v)
+	return append(b, tmp[:]...)
+}
+
+func appendBytes(b []byte, field uint64, v []byte) []byte {
+	b = appendTag(b, field, 2)
+	b = appendVarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+func appendString(b []byte, field uint64, v string) []byte {
+	return appendBytes(b, field, []byte(v))
+}
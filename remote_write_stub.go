@@ -0,0 +1,33 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build noremotewrite
+// +build noremotewrite
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cubrid/cubrid-exporter/collector"
+)
+
+func init() {
+	collector.RegisterBuildFeature(buildFeatureRemoteWrite, false)
+}
+
+// remoteWriteOnce fails, as this build excludes the remote-write client.
+func remoteWriteOnce(ctx context.Context, dsn, url string, auth remoteWriteAuth, scrapers []collector.Scraper, process pipeline) error {
+	return fmt.Errorf("the exporter was built without remote write (build tag no%s)", buildFeatureRemoteWrite)
+}
@@ -0,0 +1,30 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build noremotewrite
+// +build noremotewrite
+
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestRemoteWriteStub(t *testing.T) {
+	err := remoteWriteOnce(context.Background(), "", "http://localhost:9090/api/v1/write", remoteWriteAuth{}, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "no"+buildFeatureRemoteWrite) {
+		t.Errorf("remoteWriteOnce error = %v, want one naming the build tag", err)
+	}
+}
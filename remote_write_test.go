@@ -0,0 +1,340 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !noremotewrite
+// +build !noremotewrite
+
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/cubrid/cubrid-exporter/collector"
+)
+
+// decodedSeries is a time series of a decoded remote-write request, its
+// labels formatted like name{a="1",b="2"}.
+type decodedSeries struct {
+	labels    string
+	value     float64
+	timestamp int64
+}
+
+// decodeWriteRequest decodes the series and metadata of a WriteRequest, as
+// a receiver would.
+func decodeWriteRequest(t *testing.T, data []byte) ([]decodedSeries, []string) {
+	var series []decodedSeries
+	var metadata []string
+	forEachField(t, data, func(field uint64, b *proto.Buffer) {
+		switch field {
+		case 1:
+			raw, _ := b.DecodeRawBytes(false)
+			var s decodedSeries
+			var name string
+			var labels []string
+			forEachField(t, raw, func(field uint64, b *proto.Buffer) {
+				raw, _ := b.DecodeRawBytes(false)
+				switch field {
+				case 1:
+					var label [2]string
+					forEachField(t, raw, func(field uint64, b *proto.Buffer) {
+						v, _ := b.DecodeRawBytes(false)
+						label[field-1] = string(v)
+					})
+					if label[0] == "__name__" {
+						name = label[1]
+					} else {
+						labels = append(labels, fmt.Sprintf("%s=%q", label[0], label[1]))
+					}
+				case 2:
+					forEachField(t, raw, func(field uint64, b *proto.Buffer) {
+						if field == 1 {
+							bits, _ := b.DecodeFixed64()
+							s.value = math.Float64frombits(bits)
+						} else {
+							ts, _ := b.DecodeVarint()
+							s.timestamp = int64(ts)
+						}
+					})
+				}
+			})
+			s.labels = name + "{" + strings.Join(labels, ",") + "}"
+			series = append(series, s)
+		case 3:
+			raw, _ := b.DecodeRawBytes(false)
+			var typ uint64
+			var name, help string
+			forEachField(t, raw, func(field uint64, b *proto.Buffer) {
+				switch field {
+				case 1:
+					typ, _ = b.DecodeVarint()
+				case 2:
+					v, _ := b.DecodeRawBytes(false)
+					name = string(v)
+				case 4:
+					v, _ := b.DecodeRawBytes(false)
+					help = string(v)
+				}
+			})
+			metadata = append(metadata, fmt.Sprintf("%s %d %s", name, typ, help))
+		default:
+			t.Fatalf("unexpected WriteRequest field %d", field)
+		}
+	})
+	return series, metadata
+}
+
+// forEachField calls f with the buffer positioned at the value of every
+// field of the message in data. f must consume the value.
+func forEachField(t *testing.T, data []byte, f func(field uint64, b *proto.Buffer)) {
+	b := proto.NewBuffer(data)
+	for len(b.Unread()) > 0 {
+		tag, err := b.DecodeVarint()
+		if err != nil {
+			t.Fatalf("error decoding a tag: %s", err)
+		}
+		f(tag>>3, b)
+	}
+}
+
+func counterFamily(name string, value float64, labels ...string) *dto.MetricFamily {
+	m := &dto.Metric{Counter: &dto.Counter{Value: proto.Float64(value)}}
+	for i := 0; i < len(labels); i += 2 {
+		m.Label = append(m.Label, &dto.LabelPair{Name: proto.String(labels[i]), Value: proto.String(labels[i+1])})
+	}
+	return &dto.MetricFamily{Name: proto.String(name), Help: proto.String("Help."), Type: dto.MetricType_COUNTER.Enum(), Metric: []*dto.Metric{m}}
+}
+
+// TestEncodeWriteRequestGolden compares the encoding of a counter and a
+// gauge with the protobuf bytes of the WriteRequest message.
+func TestEncodeWriteRequestGolden(t *testing.T) {
+	counter := counterFamily("cubrid_x", 2, "z", "1")
+	counter.Help = proto.String("X.")
+	counter.Metric[0].TimestampMs = proto.Int64(1000)
+	gauge := &dto.MetricFamily{
+		Name: proto.String("cubrid_y"), Help: proto.String("Y."), Type: dto.MetricType_GAUGE.Enum(),
+		Metric: []*dto.Metric{{Gauge: &dto.Gauge{Value: proto.Float64(-0.5)}}},
+	}
+
+	golden := strings.Join([]string{
+		// TimeSeries of cubrid_x, labels sorted by name.
+		"0a34",
+		"0a14" + "0a08" + hex.EncodeToString([]byte("__name__")) + "1208" + hex.EncodeToString([]byte("cubrid_x")),
+		"0a06" + "0a0161" + "120162",                       // a="b"
+		"0a06" + "0a017a" + "120131",                       // z="1"
+		"120c" + "09" + "0000000000000040" + "10" + "e807", // 2 at the collection timestamp 1000
+		// TimeSeries of cubrid_y, without a timestamp sent at now.
+		"0a2c",
+		"0a14" + "0a08" + hex.EncodeToString([]byte("__name__")) + "1208" + hex.EncodeToString([]byte("cubrid_y")),
+		"0a06" + "0a0161" + "120162",                       // a="b"
+		"120c" + "09" + "000000000000e0bf" + "10" + "d00f", // -0.5 at 2000
+		// MetricMetadata: counter, gauge.
+		"1a10" + "0801" + "1208" + hex.EncodeToString([]byte("cubrid_x")) + "2202" + hex.EncodeToString([]byte("X.")),
+		"1a10" + "0802" + "1208" + hex.EncodeToString([]byte("cubrid_y")) + "2202" + hex.EncodeToString([]byte("Y.")),
+	}, "")
+
+	got := encodeWriteRequest([]*dto.MetricFamily{counter, gauge}, []rwLabel{{"a", "b"}}, time.Unix(2, 0))
+	if hex.EncodeToString(got) != golden {
+		t.Errorf("encoding =\n%x\nwant\n%s", got, golden)
+	}
+}
+
+// TestEncodeWriteRequestExpansion checks the series histograms and summaries
+// are expanded into.
+func TestEncodeWriteRequestExpansion(t *testing.T) {
+	histogram := &dto.MetricFamily{
+		Name: proto.String("cubrid_h"), Help: proto.String("H."), Type: dto.MetricType_HISTOGRAM.Enum(),
+		Metric: []*dto.Metric{{Histogram: &dto.Histogram{
+			SampleCount: proto.Uint64(3), SampleSum: proto.Float64(1.5),
+			Bucket: []*dto.Bucket{{UpperBound: proto.Float64(0.5), CumulativeCount: proto.Uint64(2)}},
+		}}},
+	}
+	summary := &dto.MetricFamily{
+		Name: proto.String("cubrid_s"), Help: proto.String("S."), Type: dto.MetricType_SUMMARY.Enum(),
+		Metric: []*dto.Metric{{Summary: &dto.Summary{
+			SampleCount: proto.Uint64(4), SampleSum: proto.Float64(8),
+			Quantile: []*dto.Quantile{{Quantile: proto.Float64(0.99), Value: proto.Float64(3)}},
+		}}},
+	}
+
+	series, metadata := decodeWriteRequest(t, encodeWriteRequest([]*dto.MetricFamily{histogram, summary}, nil, time.Unix(1, 0)))
+	var got []string
+	for _, s := range series {
+		got = append(got, fmt.Sprintf("%s %g %d", s.labels, s.value, s.timestamp))
+	}
+	want := []string{
+		`cubrid_h_bucket{le="0.5"} 2 1000`,
+		`cubrid_h_bucket{le="+Inf"} 3 1000`,
+		`cubrid_h_sum{} 1.5 1000`,
+		`cubrid_h_count{} 3 1000`,
+		`cubrid_s{quantile="0.99"} 3 1000`,
+		`cubrid_s_sum{} 8 1000`,
+		`cubrid_s_count{} 4 1000`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("series =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if want := []string{"cubrid_h 3 H.", "cubrid_s 5 S."}; strings.Join(metadata, "\n") != strings.Join(want, "\n") {
+		t.Errorf("metadata = %q, want %q", metadata, want)
+	}
+}
+
+// remoteWriteReceiver is an httptest receiver answering with the given
+// status codes in turn, then 204, and recording the requests.
+type remoteWriteReceiver struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (r *remoteWriteReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = append(r.requests, req)
+	r.bodies = append(r.bodies, body)
+	if len(r.statuses) > 0 {
+		status := r.statuses[0]
+		r.statuses = r.statuses[1:]
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// withRemoteWriteReceiver starts a receiver and shortens the retry backoff.
+func withRemoteWriteReceiver(t *testing.T, statuses ...int) (*remoteWriteReceiver, string) {
+	receiver := &remoteWriteReceiver{statuses: statuses}
+	srv := httptest.NewServer(receiver)
+	backoff := remoteWriteRetryBackoff
+	remoteWriteRetryBackoff = time.Millisecond
+	t.Cleanup(func() {
+		srv.Close()
+		remoteWriteRetryBackoff = backoff
+	})
+	return receiver, srv.URL
+}
+
+func sendRemoteWrite(auth remoteWriteAuth, url string) error {
+	identity := func(g prometheus.Gatherer) prometheus.Gatherer { return g }
+	return remoteWriteOnce(context.Background(), collector.SimulatedDSN, url, auth, nil, identity)
+}
+
+func TestRemoteWriteReceiver(t *testing.T) {
+	receiver, url := withRemoteWriteReceiver(t)
+	dir, err := ioutil.TempDir("", "remote-write")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	tokenFile := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sendRemoteWrite(remoteWriteAuth{bearerTokenFile: tokenFile}, url); err != nil {
+		t.Fatalf("remote write failed: %s", err)
+	}
+	if len(receiver.requests) != 1 {
+		t.Fatalf("requests = %d, want 1", len(receiver.requests))
+	}
+	req := receiver.requests[0]
+	for header, want := range map[string]string{
+		"Content-Encoding":                  "snappy",
+		"Content-Type":                      "application/x-protobuf",
+		"X-Prometheus-Remote-Write-Version": "0.1.0",
+		"Authorization":                     "Bearer s3cret",
+	} {
+		if got := req.Header.Get(header); got != want {
+			t.Errorf("header %s = %q, want %q", header, got, want)
+		}
+	}
+	if ua := req.Header.Get("User-Agent"); !strings.HasPrefix(ua, "cubrid_exporter/") {
+		t.Errorf("User-Agent = %q, want cubrid_exporter/<version>", ua)
+	}
+
+	data, err := snappy.Decode(nil, receiver.bodies[0])
+	if err != nil {
+		t.Fatalf("body is not snappy-compressed: %s", err)
+	}
+	series, _ := decodeWriteRequest(t, data)
+	found := false
+	for _, s := range series {
+		if strings.HasPrefix(s.labels, "cubrid_up{") {
+			found = true
+			if want := fmt.Sprintf("instance_id=%q", instanceID); !strings.Contains(s.labels, want) {
+				t.Errorf("cubrid_up labels = %s, want %s", s.labels, want)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("no cubrid_up series in %d series", len(series))
+	}
+}
+
+func TestRemoteWriteBasicAuth(t *testing.T) {
+	receiver, url := withRemoteWriteReceiver(t)
+	if err := sendRemoteWrite(remoteWriteAuth{username: "exporter"}, url); err != nil {
+		t.Fatalf("remote write failed: %s", err)
+	}
+	if user, password, ok := receiver.requests[0].BasicAuth(); !ok || user != "exporter" || password != "" {
+		t.Errorf("basic auth = %q, %q, %v, want exporter without a password", user, password, ok)
+	}
+}
+
+func TestRemoteWriteRetries(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		statuses []int
+		wantErr  bool
+		requests int
+	}{
+		{"success", nil, false, 1},
+		{"transient errors", []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}, false, 3},
+		{"rejected", []int{http.StatusBadRequest}, true, 1},
+		{"persistent errors", []int{500, 500, 500, 500, 500, 500}, true, remoteWriteRetries},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			receiver, url := withRemoteWriteReceiver(t, tc.statuses...)
+			if err := sendRemoteWrite(remoteWriteAuth{}, url); (err != nil) != tc.wantErr {
+				t.Errorf("remote write error = %v, want an error: %v", err, tc.wantErr)
+			}
+			if len(receiver.requests) != tc.requests {
+				t.Errorf("requests = %d, want %d", len(receiver.requests), tc.requests)
+			}
+			for i := 1; i < len(receiver.bodies); i++ {
+				if string(receiver.bodies[i]) != string(receiver.bodies[0]) {
+					t.Errorf("retry %d sent a different body", i)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,30 @@
+#!/bin/sh
+# Copyright 2020 CUBRID Authors
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+# http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+# Builds, vets and tests the exporter with every combination of the build
+# tags excluding optional features, and prints the size of each binary.
+
+set -eu
+
+cd "$(dirname "$0")/.."
+out=$(mktemp -d)
+trap 'rm -rf "$out"' EXIT
+
+for tags in "" noremotewrite noembeddedalerts "noremotewrite noembeddedalerts"; do
+	echo "== tags: ${tags:-(default)}"
+	go vet -tags "$tags" ./...
+	go test -tags "$tags" ./...
+	go build -tags "$tags" -o "$out/cubrid_exporter" .
+	echo "binary size: $(wc -c <"$out/cubrid_exporter") bytes"
+done
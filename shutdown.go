@@ -0,0 +1,124 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/common/log"
+)
+
+// component is a part of the exporter stopped at shutdown.
+type component struct {
+	name string
+	stop func(ctx context.Context) error
+}
+
+// lifecycle stops the registered components in registration order at shutdown.
+// Register components in the order they must stop: readiness first, then the
+// HTTP listener, background refreshers, sinks, state, and pools last.
+type lifecycle struct {
+	// phaseTimeout bounds the time given to each component.
+	phaseTimeout time.Duration
+
+	mu         sync.Mutex
+	components []component
+}
+
+func newLifecycle(phaseTimeout time.Duration) *lifecycle {
+	return &lifecycle{phaseTimeout: phaseTimeout}
+}
+
+// Register adds a component stopped after all previously registered ones.
+func (l *lifecycle) Register(name string, stop func(ctx context.Context) error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.components = append(l.components, component{name: name, stop: stop})
+}
+
+// Go runs the background worker run until shutdown, when its context is
+// canceled and it is waited for as a component named name. stop, if not
+// nil, runs after the worker returned, e.g. to persist its last state.
+func (l *lifecycle) Go(name string, run func(ctx context.Context), stop func(ctx context.Context) error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		run(ctx)
+	}()
+	l.Register(name, func(stopCtx context.Context) error {
+		cancel()
+		select {
+		case <-done:
+		case <-stopCtx.Done():
+			return stopCtx.Err()
+		}
+		if stop == nil {
+			return nil
+		}
+		return stop(stopCtx)
+	})
+}
+
+// Shutdown stops the components in order. A component that does not stop
+// within its phase timeout is abandoned so it can't block the ones after it.
+// The whole sequence is bounded by ctx.
+func (l *lifecycle) Shutdown(ctx context.Context) {
+	l.mu.Lock()
+	components := append([]component(nil), l.components...)
+	l.mu.Unlock()
+
+	for _, c := range components {
+		if ctx.Err() != nil {
+			log.Errorf("Shutdown timed out, not stopping %s", c.name)
+			continue
+		}
+		start := time.Now()
+		phaseCtx, cancel := context.WithTimeout(ctx, l.phaseTimeout)
+		done := make(chan error, 1)
+		go func(c component) {
+			done <- c.stop(phaseCtx)
+		}(c)
+
+		select {
+		case err := <-done:
+			if err != nil {
+				log.Errorf("Error stopping %s: %s", c.name, err)
+			} else {
+				log.Infof("Stopped %s in %s", c.name, time.Since(start))
+			}
+		case <-phaseCtx.Done():
+			log.Errorf("Timed out stopping %s after %s", c.name, time.Since(start))
+		}
+		cancel()
+	}
+}
+
+// waitForShutdown blocks until SIGINT or SIGTERM, then runs the shutdown
+// sequence bounded by timeout.
+func waitForShutdown(l *lifecycle, timeout time.Duration) {
+	term := make(chan os.Signal, 1)
+	signal.Notify(term, os.Interrupt, syscall.SIGTERM)
+	sig := <-term
+	log.Infoln("Received", sig, "shutting down")
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	l.Shutdown(ctx)
+}
@@ -0,0 +1,109 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stopRecorder records the order components are stopped in.
+type stopRecorder struct {
+	mu      sync.Mutex
+	stopped []string
+}
+
+func (r *stopRecorder) record(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stopped = append(r.stopped, name)
+}
+
+func (r *stopRecorder) stop(name string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		r.record(name)
+		return nil
+	}
+}
+
+func (r *stopRecorder) order() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.stopped...)
+}
+
+func TestLifecycleOrder(t *testing.T) {
+	var r stopRecorder
+	l := newLifecycle(time.Second)
+	l.Register("readiness", r.stop("readiness"))
+	l.Register("http server", r.stop("http server"))
+	l.Go("refresher", func(ctx context.Context) {
+		<-ctx.Done()
+		r.record("refresher canceled")
+	}, r.stop("refresher"))
+	l.Register("database connections", r.stop("database connections"))
+
+	l.Shutdown(context.Background())
+	want := []string{"readiness", "http server", "refresher canceled", "refresher", "database connections"}
+	if got := r.order(); !reflect.DeepEqual(got, want) {
+		t.Errorf("stop order = %v, want %v", got, want)
+	}
+}
+
+// TestLifecyclePhaseTimeout checks that a hanging component is abandoned
+// after the phase timeout and the components after it are still stopped.
+func TestLifecyclePhaseTimeout(t *testing.T) {
+	var r stopRecorder
+	hang := make(chan struct{})
+	defer close(hang)
+	l := newLifecycle(50 * time.Millisecond)
+	l.Register("hanging", func(ctx context.Context) error {
+		<-hang
+		return nil
+	})
+	l.Go("hanging refresher", func(ctx context.Context) { <-hang }, r.stop("hanging refresher"))
+	l.Register("database connections", r.stop("database connections"))
+
+	start := time.Now()
+	l.Shutdown(context.Background())
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("shutdown took %s, want about two phase timeouts", elapsed)
+	}
+	// The final action of a refresher that did not return is skipped.
+	if got, want := r.order(), []string{"database connections"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("stopped %v, want %v", got, want)
+	}
+}
+
+// TestLifecycleShutdownTimeout checks that components left when the whole
+// sequence times out are not stopped.
+func TestLifecycleShutdownTimeout(t *testing.T) {
+	var r stopRecorder
+	l := newLifecycle(time.Second)
+	l.Register("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	l.Register("database connections", r.stop("database connections"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	l.Shutdown(ctx)
+	if got := r.order(); len(got) != 0 {
+		t.Errorf("stopped %v after the shutdown timed out", got)
+	}
+}
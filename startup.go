@@ -0,0 +1,162 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+// Startup phases in the order they complete.
+const (
+	phaseListening = "listening"
+	phaseDatabase  = "database"
+	phaseReady     = "ready"
+)
+
+var startupPhases = []string{phaseListening, phaseDatabase, phaseReady}
+
+// failureReportTimeout bounds how long a fatal startup failure waits to be
+// observed through the listener before the process exits.
+const failureReportTimeout = 30 * time.Second
+
+// startupExit exits the process after a fatal startup failure. It is
+// replaced to test the fatal tasks.
+var startupExit = os.Exit
+
+var startupPhaseDesc = prometheus.NewDesc(
+	"cubrid_exporter_startup_phase",
+	"Whether the startup phase has completed (1 for completed).",
+	[]string{"phase"}, nil,
+)
+
+// startupTask is database-dependent work run in the background once the listener is up.
+type startupTask struct {
+	name string
+	run  func(ctx context.Context) error
+	// fatal tasks exit the process on failure.
+	fatal bool
+}
+
+// startupTracker records startup progression. It implements prometheus.Collector.
+type startupTracker struct {
+	mu       sync.Mutex
+	done     map[string]bool
+	err      error
+	reported chan struct{}
+	once     sync.Once
+}
+
+func newStartupTracker() *startupTracker {
+	return &startupTracker{
+		done:     map[string]bool{},
+		reported: make(chan struct{}),
+	}
+}
+
+func (t *startupTracker) complete(phase string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.done[phase] = true
+	log.Debugln("Startup phase completed:", phase)
+}
+
+// unready marks the exporter as not ready, e.g. while shutting down.
+func (t *startupTracker) unready() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.done[phaseReady] = false
+}
+
+func (t *startupTracker) fail(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.err = err
+}
+
+// ready returns whether startup finished, and the startup failure if any.
+func (t *startupTracker) ready() (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err != nil {
+		t.once.Do(func() { close(t.reported) })
+	}
+	return t.done[phaseReady], t.err
+}
+
+// run executes the startup tasks in order and flips readiness once all of
+// them ran. Nothing is flipped if ctx is canceled, e.g. by the shutdown.
+func (t *startupTracker) run(ctx context.Context, tasks []startupTask) {
+	for _, task := range tasks {
+		if err := task.run(ctx); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Errorf("Startup task %s failed: %s", task.name, err)
+			if task.fatal {
+				t.fail(err)
+				// Let the failure be scraped at least once before exiting.
+				select {
+				case <-t.reported:
+				case <-time.After(failureReportTimeout):
+				}
+				startupExit(1)
+				return
+			}
+		}
+	}
+	t.complete(phaseDatabase)
+	t.complete(phaseReady)
+}
+
+// Describe implements prometheus.Collector.
+func (t *startupTracker) Describe(ch chan<- *prometheus.Desc) {
+	ch <- startupPhaseDesc
+}
+
+// Collect implements prometheus.Collector.
+func (t *startupTracker) Collect(ch chan<- prometheus.Metric) {
+	t.ready()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, phase := range startupPhases {
+		v := 0.0
+		if t.done[phase] {
+			v = 1
+		}
+		ch <- prometheus.MustNewConstMetric(startupPhaseDesc, prometheus.GaugeValue, v, phase)
+	}
+}
+
+func healthyHandler(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("Healthy.\n"))
+}
+
+func (t *startupTracker) readyHandler(w http.ResponseWriter, r *http.Request) {
+	ready, err := t.ready()
+	switch {
+	case err != nil:
+		http.Error(w, "Startup failed: "+err.Error(), http.StatusServiceUnavailable)
+	case !ready:
+		http.Error(w, "Not ready.", http.StatusServiceUnavailable)
+	default:
+		w.Write([]byte("Ready.\n"))
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2020 CUBRID Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// startupServer serves the health endpoints of startup.
+func startupServer(t *testing.T, startup *startupTracker) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/-/healthy", healthyHandler)
+	mux.HandleFunc("/-/ready", startup.readyHandler)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func getStatus(t *testing.T, url string) int {
+	client := http.Client{Timeout: time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatalf("error requesting %s: %s", url, err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+// TestStartupHangingDatabase checks that the listener answers while a
+// startup task hangs on the database, and that readiness stays false.
+func TestStartupHangingDatabase(t *testing.T) {
+	startup := newStartupTracker()
+	startup.complete(phaseListening)
+	server := startupServer(t, startup)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		startup.run(ctx, []startupTask{{name: "database ping", run: func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}}})
+	}()
+
+	start := time.Now()
+	if status := getStatus(t, server.URL+"/-/healthy"); status != http.StatusOK {
+		t.Errorf("/-/healthy status = %d, want 200", status)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("/-/healthy took %s", elapsed)
+	}
+	if status := getStatus(t, server.URL+"/-/ready"); status != http.StatusServiceUnavailable {
+		t.Errorf("/-/ready status = %d, want 503", status)
+	}
+	expected := `
+# HELP cubrid_exporter_startup_phase Whether the startup phase has completed (1 for completed).
+# TYPE cubrid_exporter_startup_phase gauge
+cubrid_exporter_startup_phase{phase="database"} 0
+cubrid_exporter_startup_phase{phase="listening"} 1
+cubrid_exporter_startup_phase{phase="ready"} 0
+`
+	if err := testutil.CollectAndCompare(startup, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+
+	// Canceling the hanging task, as the shutdown does, leaves it unready.
+	cancel()
+	<-done
+	if ready, err := startup.ready(); ready || err != nil {
+		t.Errorf("ready after cancelation = %v, %v", ready, err)
+	}
+}
+
+func TestStartupTasks(t *testing.T) {
+	startup := newStartupTracker()
+	failing := errors.New("connection refused")
+	startup.run(context.Background(), []startupTask{
+		{name: "failing", run: func(ctx context.Context) error { return failing }},
+		{name: "working", run: func(ctx context.Context) error { return nil }},
+	})
+	if ready, err := startup.ready(); !ready || err != nil {
+		t.Errorf("ready after a failed non-fatal task = %v, %v, want true", ready, err)
+	}
+}
+
+// TestStartupFatalTask checks that a fatal task exits only after /-/ready
+// reported its failure.
+func TestStartupFatalTask(t *testing.T) {
+	exited := make(chan int, 1)
+	startupExit = func(code int) { exited <- code }
+	defer func() { startupExit = os.Exit }()
+
+	startup := newStartupTracker()
+	server := startupServer(t, startup)
+	go startup.run(context.Background(), []startupTask{
+		{name: "database ping", fatal: true, run: func(ctx context.Context) error { return errors.New("connection refused") }},
+	})
+
+	// Wait for the failure to be recorded without reporting it.
+	for {
+		startup.mu.Lock()
+		err := startup.err
+		startup.mu.Unlock()
+		if err != nil {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case <-exited:
+		t.Fatal("exited before the failure was reported")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if status := getStatus(t, server.URL+"/-/ready"); status != http.StatusServiceUnavailable {
+		t.Errorf("/-/ready status = %d, want 503", status)
+	}
+	select {
+	case code := <-exited:
+		if code != 1 {
+			t.Errorf("exit code = %d, want 1", code)
+		}
+	case <-time.After(time.Second):
+		t.Error("did not exit after the failure was reported")
+	}
+}